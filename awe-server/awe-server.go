@@ -17,10 +17,13 @@ import (
 	"github.com/MG-RAST/golib/goweb"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"runtime"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -125,12 +128,15 @@ func launchSite(control chan int, port int) {
 func launchAPI(control chan int, port int) {
 	c := controller.NewServerController()
 	//goweb.ConfigureDefaultFormatters()
-	r := &goweb.RouteManager{}
+	r := goweb.DefaultRouteManager
 	r.Map("/job/{jid}/acl/{type}", c.JobAcl["typed"])
 	r.Map("/job/{jid}/acl", c.JobAcl["base"])
+	r.Map("/job/{jid}/history", c.JobHistory)
 	r.Map("/cgroup/{cgid}/acl/{type}", c.ClientGroupAcl["typed"])
 	r.Map("/cgroup/{cgid}/acl", c.ClientGroupAcl["base"])
 	r.Map("/cgroup/{cgid}/token", c.ClientGroupToken)
+	r.Map("/project/{project}/acl/{type}", c.ProjectAcl["typed"])
+	r.Map("/project/{project}/acl", c.ProjectAcl["base"])
 	r.MapRest("/job", c.Job)
 	r.MapRest("/work", c.Work)
 	r.MapRest("/cgroup", c.ClientGroup)
@@ -138,15 +144,20 @@ func launchAPI(control chan int, port int) {
 	r.MapRest("/queue", c.Queue)
 	r.MapRest("/logger", c.Logger)
 	r.MapRest("/awf", c.Awf)
+	r.MapRest("/token", c.Token)
+	r.MapRest("/group", c.Group)
+	r.MapRest("/user", c.User)
 	r.MapFunc("*", controller.ResourceDescription, goweb.GetMethod)
+	// standby servers pass the requests on to the leader
+	handler := controller.NewLeaderHandler(goweb.DefaultHttpHandler)
 	if conf.SSL_ENABLED {
-		err := goweb.ListenAndServeRoutesTLS(fmt.Sprintf(":%d", conf.API_PORT), conf.SSL_CERT_FILE, conf.SSL_KEY_FILE, r)
+		err := http.ListenAndServeTLS(fmt.Sprintf(":%d", conf.API_PORT), conf.SSL_CERT_FILE, conf.SSL_KEY_FILE, handler)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "ERROR: api: %v\n", err)
 			logger.Error("ERROR: api: " + err.Error())
 		}
 	} else {
-		err := goweb.ListenAndServeRoutes(fmt.Sprintf(":%d", conf.API_PORT), r)
+		err := http.ListenAndServe(fmt.Sprintf(":%d", conf.API_PORT), handler)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "ERROR: api: %v\n", err)
 			logger.Error("ERROR: api: " + err.Error())
@@ -222,11 +233,68 @@ func main() {
 		os.Exit(1)
 	}
 
+	// a state saved by an earlier shutdown is only used when recovering
+	if err := core.InitServerState(conf.RECOVER || conf.HA_ENABLE); err != nil {
+		logger.Error("InitServerState: " + err.Error())
+	}
+
+	// a restarted server keeps its uuid, so the workers continue with their work
+	if conf.RECOVER && !conf.HA_ENABLE {
+		if state, err := core.LoadServerState(); err != nil {
+			logger.Error("LoadServerState: " + err.Error())
+		} else if state != nil && state.ServerUuid != "" {
+			core.Server_UUID = state.ServerUuid
+		}
+	}
+
 	logger.Info("init resource manager...")
 
 	//init resource manager
 	core.InitResMgr("server")
 
+	if conf.HA_ENABLE {
+		core.InitLeader()
+		if conf.HA_PROXY && conf.TRUSTED_PROXIES == "" {
+			logger.Warning("HA proxy is enabled without trusted_proxies, clientgroup ip_cidr restrictions see the address of the standby server")
+		}
+	}
+
+	var host string
+	if hostname, err := os.Hostname(); err == nil {
+		host = fmt.Sprintf("%s:%d", hostname, conf.API_PORT)
+	}
+
+	// graceful shutdown, a server started with recover continues with the checked out workunits.
+	// Until the jobs are recovered the saved state is kept, it has not been used yet.
+	started := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+		sig := <-signals
+		logger.Info("received signal %s, shutting down...", sig.String())
+		recovered := false
+		select {
+		case <-started:
+			recovered = true
+		default:
+		}
+		// a standby server has no queue, it must not overwrite the state of the leader
+		if recovered && (core.Leadership == nil || core.Leadership.IsLeader()) {
+			if err := core.QMgr.Shutdown(); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: shutdown: %s\n", err.Error())
+				logger.Error("Shutdown: " + err.Error())
+			}
+		}
+		if core.Leadership != nil {
+			if err := core.Leadership.Resign(); err != nil {
+				logger.Error("Resign: " + err.Error())
+			}
+		}
+		logger.Event(event.SERVER_SHUTDOWN, "host="+host)
+		time.Sleep(time.Second)
+		os.Exit(0)
+	}()
+
 	logger.Info("InitAwfMgr...")
 	core.InitAwfMgr()
 
@@ -236,6 +304,18 @@ func main() {
 	logger.Info("InitClientGroupDB...")
 	core.InitClientGroupDB()
 
+	logger.Info("InitWorkReuseDB...")
+	core.InitWorkReuseDB()
+
+	logger.Info("InitNodeCleanupDB...")
+	core.InitNodeCleanupDB()
+
+	logger.Info("InitJobHistoryDB...")
+	core.InitJobHistoryDB()
+
+	logger.Info("InitProjectAclDB...")
+	core.InitProjectAclDB()
+
 	logger.Info("init auth...")
 	//init auth
 	auth.Initialize()
@@ -245,20 +325,39 @@ func main() {
 
 	logger.Info("launching server...")
 
+	if conf.HA_ENABLE {
+		go core.Leadership.Campaign()
+	}
+
 	//launch server
 	control := make(chan int)
-	go core.Ttl.Handle() // deletes expired jobs
-	go core.QMgr.ClientHandle()
-	go core.QMgr.NoticeHandle()
-	go core.QMgr.ClientChecker()
-	go core.QMgr.UpdateQueueLoop()
-
 	goweb.ConfigureDefaultFormatters()
 	go launchSite(control, conf.SITE_PORT)
 	go launchAPI(control, conf.API_PORT)
 
 	logger.Info("API launched...")
 
+	if conf.HA_ENABLE {
+		// standby until this server holds the lease
+		logger.Info("waiting for leader election...")
+		<-core.Leadership.Elected()
+		core.Server_UUID = core.Leadership.ServerUuid()
+		go func() {
+			<-core.Leadership.Lost()
+			// the in-memory state is stale now, the supervisor restarts the server as standby
+			fmt.Fprintf(os.Stderr, "ERROR: lost leadership, exiting\n")
+			logger.Error("lost leadership, exiting")
+			time.Sleep(time.Second)
+			os.Exit(1)
+		}()
+	}
+
+	go core.Ttl.Handle() // deletes expired jobs
+	go core.QMgr.ClientHandle()
+	go core.QMgr.NoticeHandle()
+	go core.QMgr.ClientChecker()
+	go core.QMgr.UpdateQueueLoop()
+
 	// reload job directory
 	if conf.RELOAD != "" {
 		fmt.Println("####### Reloading #######")
@@ -272,31 +371,50 @@ func main() {
 		logger.Error("LoadWorkflows: " + err.Error())
 	}
 
-	var host string
-	if hostname, err := os.Hostname(); err == nil {
-		host = fmt.Sprintf("%s:%d", hostname, conf.API_PORT)
-	}
-
-	//recover unfinished jobs before server went down last time
-	if conf.RECOVER {
+	//recover unfinished jobs before server went down last time, a new leader always takes over the jobs
+	if conf.RECOVER || conf.HA_ENABLE {
 		if conf.RECOVER_MAX > 0 {
 			logger.Info("####### Recovering %d unfinished jobs #######", conf.RECOVER_MAX)
 		} else {
 			logger.Info("####### Recovering all unfinished jobs #######")
 		}
 
-		recovered, total, err := core.QMgr.RecoverJobs()
-		if err != nil {
-			logger.Error("RecoverJobs error: %v\n", err)
+		var recovered, total, restored int
+		var err error
+		if conf.HA_ENABLE {
+			recovered, total, err = core.Leadership.TakeOver(core.QMgr)
+			if err != nil {
+				logger.Error("RecoverJobs error: %v\n", err)
+			}
+			// workunits that were checked out when the server shut down
+			if state, err := core.LoadServerState(); err != nil {
+				logger.Error("LoadServerState: " + err.Error())
+			} else if state != nil {
+				restored, err = core.QMgr.RestoreCheckouts(state.Checkouts)
+				if err != nil {
+					logger.Error("RestoreCheckouts: " + err.Error())
+				}
+			}
+		} else {
+			// the queue is suspended and the workers keep their work until the checkouts are restored
+			recovered, total, restored, err = core.QMgr.Recover()
+			if err != nil {
+				logger.Error("Recover error: %v\n", err)
+			}
 		}
 		fmt.Printf("%d total jobs from mongo\n", total)
 		fmt.Printf("%d unfinished jobs recovered\n", recovered)
+		fmt.Printf("%d checked out workunits restored\n", restored)
 
 		logger.Info("Recovering done")
 		logger.Event(event.SERVER_RECOVER, "host="+host)
 	} else {
 		logger.Event(event.SERVER_START, "host="+host)
 	}
+	if err := core.RecordServerStart(); err != nil {
+		logger.Error("RecordServerStart: " + err.Error())
+	}
+	close(started)
 
 	if conf.PID_FILE_PATH != "" {
 		f, err := os.Create(conf.PID_FILE_PATH)
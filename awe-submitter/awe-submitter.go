@@ -59,6 +59,11 @@ func main_wrapper() (err error) {
 
 	logger.Initialize("client")
 
+	if len(conf.ARGS) > 0 && conf.ARGS[0] == "graph" {
+		err = graph_wrapper(conf.ARGS[1:])
+		return
+	}
+
 	awe_auth := os.Getenv("AWE_AUTH")
 	shock_auth := os.Getenv("SHOCK_AUTH")
 
@@ -472,6 +477,83 @@ func main_wrapper() (err error) {
 	return
 }
 
+// awe-submitter graph <workflow.cwl> [dot|mermaid|json]
+// prints the step graph of a workflow, does not contact AWE or Shock
+func graph_wrapper(args []string) (err error) {
+
+	if len(args) < 1 {
+		err = fmt.Errorf("(graph_wrapper) workflow file is required, usage: graph <workflow.cwl> [dot|mermaid|json]")
+		return
+	}
+
+	workflow_file := args[0]
+	format := core.GRAPH_FORMAT_DOT
+	if len(args) > 1 {
+		format = args[1]
+	}
+
+	var yamlstream []byte
+	if conf.SUBMITTER_PACK {
+		yamlstream, err = exec.Command("cwl-runner", "--pack", workflow_file).Output()
+		if err != nil {
+			err = fmt.Errorf("(graph_wrapper) exec.Command returned: %s (%s %s %s)", err.Error(), "cwl-runner", "--pack", workflow_file)
+			return
+		}
+	} else {
+		yamlstream, err = ioutil.ReadFile(workflow_file)
+		if err != nil {
+			err = fmt.Errorf("(graph_wrapper) error in reading workflow file: %s", err.Error())
+			return
+		}
+	}
+
+	var named_object_array cwl.Named_CWL_object_array
+	var cwl_version cwl.CWLVersion
+	var schemata []cwl.CWLType_Type
+	named_object_array, cwl_version, schemata, err = cwl.Parse_cwl_document(string(yamlstream[:]))
+	if err != nil {
+		err = fmt.Errorf("(graph_wrapper) error in parsing cwl workflow yaml file: %s", err.Error())
+		return
+	}
+
+	collection := cwl.NewCWL_collection()
+	err = collection.AddArray(named_object_array)
+	if err != nil {
+		err = fmt.Errorf("(graph_wrapper) collection.AddArray returned: %s", err.Error())
+		return
+	}
+	err = collection.AddSchemata(schemata)
+	if err != nil {
+		err = fmt.Errorf("(graph_wrapper) collection.AddSchemata returned: %s", err.Error())
+		return
+	}
+
+	var graph *core.TaskGraph
+	graph, err = core.NewCWLGraph(&collection, "#main", cwl_version)
+	if err != nil {
+		err = fmt.Errorf("(graph_wrapper) NewCWLGraph returned: %s", err.Error())
+		return
+	}
+
+	var graph_str string
+	if format == core.GRAPH_FORMAT_JSON {
+		var graph_bytes []byte
+		graph_bytes, err = json.MarshalIndent(graph, "", "  ")
+		if err != nil {
+			err = fmt.Errorf("(graph_wrapper) json.MarshalIndent returned: %s", err.Error())
+			return
+		}
+		graph_str = string(graph_bytes[:]) + "\n"
+	} else {
+		graph_str, err = graph.Render(format)
+		if err != nil {
+			return
+		}
+	}
+	fmt.Print(graph_str)
+	return
+}
+
 func SubmitCWLJobToAWE(workflow_file string, job_file string, data *[]byte, awe_auth string, shock_auth string) (jobid string, err error) {
 	multipart := NewMultipartWriter()
 
@@ -3,7 +3,9 @@ package main
 import (
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/MG-RAST/AWE/lib/conf"
@@ -182,6 +184,22 @@ func main() {
 
 	}
 
+	// drain on SIGUSR1 or SIGTERM, another SIGTERM exits without waiting for the current work
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGUSR1, syscall.SIGTERM)
+		for sig := range signals {
+			if worker.Draining() {
+				if sig == syscall.SIGTERM {
+					fmt.Fprintf(os.Stderr, "received %s while draining, exiting\n", sig.String())
+					os.Exit(1)
+				}
+				continue
+			}
+			go worker.Drain("received signal " + sig.String())
+		}
+	}()
+
 	time.Sleep(time.Second)
 
 	worker.StartClientWorkers()
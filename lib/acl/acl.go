@@ -3,15 +3,31 @@ package acl
 import ()
 
 // Acl struct
+// entries are user uuids, "public" or groups ("group:<id>")
 type Acl struct {
-	Owner  string   `bson:"owner" json:"owner"`
-	Read   []string `bson:"read" json:"read"`
-	Write  []string `bson:"write" json:"write"`
-	Delete []string `bson:"delete" json:"delete"`
+	Owner   string   `bson:"owner" json:"owner"`
+	Read    []string `bson:"read" json:"read"`
+	Write   []string `bson:"write" json:"write"`
+	Delete  []string `bson:"delete" json:"delete"`
+	Operate []string `bson:"operate" json:"operate"` // suspend, resume, recover, recompute and resubmit
+	Owners  []string `bson:"owners" json:"owners"`   // co-owners, they have all rights and can edit the acl
 }
 
 type Rights map[string]bool
 
+// roles are sets of rights
+const (
+	ROLE_VIEWER   = "viewer"
+	ROLE_OPERATOR = "operator"
+	ROLE_OWNER    = "coowner"
+)
+
+var Roles = map[string]Rights{
+	ROLE_VIEWER:   Rights{"read": true},
+	ROLE_OPERATOR: Rights{"read": true, "operate": true},
+	ROLE_OWNER:    Rights{"read": true, "operate": true, "write": true, "delete": true, "owner": true},
+}
+
 func (a *Acl) SetOwner(str string) {
 	a.Owner = str
 	return
@@ -27,6 +43,12 @@ func (a *Acl) UnSet(str string, r Rights) {
 	if r["delete"] {
 		a.Delete = del(a.Delete, str)
 	}
+	if r["operate"] {
+		a.Operate = del(a.Operate, str)
+	}
+	if r["owner"] {
+		a.Owners = del(a.Owners, str)
+	}
 	return
 }
 
@@ -40,23 +62,61 @@ func (a *Acl) Set(str string, r Rights) {
 	if r["delete"] {
 		a.Delete = insert(a.Delete, str)
 	}
+	if r["operate"] {
+		a.Operate = insert(a.Operate, str)
+	}
+	if r["owner"] {
+		a.Owners = insert(a.Owners, str)
+	}
+	return
+}
+
+// Merge adds all entries of b, the owner is not changed
+func (a *Acl) Merge(b Acl) {
+	for k, v := range map[string][]string{"read": b.Read, "write": b.Write, "delete": b.Delete, "operate": b.Operate, "owner": b.Owners} {
+		for _, id := range v {
+			a.Set(id, Rights{k: true})
+		}
+	}
 	return
 }
 
-func (a *Acl) Check(str string) (r Rights) {
-	r = Rights{"read": false, "write": false, "delete": false}
-	acls := map[string][]string{"read": a.Read, "write": a.Write, "delete": a.Delete}
+// Check returns the rights of the given ids, usually a user uuid and the groups of the user.
+// The owner and co-owners have all rights, write includes operate.
+func (a *Acl) Check(ids ...string) (r Rights) {
+	r = Rights{"read": false, "write": false, "delete": false, "operate": false, "owner": false}
+	acls := map[string][]string{"read": a.Read, "write": a.Write, "delete": a.Delete, "operate": a.Operate, "owner": a.Owners}
 	for k, v := range acls {
 		for _, id := range v {
-			if str == id {
+			if contains(ids, id) {
 				r[k] = true
 				break
 			}
 		}
 	}
+	if a.Owner != "" && contains(ids, a.Owner) {
+		r["owner"] = true
+	}
+	if r["owner"] {
+		for k := range r {
+			r[k] = true
+		}
+	}
+	if r["write"] {
+		r["operate"] = true
+	}
 	return
 }
 
+func contains(arr []string, s string) bool {
+	for _, item := range arr {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 func del(arr []string, s string) (narr []string) {
 	narr = []string{}
 	for i, item := range arr {
@@ -0,0 +1,81 @@
+package acl
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	a := Acl{
+		Owner:   "owner",
+		Read:    []string{"reader", "writer", "group:g1"},
+		Write:   []string{"writer"},
+		Delete:  []string{"deleter"},
+		Operate: []string{"operator"},
+		Owners:  []string{"coowner"},
+	}
+	all := Rights{"read": true, "write": true, "delete": true, "operate": true, "owner": true}
+	none := Rights{"read": false, "write": false, "delete": false, "operate": false, "owner": false}
+	tests := []struct {
+		ids    []string
+		rights Rights
+	}{
+		{[]string{"owner"}, all},
+		{[]string{"coowner"}, all},
+		{[]string{"reader"}, Rights{"read": true, "write": false, "delete": false, "operate": false, "owner": false}},
+		// write includes operate
+		{[]string{"writer"}, Rights{"read": true, "write": true, "delete": false, "operate": true, "owner": false}},
+		{[]string{"deleter"}, Rights{"read": false, "write": false, "delete": true, "operate": false, "owner": false}},
+		{[]string{"operator"}, Rights{"read": false, "write": false, "delete": false, "operate": true, "owner": false}},
+		// rights of the groups of a user are combined with those of the user
+		{[]string{"nobody", "group:g1"}, Rights{"read": true, "write": false, "delete": false, "operate": false, "owner": false}},
+		{[]string{"deleter", "group:g1"}, Rights{"read": true, "write": false, "delete": true, "operate": false, "owner": false}},
+		{[]string{"nobody", "group:g2"}, none},
+		{[]string{}, none},
+		{[]string{""}, none},
+	}
+	for _, test := range tests {
+		if r := a.Check(test.ids...); !reflect.DeepEqual(r, test.rights) {
+			t.Fatalf("Check(%v): expected %v, got %v", test.ids, test.rights, r)
+		}
+	}
+
+	// an acl without owner does not grant anything to an empty id
+	if r := (&Acl{}).Check(""); !reflect.DeepEqual(r, none) {
+		t.Fatalf("empty acl grants %v", r)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		a        Acl
+		b        Acl
+		expected Acl
+	}{
+		{
+			Acl{Owner: "owner", Read: []string{"u1"}},
+			Acl{Owner: "other", Read: []string{"u1", "u2"}, Write: []string{"u2"}},
+			Acl{Owner: "owner", Read: []string{"u1", "u2"}, Write: []string{"u2"}},
+		},
+		{
+			Acl{Owner: "owner"},
+			Acl{Delete: []string{"u3"}, Operate: []string{"group:g1"}, Owners: []string{"u4"}},
+			Acl{Owner: "owner", Delete: []string{"u3"}, Operate: []string{"group:g1"}, Owners: []string{"u4"}},
+		},
+		{
+			Acl{Owner: "owner", Read: []string{"public"}},
+			Acl{},
+			Acl{Owner: "owner", Read: []string{"public"}},
+		},
+	}
+	for i, test := range tests {
+		test.a.Merge(test.b)
+		for _, list := range [][]string{test.a.Read, test.a.Write, test.a.Delete, test.a.Operate, test.a.Owners} {
+			sort.Strings(list)
+		}
+		if !reflect.DeepEqual(test.a, test.expected) {
+			t.Fatalf("merge %d: expected %+v, got %+v", i, test.expected, test.a)
+		}
+	}
+}
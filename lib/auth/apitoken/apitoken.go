@@ -0,0 +1,63 @@
+// Package apitoken implements authentication with API tokens issued by AWE
+package apitoken
+
+import (
+	"errors"
+	"strings"
+
+	e "github.com/MG-RAST/AWE/lib/errors"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/user"
+)
+
+// secret returns the token of "Bearer awe_..." or "Token awe_..." headers
+func secret(header string) (token string, ok bool) {
+	tmp := strings.SplitN(header, " ", 2)
+	if len(tmp) != 2 {
+		return
+	}
+	scheme := strings.ToLower(tmp[0])
+	token = strings.TrimSpace(tmp[1])
+	if (scheme != "bearer" && scheme != "token") || !strings.HasPrefix(token, user.API_TOKEN_PREFIX) {
+		return "", false
+	}
+	return token, true
+}
+
+// IsApiToken returns true if the header contains an AWE API token
+func IsApiToken(header string) bool {
+	_, ok := secret(header)
+	return ok
+}
+
+// Auth takes the request authorization header and returns the owner of the token,
+// headers without an API token return neither user nor error, so that other methods are tried
+func Auth(header string) (u *user.User, err error) {
+	token_str, ok := secret(header)
+	if !ok {
+		return
+	}
+	token, err := user.FindApiToken(token_str)
+	if err != nil {
+		return nil, errors.New("(apitoken) unknown token, " + e.InvalidAuth)
+	}
+	if token.Expired() {
+		return nil, errors.New("(apitoken) token " + token.Id + " expired, " + e.InvalidAuth)
+	}
+	u, err = user.FindByUuid(token.UserUuid)
+	if err != nil {
+		return nil, errors.New("(apitoken) owner of token " + token.Id + " not found: " + err.Error())
+	}
+	if u.Disabled {
+		return nil, errors.New("(apitoken) owner of token " + token.Id + " is disabled, " + e.InvalidAuth)
+	}
+	u.Scope = token.Scope
+	u.TokenExpires = token.Expires
+	if token.Scope != user.SCOPE_ADMIN {
+		u.Admin = false
+	}
+	if xerr := token.Touch(); xerr != nil {
+		logger.Error("(apitoken) recording use of token %s: %s", token.Id, xerr.Error())
+	}
+	return
+}
@@ -3,14 +3,18 @@ package auth
 
 import (
 	"errors"
+	"github.com/MG-RAST/AWE/lib/auth/apitoken"
+	"github.com/MG-RAST/AWE/lib/auth/basic"
 	"github.com/MG-RAST/AWE/lib/auth/clientgroup"
 	"github.com/MG-RAST/AWE/lib/auth/globus"
 	"github.com/MG-RAST/AWE/lib/auth/oauth"
+	"github.com/MG-RAST/AWE/lib/auth/oidc"
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core"
 	e "github.com/MG-RAST/AWE/lib/errors"
 	"github.com/MG-RAST/AWE/lib/logger"
 	"github.com/MG-RAST/AWE/lib/user"
+	"time"
 )
 
 // authCache is a
@@ -19,7 +23,15 @@ var authMethods []func(string) (*user.User, error)
 
 func Initialize() {
 	authCache = cache{m: make(map[string]cacheValue)}
-	authMethods = []func(string) (*user.User, error){}
+	authMethods = []func(string) (*user.User, error){apitoken.Auth}
+	if conf.BASIC_AUTH {
+		authMethods = append(authMethods, basic.Auth)
+	}
+	// tokens are verified locally, so it goes before the remote methods
+	if conf.OIDC_ISSUER != "" {
+		oidc.Initialize()
+		authMethods = append(authMethods, oidc.Auth)
+	}
 	if len(conf.AUTH_OAUTH) > 0 {
 		authMethods = append(authMethods, oauth.Auth)
 	}
@@ -29,6 +41,8 @@ func Initialize() {
 }
 
 func Authenticate(header string) (u *user.User, err error) {
+	// API tokens can be revoked at any time and their use is recorded, so they are not cached
+	is_api_token := apitoken.IsApiToken(header)
 	if u = authCache.lookup(header); u != nil {
 		return u, nil
 	} else {
@@ -43,8 +57,19 @@ func Authenticate(header string) (u *user.User, err error) {
 				logger.Error("(auth.Authenticate) err=%s (header=%s)", err.Error(), header[0:last_position]+"...")
 				err = nil
 			}
+			if is_api_token {
+				if u != nil {
+					return
+				}
+				break
+			}
 			if u != nil {
-				authCache.add(header, u)
+				expires := time.Now().Add(1 * time.Hour)
+				// a JWT must not be accepted after it expired
+				if exp, ok := oidc.Expiry(header); ok && exp.Before(expires) {
+					expires = exp
+				}
+				authCache.add(header, u, expires)
 				return
 			}
 		}
@@ -52,6 +77,11 @@ func Authenticate(header string) (u *user.User, err error) {
 	return nil, errors.New(e.InvalidAuth)
 }
 
+// InvalidateUser removes the cached authentications of a user, e.g. after a password change
+func InvalidateUser(uuid string) {
+	authCache.removeUser(uuid)
+}
+
 func AuthenticateClientGroup(header string) (cg *core.ClientGroup, err error) {
 	if cg, err = clientgroup.Auth(header); err != nil {
 		return nil, err
@@ -10,28 +10,39 @@ import (
 	"strings"
 )
 
+// IsBasic returns true if the header uses the basic scheme
+func IsBasic(header string) bool {
+	return strings.ToLower(strings.SplitN(header, " ", 2)[0]) == "basic"
+}
+
 // DecodeHeader takes the request authorization header and returns
 // username and password if it is correctly encoded.
 func DecodeHeader(header string) (username string, password string, err error) {
-	if strings.ToLower(strings.Split(header, " ")[0]) == "basic" {
-		if val, err := base64.URLEncoding.DecodeString(strings.Split(header, " ")[1]); err == nil {
-			tmp := strings.Split(string(val), ":")
-			if len(tmp) >= 2 {
-				return tmp[0], tmp[1], nil
-			} else {
-				return "", "", errors.New(e.InvalidAuth)
-			}
-		} else {
+	tmp := strings.SplitN(header, " ", 2)
+	if len(tmp) != 2 || strings.ToLower(tmp[0]) != "basic" {
+		return "", "", errors.New(e.InvalidAuth)
+	}
+	val, err := base64.StdEncoding.DecodeString(strings.TrimSpace(tmp[1]))
+	if err != nil {
+		// older clients encoded the credentials url-safe
+		if val, err = base64.URLEncoding.DecodeString(strings.TrimSpace(tmp[1])); err != nil {
 			return "", "", errors.New(e.InvalidAuth)
 		}
-
 	}
-	return "", "", errors.New(e.InvalidAuth)
+	// the password may contain ':'
+	creds := strings.SplitN(string(val), ":", 2)
+	if len(creds) != 2 {
+		return "", "", errors.New(e.InvalidAuth)
+	}
+	return creds[0], creds[1], nil
 }
 
 // Auth takes the request authorization header and returns
-// user
+// user, headers of other schemes return neither user nor error
 func Auth(header string) (u *user.User, err error) {
+	if !IsBasic(header) {
+		return nil, nil
+	}
 	username, password, err := DecodeHeader(header)
 	if err == nil {
 		return user.FindByUsernamePassword(username, password)
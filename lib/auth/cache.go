@@ -17,24 +17,38 @@ type cacheValue struct {
 }
 
 func (c *cache) lookup(header string) *user.User {
+	c.Lock()
+	defer c.Unlock()
 	if v, ok := c.m[header]; ok {
 		if time.Now().Before(v.expires) {
-			return v.user
+			// every request gets its own copy, e.g. for the groups of the user
+			u := *v.user
+			return &u
 		} else {
-			c.Lock()
-			defer c.Unlock()
 			delete(c.m, header)
 		}
 	}
 	return nil
 }
 
-func (c *cache) add(header string, u *user.User) {
+func (c *cache) add(header string, u *user.User, expires time.Time) {
 	c.Lock()
 	defer c.Unlock()
+	cached := *u
 	c.m[header] = cacheValue{
-		expires: time.Now().Add(1 * time.Hour),
-		user:    u,
+		expires: expires,
+		user:    &cached,
+	}
+	return
+}
+
+func (c *cache) removeUser(uuid string) {
+	c.Lock()
+	defer c.Unlock()
+	for header, v := range c.m {
+		if v.user.Uuid == uuid {
+			delete(c.m, header)
+		}
 	}
 	return
 }
@@ -0,0 +1,169 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a JSON Web Key as published in the jwks_uri of the issuer (RFC 7517), only RSA and EC signing keys are used
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// ParseJWKS returns the signing keys of a key set by key id
+func ParseJWKS(data []byte) (keys map[string]crypto.PublicKey, err error) {
+	jwks := JWKS{}
+	err = json.Unmarshal(data, &jwks)
+	if err != nil {
+		err = fmt.Errorf("(ParseJWKS) json.Unmarshal returned: %s", err.Error())
+		return
+	}
+	keys = make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, xerr := jwk.PublicKey()
+		if xerr != nil {
+			// keys of unsupported types are skipped, the issuer may publish more than we need
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		err = errors.New("(ParseJWKS) key set contains no usable signing key")
+	}
+	return
+}
+
+// PublicKey converts the JWK into a *rsa.PublicKey or *ecdsa.PublicKey
+func (jwk *JWK) PublicKey() (key crypto.PublicKey, err error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, xerr := decodeBigInt(jwk.N)
+		if xerr != nil {
+			err = fmt.Errorf("(JWK.PublicKey) invalid modulus: %s", xerr.Error())
+			return
+		}
+		e, xerr := decodeBigInt(jwk.E)
+		if xerr != nil || !e.IsInt64() || e.Int64() < 2 || e.Int64() > 1<<31-1 {
+			err = errors.New("(JWK.PublicKey) invalid exponent")
+			return
+		}
+		key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			err = fmt.Errorf("(JWK.PublicKey) unsupported curve %s", jwk.Crv)
+			return
+		}
+		x, xerr := decodeBigInt(jwk.X)
+		if xerr != nil {
+			err = fmt.Errorf("(JWK.PublicKey) invalid x: %s", xerr.Error())
+			return
+		}
+		y, xerr := decodeBigInt(jwk.Y)
+		if xerr != nil {
+			err = fmt.Errorf("(JWK.PublicKey) invalid y: %s", xerr.Error())
+			return
+		}
+		if !curve.IsOnCurve(x, y) {
+			err = errors.New("(JWK.PublicKey) point is not on the curve")
+			return
+		}
+		key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	default:
+		err = fmt.Errorf("(JWK.PublicKey) unsupported key type %s", jwk.Kty)
+	}
+	return
+}
+
+func decodeBigInt(value string) (i *big.Int, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return
+	}
+	if len(data) == 0 {
+		err = errors.New("empty value")
+		return
+	}
+	i = new(big.Int).SetBytes(data)
+	return
+}
+
+var algHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// verifySignature checks the JWS signature of signed (header.payload), "none" and HMAC algorithms are rejected
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) (err error) {
+	hash, ok := algHashes[alg]
+	if !ok {
+		err = fmt.Errorf("(verifySignature) unsupported algorithm %s", alg)
+		return
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch alg[0:2] {
+	case "RS", "PS":
+		rsa_key, ok := key.(*rsa.PublicKey)
+		if !ok {
+			err = fmt.Errorf("(verifySignature) algorithm %s requires an RSA key", alg)
+			return
+		}
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(rsa_key, hash, digest, signature)
+		} else {
+			err = rsa.VerifyPSS(rsa_key, hash, digest, signature, nil)
+		}
+	case "ES":
+		ec_key, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			err = fmt.Errorf("(verifySignature) algorithm %s requires an EC key", alg)
+			return
+		}
+		size := (ec_key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			err = errors.New("(verifySignature) invalid signature length")
+			return
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ec_key, digest, r, s) {
+			err = errors.New("(verifySignature) ecdsa verification failed")
+		}
+	}
+	return
+}
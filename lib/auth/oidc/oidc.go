@@ -0,0 +1,373 @@
+// Package oidc implements OpenID Connect authentication, JWT bearer tokens are verified locally with the published keys of the issuer
+package oidc
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	e "github.com/MG-RAST/AWE/lib/errors"
+	"github.com/MG-RAST/AWE/lib/user"
+)
+
+const (
+	// tolerated clock difference to the issuer
+	CLOCK_SKEW = time.Minute
+	// an unknown key id reloads the key set (key rotation), but not more often than this
+	KEYS_RELOAD = time.Minute
+)
+
+type Config struct {
+	Issuer        string
+	Audience      string // if set, the aud claim must contain it
+	UsernameClaim string // falls back to sub if the claim is missing
+	FullnameClaim string
+	EmailClaim    string
+	GroupsClaim   string
+	AdminGroup    string // members of this group are admins
+}
+
+// Claims of a verified token
+type Claims map[string]interface{}
+
+type Provider struct {
+	sync.Mutex
+	Config
+	jwks_uri   string
+	keys       map[string]crypto.PublicKey
+	last_fetch time.Time
+	client     *http.Client
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+var provider *Provider
+
+func Initialize() {
+	provider = NewProvider(Config{
+		Issuer:        conf.OIDC_ISSUER,
+		Audience:      conf.OIDC_AUDIENCE,
+		UsernameClaim: conf.OIDC_USERNAME_CLAIM,
+		FullnameClaim: conf.OIDC_FULLNAME_CLAIM,
+		EmailClaim:    conf.OIDC_EMAIL_CLAIM,
+		GroupsClaim:   conf.OIDC_GROUPS_CLAIM,
+		AdminGroup:    conf.OIDC_ADMIN_GROUP,
+	})
+}
+
+func NewProvider(config Config) *Provider {
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "preferred_username"
+	}
+	if config.FullnameClaim == "" {
+		config.FullnameClaim = "name"
+	}
+	if config.EmailClaim == "" {
+		config.EmailClaim = "email"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	return &Provider{
+		Config: config,
+		keys:   make(map[string]crypto.PublicKey),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Auth takes the request authorization header and returns the user, users are created on first login
+func Auth(header string) (u *user.User, err error) {
+	if provider == nil {
+		return nil, errors.New("(oidc) OpenID Connect is not configured")
+	}
+	tmp := strings.SplitN(header, " ", 2)
+	if len(tmp) != 2 || strings.ToLower(tmp[0]) != "bearer" {
+		return nil, errors.New("(oidc) Invalid authentication header, expected bearer token.")
+	}
+	claims, err := provider.Verify(strings.TrimSpace(tmp[1]))
+	if err != nil {
+		return
+	}
+	u, err = provider.UserFromClaims(claims)
+	if err != nil {
+		return
+	}
+	err = u.SetOidcInfo(provider.IsAdmin(claims))
+	if err != nil {
+		u = nil
+		err = errors.New("(oidc) MongoDB: " + err.Error())
+		return
+	}
+	if exp, ok := claims.Time("exp"); ok {
+		u.TokenExpires = &exp
+	}
+	return
+}
+
+// Expiry returns the exp claim of a bearer JWT without verifying the token, it limits how long an authentication is cached
+func Expiry(header string) (exp time.Time, ok bool) {
+	tmp := strings.SplitN(header, " ", 2)
+	if len(tmp) != 2 || strings.ToLower(tmp[0]) != "bearer" {
+		return
+	}
+	parts := strings.Split(strings.TrimSpace(tmp[1]), ".")
+	if len(parts) != 3 {
+		return
+	}
+	claims := Claims{}
+	if decodeSegment(parts[1], &claims) != nil {
+		return
+	}
+	return claims.Time("exp")
+}
+
+// Verify checks signature, issuer, audience and validity period of the token and returns its claims
+func (p *Provider) Verify(token string) (claims Claims, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		err = errors.New("(Verify) token is not a JWT")
+		return
+	}
+	header := jwtHeader{}
+	err = decodeSegment(parts[0], &header)
+	if err != nil {
+		err = fmt.Errorf("(Verify) invalid token header: %s", err.Error())
+		return
+	}
+	claims = Claims{}
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		claims = nil
+		err = fmt.Errorf("(Verify) invalid token payload: %s", err.Error())
+		return
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		claims = nil
+		err = fmt.Errorf("(Verify) invalid token signature: %s", err.Error())
+		return
+	}
+
+	// the claims are checked first, a token of another issuer must not trigger a key reload
+	err = p.checkClaims(claims)
+	if err != nil {
+		claims = nil
+		return
+	}
+	key, err := p.key(header.Kid)
+	if err != nil {
+		claims = nil
+		return
+	}
+	err = verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature)
+	if err != nil {
+		claims = nil
+		err = fmt.Errorf("(Verify) %s: %s", e.InvalidAuth, err.Error())
+	}
+	return
+}
+
+func (p *Provider) checkClaims(claims Claims) (err error) {
+	if claims.String("iss") != p.Issuer {
+		return fmt.Errorf("(checkClaims) token issuer %s is not %s", claims.String("iss"), p.Issuer)
+	}
+	now := time.Now()
+	exp, ok := claims.Time("exp")
+	if !ok {
+		return errors.New("(checkClaims) token has no expiration")
+	}
+	if now.After(exp.Add(CLOCK_SKEW)) {
+		return errors.New("(checkClaims) token expired")
+	}
+	if nbf, ok := claims.Time("nbf"); ok && now.Add(CLOCK_SKEW).Before(nbf) {
+		return errors.New("(checkClaims) token is not valid yet")
+	}
+	if p.Audience != "" && !contains(claims.Strings("aud"), p.Audience) {
+		return fmt.Errorf("(checkClaims) token audience does not include %s", p.Audience)
+	}
+	return
+}
+
+// UserFromClaims maps the configured claims onto a user, the user is not looked up in mongo.
+// The user is identified by issuer and sub, the username claim can be changed by the user.
+func (p *Provider) UserFromClaims(claims Claims) (u *user.User, err error) {
+	subject := claims.String("sub")
+	if subject == "" {
+		err = fmt.Errorf("(UserFromClaims) token has no sub claim, %s", e.InvalidAuth)
+		return
+	}
+	username := claims.String(p.UsernameClaim)
+	if username == "" {
+		username = subject
+	}
+	u = &user.User{
+		Username:    username,
+		Fullname:    claims.String(p.FullnameClaim),
+		Email:       claims.String(p.EmailClaim),
+		OidcSubject: p.Issuer + "|" + subject,
+	}
+	return
+}
+
+// IsAdmin returns true if the groups claim contains the admin group
+func (p *Provider) IsAdmin(claims Claims) bool {
+	if p.AdminGroup == "" {
+		return false
+	}
+	return contains(claims.Strings(p.GroupsClaim), p.AdminGroup)
+}
+
+// key returns the key with id kid, the key set is (re)loaded if the key is unknown
+func (p *Provider) key(kid string) (key crypto.PublicKey, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	key, ok := p.lookup(kid)
+	if ok {
+		return
+	}
+	if !p.last_fetch.IsZero() && time.Since(p.last_fetch) < KEYS_RELOAD {
+		err = fmt.Errorf("(key) unknown key id \"%s\"", kid)
+		return
+	}
+	p.last_fetch = time.Now()
+	err = p.loadKeys()
+	if err != nil {
+		return
+	}
+	key, ok = p.lookup(kid)
+	if !ok {
+		err = fmt.Errorf("(key) unknown key id \"%s\"", kid)
+	}
+	return
+}
+
+// lookup finds a key by id, a token without kid can only be used with a single key, p must be locked
+func (p *Provider) lookup(kid string) (key crypto.PublicKey, ok bool) {
+	key, ok = p.keys[kid]
+	if !ok && kid == "" && len(p.keys) == 1 {
+		for _, key = range p.keys {
+			ok = true
+		}
+	}
+	return
+}
+
+// loadKeys fetches the key set, the jwks_uri is taken from the discovery document of the issuer, p must be locked
+func (p *Provider) loadKeys() (err error) {
+	if p.jwks_uri == "" {
+		discovery := struct {
+			Issuer  string `json:"issuer"`
+			JwksUri string `json:"jwks_uri"`
+		}{}
+		err = p.getJSON(strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", &discovery)
+		if err != nil {
+			err = fmt.Errorf("(loadKeys) discovery returned: %s", err.Error())
+			return
+		}
+		if discovery.Issuer != p.Issuer {
+			err = fmt.Errorf("(loadKeys) discovery document is for issuer %s", discovery.Issuer)
+			return
+		}
+		if discovery.JwksUri == "" {
+			err = errors.New("(loadKeys) discovery document has no jwks_uri")
+			return
+		}
+		p.jwks_uri = discovery.JwksUri
+	}
+
+	resp, err := p.client.Get(p.jwks_uri)
+	if err != nil {
+		err = fmt.Errorf("(loadKeys) %s", err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("(loadKeys) unexpected response status from %s: %s", p.jwks_uri, resp.Status)
+		return
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return
+	}
+	p.keys = keys
+	return
+}
+
+func (p *Provider) getJSON(url string, v interface{}) (err error) {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected response status from %s: %s", url, resp.Status)
+		return
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	return
+}
+
+func decodeSegment(segment string, v interface{}) (err error) {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, v)
+	return
+}
+
+// String returns a string claim, other types are ignored
+func (c Claims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// Strings returns a claim that is either a string or a list of strings (e.g. aud, groups)
+func (c Claims) Strings(name string) (values []string) {
+	switch value := c[name].(type) {
+	case string:
+		values = []string{value}
+	case []interface{}:
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return
+}
+
+// Time returns a NumericDate claim (seconds since the epoch)
+func (c Claims) Time(name string) (t time.Time, ok bool) {
+	value, ok := c[name].(float64)
+	if !ok {
+		return
+	}
+	t = time.Unix(int64(value), 0)
+	return
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
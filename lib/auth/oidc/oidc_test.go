@@ -0,0 +1,182 @@
+package oidc_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/MG-RAST/AWE/lib/auth/oidc"
+)
+
+var (
+	rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _  = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func jwks() []byte {
+	set := JWKS{Keys: []JWK{
+		{Kty: "RSA", Kid: "rsa1", Use: "sig", N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{Kty: "EC", Kid: "ec1", Crv: "P-256", X: b64(ecKey.X.Bytes()), Y: b64(ecKey.Y.Bytes())},
+	}}
+	data, _ := json.Marshal(set)
+	return data
+}
+
+func sign(t *testing.T, alg string, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signed))
+	var signature []byte
+	switch alg {
+	case "RS256":
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + b64(signature)
+}
+
+func newIssuer(t *testing.T) (server *httptest.Server, p *Provider) {
+	mux := http.NewServeMux()
+	server = httptest.NewServer(mux)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": server.URL, "jwks_uri": server.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(jwks())
+	})
+	p = NewProvider(Config{Issuer: server.URL, Audience: "awe", AdminGroup: "awe-admins"})
+	return
+}
+
+func TestVerify(t *testing.T) {
+	server, p := newIssuer(t)
+	defer server.Close()
+
+	now := time.Now().Unix()
+	valid := map[string]interface{}{
+		"iss":                server.URL,
+		"aud":                []string{"awe", "shock"},
+		"sub":                "0815",
+		"exp":                now + 300,
+		"preferred_username": "jdoe",
+		"email":              "jdoe@example.org",
+		"groups":             []string{"users", "awe-admins"},
+	}
+	for _, alg := range []string{"RS256", "ES256"} {
+		kid := "rsa1"
+		if alg == "ES256" {
+			kid = "ec1"
+		}
+		claims, err := p.Verify(sign(t, alg, kid, valid))
+		if err != nil {
+			t.Fatalf("%s: %s", alg, err.Error())
+		}
+		u, err := p.UserFromClaims(claims)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if u.Username != "jdoe" || u.Email != "jdoe@example.org" {
+			t.Fatalf("unexpected user %#v", u)
+		}
+		if !p.IsAdmin(claims) {
+			t.Fatal("member of admin group is not admin")
+		}
+	}
+
+	invalid := map[string]map[string]interface{}{
+		"expired":      {"exp": now - 3600},
+		"wrong issuer": {"iss": "https://example.org"},
+		"wrong aud":    {"aud": "other"},
+		"no exp":       {"exp": nil},
+	}
+	for name, changes := range invalid {
+		claims := map[string]interface{}{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(claims, k)
+			} else {
+				claims[k] = v
+			}
+		}
+		if _, err := p.Verify(sign(t, "RS256", "rsa1", claims)); err == nil {
+			t.Fatalf("%s: token accepted", name)
+		}
+	}
+
+	// signature of the RSA key with the id of the EC key
+	if _, err := p.Verify(sign(t, "RS256", "ec1", valid)); err == nil {
+		t.Fatal("token with wrong key accepted")
+	}
+	// tampered payload
+	token := sign(t, "RS256", "rsa1", valid)
+	valid["preferred_username"] = "root"
+	forged := sign(t, "RS256", "rsa1", valid)
+	if _, err := p.Verify(token[:len(token)-10] + forged[len(forged)-10:]); err == nil {
+		t.Fatal("token with invalid signature accepted")
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "none", "kid": "rsa1"})
+	payload, _ := json.Marshal(valid)
+	if _, err := p.Verify(b64(header) + "." + b64(payload) + "."); err == nil {
+		t.Fatal("unsigned token accepted")
+	}
+}
+
+func TestUserFromClaims(t *testing.T) {
+	p := NewProvider(Config{Issuer: "https://example.org"})
+	u, err := p.UserFromClaims(Claims{"sub": "0815"})
+	if err != nil || u.Username != "0815" {
+		t.Fatal("sub is not used as fallback username")
+	}
+	if u.OidcSubject != "https://example.org|0815" {
+		t.Fatalf("unexpected subject %s", u.OidcSubject)
+	}
+	if _, err := p.UserFromClaims(Claims{"preferred_username": "alice"}); err == nil {
+		t.Fatal("user without sub")
+	}
+	if p.IsAdmin(Claims{"groups": []interface{}{"admins"}}) {
+		t.Fatal("admin without admin group configured")
+	}
+	if _, err := p.UserFromClaims(Claims{}); err == nil {
+		t.Fatal("user without username")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	payload, _ := json.Marshal(map[string]interface{}{"exp": 1700000000})
+	exp, ok := Expiry("Bearer e30." + b64(payload) + ".c2ln")
+	if !ok || exp.Unix() != 1700000000 {
+		t.Fatal("exp not found")
+	}
+	if _, ok := Expiry("OAuth abcdef"); ok {
+		t.Fatal("exp of non-JWT token")
+	}
+}
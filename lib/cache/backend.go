@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"github.com/MG-RAST/AWE/lib/core"
+)
+
+// Backend is a storage system that workunit inputs are read from and outputs are written to.
+// The backend of an IO is selected by core.IO.StorageScheme, i.e. by Shock node, IO.Host or url scheme.
+type Backend interface {
+	// Fetch downloads the file at location to local_path
+	Fetch(location string, local_path string, token string, uncompress string) (size int64, err error)
+	// Put uploads the output file at local_path (empty for outputs without file) to the location of io
+	// and updates io if the backend assigns a new location
+	Put(work *core.Workunit, io *core.IO, local_path string, attrfile_path string) (err error)
+}
+
+// VerifiedFetcher is implemented by backends that can verify a download against a known checksum
+type VerifiedFetcher interface {
+	FetchVerified(location string, local_path string, token string, uncompress string, md5 string, expected_size int64) (size int64, err error)
+}
+
+var backends = map[string]Backend{
+	core.STORAGE_SHOCK: &ShockBackend{},
+	core.STORAGE_HTTP:  &HTTPBackend{},
+	core.STORAGE_FILE:  &FileBackend{},
+	core.STORAGE_S3:    &S3Backend{},
+}
+
+// RegisterBackend adds or replaces the backend used for the storage scheme
+func RegisterBackend(scheme string, backend Backend) {
+	backends[scheme] = backend
+}
+
+func GetBackend(scheme string) (backend Backend, err error) {
+	backend, ok := backends[scheme]
+	if !ok {
+		err = fmt.Errorf("(GetBackend) no storage backend for scheme %s", scheme)
+		return
+	}
+	return
+}
+
+// GetBackendByUrl selects the backend for a location that is not described by a core.IO (e.g. CWL File)
+func GetBackendByUrl(location string) (backend Backend, err error) {
+	location_io := &core.IO{Url: location}
+	if u, xerr := url.Parse(location); xerr == nil && u.Scheme == "" {
+		location_io.Url = "file://" + location
+	}
+	return GetBackend(location_io.StorageScheme())
+}
+
+// writeStream writes body to local_path, optionally uncompressing it
+func writeStream(local_path string, body io.Reader, uncompress string) (size int64, err error) {
+	local_file, err := os.Create(local_path)
+	if err != nil {
+		return
+	}
+	defer local_file.Close()
+
+	switch uncompress {
+	case "":
+		size, err = io.Copy(local_file, body)
+	case "gzip":
+		gr, gerr := gzip.NewReader(body)
+		if gerr != nil {
+			err = gerr
+			return
+		}
+		defer gr.Close()
+		size, err = io.Copy(local_file, gr)
+	default:
+		err = fmt.Errorf("(writeStream) uncompress method unknown: %s", uncompress)
+	}
+	return
+}
@@ -75,27 +75,61 @@ func MoveInputIO(work *core.Workunit, io *core.IO, work_path string) (size int64
 		}
 
 		// only get file Part based on work.Partition
+		is_partition := false
 		if (work.Rank > 0) && (work.Partition != nil) && (work.Partition.Input == io.FileName) {
 			dataUrl = fmt.Sprintf("%s&index=%s&part=%s", dataUrl, work.Partition.Index, work.Part())
+			is_partition = true
 		}
 		logger.Debug(2, "mover: fetching input file from url:"+dataUrl)
 		logger.Event(event.FILE_IN, "workid="+work.Id+";url="+dataUrl)
 
-		// download file
+		backend, xerr := GetBackend(io.StorageScheme())
+		if xerr != nil {
+			err = xerr
+			return
+		}
+
+		// whole Shock nodes are verified against the md5 checksum of the node
+		verifier, can_verify := backend.(VerifiedFetcher)
+		var node_md5 string
+		var node_size int64
+		if can_verify && !is_partition && io.Node != "" && io.Node != "-" {
+			node, xerr := shock.ShockGet(io.Host, io.Node, work.Info.DataToken)
+			if xerr != nil {
+				err = fmt.Errorf("(MoveInputIO) shock.ShockGet returned: %s", xerr.Error())
+				return
+			}
+			node_md5 = node.File.Checksum["md5"]
+			node_size = node.File.Size
+			if node_md5 == "" {
+				logger.Warning("(MoveInputIO) Shock node %s has no md5 checksum, input %s is not verified", io.Node, io.FileName)
+			}
+		} else {
+			can_verify = false
+		}
+
+		// download file, interrupted downloads are resumed, corrupted downloads start over
 		retry := 1
 		for true {
-			datamoved, _, err := shock.FetchFile(inputFilePath, dataUrl, work.Info.DataToken, io.Uncompress, false)
+			var datamoved int64
+			var err error
+			if can_verify {
+				datamoved, err = verifier.FetchVerified(dataUrl, inputFilePath, work.Info.DataToken, io.Uncompress, node_md5, node_size)
+			} else {
+				datamoved, err = backend.Fetch(dataUrl, inputFilePath, work.Info.DataToken, io.Uncompress)
+			}
 			if err != nil {
-				if !strings.Contains(err.Error(), "Node has no file") {
-					logger.Debug(3, "(MoveInputData) got: %s", err.Error())
-					return size, err
-				}
-				logger.Debug(3, "(MoveInputData) got: Node has no file")
-				if retry >= 3 {
+				logger.Debug(3, "(MoveInputData) got: %s", err.Error())
+				// a missing file or token is not fixed by a retry, checksum mismatches and network errors are
+				if retry >= 3 || shock.IsPermanentError(err) {
 					return size, err
 				}
 				logger.Warning("(MoveInputData) Will retry download, got this error: %s", err.Error())
-				time.Sleep(time.Second * 20)
+				if strings.Contains(err.Error(), "Node has no file") {
+					time.Sleep(time.Second * 20)
+				} else {
+					time.Sleep(time.Second * 5)
+				}
 				retry += 1
 				continue
 			}
@@ -107,7 +141,7 @@ func MoveInputIO(work *core.Workunit, io *core.IO, work_path string) (size int64
 	}
 
 	// download node attributes if requested
-	if io.AttrFile != "" {
+	if io.AttrFile != "" && io.StorageScheme() == core.STORAGE_SHOCK {
 		// get node
 		node, xerr := shock.ShockGet(io.Host, io.Node, work.Info.DataToken)
 		if xerr != nil {
@@ -252,7 +286,11 @@ func DownloadFile(file *cwl.File, download_path string) (err error) {
 
 	//fmt.Printf("Using path %s\n", file_path)
 
-	_, _, err = shock.FetchFile(file_path, file.Location, "", "", false)
+	backend, err := GetBackendByUrl(file.Location)
+	if err != nil {
+		return
+	}
+	_, err = backend.Fetch(file.Location, file_path, "", "")
 	if err != nil {
 		return
 	}
@@ -618,11 +656,21 @@ func UploadOutputIO(work *core.Workunit, io *core.IO) (size int64, new_node_id s
 		size += fi.Size()
 
 	}
-	logger.Debug(1, "(UploadOutputIO) deliverer: push output to shock, filename="+name)
+	scheme := io.StorageScheme()
+	backend, err := GetBackend(scheme)
+	if err != nil {
+		return
+	}
+	if scheme != core.STORAGE_SHOCK && work.Rank > 0 {
+		err = fmt.Errorf("(UploadOutputIO) partitioned workunits can only write to Shock, output %s uses %s", name, scheme)
+		return
+	}
+
+	logger.Debug(1, "(UploadOutputIO) deliverer: push output to %s, filename=%s", scheme, name)
 	logger.Event(event.FILE_OUT,
 		"workid="+work.Id,
 		"filename="+name,
-		fmt.Sprintf("url=%s/node/%s", io.Host, io.Node))
+		"url="+outputLocation(io))
 
 	//upload attribute file to shock IF attribute file is specified in outputs AND it is found in local directory.
 	var attrfile_path string = ""
@@ -642,40 +690,21 @@ func UploadOutputIO(work *core.Workunit, io *core.IO) (size int64, new_node_id s
 		}
 	}
 
-	logger.Debug(1, "UploadOutputData, core.PutFileToShock: file_path: %s (io.Node: %s)", file_path, io.Node)
-	sc := shock.ShockClient{Host: io.Host, Token: work.Info.DataToken}
-	sc.Debug = true
-
-	new_node_id, err = sc.PutFileToShock(file_path, io.Node, work.Rank, attrfile_path, io.Type, io.FormOptions, io.NodeAttr)
+	old_node := io.Node
+	err = backend.Put(work, io, file_path, attrfile_path)
 	if err != nil {
-
-		time.Sleep(3 * time.Second) //wait for 3 seconds and try again
-		new_node_id, err = sc.PutFileToShock(file_path, io.Node, work.Rank, attrfile_path, io.Type, io.FormOptions, io.NodeAttr)
-		if err != nil {
-			err = fmt.Errorf("push file error: %s", err.Error())
-			logger.Error("op=pushfile,err=" + err.Error())
-			return
-		}
+		return
 	}
-
-	if new_node_id != "" {
-		io.Node = new_node_id
-	}
-
-	// worker only index if not parts node, otherwise server is responsible
-	if (io.ShockIndex != "") && (work.Rank == 0) {
-		sc := shock.ShockClient{Host: io.Host, Token: work.Info.DataToken}
-		if err := sc.ShockPutIndex(io.Node, io.ShockIndex); err != nil {
-			logger.Error("warning: fail to create index on shock for shock node: " + io.Node)
-		}
+	if io.Node != old_node {
+		new_node_id = io.Node
 	}
 
 	logger.Event(event.FILE_DONE,
 		"workid="+work.Id,
 		"filename="+name,
-		fmt.Sprintf("url=%s/node/%s", io.Host, io.Node))
+		"url="+outputLocation(io))
 
-	if conf.CACHE_ENABLED {
+	if conf.CACHE_ENABLED && scheme == core.STORAGE_SHOCK {
 		//move output files to cache
 		cacheDir := getCacheDir(io.Node)
 		if err := os.MkdirAll(cacheDir, 0777); err != nil {
@@ -690,6 +719,14 @@ func UploadOutputIO(work *core.Workunit, io *core.IO) (size int64, new_node_id s
 	return
 }
 
+// outputLocation is used for logging
+func outputLocation(io *core.IO) string {
+	if io.StorageScheme() == core.STORAGE_SHOCK {
+		return fmt.Sprintf("%s/node/%s", io.Host, io.Node)
+	}
+	return io.Url
+}
+
 func UploadOutputData(work *core.Workunit, shock_client *shock.ShockClient) (size int64, err error) {
 
 	if work.CWL_workunit != nil {
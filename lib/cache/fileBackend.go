@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/shock"
+)
+
+// FileBackend reads and writes file:// urls on a filesystem shared by server and workers (e.g. Lustre).
+// Only paths below the configured roots are accepted, symlinks are resolved before the check.
+type FileBackend struct {
+	Roots []string // empty means conf.FILE_ROOTS
+}
+
+func (b *FileBackend) roots() (roots []string) {
+	list := b.Roots
+	if len(list) == 0 {
+		list = strings.Split(conf.FILE_ROOTS, ",")
+	}
+	for _, root := range list {
+		root = strings.TrimSpace(root)
+		if root == "" || !filepath.IsAbs(root) {
+			continue
+		}
+		root = filepath.Clean(root)
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			root = resolved
+		}
+		roots = append(roots, root)
+	}
+	return
+}
+
+func filePath(location string) (file_path string, err error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		err = fmt.Errorf("(filePath) url.Parse returned: %s", err.Error())
+		return
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		err = fmt.Errorf("(filePath) file url with remote host not supported: %s", location)
+		return
+	}
+	if !path.IsAbs(u.Path) {
+		err = fmt.Errorf("(filePath) file url needs absolute path: %s", location)
+		return
+	}
+	file_path = filepath.Clean(u.Path)
+	return
+}
+
+// checkRoots returns an error if file_path is not one of the roots or below one of them
+func checkRoots(file_path string, roots []string) (err error) {
+	for _, root := range roots {
+		if file_path == root || strings.HasPrefix(file_path, strings.TrimSuffix(root, "/")+"/") {
+			return
+		}
+	}
+	err = fmt.Errorf("(checkRoots) path %s is not below the allowed roots (file_roots)", file_path)
+	return
+}
+
+// sourcePath returns the resolved path of an existing file below the roots
+func (b *FileBackend) sourcePath(location string) (source string, err error) {
+	source, err = filePath(location)
+	if err != nil {
+		return
+	}
+	source, err = filepath.EvalSymlinks(source)
+	if err != nil {
+		err = fmt.Errorf("(FileBackend) filepath.EvalSymlinks returned: %s", err.Error())
+		return
+	}
+	err = checkRoots(source, b.roots())
+	return
+}
+
+// targetPath creates the directory of the output and returns the resolved path below the roots
+func (b *FileBackend) targetPath(location string) (target string, err error) {
+	target, err = filePath(location)
+	if err != nil {
+		return
+	}
+	roots := b.roots()
+	err = checkRoots(target, roots)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(target), 0777)
+	if err != nil {
+		err = fmt.Errorf("(FileBackend) os.MkdirAll returned: %s", err.Error())
+		return
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(target))
+	if err != nil {
+		err = fmt.Errorf("(FileBackend) filepath.EvalSymlinks returned: %s", err.Error())
+		return
+	}
+	target = filepath.Join(dir, filepath.Base(target))
+	if fi, xerr := os.Lstat(target); xerr == nil && fi.Mode()&os.ModeSymlink != 0 {
+		// the copy would be written through the link
+		err = fmt.Errorf("(FileBackend) output %s is a symlink", target)
+		return
+	}
+	err = checkRoots(target, roots)
+	return
+}
+
+// Fetch creates a symlink to the shared file, unless conf.NO_SYMLINK is set or the file has to be uncompressed
+func (b *FileBackend) Fetch(location string, local_path string, token string, uncompress string) (size int64, err error) {
+	source, err := b.sourcePath(location)
+	if err != nil {
+		return
+	}
+	fi, err := os.Stat(source)
+	if err != nil {
+		err = fmt.Errorf("(FileBackend/Fetch) os.Stat returned: %s", err.Error())
+		return
+	}
+	if uncompress != "" {
+		var source_file *os.File
+		source_file, err = os.Open(source)
+		if err != nil {
+			return
+		}
+		defer source_file.Close()
+		size, err = writeStream(local_path, source_file, uncompress)
+		return
+	}
+	if conf.NO_SYMLINK {
+		size, err = shock.CopyFile(source, local_path)
+		return
+	}
+	logger.Debug(1, "(FileBackend/Fetch) symlink: %s -> %s", local_path, source)
+	err = os.Symlink(source, local_path)
+	if err != nil {
+		err = fmt.Errorf("(FileBackend/Fetch) os.Symlink returned: %s", err.Error())
+		return
+	}
+	size = fi.Size()
+	return
+}
+
+func (b *FileBackend) Put(work *core.Workunit, io *core.IO, local_path string, attrfile_path string) (err error) {
+	if local_path == "" {
+		return
+	}
+	target, err := b.targetPath(io.Url)
+	if err != nil {
+		return
+	}
+	// a rename is only possible on the same filesystem, copy otherwise
+	if os.Rename(local_path, target) == nil {
+		return
+	}
+	_, err = shock.CopyFile(local_path, target)
+	if err != nil {
+		err = fmt.Errorf("(FileBackend/Put) CopyFile returned: %s", err.Error())
+	}
+	return
+}
@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/MG-RAST/AWE/lib/core"
+)
+
+func TestFileBackendRoots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filebackend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := path.Join(dir, "shared")
+	outside := path.Join(dir, "outside")
+	for _, d := range []string{root, outside} {
+		if err = os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = ioutil.WriteFile(path.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a link inside the root must not give access to files outside
+	if err = os.Symlink(outside, path.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := &FileBackend{Roots: []string{root}}
+
+	source := path.Join(dir, "output.txt")
+	if err = ioutil.WriteFile(source, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := &core.IO{FileName: "output.txt", Url: "file://" + root + "/job/output.txt"}
+	if err = backend.Put(nil, output, source, ""); err != nil {
+		t.Fatalf("Put returned: %s", err.Error())
+	}
+	size, err := backend.Fetch(output.Url, path.Join(dir, "input.txt"), "", "")
+	if err != nil {
+		t.Fatalf("Fetch returned: %s", err.Error())
+	}
+	if size != 5 {
+		t.Fatalf("unexpected size %d", size)
+	}
+
+	for _, location := range []string{
+		"file://" + outside + "/secret.txt",
+		"file://" + root + "/../outside/secret.txt",
+		"file://" + root + "/link/secret.txt",
+	} {
+		if _, err = backend.Fetch(location, path.Join(dir, "fetched.txt"), "", ""); err == nil {
+			t.Fatalf("Fetch of %s should be rejected", location)
+		}
+		os.Remove(path.Join(dir, "fetched.txt"))
+
+		if err = ioutil.WriteFile(source, []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err = backend.Put(nil, &core.IO{FileName: "secret.txt", Url: location}, source, ""); err == nil {
+			t.Fatalf("Put to %s should be rejected", location)
+		}
+	}
+	content, _ := ioutil.ReadFile(path.Join(outside, "secret.txt"))
+	if string(content) != "secret" {
+		t.Fatalf("file outside of the roots was modified")
+	}
+
+	if _, err = (&FileBackend{}).Fetch(output.Url, path.Join(dir, "noroots.txt"), "", ""); err == nil {
+		t.Fatalf("Fetch without configured roots should be rejected")
+	}
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/shock"
+)
+
+// HTTPBackend reads plain http(s) urls and writes outputs with HTTP PUT, the Shock token is not sent
+type HTTPBackend struct{}
+
+func (b *HTTPBackend) Fetch(location string, local_path string, token string, uncompress string) (size int64, err error) {
+	res, err := http.Get(location)
+	if err != nil {
+		err = fmt.Errorf("(HTTPBackend/Fetch) http.Get returned: %s", err.Error())
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		err = &shock.StatusError{Url: location, StatusCode: res.StatusCode, Body: string(body)}
+		return
+	}
+	size, err = writeStream(local_path, res.Body, uncompress)
+	return
+}
+
+func (b *HTTPBackend) Put(work *core.Workunit, io *core.IO, local_path string, attrfile_path string) (err error) {
+	if local_path == "" {
+		return
+	}
+	file, err := os.Open(local_path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("PUT", io.Url, file)
+	if err != nil {
+		err = fmt.Errorf("(HTTPBackend/Put) http.NewRequest returned: %s", err.Error())
+		return
+	}
+	req.ContentLength = fi.Size()
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		err = fmt.Errorf("(HTTPBackend/Put) PUT %s returned: %s", io.Url, err.Error())
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(res.Body)
+		err = fmt.Errorf("(HTTPBackend/Put) url=%s, status=%d, res=%s", io.Url, res.StatusCode, body)
+		return
+	}
+	return
+}
@@ -0,0 +1,214 @@
+package cache
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/shock"
+)
+
+const s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Backend reads and writes s3://bucket/key urls on an S3 compatible storage (AWS, MinIO, Ceph),
+// using path-style requests signed with AWS signature version 4. Empty fields are taken from conf.
+// Objects are uploaded with a single PUT, which limits outputs to 5GB. Only the configured buckets are accepted.
+type S3Backend struct {
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Buckets   []string
+}
+
+// checkBucket returns an error if the bucket is not in b.Buckets, or conf.S3_BUCKETS if that is empty
+func (b *S3Backend) checkBucket(bucket string) (err error) {
+	buckets := b.Buckets
+	if len(buckets) == 0 {
+		buckets = strings.Split(conf.S3_BUCKETS, ",")
+	}
+	for _, allowed := range buckets {
+		if strings.TrimSpace(allowed) == bucket {
+			return
+		}
+	}
+	err = fmt.Errorf("(S3Backend) bucket %s is not allowed (s3_buckets)", bucket)
+	return
+}
+
+func (b *S3Backend) config() (endpoint string, region string, access_key string, secret_key string) {
+	endpoint, region, access_key, secret_key = b.Endpoint, b.Region, b.AccessKey, b.SecretKey
+	if endpoint == "" {
+		endpoint = conf.S3_ENDPOINT
+	}
+	if region == "" {
+		region = conf.S3_REGION
+	}
+	if access_key == "" {
+		access_key = conf.S3_ACCESS_KEY
+		secret_key = conf.S3_SECRET_KEY
+	}
+	return
+}
+
+func parseS3Url(location string) (bucket string, key string, err error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		err = fmt.Errorf("(parseS3Url) url.Parse returned: %s", err.Error())
+		return
+	}
+	bucket = u.Host
+	key = strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != core.STORAGE_S3 || bucket == "" || key == "" {
+		err = fmt.Errorf("(parseS3Url) not a valid s3 url, expected s3://bucket/key: %s", location)
+		return
+	}
+	return
+}
+
+// s3Escape encodes the path as required for the canonical request, slashes are kept
+func s3Escape(p string) string {
+	var buf strings.Builder
+	for _, c := range []byte(p) {
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '.' || c == '_' || c == '~' || c == '/' {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func (b *S3Backend) newRequest(method string, location string, body io.Reader, payload_hash string) (req *http.Request, err error) {
+	bucket, key, err := parseS3Url(location)
+	if err != nil {
+		return
+	}
+	err = b.checkBucket(bucket)
+	if err != nil {
+		return
+	}
+	endpoint, region, access_key, secret_key := b.config()
+	endpoint_url, err := url.Parse(endpoint)
+	if err != nil || endpoint_url.Host == "" {
+		err = fmt.Errorf("(S3Backend/newRequest) invalid s3 endpoint: %s", endpoint)
+		return
+	}
+	object_path := strings.TrimSuffix(endpoint_url.Path, "/") + "/" + bucket + "/" + key
+	canonical_uri := s3Escape(object_path)
+	req, err = http.NewRequest(method, endpoint_url.Scheme+"://"+endpoint_url.Host+canonical_uri, body)
+	if err != nil {
+		err = fmt.Errorf("(S3Backend/newRequest) http.NewRequest returned: %s", err.Error())
+		return
+	}
+	if access_key == "" {
+		// anonymous access to public buckets
+		return
+	}
+	s3Sign(req, canonical_uri, payload_hash, region, access_key, secret_key, time.Now().UTC())
+	return
+}
+
+func s3Sign(req *http.Request, canonical_uri string, payload_hash string, region string, access_key string, secret_key string, t time.Time) {
+	amz_date := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	req.Header.Set("x-amz-date", amz_date)
+	req.Header.Set("x-amz-content-sha256", payload_hash)
+
+	signed_headers := "host;x-amz-content-sha256;x-amz-date"
+	canonical_headers := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payload_hash + "\n" +
+		"x-amz-date:" + amz_date + "\n"
+	canonical_request := strings.Join([]string{req.Method, canonical_uri, req.URL.RawQuery, canonical_headers, signed_headers, payload_hash}, "\n")
+	canonical_hash := sha256.Sum256([]byte(canonical_request))
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	string_to_sign := "AWS4-HMAC-SHA256\n" + amz_date + "\n" + scope + "\n" + hex.EncodeToString(canonical_hash[:])
+
+	signing_key := s3Hmac([]byte("AWS4"+secret_key), date)
+	signing_key = s3Hmac(signing_key, region)
+	signing_key = s3Hmac(signing_key, "s3")
+	signing_key = s3Hmac(signing_key, "aws4_request")
+	signature := hex.EncodeToString(s3Hmac(signing_key, string_to_sign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", access_key, scope, signed_headers, signature))
+	return
+}
+
+func s3Hmac(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func (b *S3Backend) Fetch(location string, local_path string, token string, uncompress string) (size int64, err error) {
+	req, err := b.newRequest("GET", location, nil, s3EmptyPayloadHash)
+	if err != nil {
+		return
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		err = fmt.Errorf("(S3Backend/Fetch) GET %s returned: %s", location, err.Error())
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		err = &shock.StatusError{Url: location, StatusCode: res.StatusCode, Body: string(body)}
+		return
+	}
+	size, err = writeStream(local_path, res.Body, uncompress)
+	return
+}
+
+func (b *S3Backend) Put(work *core.Workunit, output *core.IO, local_path string, attrfile_path string) (err error) {
+	if local_path == "" {
+		return
+	}
+	file, err := os.Open(local_path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	// the payload hash is part of the signature, this needs a first pass over the file
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		err = fmt.Errorf("(S3Backend/Put) reading %s returned: %s", local_path, err.Error())
+		return
+	}
+	_, err = file.Seek(0, 0)
+	if err != nil {
+		return
+	}
+
+	req, err := b.newRequest("PUT", output.Url, file, hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return
+	}
+	req.ContentLength = size
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		err = fmt.Errorf("(S3Backend/Put) PUT %s returned: %s", output.Url, err.Error())
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		err = fmt.Errorf("(S3Backend/Put) url=%s, status=%d, res=%s", output.Url, res.StatusCode, body)
+		return
+	}
+	return
+}
@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/core"
+)
+
+func TestS3Sign(t *testing.T) {
+	req, _ := http.NewRequest("PUT", "http://minio:9000/bucket/dir/a%20b.txt", nil)
+	payload_hash := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" // sha256("hello")
+	s3Sign(req, "/bucket/dir/a%20b.txt", payload_hash, "us-east-1", "AKID", "SECRET", time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKID/20260102/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=7a31179c44d67a5605dae375a844d40138076082ccbcbda29d6507920781e0be"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Fatalf("Authorization header:\n got: %s\nwant: %s", got, expected)
+	}
+}
+
+// TestS3BackendRoundTrip uploads and downloads a file through an in-memory stand-in for MinIO
+func TestS3BackendRoundTrip(t *testing.T) {
+	var lock sync.Mutex
+	objects := make(map[string][]byte)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=AKID/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		switch r.Method {
+		case "PUT":
+			body, _ := ioutil.ReadAll(r.Body)
+			sum := sha256.Sum256(body)
+			if r.Header.Get("x-amz-content-sha256") != hex.EncodeToString(sum[:]) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			objects[r.URL.Path] = body
+		case "GET":
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(body)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "s3backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := path.Join(dir, "source.txt")
+	if err = ioutil.WriteFile(source, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := &S3Backend{Endpoint: srv.URL, Region: "us-east-1", AccessKey: "AKID", SecretKey: "SECRET", Buckets: []string{"bucket"}}
+	output := &core.IO{FileName: "source.txt", Url: "s3://bucket/job/source.txt"}
+	if output.StorageScheme() != core.STORAGE_S3 {
+		t.Fatalf("expected storage scheme %s, got %s", core.STORAGE_S3, output.StorageScheme())
+	}
+
+	if err = backend.Put(nil, output, source, ""); err != nil {
+		t.Fatalf("Put returned: %s", err.Error())
+	}
+	if _, ok := objects["/bucket/job/source.txt"]; !ok {
+		t.Fatalf("object was not stored")
+	}
+
+	target := path.Join(dir, "target.txt")
+	size, err := backend.Fetch(output.Url, target, "", "")
+	if err != nil {
+		t.Fatalf("Fetch returned: %s", err.Error())
+	}
+	content, _ := ioutil.ReadFile(target)
+	if size != 5 || string(content) != "hello" {
+		t.Fatalf("unexpected content: size=%d content=%q", size, content)
+	}
+
+	if _, err = backend.Fetch("s3://bucket/missing", path.Join(dir, "missing"), "", ""); err == nil {
+		t.Fatalf("expected error for missing object")
+	}
+
+	if _, err = backend.Fetch("s3://other/job/source.txt", path.Join(dir, "other"), "", ""); err == nil {
+		t.Fatalf("expected error for bucket that is not allowed")
+	}
+}
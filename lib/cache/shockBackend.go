@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/shock"
+)
+
+// ShockBackend reads and writes Shock nodes, this is the default backend
+type ShockBackend struct{}
+
+// Fetch downloads without checksum, e.g. partitions of a node. A partial file of an earlier attempt
+// cannot be verified after resuming, so the download starts over.
+func (b *ShockBackend) Fetch(location string, local_path string, token string, uncompress string) (size int64, err error) {
+	if err = os.Remove(local_path + ".part"); err != nil && !os.IsNotExist(err) {
+		err = fmt.Errorf("(ShockBackend/Fetch) removing partial download returned: %s", err.Error())
+		return
+	}
+	size, _, err = shock.FetchFileResumable(local_path, location, token, uncompress, "", 0, 1)
+	return
+}
+
+// FetchVerified downloads large nodes with parallel range requests and verifies the md5 checksum of the node
+func (b *ShockBackend) FetchVerified(location string, local_path string, token string, uncompress string, md5 string, expected_size int64) (size int64, err error) {
+	threads := 1
+	if conf.DOWNLOAD_PARALLEL > 0 && expected_size > int64(conf.DOWNLOAD_PARALLEL)*1024*1024 {
+		threads = conf.DOWNLOAD_THREADS
+	}
+	size, _, err = shock.FetchFileResumable(local_path, location, token, uncompress, md5, expected_size, threads)
+	return
+}
+
+func (b *ShockBackend) Put(work *core.Workunit, io *core.IO, local_path string, attrfile_path string) (err error) {
+	logger.Debug(1, "(ShockBackend/Put) PutFileToShock: file_path: %s (io.Node: %s)", local_path, io.Node)
+	sc := shock.ShockClient{Host: io.Host, Token: work.Info.DataToken}
+	sc.Debug = true
+
+	if chunked, fi := useChunkedUpload(work, io, local_path); chunked {
+		err = b.putChunked(&sc, io, local_path, attrfile_path, fi)
+		if err != nil {
+			time.Sleep(3 * time.Second) //wait for 3 seconds and resume the upload
+			err = b.putChunked(&sc, io, local_path, attrfile_path, fi)
+			if err != nil {
+				err = fmt.Errorf("push file error: %s", err.Error())
+				logger.Error("op=pushfile,err=" + err.Error())
+				return
+			}
+		}
+		b.putIndex(work, io)
+		return
+	}
+
+	new_node_id, err := sc.PutFileToShock(local_path, io.Node, work.Rank, attrfile_path, io.Type, io.FormOptions, io.NodeAttr)
+	if err != nil {
+
+		time.Sleep(3 * time.Second) //wait for 3 seconds and try again
+		new_node_id, err = sc.PutFileToShock(local_path, io.Node, work.Rank, attrfile_path, io.Type, io.FormOptions, io.NodeAttr)
+		if err != nil {
+			err = fmt.Errorf("push file error: %s", err.Error())
+			logger.Error("op=pushfile,err=" + err.Error())
+			return
+		}
+	}
+
+	if new_node_id != "" {
+		io.Node = new_node_id
+	}
+
+	b.putIndex(work, io)
+	return
+}
+
+func (b *ShockBackend) putIndex(work *core.Workunit, io *core.IO) {
+	// worker only index if not parts node, otherwise server is responsible
+	if (io.ShockIndex != "") && (work.Rank == 0) {
+		sc := shock.ShockClient{Host: io.Host, Token: work.Info.DataToken}
+		if err := sc.ShockPutIndex(io.Node, io.ShockIndex); err != nil {
+			logger.Error("warning: fail to create index on shock for shock node: " + io.Node)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/shock"
+)
+
+// uploadJournal records the progress of a chunked upload in the work directory,
+// an interrupted upload of the same file continues with the missing parts
+type uploadJournal struct {
+	Host        string    `json:"host"`
+	Node        string    `json:"node"`
+	File        string    `json:"file"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	ChunkSize   int64     `json:"chunk_size"`
+	Parts       int       `json:"parts"`
+	Md5         string    `json:"md5"`
+	Initialized bool      `json:"initialized"`
+	Done        []int     `json:"done"`
+	path        string
+	lock        sync.Mutex
+}
+
+func journalPath(local_path string) string {
+	return path.Join(path.Dir(local_path), "."+path.Base(local_path)+".upload")
+}
+
+// useChunkedUpload decides if an output is uploaded in parts, partitioned workunits, copy and subset nodes use the regular upload
+func useChunkedUpload(work *core.Workunit, output *core.IO, local_path string) (ok bool, fi os.FileInfo) {
+	if conf.SHOCK_CHUNK_SIZE <= 0 || local_path == "" || work.Rank != 0 {
+		return
+	}
+	if output.Type == "copy" || output.Type == "subset" || len(output.FormOptions) > 0 {
+		return
+	}
+	fi, err := os.Stat(local_path)
+	if err != nil {
+		return
+	}
+	ok = fi.Size() > int64(conf.SHOCK_CHUNK_SIZE)*1024*1024
+	return
+}
+
+// loadJournal returns the journal of a previous attempt if it matches the file, otherwise a new journal
+func loadJournal(host string, nodeid string, local_path string, fi os.FileInfo, chunk_size int64) (journal *uploadJournal, err error) {
+	journal_path := journalPath(local_path)
+	if data, xerr := ioutil.ReadFile(journal_path); xerr == nil {
+		old := &uploadJournal{}
+		if json.Unmarshal(data, old) == nil &&
+			old.Host == host &&
+			(nodeid == "" || old.Node == nodeid) &&
+			old.File == path.Base(local_path) &&
+			old.Size == fi.Size() &&
+			old.ModTime.Equal(fi.ModTime()) &&
+			old.ChunkSize == chunk_size {
+			old.path = journal_path
+			logger.Info("(loadJournal) resuming upload of %s, %d of %d parts done", local_path, len(old.Done), old.Parts)
+			journal = old
+			return
+		}
+		logger.Warning("(loadJournal) ignoring upload journal %s, it does not match the output", journal_path)
+	}
+
+	journal = &uploadJournal{
+		Host:      host,
+		Node:      nodeid,
+		File:      path.Base(local_path),
+		Size:      fi.Size(),
+		ModTime:   fi.ModTime(),
+		ChunkSize: chunk_size,
+		Parts:     int((fi.Size() + chunk_size - 1) / chunk_size),
+		Done:      []int{},
+		path:      journal_path,
+	}
+	journal.Md5, err = shock.FileMd5(local_path)
+	if err != nil {
+		err = fmt.Errorf("(loadJournal) FileMd5 returned: %s", err.Error())
+		return
+	}
+	err = journal.save()
+	return
+}
+
+// save expects the lock to be held (or no concurrent access)
+func (j *uploadJournal) save() (err error) {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return
+	}
+	tmp_path := j.path + ".tmp"
+	err = ioutil.WriteFile(tmp_path, data, 0644)
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmp_path, j.path)
+	return
+}
+
+func (j *uploadJournal) isDone(part int) bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	for _, p := range j.Done {
+		if p == part {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *uploadJournal) setDone(part int) (err error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	for _, p := range j.Done {
+		if p == part {
+			return
+		}
+	}
+	j.Done = append(j.Done, part)
+	sort.Ints(j.Done)
+	err = j.save()
+	return
+}
+
+// putChunked uploads local_path to Shock in parts of conf.SHOCK_CHUNK_SIZE, conf.SHOCK_UPLOAD_THREADS parts in parallel.
+// Each part is retried conf.SHOCK_CHUNK_RETRIES times, the complete file is verified by md5.
+func (b *ShockBackend) putChunked(sc *shock.ShockClient, output *core.IO, local_path string, attrfile_path string, fi os.FileInfo) (err error) {
+	chunk_size := int64(conf.SHOCK_CHUNK_SIZE) * 1024 * 1024
+	journal, err := loadJournal(sc.Host, output.Node, local_path, fi, chunk_size)
+	if err != nil {
+		return
+	}
+
+	if journal.Node == "" {
+		var node *shock.ShockNode
+		node, err = sc.CreateOrUpdate(shock.Opts{}, "", nil)
+		if err != nil {
+			err = fmt.Errorf("(ShockBackend/putChunked) creating node returned: %s", err.Error())
+			return
+		}
+		journal.Node = node.Id
+		journal.save()
+	}
+
+	if !journal.Initialized {
+		if attrfile_path != "" || len(output.NodeAttr) > 0 {
+			opts := shock.Opts{}
+			if attrfile_path != "" {
+				opts["attributes"] = attrfile_path
+			}
+			_, err = sc.CreateOrUpdate(opts, journal.Node, output.NodeAttr)
+			if err != nil {
+				err = fmt.Errorf("(ShockBackend/putChunked) setting attributes returned: %s", err.Error())
+				return
+			}
+		}
+		err = sc.SetParts(journal.Node, journal.File, journal.Parts)
+		if err != nil {
+			err = fmt.Errorf("(ShockBackend/putChunked) SetParts returned: %s", err.Error())
+			return
+		}
+		journal.Initialized = true
+		journal.save()
+	} else {
+		// parts may have been received by Shock without the response reaching us
+		if node, xerr := sc.Get_node(journal.Node); xerr == nil {
+			for part, _ := range node.UploadedParts() {
+				journal.setDone(part)
+			}
+		}
+	}
+
+	file, err := os.Open(local_path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	threads := conf.SHOCK_UPLOAD_THREADS
+	if threads < 1 {
+		threads = 1
+	}
+	todo := make(chan int, journal.Parts)
+	for part := 1; part <= journal.Parts; part++ {
+		if !journal.isDone(part) {
+			todo <- part
+		}
+	}
+	close(todo)
+
+	var wg sync.WaitGroup
+	var err_lock sync.Mutex
+	var part_errors []string
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range todo {
+				if perr := b.putPart(sc, journal, file, part); perr != nil {
+					err_lock.Lock()
+					part_errors = append(part_errors, perr.Error())
+					err_lock.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(part_errors) > 0 {
+		err = fmt.Errorf("(ShockBackend/putChunked) %d parts of %s failed, first error: %s", len(part_errors), local_path, part_errors[0])
+		return
+	}
+
+	node, err := sc.WaitFile(journal.Node)
+	if err != nil {
+		err = fmt.Errorf("(ShockBackend/putChunked) WaitFile returned: %s", err.Error())
+		return
+	}
+	remote_md5 := node.File.Checksum["md5"]
+	if remote_md5 == "" {
+		logger.Warning("(ShockBackend/putChunked) Shock node %s has no md5 checksum, upload of %s not verified", journal.Node, local_path)
+	} else if remote_md5 != journal.Md5 {
+		os.Remove(journal.path)
+		err = fmt.Errorf("(ShockBackend/putChunked) md5 mismatch for %s, node %s: local=%s, shock=%s", local_path, journal.Node, journal.Md5, remote_md5)
+		// the parts of the node are complete and cannot be replaced, the next attempt uploads to a new node
+		if xerr := shock.ShockDelete(sc.Host, journal.Node, sc.Token); xerr != nil {
+			logger.Error("(ShockBackend/putChunked) could not delete node %s: %s", journal.Node, xerr.Error())
+		}
+		output.Node = ""
+		return
+	}
+
+	os.Remove(journal.path)
+	output.Node = journal.Node
+	return
+}
+
+func (b *ShockBackend) putPart(sc *shock.ShockClient, journal *uploadJournal, file *os.File, part int) (err error) {
+	offset := int64(part-1) * journal.ChunkSize
+	size := journal.ChunkSize
+	if offset+size > journal.Size {
+		size = journal.Size - offset
+	}
+	for attempt := 0; attempt <= conf.SHOCK_CHUNK_RETRIES; attempt++ {
+		if attempt > 0 {
+			logger.Warning("(ShockBackend/putPart) retrying part %d of node %s (attempt %d): %s", part, journal.Node, attempt, err.Error())
+			time.Sleep(time.Duration(3*attempt) * time.Second)
+		}
+		err = sc.PutPart(journal.Node, part, io.NewSectionReader(file, offset, size), size)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return
+	}
+	logger.Debug(2, "(ShockBackend/putPart) node %s part %d/%d uploaded", journal.Node, part, journal.Parts)
+	if xerr := journal.setDone(part); xerr != nil {
+		logger.Error("(ShockBackend/putPart) could not write upload journal %s: %s", journal.path, xerr.Error())
+	}
+	return
+}
@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/shock"
+)
+
+// fakeShock implements the parts upload of a single Shock node
+type fakeShock struct {
+	sync.Mutex
+	parts    [][]byte
+	uploads  map[int]int
+	failPart int
+	corrupt  bool // report a wrong md5 for the complete file
+	nodes    int
+	deleted  []string
+}
+
+func (f *fakeShock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	response := shock.ShockResponse{Code: 200}
+	response.Data.Id = "node" + strconv.Itoa(f.nodes)
+
+	switch r.Method {
+	case "POST":
+		f.nodes += 1
+		f.parts = nil
+		response.Data.Id = "node" + strconv.Itoa(f.nodes)
+	case "DELETE":
+		f.deleted = append(f.deleted, path.Base(r.URL.Path))
+		json.NewEncoder(w).Encode(response)
+		return
+	case "PUT":
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if count := r.FormValue("parts"); count != "" {
+			n, _ := strconv.Atoi(count)
+			f.parts = make([][]byte, n)
+		}
+		for name, files := range r.MultipartForm.File {
+			part, _ := strconv.Atoi(name)
+			if part == f.failPart {
+				response.Errs = []string{"simulated failure"}
+				continue
+			}
+			file, _ := files[0].Open()
+			f.parts[part-1], _ = ioutil.ReadAll(file)
+			file.Close()
+			f.uploads[part] += 1
+		}
+	case "GET":
+	}
+
+	complete := len(f.parts) > 0
+	var content []byte
+	for _, p := range f.parts {
+		if p == nil {
+			complete = false
+		}
+		content = append(content, p...)
+	}
+	if complete {
+		if f.corrupt {
+			content = append(content, '!')
+		}
+		response.Data.File.Checksum = map[string]string{"md5": fmt.Sprintf("%x", md5.Sum(content))}
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func TestShockChunkedUploadResume(t *testing.T) {
+	logger.Initialize("test")
+	conf.SHOCK_CHUNK_SIZE = 1
+	conf.SHOCK_UPLOAD_THREADS = 2
+	conf.SHOCK_CHUNK_RETRIES = 0
+
+	fake := &fakeShock{uploads: make(map[int]int), failPart: 2, nodes: 1}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "shockupload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 2.5 MB, i.e. three parts
+	data := bytes.Repeat([]byte("0123456789"), 256*1024)
+	local_path := path.Join(dir, "output.txt")
+	if err = ioutil.WriteFile(local_path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := os.Stat(local_path)
+
+	work := &core.Workunit{}
+	output := &core.IO{FileName: "output.txt", Host: srv.URL, Node: "node1"}
+	if ok, _ := useChunkedUpload(work, output, local_path); !ok {
+		t.Fatalf("expected chunked upload for %d bytes", fi.Size())
+	}
+
+	backend := &ShockBackend{}
+	sc := &shock.ShockClient{Host: srv.URL}
+	if err = backend.putChunked(sc, output, local_path, "", fi); err == nil {
+		t.Fatalf("expected error for failing part")
+	}
+	if _, err = os.Stat(journalPath(local_path)); err != nil {
+		t.Fatalf("upload journal missing after failure: %s", err.Error())
+	}
+
+	fake.failPart = 0
+	if err = backend.putChunked(sc, output, local_path, "", fi); err != nil {
+		t.Fatalf("resumed upload returned: %s", err.Error())
+	}
+	if fake.uploads[1] != 1 || fake.uploads[2] != 1 || fake.uploads[3] != 1 {
+		t.Fatalf("each part should be uploaded exactly once, got %v", fake.uploads)
+	}
+	if _, err = os.Stat(journalPath(local_path)); !os.IsNotExist(err) {
+		t.Fatalf("upload journal should be removed after success")
+	}
+}
+
+func TestShockChunkedUploadMd5Mismatch(t *testing.T) {
+	logger.Initialize("test")
+	conf.SHOCK_CHUNK_SIZE = 1
+	conf.SHOCK_UPLOAD_THREADS = 2
+	conf.SHOCK_CHUNK_RETRIES = 0
+
+	fake := &fakeShock{uploads: make(map[int]int), corrupt: true}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "shockupload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := bytes.Repeat([]byte("0123456789"), 256*1024)
+	local_path := path.Join(dir, "output.txt")
+	if err = ioutil.WriteFile(local_path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := os.Stat(local_path)
+
+	backend := &ShockBackend{}
+	sc := &shock.ShockClient{Host: srv.URL}
+	output := &core.IO{FileName: "output.txt", Host: srv.URL}
+	if err = backend.putChunked(sc, output, local_path, "", fi); err == nil {
+		t.Fatalf("expected md5 mismatch")
+	}
+	if len(fake.deleted) != 1 || fake.deleted[0] != "node1" {
+		t.Fatalf("node with wrong content should be deleted, deleted: %v", fake.deleted)
+	}
+
+	fake.corrupt = false
+	if err = backend.putChunked(sc, output, local_path, "", fi); err != nil {
+		t.Fatalf("second upload returned: %s", err.Error())
+	}
+	if output.Node != "node2" {
+		t.Fatalf("second upload should use a new node, got %s", output.Node)
+	}
+}
@@ -50,12 +50,13 @@ func (a *ClientGroupAcl) Set(str string, r Rights) {
 	return
 }
 
-func (a *ClientGroupAcl) Check(str string) (r Rights) {
+// Check returns the rights of the given ids, usually a user uuid and the groups ("group:<id>") of the user
+func (a *ClientGroupAcl) Check(ids ...string) (r Rights) {
 	r = Rights{"read": false, "write": false, "delete": false, "execute": false}
 	acls := map[string][]string{"read": a.Read, "write": a.Write, "delete": a.Delete, "execute": a.Execute}
 	for k, v := range acls {
 		for _, id := range v {
-			if str == id {
+			if contains(ids, id) {
 				r[k] = true
 				break
 			}
@@ -64,6 +65,15 @@ func (a *ClientGroupAcl) Check(str string) (r Rights) {
 	return
 }
 
+func contains(arr []string, s string) bool {
+	for _, item := range arr {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 func del(arr []string, s string) (narr []string) {
 	narr = []string{}
 	for i, item := range arr {
@@ -23,6 +23,14 @@ const DB_COLL_JOBS string = "Jobs"
 const DB_COLL_PERF string = "Perf"
 const DB_COLL_CGS string = "ClientGroups"
 const DB_COLL_USERS string = "Users"
+const DB_COLL_REUSE string = "WorkReuse"
+const DB_COLL_CLEANUP string = "NodeCleanup"
+const DB_COLL_HISTORY string = "JobHistory"
+const DB_COLL_API_TOKENS string = "ApiTokens"
+const DB_COLL_GROUPS string = "Groups"
+const DB_COLL_PROJECT_ACLS string = "ProjectAcls"
+const DB_COLL_LEASES string = "Leases"
+const DB_COLL_SERVER_STATE string = "ServerState"
 
 //prefix for site login
 const LOGIN_PREFIX string = "go4711"
@@ -64,6 +72,12 @@ var (
 	RECOVER     bool
 	RECOVER_MAX int
 
+	// High availability
+	HA_ENABLE bool
+	HA_LEASE  int
+	HA_URL    string
+	HA_PROXY  bool
+
 	// AWE server port
 	SITE_PORT int
 	API_PORT  int
@@ -95,6 +109,15 @@ var (
 	OAUTH_URL_STR      string
 	OAUTH_BEARER_STR   string
 	SITE_LOGIN_URL     string
+
+	OIDC_ISSUER         string
+	OIDC_AUDIENCE       string
+	OIDC_USERNAME_CLAIM string
+	OIDC_FULLNAME_CLAIM string
+	OIDC_EMAIL_CLAIM    string
+	OIDC_GROUPS_CLAIM   string
+	OIDC_ADMIN_GROUP    string
+
 	CLIENT_AUTH_REQ    bool
 	CLIENT_GROUP_TOKEN string
 
@@ -109,6 +132,10 @@ var (
 	AWF_PATH      string
 	PID_FILE_PATH string
 
+	// Database
+	DB_BACKEND string
+	DB_PATH    string
+
 	// Mongodb
 	MONGODB_HOST     string
 	MONGODB_DATABASE string
@@ -116,15 +143,28 @@ var (
 	MONGODB_PASSWD   string
 	MONGODB_TIMEOUT  int
 
+	// Mongodb connection options
+	MONGODB_URI         string
+	MONGODB_REPLICA_SET string
+	MONGODB_AUTH_SOURCE string
+	MONGODB_TLS         bool
+	MONGODB_TLS_CA_FILE string
+
 	// Server
-	COREQ_LENGTH       int
-	EXPIRE_WAIT        int
-	GLOBAL_EXPIRE      string
-	PIPELINE_EXPIRE    string
-	PERF_LOG_WORKUNIT  bool
-	MAX_WORK_FAILURE   int
-	MAX_CLIENT_FAILURE int
-	GOMAXPROCS         int
+	COREQ_LENGTH          int
+	EXPIRE_WAIT           int
+	GLOBAL_EXPIRE         string
+	PIPELINE_EXPIRE       string
+	PERF_LOG_WORKUNIT     bool
+	MAX_WORK_FAILURE      int
+	MAX_CLIENT_FAILURE    int
+	WALLTIME_GRACE        int
+	PREDATA_LOCALITY_WAIT int
+	GOMAXPROCS            int
+	WORK_REUSE            bool
+	TRUSTED_PROXIES       string
+	STORAGE_CLIENTGROUPS  string
+	LIVE_LOG_BUFFER       int
 
 	// Client
 	WORK_PATH                   string
@@ -149,6 +189,12 @@ var (
 	NO_SYMLINK     bool
 	CACHE_ENABLED  bool
 
+	PREDATA_CACHE_MAX int
+	PREDATA_MIN_FREE  int
+
+	LIVE_LOG_INTERVAL int
+	LIVE_LOG_CHUNK    int
+
 	CWL_TOOL  string
 	CWL_JOB   string
 	SHOCK_URL string
@@ -166,6 +212,19 @@ var (
 	DOCKER_WORKUNIT_PREDATA_DIR   string
 	SHOCK_DOCKER_IMAGE_REPOSITORY string
 
+	// Storage
+	S3_ENDPOINT          string
+	S3_REGION            string
+	S3_ACCESS_KEY        string
+	S3_SECRET_KEY        string
+	S3_BUCKETS           string
+	FILE_ROOTS           string
+	SHOCK_CHUNK_SIZE     int
+	SHOCK_UPLOAD_THREADS int
+	SHOCK_CHUNK_RETRIES  int
+	DOWNLOAD_THREADS     int
+	DOWNLOAD_PARALLEL    int
+
 	// Other
 	ERROR_LENGTH         int
 	DEV_MODE             bool
@@ -283,11 +342,18 @@ func getConfiguration(c *config.Config, mode string) (c_store *Config_store) {
 		c_store.AddBool(&ANON_CG_DELETE, false, "Anonymous", "cg_delete", "", "")
 
 		// Auth
-		c_store.AddBool(&BASIC_AUTH, false, "Auth", "basic", "", "")
+		c_store.AddBool(&BASIC_AUTH, false, "Auth", "basic", "enable basic auth with local users, managed with /user", "")
 		c_store.AddString(&GLOBUS_TOKEN_URL, "", "Auth", "globus_token_url", "", "")
 		c_store.AddString(&GLOBUS_PROFILE_URL, "", "Auth", "globus_profile_url", "", "")
 		c_store.AddString(&OAUTH_URL_STR, "", "Auth", "oauth_urls", "", "")
 		c_store.AddString(&OAUTH_BEARER_STR, "", "Auth", "oauth_bearers", "", "")
+		c_store.AddString(&OIDC_ISSUER, "", "Auth", "oidc_issuer", "OpenID Connect issuer URL, bearer JWTs of this issuer are verified with its published keys", "")
+		c_store.AddString(&OIDC_AUDIENCE, "", "Auth", "oidc_audience", "if set, tokens must contain this audience", "")
+		c_store.AddString(&OIDC_USERNAME_CLAIM, "preferred_username", "Auth", "oidc_username_claim", "claim used as username, sub is used if the claim is missing", "")
+		c_store.AddString(&OIDC_FULLNAME_CLAIM, "name", "Auth", "oidc_fullname_claim", "", "")
+		c_store.AddString(&OIDC_EMAIL_CLAIM, "email", "Auth", "oidc_email_claim", "", "")
+		c_store.AddString(&OIDC_GROUPS_CLAIM, "groups", "Auth", "oidc_groups_claim", "", "")
+		c_store.AddString(&OIDC_ADMIN_GROUP, "", "Auth", "oidc_admin_group", "members of this group (in the groups claim) are admins", "")
 
 		// WebApp
 		c_store.AddString(&SITE_LOGIN_URL, "", "WebApp", "login_url", "", "")
@@ -314,12 +380,21 @@ func getConfiguration(c *config.Config, mode string) (c_store *Config_store) {
 	}
 
 	if mode == "server" {
+		// Database
+		c_store.AddString(&DB_BACKEND, "mongodb", "Database", "backend", "mongodb, or embedded for single host installations without a database service", "")
+		c_store.AddString(&DB_PATH, "", "Database", "path", "database file of the embedded backend, default <data>/awe.db", "")
+
 		// Mongodb
 		c_store.AddString(&MONGODB_HOST, "localhost", "Mongodb", "hosts", "", "")
 		c_store.AddString(&MONGODB_DATABASE, "AWEDB", "Mongodb", "database", "", "")
 		c_store.AddString(&MONGODB_USER, "", "Mongodb", "user", "", "")
 		c_store.AddString(&MONGODB_PASSWD, "", "Mongodb", "password", "", "")
 		c_store.AddInt(&MONGODB_TIMEOUT, 1200, "Mongodb", "timeout", "", "")
+		c_store.AddString(&MONGODB_URI, "", "Mongodb", "uri", "connection string (mongodb:// or mongodb+srv://), replaces hosts, user and password", "")
+		c_store.AddString(&MONGODB_REPLICA_SET, "", "Mongodb", "replica_set", "name of the replica set, transactions need a replica set", "")
+		c_store.AddString(&MONGODB_AUTH_SOURCE, "", "Mongodb", "auth_source", "database of the user, default is the AWE database", "")
+		c_store.AddBool(&MONGODB_TLS, false, "Mongodb", "tls", "connect with TLS", "")
+		c_store.AddString(&MONGODB_TLS_CA_FILE, "", "Mongodb", "tls_ca_file", "CA certificates (PEM) to verify the server with, enables TLS", "")
 
 		// Server
 		c_store.AddString(&TITLE, "AWE Server", "Server", "title", "", "")
@@ -330,10 +405,22 @@ func getConfiguration(c *config.Config, mode string) (c_store *Config_store) {
 		c_store.AddBool(&PERF_LOG_WORKUNIT, false, "Server", "perf_log_workunit", "collecting performance log per workunit (not working)", "")
 		c_store.AddInt(&MAX_WORK_FAILURE, 3, "Server", "max_work_failure", "number of times that one workunit fails before the workunit considered suspend", "")
 		c_store.AddInt(&MAX_CLIENT_FAILURE, 5, "Server", "max_client_failure", "number of times that one client consecutively fails running workunits before the client considered suspend", "")
+		c_store.AddInt(&WALLTIME_GRACE, 300, "Server", "walltime_grace", "seconds a checked-out workunit may exceed its walltime before it is requeued", "")
+		c_store.AddInt(&PREDATA_LOCALITY_WAIT, 120, "Server", "predata_locality_wait", "seconds a queued workunit is reserved for clients that hold its predata in their cache, 0 disables data-locality scheduling", "")
 		c_store.AddInt(&GOMAXPROCS, 0, "Server", "go_max_procs", "", "")
+		c_store.AddBool(&WORK_REUSE, true, "Server", "work_reuse", "reuse outputs of completed tasks with identical command, inputs and docker image", "")
+		c_store.AddString(&STORAGE_CLIENTGROUPS, "", "Server", "storage_clientgroups", "comma separated list of clientgroup=url, outputs of tasks restricted to the clientgroup are stored at url (s3://bucket/prefix or file:///path) instead of Shock", "")
+		c_store.AddInt(&LIVE_LOG_BUFFER, 1024, "Server", "live_log_buffer", "size in KB of the stdout/stderr tail kept in memory per running workunit for live logs", "")
+		c_store.AddString(&TRUSTED_PROXIES, "", "Server", "trusted_proxies", "comma separated list of CIDRs of reverse proxies whose X-Forwarded-For header is used to identify clients, with HA proxy this must include the standby servers", "")
 		c_store.AddString(&RELOAD, "", "Server", "reload", "path or url to awe job data. WARNING this will drop all current jobs", "")
 		c_store.AddBool(&RECOVER, false, "Server", "recover", "load unfinished jobs from mongodb on startup", "")
 		c_store.AddInt(&RECOVER_MAX, 0, "Server", "recover_max", "max number of jobs to recover, default (0) means recover all", "")
+
+		// High availability
+		c_store.AddBool(&HA_ENABLE, false, "HA", "enable", "run as one of several servers sharing the database, only the elected leader schedules work", "")
+		c_store.AddInt(&HA_LEASE, 30, "HA", "lease", "seconds after which a standby server takes over from a leader that stopped renewing its lease", "")
+		c_store.AddString(&HA_URL, "", "HA", "url", "API url under which the other servers reach this server, default is api-url", "")
+		c_store.AddBool(&HA_PROXY, true, "HA", "proxy", "standby servers proxy API calls to the leader, false to redirect them", "")
 	}
 
 	if mode == "worker" || mode == "submitter" {
@@ -381,6 +468,10 @@ func getConfiguration(c *config.Config, mode string) (c_store *Config_store) {
 		c_store.AddBool(&AUTO_CLEAN_DIR, true, "Client", "auto_clean_dir", "delete workunit directory to save space after completion, turn of for debugging", "")
 		c_store.AddBool(&CACHE_ENABLED, false, "Client", "cache_enabled", "", "")
 		c_store.AddBool(&NO_SYMLINK, false, "Client", "no_symlink", "copy files from predata to work dir, default is to create symlink", "")
+		c_store.AddInt(&PREDATA_CACHE_MAX, 0, "Client", "predata_cache_max", "maximum size of the predata cache in MB, least recently used files are evicted, 0 means no limit", "")
+		c_store.AddInt(&PREDATA_MIN_FREE, 0, "Client", "predata_min_free", "minimum free disk space in MB to keep on the predata filesystem, least recently used files are evicted, 0 means disabled", "")
+		c_store.AddInt(&LIVE_LOG_INTERVAL, 10, "Client", "live_log_interval", "seconds between uploads of new stdout/stderr output of running workunits, 0 disables live logs", "")
+		c_store.AddInt(&LIVE_LOG_CHUNK, 64, "Client", "live_log_chunk", "maximum size in KB of stdout/stderr output sent per interval, older output is skipped", "")
 
 		c_store.AddString(&CWL_RUNNER_ARGS, "", "Client", "cwl_runner_args", "arguments to pass", "")
 
@@ -404,6 +495,21 @@ func getConfiguration(c *config.Config, mode string) (c_store *Config_store) {
 		c_store.AddString(&APP_REGISTRY_URL, "https://raw.githubusercontent.com/MG-RAST/Skyport/master/app_definitions/", "Docker", "app_registry_url", "URL for app defintions", "")
 	}
 
+	// Storage
+	if mode == "worker" {
+		c_store.AddString(&S3_ENDPOINT, "https://s3.amazonaws.com", "Storage", "s3_endpoint", "endpoint of the S3 compatible storage (e.g. MinIO) used for s3://bucket/key urls", "")
+		c_store.AddString(&S3_REGION, "us-east-1", "Storage", "s3_region", "region used for request signing", "")
+		c_store.AddString(&S3_ACCESS_KEY, "", "Storage", "s3_access_key", "", "")
+		c_store.AddString(&S3_SECRET_KEY, "", "Storage", "s3_secret_key", "", "")
+		c_store.AddString(&S3_BUCKETS, "", "Storage", "s3_buckets", "comma separated list of buckets that s3:// urls may use, other buckets are rejected", "")
+		c_store.AddString(&FILE_ROOTS, "", "Storage", "file_roots", "comma separated list of directories on the shared filesystem that file:// urls may use, other paths are rejected", "")
+		c_store.AddInt(&SHOCK_CHUNK_SIZE, 1024, "Storage", "shock_chunk_size", "outputs larger than this (in MB) are uploaded to Shock in parts of this size, 0 disables chunked uploads", "")
+		c_store.AddInt(&SHOCK_UPLOAD_THREADS, 4, "Storage", "shock_upload_threads", "number of parts uploaded to Shock in parallel", "")
+		c_store.AddInt(&SHOCK_CHUNK_RETRIES, 3, "Storage", "shock_chunk_retries", "number of times the upload of a single part is retried", "")
+		c_store.AddInt(&DOWNLOAD_THREADS, 4, "Storage", "download_threads", "number of parallel range requests used to download large Shock inputs", "")
+		c_store.AddInt(&DOWNLOAD_PARALLEL, 1024, "Storage", "download_parallel", "Shock inputs larger than this (in MB) are downloaded with parallel range requests, 0 disables parallel downloads", "")
+	}
+
 	if mode == "server" || mode == "worker" {
 		//Proxy
 		c_store.AddInt(&P_SITE_PORT, 8082, "Proxy", "p-site-port", "", "")
@@ -555,6 +661,27 @@ func Init_conf(mode string) (err error) {
 		PID_FILE_PATH = DATA_PATH + "/pidfile"
 	}
 
+	if DB_BACKEND == "embedded" {
+		if DB_PATH == "" {
+			DB_PATH = DATA_PATH + "/awe.db"
+		}
+	} else if DB_BACKEND != "" && DB_BACKEND != "mongodb" {
+		return fmt.Errorf("\"%s\" is invalid option for database backend, use one of: mongodb, embedded", DB_BACKEND)
+	}
+
+	if HA_ENABLE {
+		if DB_BACKEND == "embedded" {
+			return errors.New("high availability needs the mongodb backend, the embedded database cannot be shared")
+		}
+		if HA_LEASE < 5 {
+			return fmt.Errorf("HA lease of %d seconds is too short, use at least 5", HA_LEASE)
+		}
+		if HA_URL == "" {
+			HA_URL = API_URL
+		}
+		HA_URL = strings.TrimSuffix(HA_URL, "/")
+	}
+
 	if PRE_WORK_SCRIPT_ARGS_STRING != "" {
 		PRE_WORK_SCRIPT_ARGS = strings.Split(PRE_WORK_SCRIPT_ARGS_STRING, ",")
 	}
@@ -576,6 +703,8 @@ func Init_conf(mode string) (err error) {
 	APP_PATH = cleanPath(APP_PATH)
 	AWF_PATH = cleanPath(AWF_PATH)
 	PID_FILE_PATH = cleanPath(PID_FILE_PATH)
+	DB_PATH = cleanPath(DB_PATH)
+	MONGODB_TLS_CA_FILE = cleanPath(MONGODB_TLS_CA_FILE)
 
 	VERSIONS["Job"] = 2
 
@@ -622,6 +751,9 @@ func Print(service string) {
 	if GLOBUS_TOKEN_URL != "" && GLOBUS_PROFILE_URL != "" {
 		fmt.Printf("type:\tglobus\ntoken_url:\t%s\nprofile_url:\t%s\n", GLOBUS_TOKEN_URL, GLOBUS_PROFILE_URL)
 	}
+	if OIDC_ISSUER != "" {
+		fmt.Printf("type:\toidc\nissuer:\t%s\n", OIDC_ISSUER)
+	}
 	if len(AUTH_OAUTH) > 0 {
 		fmt.Printf("type:\toauth\n")
 		for b, u := range AUTH_OAUTH {
@@ -668,7 +800,11 @@ func Print(service string) {
 	}
 
 	if service == "server" {
-		fmt.Printf("##### Mongodb #####\nhost(s):\t%s\ndatabase:\t%s\ntimeout:\t%d\n\n", MONGODB_HOST, MONGODB_DATABASE, MONGODB_TIMEOUT)
+		if DB_BACKEND == "embedded" {
+			fmt.Printf("##### Database #####\nbackend:\t%s\npath:\t%s\n\n", DB_BACKEND, DB_PATH)
+		} else {
+			fmt.Printf("##### Mongodb #####\nhost(s):\t%s\ndatabase:\t%s\ntimeout:\t%d\n\n", MONGODB_HOST, MONGODB_DATABASE, MONGODB_TIMEOUT)
+		}
 	}
 
 	if service == "server" {
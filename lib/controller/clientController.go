@@ -36,6 +36,9 @@ func (cr *ClientController) Create(cx *goweb.Context) {
 	if done {
 		return
 	}
+	if CheckClientGroupAddress(cx, cg, "register") {
+		return
+	}
 	// Parse uploaded form
 
 	_, files, err := ParseMultipartForm(cx.Request)
@@ -214,6 +217,9 @@ func (cr *ClientController) Update(id string, cx *goweb.Context) {
 		if done {
 			return
 		}
+		if CheckClientGroupAddress(cx, cg, "heartbeat") {
+			return
+		}
 
 		const MAX_MEMORY = 1024
 
@@ -246,11 +252,48 @@ func (cr *ClientController) Update(id string, cx *goweb.Context) {
 
 	}
 
+	if query.Has("deregister") { // draining worker leaves
+		cg, done := GetClientGroup(cx)
+		if done {
+			return
+		}
+		if CheckClientGroupAddress(cx, cg, "deregister") {
+			return
+		}
+		client, ok, err := core.QMgr.GetClient(id, true)
+		if err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			cx.RespondWithErrorMessage(e.ClientNotFound, http.StatusBadRequest)
+			return
+		}
+		if cg != nil && client.Group != cg.Name {
+			cx.RespondWithErrorMessage(e.ClientGroupBadName, http.StatusBadRequest)
+			return
+		}
+		if err = core.QMgr.RemoveClient(id, true); err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+			return
+		}
+		cx.RespondWithData("client deregistered")
+		return
+	}
+
 	u, done := GetAuthorizedUser(cx)
 	if done {
 		return
 	}
 
+	if query.Has("drain") { // worker finishes its current work and exits
+		if err := core.QMgr.DrainClientByUser(id, u); err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+		} else {
+			cx.RespondWithData("client draining")
+		}
+		return
+	}
 	if query.Has("subclients") { //update the number of subclients for a proxy
 		if count, err := strconv.Atoi(query.Value("subclients")); err != nil {
 			cx.RespondWithError(http.StatusNotImplemented)
@@ -3,12 +3,11 @@ package controller
 import (
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/db"
 	e "github.com/MG-RAST/AWE/lib/errors"
 	"github.com/MG-RAST/AWE/lib/request"
 	"github.com/MG-RAST/AWE/lib/user"
-	"github.com/MG-RAST/golib/go-uuid/uuid"
 	"github.com/MG-RAST/golib/goweb"
-	mgo "gopkg.in/mgo.v2"
 	"net/http"
 	"strings"
 )
@@ -49,7 +48,7 @@ var ClientGroupAclController goweb.ControllerFunc = func(cx *goweb.Context) {
 	// Load clientgroup by id
 	cg, err := core.LoadClientGroup(cgid)
 	if err != nil {
-		if err == mgo.ErrNotFound {
+		if err == db.ErrNotFound {
 			cx.RespondWithNotFound()
 			return
 		} else {
@@ -65,7 +64,7 @@ var ClientGroupAclController goweb.ControllerFunc = func(cx *goweb.Context) {
 	// NOTE: If the clientgroup is publicly owned, then anyone can view all acl's. The owner can only
 	//       be "public" when anonymous clientgroup creation (ANON_CG_WRITE) is enabled in AWE config.
 
-	rights := cg.Acl.Check(u.Uuid)
+	rights := cg.Acl.Check(u.AclIds()...)
 	if cg.Acl.Owner != u.Uuid && u.Admin == false && cg.Acl.Owner != "public" && rights["read"] == false {
 		cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
 		return
@@ -107,7 +106,7 @@ var ClientGroupAclControllerTyped goweb.ControllerFunc = func(cx *goweb.Context)
 	// Load clientgroup by id
 	cg, err := core.LoadClientGroup(cgid)
 	if err != nil {
-		if err == mgo.ErrNotFound {
+		if err == db.ErrNotFound {
 			cx.RespondWithNotFound()
 		} else {
 			// In theory the db connection could be lost between
@@ -154,7 +153,7 @@ var ClientGroupAclControllerTyped goweb.ControllerFunc = func(cx *goweb.Context)
 		return
 	} else if rmeth == "POST" || rmeth == "PUT" {
 		if rtype == "owner" {
-			if len(ids) == 1 {
+			if len(ids) == 1 && !strings.HasPrefix(ids[0], user.GROUP_PREFIX) {
 				cg.Acl.SetOwner(ids[0])
 			} else {
 				cx.RespondWithErrorMessage("Clientgroups must have one owner.", http.StatusBadRequest)
@@ -225,16 +224,5 @@ func parseClientGroupAclRequestTyped(cx *goweb.Context) (ids []string, err error
 	} else {
 		return nil, nil
 	}
-	for _, v := range users {
-		if uuid.Parse(v) != nil {
-			ids = append(ids, v)
-		} else {
-			u := user.User{Username: v}
-			if err := u.SetMongoInfo(); err != nil {
-				return nil, err
-			}
-			ids = append(ids, u.Uuid)
-		}
-	}
-	return ids, nil
+	return parseAclIds(users)
 }
@@ -3,11 +3,11 @@ package controller
 import (
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/db"
 	e "github.com/MG-RAST/AWE/lib/errors"
 	"github.com/MG-RAST/AWE/lib/request"
 	"github.com/MG-RAST/AWE/lib/user"
 	"github.com/MG-RAST/golib/goweb"
-	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"net/http"
 	"strconv"
@@ -83,7 +83,7 @@ func (cr *ClientGroupController) Read(id string, cx *goweb.Context) {
 	cg, err := core.LoadClientGroup(id)
 
 	if err != nil {
-		if err == mgo.ErrNotFound {
+		if err == db.ErrNotFound {
 			cx.RespondWithNotFound()
 		} else {
 			// In theory the db connection could be lost between
@@ -95,7 +95,7 @@ func (cr *ClientGroupController) Read(id string, cx *goweb.Context) {
 
 	// User must have read permissions on clientgroup or be clientgroup owner or be an admin or the clientgroup is publicly readable.
 	// The other possibility is that public read of clientgroups is enabled and the clientgroup is publicly readable.
-	rights := cg.Acl.Check(u.Uuid)
+	rights := cg.Acl.Check(u.AclIds()...)
 	public_rights := cg.Acl.Check("public")
 	if (u.Uuid != "public" && (cg.Acl.Owner == u.Uuid || rights["read"] == true || u.Admin == true || public_rights["read"] == true)) ||
 		(u.Uuid == "public" && conf.ANON_CG_READ == true && public_rights["read"] == true) {
@@ -139,7 +139,7 @@ func (cr *ClientGroupController) ReadMany(cx *goweb.Context) {
 
 	// Add authorization checking to query if the user is not an admin
 	if u.Admin == false {
-		q["$or"] = []bson.M{bson.M{"acl.read": "public"}, bson.M{"acl.read": u.Uuid}, bson.M{"acl.owner": u.Uuid}}
+		q["$or"] = []bson.M{bson.M{"acl.read": bson.M{"$in": append(u.AclIds(), "public")}}, bson.M{"acl.owner": u.Uuid}}
 	}
 
 	limit := conf.DEFAULT_PAGE_SIZE
@@ -220,7 +220,7 @@ func (cr *ClientGroupController) Delete(id string, cx *goweb.Context) {
 	cg, err := core.LoadClientGroup(id)
 
 	if err != nil {
-		if err == mgo.ErrNotFound {
+		if err == db.ErrNotFound {
 			cx.RespondWithNotFound()
 		} else {
 			// In theory the db connection could be lost between
@@ -232,7 +232,7 @@ func (cr *ClientGroupController) Delete(id string, cx *goweb.Context) {
 
 	// User must have delete permissions on clientgroup or be clientgroup owner or be an admin or the clientgroup is publicly deletable.
 	// The other possibility is that public deletion of clientgroups is enabled and the clientgroup is publicly deletable.
-	rights := cg.Acl.Check(u.Uuid)
+	rights := cg.Acl.Check(u.AclIds()...)
 	public_rights := cg.Acl.Check("public")
 	if (u.Uuid != "public" && (cg.Acl.Owner == u.Uuid || rights["delete"] == true || u.Admin == true || public_rights["delete"] == true)) ||
 		(u.Uuid == "public" && conf.ANON_CG_DELETE == true && public_rights["delete"] == true) {
@@ -248,3 +248,68 @@ func (cr *ClientGroupController) Delete(id string, cx *goweb.Context) {
 	cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
 	return
 }
+
+// PUT: /cgroup/{id}?ip_cidr=<cidr>[,<cidr>...]
+func (cr *ClientGroupController) Update(id string, cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	// Try to authenticate user.
+	u, err := request.Authenticate(cx.Request)
+	if err != nil && err.Error() != e.NoAuth {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// If no auth was provided and ANON_CG_WRITE is true, use the public user.
+	// Otherwise if no auth was provided, throw an error.
+	// Otherwise, proceed with update of the clientgroup using the user.
+	if u == nil {
+		if conf.ANON_CG_WRITE == true {
+			u = &user.User{Uuid: "public"}
+		} else {
+			cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// Load clientgroup by id
+	cg, err := core.LoadClientGroup(id)
+
+	if err != nil {
+		if err == db.ErrNotFound {
+			cx.RespondWithNotFound()
+		} else {
+			// In theory the db connection could be lost between
+			// checking user and load but seems unlikely.
+			cx.RespondWithErrorMessage("clientgroup id not found:"+id, http.StatusBadRequest)
+		}
+		return
+	}
+
+	// User must have write permissions on clientgroup or be clientgroup owner or be an admin or the clientgroup is publicly writable.
+	// The other possibility is that public write of clientgroups is enabled and the clientgroup is publicly writable.
+	rights := cg.Acl.Check(u.AclIds()...)
+	public_rights := cg.Acl.Check("public")
+	if (u.Uuid != "public" && (cg.Acl.Owner == u.Uuid || rights["write"] == true || u.Admin == true || public_rights["write"] == true)) ||
+		(u.Uuid == "public" && conf.ANON_CG_WRITE == true && public_rights["write"] == true) {
+
+		query := &Query{Li: cx.Request.URL.Query()}
+		if !query.Has("ip_cidr") {
+			cx.RespondWithErrorMessage("nothing to update, use ip_cidr=<cidr>[,<cidr>...]", http.StatusBadRequest)
+			return
+		}
+		if err = cg.SetIPCIDR(query.Value("ip_cidr")); err != nil {
+			cx.RespondWithErrorMessage("invalid ip_cidr: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = cg.Save(); err != nil {
+			cx.RespondWithErrorMessage("Could not save clientgroup.", http.StatusInternalServerError)
+			return
+		}
+		cx.RespondWithData(cg)
+		return
+	}
+
+	cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
+	return
+}
@@ -3,11 +3,11 @@ package controller
 import (
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/db"
 	e "github.com/MG-RAST/AWE/lib/errors"
 	"github.com/MG-RAST/AWE/lib/request"
 	"github.com/MG-RAST/AWE/lib/user"
 	"github.com/MG-RAST/golib/goweb"
-	mgo "gopkg.in/mgo.v2"
 	"net/http"
 )
 
@@ -43,7 +43,7 @@ var ClientGroupTokenController goweb.ControllerFunc = func(cx *goweb.Context) {
 	cg, err := core.LoadClientGroup(cgid)
 
 	if err != nil {
-		if err == mgo.ErrNotFound {
+		if err == db.ErrNotFound {
 			cx.RespondWithNotFound()
 		} else {
 			// In theory the db connection could be lost between
@@ -55,7 +55,7 @@ var ClientGroupTokenController goweb.ControllerFunc = func(cx *goweb.Context) {
 
 	// User must have write permissions on clientgroup or be clientgroup owner or be an admin or the clientgroup is publicly writable.
 	// The other possibility is that public write of clientgroups is enabled and the clientgroup is publicly writable.
-	rights := cg.Acl.Check(u.Uuid)
+	rights := cg.Acl.Check(u.AclIds()...)
 	public_rights := cg.Acl.Check("public")
 	if (u.Uuid != "public" && (cg.Acl.Owner == u.Uuid || rights["write"] == true || u.Admin == true || public_rights["write"] == true)) ||
 		(u.Uuid == "public" && conf.ANON_CG_WRITE == true && public_rights["write"] == true) {
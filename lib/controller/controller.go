@@ -10,10 +10,15 @@ type ServerController struct {
 	ClientGroup      *ClientGroupController
 	ClientGroupAcl   map[string]goweb.ControllerFunc
 	ClientGroupToken goweb.ControllerFunc
+	Group            *GroupController
 	Job              *JobController
 	JobAcl           map[string]goweb.ControllerFunc
+	JobHistory       goweb.ControllerFunc
 	Logger           *LoggerController
+	ProjectAcl       map[string]goweb.ControllerFunc
 	Queue            *QueueController
+	Token            *TokenController
+	User             *UserController
 	Work             *WorkController
 }
 
@@ -24,10 +29,15 @@ func NewServerController() *ServerController {
 		ClientGroup:      new(ClientGroupController),
 		ClientGroupAcl:   map[string]goweb.ControllerFunc{"base": ClientGroupAclController, "typed": ClientGroupAclControllerTyped},
 		ClientGroupToken: ClientGroupTokenController,
+		Group:            new(GroupController),
 		Job:              new(JobController),
 		JobAcl:           map[string]goweb.ControllerFunc{"base": JobAclController, "typed": JobAclControllerTyped},
+		JobHistory:       JobHistoryController,
 		Logger:           new(LoggerController),
+		ProjectAcl:       map[string]goweb.ControllerFunc{"base": ProjectAclController, "typed": ProjectAclControllerTyped},
 		Queue:            new(QueueController),
+		Token:            new(TokenController),
+		User:             new(UserController),
 		Work:             new(WorkController),
 	}
 }
@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/MG-RAST/AWE/lib/db"
+	e "github.com/MG-RAST/AWE/lib/errors"
+	"github.com/MG-RAST/AWE/lib/user"
+	"github.com/MG-RAST/golib/goweb"
+)
+
+type GroupController struct{}
+
+// loadGroup returns the group if u may see it (member, owner or admin), manage requires owner or admin
+func loadGroup(cx *goweb.Context, u *user.User, id string, manage bool) (g *user.Group, done bool) {
+	g, err := user.GetGroup(id)
+	if err != nil {
+		if err == db.ErrNotFound {
+			cx.RespondWithNotFound()
+		} else {
+			cx.RespondWithErrorMessage("failed to load group "+id+": "+err.Error(), http.StatusInternalServerError)
+		}
+		return nil, true
+	}
+	if g.Owner != u.Uuid && !u.Admin && (manage || !g.HasMember(u.Uuid)) {
+		cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
+		return nil, true
+	}
+	return g, false
+}
+
+// parseGroupMembers converts a comma separated list of usernames or uuids to uuids
+func parseGroupMembers(value string) (ids []string, err error) {
+	members := []string{}
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if strings.HasPrefix(v, user.GROUP_PREFIX) {
+			return nil, errors.New("groups cannot be members of groups")
+		}
+		members = append(members, v)
+	}
+	return parseAclIds(members)
+}
+
+// OPTIONS: /group
+func (cr *GroupController) Options(cx *goweb.Context) {
+	LogRequest(cx.Request)
+	cx.RespondWithOK()
+	return
+}
+
+// POST: /group/{name}
+// the user creating the group is its owner and first member
+func (cr *GroupController) CreateWithId(name string, cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	u, done := getAuthenticatedUser(cx)
+	if done {
+		return
+	}
+	g, err := user.NewGroup(name, u)
+	if err != nil {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+		return
+	}
+	cx.RespondWithData(g)
+	return
+}
+
+// GET: /group/{id}, id or name of the group
+func (cr *GroupController) Read(id string, cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	u, done := getAuthenticatedUser(cx)
+	if done {
+		return
+	}
+	g, done := loadGroup(cx, u, id, false)
+	if done {
+		return
+	}
+	cx.RespondWithData(g)
+	return
+}
+
+// GET: /group
+// groups of the user, admins can use ?all
+func (cr *GroupController) ReadMany(cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	u, done := getAuthenticatedUser(cx)
+	if done {
+		return
+	}
+	query := &Query{Li: cx.Request.URL.Query()}
+	member := u.Uuid
+	if query.Has("all") {
+		if !u.Admin {
+			cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
+			return
+		}
+		member = ""
+	}
+	groups, err := user.GetGroups(member)
+	if err != nil {
+		cx.RespondWithErrorMessage("failed to load groups: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cx.RespondWithData(groups)
+	return
+}
+
+// PUT: /group/{id}?add=<users>&remove=<users>&owner=<user>
+func (cr *GroupController) Update(id string, cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	u, done := getAuthenticatedUser(cx)
+	if done {
+		return
+	}
+	g, done := loadGroup(cx, u, id, true)
+	if done {
+		return
+	}
+
+	query := &Query{Li: cx.Request.URL.Query()}
+	if query.Has("add") {
+		ids, err := parseGroupMembers(query.Value("add"))
+		if err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, i := range ids {
+			g.AddMember(i)
+		}
+	}
+	if query.Has("remove") {
+		ids, err := parseGroupMembers(query.Value("remove"))
+		if err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, i := range ids {
+			g.RemoveMember(i)
+		}
+	}
+	if query.Has("owner") {
+		ids, err := parseGroupMembers(query.Value("owner"))
+		if err != nil || len(ids) != 1 {
+			cx.RespondWithErrorMessage("groups must have one owner", http.StatusBadRequest)
+			return
+		}
+		g.Owner = ids[0]
+		g.AddMember(ids[0])
+	}
+	if err := g.Save(); err != nil {
+		cx.RespondWithErrorMessage("failed to save group "+id+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cx.RespondWithData(g)
+	return
+}
+
+// DELETE: /group/{id}
+func (cr *GroupController) Delete(id string, cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	u, done := getAuthenticatedUser(cx)
+	if done {
+		return
+	}
+	g, done := loadGroup(cx, u, id, true)
+	if done {
+		return
+	}
+	if err := g.Delete(); err != nil {
+		cx.RespondWithErrorMessage("failed to delete group "+id+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cx.RespondWithData("group " + g.Name + " deleted")
+	return
+}
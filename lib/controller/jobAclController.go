@@ -1,21 +1,26 @@
 package controller
 
 import (
+	"errors"
+
+	aclpkg "github.com/MG-RAST/AWE/lib/acl"
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/db"
 	e "github.com/MG-RAST/AWE/lib/errors"
+	"github.com/MG-RAST/AWE/lib/logger/event"
 	"github.com/MG-RAST/AWE/lib/request"
 	"github.com/MG-RAST/AWE/lib/user"
 	"github.com/MG-RAST/golib/go-uuid/uuid"
 	"github.com/MG-RAST/golib/goweb"
-	mgo "gopkg.in/mgo.v2"
 	"net/http"
 	"strings"
 )
 
 var (
-	validJobAclTypes = map[string]bool{"all": true, "read": true, "write": true, "delete": true, "owner": true,
-		"public_all": true, "public_read": true, "public_write": true, "public_delete": true}
+	validJobAclTypes = map[string]bool{"all": true, "read": true, "write": true, "delete": true, "operate": true, "owner": true,
+		"public_all": true, "public_read": true, "public_write": true, "public_delete": true,
+		aclpkg.ROLE_VIEWER: true, aclpkg.ROLE_OPERATOR: true, aclpkg.ROLE_OWNER: true}
 )
 
 // GET: /job/{jid}/acl/ (only OPTIONS and GET are supported here)
@@ -50,7 +55,7 @@ var JobAclController goweb.ControllerFunc = func(cx *goweb.Context) {
 	//job, err := core.LoadJob(jid)
 	acl, err := core.DBGetJobAcl(jid)
 	if err != nil {
-		if err == mgo.ErrNotFound {
+		if err == db.ErrNotFound {
 			cx.RespondWithNotFound()
 		} else {
 			// In theory the db connection could be lost between
@@ -65,8 +70,8 @@ var JobAclController goweb.ControllerFunc = func(cx *goweb.Context) {
 	// NOTE: If the job is publicly owned, then anyone can view all acl's. The owner can only
 	//       be "public" when anonymous job creation (ANON_WRITE) is enabled in AWE config.
 
-	rights := acl.Check(u.Uuid)
-	if acl.Owner != u.Uuid && u.Admin == false && acl.Owner != "public" && rights["read"] == false {
+	rights := core.JobRights(acl, u)
+	if acl.Owner != "public" && rights["read"] == false {
 		cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
 		return
 	}
@@ -107,7 +112,7 @@ var JobAclControllerTyped goweb.ControllerFunc = func(cx *goweb.Context) {
 	// Load job by id
 	//job, err := core.LoadJob(jid)
 	//if err != nil {
-	//	if err == mgo.ErrNotFound {
+	//	if err == db.ErrNotFound {
 	//		cx.RespondWithNotFound()
 	//	} else {
 	//		cx.RespondWithErrorMessage("job not found: "+jid, http.StatusBadRequest)
@@ -117,7 +122,7 @@ var JobAclControllerTyped goweb.ControllerFunc = func(cx *goweb.Context) {
 
 	acl, err := core.DBGetJobAcl(jid)
 	if err != nil {
-		if err == mgo.ErrNotFound {
+		if err == db.ErrNotFound {
 			cx.RespondWithNotFound()
 		} else {
 			// In theory the db connection could be lost between
@@ -134,8 +139,9 @@ var JobAclControllerTyped goweb.ControllerFunc = func(cx *goweb.Context) {
 		return
 	}
 
-	// Users that are not an admin or the job owner can only delete themselves from an ACL.
-	if acl.Owner != u.Uuid && u.Admin == false {
+	// Users that are not an admin or an owner of the job can only delete themselves from an ACL.
+	rights := core.JobRights(acl, u)
+	if rights["owner"] == false {
 		if rmeth == "DELETE" {
 			if len(ids) != 1 || (len(ids) == 1 && ids[0] != u.Uuid) {
 				cx.RespondWithErrorMessage("Non-owners of a job can delete one and only user from the ACLs (themselves).", http.StatusBadRequest)
@@ -145,11 +151,12 @@ var JobAclControllerTyped goweb.ControllerFunc = func(cx *goweb.Context) {
 				cx.RespondWithErrorMessage("Deleting job ownership is not a supported request type.", http.StatusBadRequest)
 				return
 			}
-			if rtype == "all" {
-				acl.UnSet(ids[0], map[string]bool{"read": true, "write": true, "delete": true})
-			} else {
-				acl.UnSet(ids[0], map[string]bool{rtype: true})
+			public, type_rights := jobAclRights(rtype)
+			if public {
+				cx.RespondWithErrorMessage("Users that are not job owners can only delete themselves from ACLs.", http.StatusBadRequest)
+				return
 			}
+			acl.UnSet(ids[0], type_rights)
 
 			//save acl
 
@@ -158,6 +165,7 @@ var JobAclControllerTyped goweb.ControllerFunc = func(cx *goweb.Context) {
 				cx.RespondWithErrorMessage("acl update error: "+jid, http.StatusBadRequest)
 				return
 			}
+			core.LogJobEvent(jid, event.JOB_ACL, core.Actor(u), "", map[string]string{"method": rmeth, "type": rtype, "users": strings.Join(ids, ",")})
 
 			cx.RespondWithData(acl)
 			return
@@ -166,34 +174,31 @@ var JobAclControllerTyped goweb.ControllerFunc = func(cx *goweb.Context) {
 		return
 	}
 
-	// At this point we know we're dealing with an admin or the job owner.
+	// At this point we know we're dealing with an admin or an owner of the job.
 	// Admins and job owners can view/edit/delete ACLs
 	if rmeth == "GET" {
 		cx.RespondWithData(acl)
 		return
 	} else if rmeth == "POST" || rmeth == "PUT" {
 		if rtype == "owner" {
-			if len(ids) == 1 {
+			if acl.Owner != u.Uuid && u.Admin == false {
+				cx.RespondWithErrorMessage("Only the owner of a job can transfer the ownership, co-owners are added with type "+aclpkg.ROLE_OWNER+".", http.StatusUnauthorized)
+				return
+			}
+			if len(ids) == 1 && !strings.HasPrefix(ids[0], user.GROUP_PREFIX) {
 				acl.SetOwner(ids[0])
 			} else {
 				cx.RespondWithErrorMessage("Jobs must have one owner.", http.StatusBadRequest)
 				return
 			}
-		} else if rtype == "all" {
-			for _, i := range ids {
-				acl.Set(i, map[string]bool{"read": true, "write": true, "delete": true})
-			}
-		} else if rtype == "public_read" {
-			acl.Set("public", map[string]bool{"read": true})
-		} else if rtype == "public_write" {
-			acl.Set("public", map[string]bool{"write": true})
-		} else if rtype == "public_delete" {
-			acl.Set("public", map[string]bool{"delete": true})
-		} else if rtype == "public_all" {
-			acl.Set("public", map[string]bool{"read": true, "write": true, "delete": true})
 		} else {
-			for _, i := range ids {
-				acl.Set(i, map[string]bool{rtype: true})
+			public, type_rights := jobAclRights(rtype)
+			if public {
+				acl.Set("public", type_rights)
+			} else {
+				for _, i := range ids {
+					acl.Set(i, type_rights)
+				}
 			}
 		}
 		err = core.DbUpdateJobField(jid, "acl", acl)
@@ -201,6 +206,7 @@ var JobAclControllerTyped goweb.ControllerFunc = func(cx *goweb.Context) {
 			cx.RespondWithErrorMessage("acl update error: "+jid, http.StatusBadRequest)
 			return
 		}
+		core.LogJobEvent(jid, event.JOB_ACL, core.Actor(u), "", map[string]string{"method": rmeth, "type": rtype, "users": strings.Join(ids, ",")})
 
 		cx.RespondWithData(acl)
 		return
@@ -208,21 +214,13 @@ var JobAclControllerTyped goweb.ControllerFunc = func(cx *goweb.Context) {
 		if rtype == "owner" {
 			cx.RespondWithErrorMessage("Deleting ownership is not a supported request type.", http.StatusBadRequest)
 			return
-		} else if rtype == "all" {
-			for _, i := range ids {
-				acl.UnSet(i, map[string]bool{"read": true, "write": true, "delete": true})
-			}
-		} else if rtype == "public_read" {
-			acl.UnSet("public", map[string]bool{"read": true})
-		} else if rtype == "public_write" {
-			acl.UnSet("public", map[string]bool{"write": true})
-		} else if rtype == "public_delete" {
-			acl.UnSet("public", map[string]bool{"delete": true})
-		} else if rtype == "public_all" {
-			acl.UnSet("public", map[string]bool{"read": true, "write": true, "delete": true})
+		}
+		public, type_rights := jobAclRights(rtype)
+		if public {
+			acl.UnSet("public", type_rights)
 		} else {
 			for _, i := range ids {
-				acl.UnSet(i, map[string]bool{rtype: true})
+				acl.UnSet(i, type_rights)
 			}
 		}
 		err = core.DbUpdateJobField(jid, "acl", acl)
@@ -230,6 +228,7 @@ var JobAclControllerTyped goweb.ControllerFunc = func(cx *goweb.Context) {
 			cx.RespondWithErrorMessage("acl update error: "+jid, http.StatusBadRequest)
 			return
 		}
+		core.LogJobEvent(jid, event.JOB_ACL, core.Actor(u), "", map[string]string{"method": rmeth, "type": rtype, "users": strings.Join(ids, ",")})
 		cx.RespondWithData(acl)
 		return
 	} else {
@@ -238,6 +237,22 @@ var JobAclControllerTyped goweb.ControllerFunc = func(cx *goweb.Context) {
 	}
 }
 
+// jobAclRights returns the rights of an acl type (a right, a role or all), public types apply to the "public" entry
+func jobAclRights(rtype string) (public bool, rights aclpkg.Rights) {
+	if strings.HasPrefix(rtype, "public_") {
+		public = true
+		rtype = strings.TrimPrefix(rtype, "public_")
+	}
+	if rtype == "all" {
+		rights = aclpkg.Rights{"read": true, "write": true, "delete": true}
+	} else if role, ok := aclpkg.Roles[rtype]; ok {
+		rights = role
+	} else {
+		rights = aclpkg.Rights{rtype: true}
+	}
+	return
+}
+
 func parseJobAclRequestTyped(cx *goweb.Context) (ids []string, err error) {
 	var users []string
 	query := cx.Request.URL.Query()
@@ -249,9 +264,22 @@ func parseJobAclRequestTyped(cx *goweb.Context) (ids []string, err error) {
 	} else {
 		return nil, nil
 	}
+	return parseAclIds(users)
+}
+
+// parseAclIds converts usernames to uuids and "group:<name>" to the acl entry of the group
+func parseAclIds(users []string) (ids []string, err error) {
 	for _, v := range users {
-		if uuid.Parse(v) != nil {
+		if strings.HasPrefix(v, user.GROUP_PREFIX) {
+			g, err := user.GetGroup(strings.TrimPrefix(v, user.GROUP_PREFIX))
+			if err != nil {
+				return nil, errors.New("group not found: " + strings.TrimPrefix(v, user.GROUP_PREFIX))
+			}
+			ids = append(ids, g.AclId())
+		} else if uuid.Parse(v) != nil {
 			ids = append(ids, v)
+		} else if existing, err := user.GetUser(v); err == nil {
+			ids = append(ids, existing.Uuid)
 		} else {
 			u := user.User{Username: v}
 			if err := u.SetMongoInfo(); err != nil {
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -9,14 +10,15 @@ import (
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core"
 	"github.com/MG-RAST/AWE/lib/core/cwl"
+	"github.com/MG-RAST/AWE/lib/db"
 	e "github.com/MG-RAST/AWE/lib/errors"
+	"github.com/MG-RAST/AWE/lib/foreign/prov"
 	"github.com/MG-RAST/AWE/lib/foreign/taverna"
 	"github.com/MG-RAST/AWE/lib/logger"
 	"github.com/MG-RAST/AWE/lib/logger/event"
 	"github.com/MG-RAST/AWE/lib/request"
 	"github.com/MG-RAST/AWE/lib/user"
 	"github.com/MG-RAST/golib/goweb"
-	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	//"os"
 	"encoding/json"
@@ -58,7 +60,7 @@ func (cr *JobController) Create(cx *goweb.Context) {
 	}
 
 	// Parse uploaded form
-	_, files, err := ParseMultipartForm(cx.Request)
+	params, files, err := ParseMultipartForm(cx.Request)
 
 	if err != nil {
 		if err.Error() == "request Content-Type isn't multipart/form-data" {
@@ -74,15 +76,46 @@ func (cr *JobController) Create(cx *goweb.Context) {
 	}
 
 	_, has_import := files["import"]
+	archive_file, has_archive := files["archive"]
 	_, has_upload := files["upload"]
 	_, has_awf := files["awf"]
 	cwl_file, has_cwl := files["cwl"] // TODO I could overload 'upload'
 	job_file, has_job := files["job"] // input data for an CWL workflow
 
+	// dryrun: parse the job and estimate workunits, but do not register the job
+	query := &Query{Li: cx.Request.URL.Query()}
+	_, dryrun := params["dryrun"]
+	if query.Has("dryrun") {
+		dryrun = true
+	}
+
 	var job *core.Job
 	job = nil
 
-	if has_import {
+	if (has_import || has_archive) && dryrun {
+		cx.RespondWithErrorMessage("dryrun is not supported for job import", http.StatusBadRequest)
+		return
+	}
+
+	if has_archive {
+		// import a job archive exported from another AWE server
+		remap := &core.JobArchiveRemap{}
+		remap.ClientGroups, err = core.ParseRemap(params["remap_clientgroups"])
+		if err == nil {
+			remap.ShockHosts, err = core.ParseRemap(params["remap_shockhosts"])
+		}
+		if err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+			return
+		}
+		job, err = core.ImportJobArchive(_user, archive_file, remap)
+		if err != nil {
+			logger.Error("Err@job_Create:ImportJobArchive: " + err.Error())
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Event(event.JOB_IMPORT, "jobid="+job.Id+";name="+job.Info.Name+";project="+job.Info.Project+";user="+job.Info.User)
+	} else if has_import {
 		// import a job document
 		job, err = core.CreateJobImport(_user, files["import"])
 		if err != nil {
@@ -449,7 +482,14 @@ func (cr *JobController) Create(cx *goweb.Context) {
 		//}
 
 		//fmt.Println("\n\n\n--------------------------------- Create AWE Job:\n")
-		job, err = core.CWL2AWE(_user, files, job_input, cwl_workflow, &collection)
+		if dryrun {
+			job, err = core.NewCWLJob(_user, job_input, cwl_workflow)
+			if err == nil {
+				job.CWL_collection = &collection
+			}
+		} else {
+			job, err = core.CWL2AWE(_user, files, job_input, cwl_workflow, &collection)
+		}
 		if err != nil {
 			cx.RespondWithErrorMessage("Error: "+err.Error(), http.StatusBadRequest)
 			return
@@ -493,7 +533,11 @@ func (cr *JobController) Create(cx *goweb.Context) {
 	} else {
 		// create new uploaded job
 
-		job, err = core.CreateJobUpload(_user, files)
+		if dryrun {
+			job, err = core.NewJobUpload(_user, files)
+		} else {
+			job, err = core.CreateJobUpload(_user, files)
+		}
 
 		if err != nil {
 			err = fmt.Errorf("(JobController/Create) CreateJobUpload returned: %s", err.Error())
@@ -501,7 +545,23 @@ func (cr *JobController) Create(cx *goweb.Context) {
 			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
 			return
 		}
-		logger.Event(event.JOB_SUBMISSION, "jobid="+job.Id+";name="+job.Info.Name+";project="+job.Info.Project+";user="+job.Info.User)
+		if !dryrun {
+			logger.Event(event.JOB_SUBMISSION, "jobid="+job.Id+";name="+job.Info.Name+";project="+job.Info.Project+";user="+job.Info.User)
+		}
+	}
+
+	if dryrun {
+		// token is needed to access input nodes in shock, but is not stored
+		token, _ := request.RetrieveToken(cx.Request)
+		job.Info.DataToken = token
+
+		dr, err := core.DryRunJob(job)
+		if err != nil {
+			cx.RespondWithErrorMessage(fmt.Sprintf("(JobController/Create) DryRunJob returned: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		cx.RespondWithData(dr)
+		return
 	}
 
 	token, err := request.RetrieveToken(cx.Request)
@@ -521,6 +581,11 @@ func (cr *JobController) Create(cx *goweb.Context) {
 		cx.RespondWithErrorMessage(fmt.Sprintf("(JobController/Create) job.Save returned: %s", err.Error()), http.StatusBadRequest)
 		return
 	}
+	if has_import || has_archive {
+		core.RecordJobEvent(job.Id, event.JOB_IMPORT, core.Actor(_user), "", nil)
+	} else {
+		core.RecordJobEvent(job.Id, event.JOB_SUBMISSION, core.Actor(_user), "", nil)
+	}
 
 	// make a copy to prevent race conditions
 	SR := StandardResponse{
@@ -538,7 +603,7 @@ func (cr *JobController) Create(cx *goweb.Context) {
 	}
 
 	// don't enqueue imports
-	if !has_import {
+	if !has_import && !has_archive {
 		err = core.QMgr.EnqueueTasksByJobId(job.Id)
 		if err != nil {
 			err = fmt.Errorf("(JobController/Create) core.QMgr.EnqueueTasksByJobId returned: %s", err.Error())
@@ -546,6 +611,15 @@ func (cr *JobController) Create(cx *goweb.Context) {
 			return
 		}
 	}
+	// suspended jobs are kept in memory, so that they can be resumed
+	if has_archive && job.State == core.JOB_STAT_SUSPEND {
+		_, err = core.QMgr.RecoverJob(job.Id, nil)
+		if err != nil {
+			err = fmt.Errorf("(JobController/Create) core.QMgr.RecoverJob returned: %s", err.Error())
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	//cx.RespondWithData(job)
 	cx.ResponseWriter.WriteHeader(http.StatusOK)
@@ -579,7 +653,7 @@ func (cr *JobController) Read(id string, cx *goweb.Context) {
 	// Load job by id
 	job, err := core.GetJob(id)
 	if err != nil {
-		if err == mgo.ErrNotFound {
+		if err == db.ErrNotFound {
 			cx.RespondWithNotFound()
 		} else {
 			// In theory the db connection could be lost between
@@ -602,9 +676,8 @@ func (cr *JobController) Read(id string, cx *goweb.Context) {
 	}
 
 	// User must have read permissions on job or be job owner or be an admin
-	rights := job.Acl.Check(u.Uuid)
-	prights := job.Acl.Check("public")
-	if job.Acl.Owner != u.Uuid && rights["read"] == false && u.Admin == false && prights["read"] == false {
+	rights := core.JobRights(job.Acl, u)
+	if rights["read"] == false {
 		cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
 		return
 	}
@@ -616,7 +689,7 @@ func (cr *JobController) Read(id string, cx *goweb.Context) {
 		//Load job perf by id
 		perf, err := core.LoadJobPerf(id)
 		if err != nil {
-			if err == mgo.ErrNotFound {
+			if err == db.ErrNotFound {
 				cx.RespondWithNotFound()
 			} else {
 				// In theory the db connection could be lost between
@@ -630,6 +703,20 @@ func (cr *JobController) Read(id string, cx *goweb.Context) {
 		return //done with returning perf, no need to load job further.
 	}
 
+	if query.Has("cleanup") {
+		if query.Value("cleanup") != "preview" {
+			cx.RespondWithErrorMessage("unknown value for cleanup, use cleanup=preview", http.StatusBadRequest)
+			return
+		}
+		failed, err := core.GetFailedNodeCleanups(id)
+		if err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cx.RespondWithData(map[string]interface{}{"remove": job.TemporaryNodes(), "failed": failed})
+		return
+	}
+
 	if query.Has("position") {
 		if job_state != "queued" && job_state != "in-progress" {
 			cx.RespondWithErrorMessage("job is not queued or in-progress, job state:"+job_state, http.StatusBadRequest)
@@ -672,6 +759,31 @@ func (cr *JobController) Read(id string, cx *goweb.Context) {
 		return
 	}
 
+	if query.Has("graph") {
+		format := query.Value("graph")
+		if format == "" {
+			format = core.GRAPH_FORMAT_JSON
+		}
+		graph, err := core.NewJobGraph(job)
+		if err != nil {
+			cx.RespondWithErrorMessage("could not create job graph: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if format == core.GRAPH_FORMAT_JSON {
+			cx.RespondWithData(graph)
+			return
+		}
+		graph_str, err := graph.Render(format)
+		if err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+			return
+		}
+		cx.ResponseWriter.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		cx.ResponseWriter.WriteHeader(http.StatusOK)
+		cx.ResponseWriter.Write([]byte(graph_str))
+		return
+	}
+
 	if core.QMgr.IsJobRegistered(id) {
 		job.Registered = true
 	} else {
@@ -691,6 +803,57 @@ func (cr *JobController) Read(id string, cx *goweb.Context) {
 			}
 			cx.RespondWithData(wfrun)
 			return
+		} else if target == "archive" {
+			// the archive contains the complete job, read rights are not enough
+			if job.Acl.Owner != u.Uuid && !u.Admin {
+				cx.RespondWithErrorMessage("only the owner of job "+id+" or an admin can export the archive", http.StatusUnauthorized)
+				return
+			}
+			// the archive is buffered, so that errors can still be reported
+			var archive bytes.Buffer
+			err = core.WriteJobArchive(job, &archive)
+			if err != nil {
+				cx.RespondWithErrorMessage("failed to export job "+id+": "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			cx.ResponseWriter.Header().Set("Content-Type", "application/x-gzip")
+			cx.ResponseWriter.Header().Set("Content-Disposition", "attachment; filename="+id+".awe.tar.gz")
+			cx.ResponseWriter.WriteHeader(http.StatusOK)
+			cx.ResponseWriter.Write(archive.Bytes())
+			return
+		} else if target == "prov" || target == "rocrate" {
+			// PROV-JSON and RO-Crate metadata are returned as plain documents, not wrapped in a response
+			if job.State != core.JOB_STAT_COMPLETED {
+				cx.RespondWithErrorMessage("provenance can only be exported for completed jobs, job "+id+" is "+job.State, http.StatusBadRequest)
+				return
+			}
+			var doc interface{}
+			if target == "prov" {
+				doc, err = prov.ExportProv(job)
+			} else {
+				doc, err = prov.ExportROCrate(job)
+			}
+			if err != nil {
+				cx.RespondWithErrorMessage("failed to export provenance of job "+id+": "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			var doc_bytes []byte
+			doc_bytes, err = json.Marshal(doc)
+			if err != nil {
+				cx.RespondWithErrorMessage("Could not marshal provenance: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			content_type := "application/ld+json"
+			if target == "prov" {
+				content_type = "application/json"
+			}
+			cx.ResponseWriter.Header().Set("Content-Type", content_type)
+			cx.ResponseWriter.WriteHeader(http.StatusOK)
+			cx.ResponseWriter.Write(doc_bytes)
+			return
+		} else {
+			cx.RespondWithErrorMessage("unknown export target "+target+", use taverna, prov, rocrate or archive", http.StatusBadRequest)
+			return
 		}
 	}
 
@@ -725,7 +888,7 @@ func (cr *JobController) ReadMany(cx *goweb.Context) {
 	if u != nil {
 		// Add authorization checking to query if the user is not an admin
 		if u.Admin == false {
-			q["$or"] = []bson.M{bson.M{"acl.read": "public"}, bson.M{"acl.read": u.Uuid}, bson.M{"acl.owner": u.Uuid}, bson.M{"acl": bson.M{"$exists": "false"}}}
+			q["$or"] = core.JobAclFilter(u)
 		}
 	} else {
 		// User is anonymous
@@ -1222,7 +1385,7 @@ func (cr *JobController) Update(id string, cx *goweb.Context) {
 	if query.Has("clientgroup") || query.Has("priority") || query.Has("pipeline") || query.Has("expiration") || query.Has("settoken") {
 		job, err = core.GetJob(id)
 		if err != nil {
-			if err == mgo.ErrNotFound {
+			if err == db.ErrNotFound {
 				cx.RespondWithNotFound()
 			} else {
 				// In theory the db connection could be lost between
@@ -1236,7 +1399,7 @@ func (cr *JobController) Update(id string, cx *goweb.Context) {
 	// User must have write permissions on job or be job owner or be an admin
 	acl, err := core.DBGetJobAcl(id)
 	if err != nil {
-		if err == mgo.ErrNotFound {
+		if err == db.ErrNotFound {
 			cx.RespondWithNotFound()
 		} else {
 			// In theory the db connection could be lost between
@@ -1246,8 +1409,9 @@ func (cr *JobController) Update(id string, cx *goweb.Context) {
 		return
 	}
 
-	rights := acl.Check(u.Uuid)
-	if acl.Owner != u.Uuid && rights["write"] == false && u.Admin == false {
+	// operators can control the job, changing it requires write permissions
+	rights := core.JobRights(acl, u)
+	if rights["operate"] == false {
 		cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
 		return
 	}
@@ -1264,6 +1428,7 @@ func (cr *JobController) Update(id string, cx *goweb.Context) {
 		jerror := &core.JobError{
 			ServerNotes: "manually suspended",
 			Status:      core.JOB_STAT_SUSPEND,
+			Actor:       core.Actor(u),
 		}
 		if err := core.QMgr.SuspendJob(id, jerror); err != nil {
 			cx.RespondWithErrorMessage("fail to suspend job: "+id+" "+err.Error(), http.StatusBadRequest)
@@ -1277,6 +1442,7 @@ func (cr *JobController) Update(id string, cx *goweb.Context) {
 			cx.RespondWithErrorMessage("fail to recover job: "+id+" "+err.Error(), http.StatusBadRequest)
 			return
 		}
+		core.LogJobEvent(id, event.JOB_RECOVER, core.Actor(u), "", nil)
 		cx.RespondWithData("job recovered: " + id)
 		return
 	}
@@ -1290,6 +1456,7 @@ func (cr *JobController) Update(id string, cx *goweb.Context) {
 			cx.RespondWithErrorMessage("fail to recompute job: "+id+" "+err.Error(), http.StatusBadRequest)
 			return
 		}
+		core.LogJobEvent(id, event.JOB_RECOMPUTE, core.Actor(u), "", map[string]string{"task": stage})
 		cx.RespondWithData("job recompute started at task " + stage + ": " + id)
 		return
 	}
@@ -1298,19 +1465,27 @@ func (cr *JobController) Update(id string, cx *goweb.Context) {
 			cx.RespondWithErrorMessage("fail to resubmit job: "+id+" "+err.Error(), http.StatusBadRequest)
 			return
 		}
+		core.LogJobEvent(id, event.JOB_RESUBMIT, core.Actor(u), "", nil)
 		cx.RespondWithData("job resubmitted: " + id)
 		return
 	}
+	if rights["write"] == false {
+		cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
+		return
+	}
+
 	if query.Has("clientgroup") { // change the clientgroup attribute of the job
 		newgroup := query.Value("clientgroup")
 		if newgroup == "" {
 			cx.RespondWithErrorMessage("lacking clientgroup name", http.StatusBadRequest)
 			return
 		}
+		oldgroup := job.Info.ClientGroups
 		if err := job.SetClientgroups(newgroup); err != nil {
 			cx.RespondWithErrorMessage("failed to update group for job: "+id+" "+err.Error(), http.StatusBadRequest)
 			return
 		}
+		core.LogJobEvent(id, event.JOB_UPDATE, core.Actor(u), "", map[string]string{"field": "clientgroup", "old": oldgroup, "new": newgroup})
 		cx.RespondWithData("job group updated: " + id + " to " + newgroup)
 		return
 	}
@@ -1325,10 +1500,12 @@ func (cr *JobController) Update(id string, cx *goweb.Context) {
 			cx.RespondWithErrorMessage("priority value must be an integer"+err.Error(), http.StatusBadRequest)
 			return
 		}
+		old_priority := strconv.Itoa(job.Info.Priority)
 		if err := job.SetPriority(priority); err != nil {
 			cx.RespondWithErrorMessage("failed to set the priority for job: "+id+" "+err.Error(), http.StatusBadRequest)
 			return
 		}
+		core.LogJobEvent(id, event.JOB_UPDATE, core.Actor(u), "", map[string]string{"field": "priority", "old": old_priority, "new": priority_str})
 		cx.RespondWithData("job priority updated: " + id + " to " + priority_str)
 		return
 	}
@@ -1338,10 +1515,12 @@ func (cr *JobController) Update(id string, cx *goweb.Context) {
 			cx.RespondWithErrorMessage("lacking pipeline value", http.StatusBadRequest)
 			return
 		}
+		old_pipeline := job.Info.Pipeline
 		if err := job.SetPipeline(pipeline); err != nil {
 			cx.RespondWithErrorMessage("failed to set the pipeline for job: "+id+" "+err.Error(), http.StatusBadRequest)
 			return
 		}
+		core.LogJobEvent(id, event.JOB_UPDATE, core.Actor(u), "", map[string]string{"field": "pipeline", "old": old_pipeline, "new": pipeline})
 		cx.RespondWithData("job pipeline updated: " + id + " to " + pipeline)
 		return
 	}
@@ -1351,10 +1530,12 @@ func (cr *JobController) Update(id string, cx *goweb.Context) {
 			cx.RespondWithErrorMessage("lacking expiration value", http.StatusBadRequest)
 			return
 		}
+		old_expiration := job.Expiration.String()
 		if err := job.SetExpiration(expire); err != nil {
 			cx.RespondWithErrorMessage("failed to set the expiration for job: "+id+" "+err.Error(), http.StatusBadRequest)
 			return
 		}
+		core.LogJobEvent(id, event.JOB_UPDATE, core.Actor(u), "", map[string]string{"field": "expiration", "old": old_expiration, "new": job.Expiration.String()})
 		cx.RespondWithData("expiration '" + job.Expiration.String() + "' set for job: " + id)
 		return
 	}
@@ -1369,6 +1550,8 @@ func (cr *JobController) Update(id string, cx *goweb.Context) {
 			cx.RespondWithErrorMessage("failed to set the token for job: "+id+" "+err.Error(), http.StatusBadRequest)
 			return
 		}
+		// the token itself is not recorded
+		core.LogJobEvent(id, event.JOB_UPDATE, core.Actor(u), "", map[string]string{"field": "token"})
 		cx.RespondWithData("data token set for job: " + id)
 		return
 	}
@@ -1405,7 +1588,7 @@ func (cr *JobController) Delete(id string, cx *goweb.Context) {
 	}
 
 	if err = core.QMgr.DeleteJobByUser(id, u, full); err != nil {
-		if err == mgo.ErrNotFound {
+		if err == db.ErrNotFound {
 			cx.RespondWithNotFound()
 			return
 		} else if err.Error() == e.UnAuth {
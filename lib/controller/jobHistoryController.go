@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/db"
+	e "github.com/MG-RAST/AWE/lib/errors"
+	"github.com/MG-RAST/AWE/lib/request"
+	"github.com/MG-RAST/AWE/lib/user"
+	"github.com/MG-RAST/golib/goweb"
+)
+
+// GET: /job/{jid}/history?limit=&offset=
+// events of the job, oldest first
+var JobHistoryController goweb.ControllerFunc = func(cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	if cx.Request.Method == "OPTIONS" {
+		cx.RespondWithOK()
+		return
+	}
+	if cx.Request.Method != "GET" {
+		cx.RespondWithErrorMessage("This request type is not implemented.", http.StatusNotImplemented)
+		return
+	}
+
+	// Try to authenticate user.
+	u, err := request.Authenticate(cx.Request)
+	if err != nil && err.Error() != e.NoAuth {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// If no auth was provided, and anonymous read is allowed, use the public user
+	if u == nil {
+		if conf.ANON_READ == true {
+			u = &user.User{Uuid: "public"}
+		} else {
+			cx.RespondWithErrorMessage(e.NoAuth, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	jid := cx.PathParams["jid"]
+
+	// the history is kept as long as the job document
+	acl, err := core.DBGetJobAcl(jid)
+	if err != nil {
+		if err == db.ErrNotFound {
+			cx.RespondWithNotFound()
+		} else {
+			cx.RespondWithErrorMessage("job not found: "+jid+" "+err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	rights := core.JobRights(acl, u)
+	if acl.Owner != "public" && rights["read"] == false {
+		cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
+		return
+	}
+
+	query := &Query{Li: cx.Request.URL.Query()}
+	limit := conf.DEFAULT_PAGE_SIZE
+	offset := 0
+	if query.Has("limit") {
+		limit, err = strconv.Atoi(query.Value("limit"))
+		if err != nil || limit < 1 {
+			cx.RespondWithErrorMessage("limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	if query.Has("offset") {
+		offset, err = strconv.Atoi(query.Value("offset"))
+		if err != nil || offset < 0 {
+			cx.RespondWithErrorMessage("offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, total, err := core.GetJobHistory(jid, limit, offset)
+	if err != nil {
+		cx.RespondWithErrorMessage("failed to load history of job "+jid+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cx.RespondWithPaginatedData(events, limit, offset, total)
+	return
+}
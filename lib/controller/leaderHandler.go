@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+// LeaderHandler serves the API on the leader, standby servers proxy or redirect the requests to the leader
+type LeaderHandler struct {
+	handler http.Handler
+	lock    sync.Mutex
+	url     string
+	proxy   *httputil.ReverseProxy
+}
+
+func NewLeaderHandler(handler http.Handler) *LeaderHandler {
+	return &LeaderHandler{handler: handler}
+}
+
+func (h *LeaderHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if core.Leadership == nil || core.Leadership.IsLeader() {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	leader_url := core.Leadership.LeaderUrl()
+	if leader_url == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(core.StandardResponse{Status: http.StatusServiceUnavailable, Error: []string{"no AWE server is leader at the moment, try again later"}})
+		return
+	}
+
+	if !conf.HA_PROXY {
+		http.Redirect(w, r, leader_url+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+		return
+	}
+
+	proxy, err := h.getProxy(leader_url)
+	if err != nil {
+		logger.Error("(LeaderHandler) invalid leader url %s: %s", leader_url, err.Error())
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+func (h *LeaderHandler) getProxy(leader_url string) (proxy *httputil.ReverseProxy, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.proxy != nil && h.url == leader_url {
+		proxy = h.proxy
+		return
+	}
+	target, err := url.Parse(leader_url)
+	if err != nil {
+		return
+	}
+	h.proxy = httputil.NewSingleHostReverseProxy(target)
+	h.url = leader_url
+	proxy = h.proxy
+	return
+}
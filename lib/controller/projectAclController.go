@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"github.com/MG-RAST/AWE/lib/acl"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/db"
+	e "github.com/MG-RAST/AWE/lib/errors"
+	"github.com/MG-RAST/AWE/lib/request"
+	"github.com/MG-RAST/golib/goweb"
+	"net/http"
+)
+
+// GET: /project/{project}/acl
+// the default acl added to new jobs of the project
+var ProjectAclController goweb.ControllerFunc = func(cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	if cx.Request.Method == "OPTIONS" {
+		cx.RespondWithOK()
+		return
+	}
+
+	if _, err := request.Authenticate(cx.Request); err != nil {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if cx.Request.Method != "GET" {
+		cx.RespondWithErrorMessage("This request type is not implemented.", http.StatusNotImplemented)
+		return
+	}
+
+	project := cx.PathParams["project"]
+	pacl, err := core.GetProjectAcl(project)
+	if err != nil {
+		if err == db.ErrNotFound {
+			cx.RespondWithNotFound()
+		} else {
+			cx.RespondWithErrorMessage("failed to load acl of project "+project+": "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	cx.RespondWithData(pacl)
+	return
+}
+
+// GET, POST, PUT, DELETE, OPTIONS: /project/{project}/acl/{type}
+// only admins can change project acls, the owner of a job is always its submitter
+var ProjectAclControllerTyped goweb.ControllerFunc = func(cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	if cx.Request.Method == "OPTIONS" {
+		cx.RespondWithOK()
+		return
+	}
+
+	u, err := request.Authenticate(cx.Request)
+	if err != nil {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	project := cx.PathParams["project"]
+	rtype := cx.PathParams["type"]
+	rmeth := cx.Request.Method
+
+	if !validJobAclTypes[rtype] || rtype == "owner" {
+		cx.RespondWithErrorMessage("Invalid acl type", http.StatusBadRequest)
+		return
+	}
+
+	pacl, err := core.GetProjectAcl(project)
+	if err != nil {
+		if err != db.ErrNotFound {
+			cx.RespondWithErrorMessage("failed to load acl of project "+project+": "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		pacl = &core.ProjectAcl{Project: project, Acl: acl.Acl{}}
+	}
+
+	if rmeth == "GET" {
+		cx.RespondWithData(pacl)
+		return
+	}
+	if !u.Admin {
+		cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
+		return
+	}
+
+	ids, err := parseJobAclRequestTyped(cx)
+	if err != nil {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+		return
+	}
+	public, rights := jobAclRights(rtype)
+	if public {
+		ids = []string{"public"}
+	}
+
+	if rmeth == "POST" || rmeth == "PUT" {
+		for _, i := range ids {
+			pacl.Acl.Set(i, rights)
+		}
+	} else if rmeth == "DELETE" {
+		for _, i := range ids {
+			pacl.Acl.UnSet(i, rights)
+		}
+	} else {
+		cx.RespondWithErrorMessage("This request type is not implemented.", http.StatusNotImplemented)
+		return
+	}
+	if err = pacl.Save(); err != nil {
+		cx.RespondWithErrorMessage("failed to save acl of project "+project+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cx.RespondWithData(pacl)
+	return
+}
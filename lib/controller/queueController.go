@@ -95,7 +95,7 @@ func (cr *QueueController) ReadMany(cx *goweb.Context) {
 		}
 		// User must have read permissions on clientgroup or be clientgroup owner or be an admin or the clientgroup is publicly readable.
 		// The other possibility is that public read of clientgroups is enabled and the clientgroup is publicly readable.
-		rights := cg.Acl.Check(u.Uuid)
+		rights := cg.Acl.Check(u.AclIds()...)
 		public_rights := cg.Acl.Check("public")
 		if (u.Uuid != "public" && (cg.Acl.Owner == u.Uuid || rights["read"] == true || u.Admin == true || public_rights["read"] == true)) ||
 			(u.Uuid == "public" && conf.ANON_CG_READ == true && public_rights["read"] == true) {
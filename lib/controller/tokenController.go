@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/db"
+	e "github.com/MG-RAST/AWE/lib/errors"
+	"github.com/MG-RAST/AWE/lib/request"
+	"github.com/MG-RAST/AWE/lib/user"
+	"github.com/MG-RAST/golib/goweb"
+)
+
+type TokenController struct{}
+
+// the secret of a new token is only returned once
+type apiTokenCreated struct {
+	*user.ApiToken
+	Token string `json:"token"`
+}
+
+// getAuthenticatedUser authenticates the user, for resources that are not available to anonymous users
+func getAuthenticatedUser(cx *goweb.Context) (u *user.User, done bool) {
+	u, err := request.Authenticate(cx.Request)
+	if err != nil {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusUnauthorized)
+		return nil, true
+	}
+	return u, false
+}
+
+// loadApiToken returns the token if u is its owner or an admin
+func loadApiToken(cx *goweb.Context, u *user.User, id string) (token *user.ApiToken, done bool) {
+	token, err := user.GetApiToken(id)
+	if err != nil {
+		if err == db.ErrNotFound {
+			cx.RespondWithNotFound()
+		} else {
+			cx.RespondWithErrorMessage("failed to load token "+id+": "+err.Error(), http.StatusInternalServerError)
+		}
+		return nil, true
+	}
+	if token.UserUuid != u.Uuid && !u.Admin {
+		cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
+		return nil, true
+	}
+	return token, false
+}
+
+// OPTIONS: /token
+func (cr *TokenController) Options(cx *goweb.Context) {
+	LogRequest(cx.Request)
+	cx.RespondWithOK()
+	return
+}
+
+// POST: /token?name=&scope=read|submit|admin&expires=<RFC3339>&user=<service account>
+// creates a token for the user, admins can create tokens for service accounts (without admin scope).
+// The expiration is capped at the expiration of the token used for the request.
+func (cr *TokenController) Create(cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	u, done := getAuthenticatedUser(cx)
+	if done {
+		return
+	}
+
+	query := &Query{Li: cx.Request.URL.Query()}
+	scope := user.SCOPE_SUBMIT
+	if query.Has("scope") {
+		scope = query.Value("scope")
+	}
+	if !user.ValidScope(scope) {
+		cx.RespondWithErrorMessage("scope must be one of "+user.SCOPE_READ+", "+user.SCOPE_SUBMIT+", "+user.SCOPE_ADMIN, http.StatusBadRequest)
+		return
+	}
+	var expires *time.Time
+	if query.Has("expires") {
+		t, err := time.Parse(time.RFC3339, query.Value("expires"))
+		if err != nil {
+			cx.RespondWithErrorMessage("expires must be a RFC3339 timestamp: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		expires = &t
+	}
+
+	owner := u
+	if query.Has("user") && query.Value("user") != u.Username {
+		if !u.Admin {
+			cx.RespondWithErrorMessage("only admins can create tokens for service accounts", http.StatusUnauthorized)
+			return
+		}
+		var err error
+		owner, err = user.GetServiceAccount(query.Value("user"))
+		if err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	expires, err := u.CheckNewApiToken(owner, scope, expires)
+	if err != nil {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	token, secret, err := user.NewApiToken(owner, query.Value("name"), scope, expires, u.Username)
+	if err != nil {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+		return
+	}
+	cx.RespondWithData(apiTokenCreated{ApiToken: token, Token: secret})
+	return
+}
+
+// GET: /token/{id}
+func (cr *TokenController) Read(id string, cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	u, done := getAuthenticatedUser(cx)
+	if done {
+		return
+	}
+	token, done := loadApiToken(cx, u, id)
+	if done {
+		return
+	}
+	cx.RespondWithData(token)
+	return
+}
+
+// GET: /token, admins can use ?all or ?user=<name> to list tokens of other users
+func (cr *TokenController) ReadMany(cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	u, done := getAuthenticatedUser(cx)
+	if done {
+		return
+	}
+
+	query := &Query{Li: cx.Request.URL.Query()}
+	user_uuid := u.Uuid
+	if query.Has("all") || query.Has("user") {
+		if !u.Admin {
+			cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
+			return
+		}
+		user_uuid = ""
+	}
+	tokens, err := user.GetApiTokens(user_uuid)
+	if err != nil {
+		cx.RespondWithErrorMessage("failed to load tokens: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if query.Has("user") {
+		filtered := []*user.ApiToken{}
+		for _, token := range tokens {
+			if token.Username == query.Value("user") {
+				filtered = append(filtered, token)
+			}
+		}
+		tokens = filtered
+	}
+	cx.RespondWithData(tokens)
+	return
+}
+
+// DELETE: /token/{id}
+// revokes the token
+func (cr *TokenController) Delete(id string, cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	u, done := getAuthenticatedUser(cx)
+	if done {
+		return
+	}
+	token, done := loadApiToken(cx, u, id)
+	if done {
+		return
+	}
+	err := user.DeleteApiToken(token.Id)
+	if err != nil {
+		cx.RespondWithErrorMessage("failed to revoke token "+id+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cx.RespondWithData("token " + id + " revoked")
+	return
+}
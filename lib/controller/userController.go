@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/MG-RAST/AWE/lib/auth"
+	"github.com/MG-RAST/AWE/lib/db"
+	e "github.com/MG-RAST/AWE/lib/errors"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/user"
+	"github.com/MG-RAST/golib/go-uuid/uuid"
+	"github.com/MG-RAST/golib/goweb"
+)
+
+type UserController struct{}
+
+// parseUserForm reads the fields of a multipart or urlencoded form, passwords are not accepted in the query
+// string since requests are logged with their url
+func parseUserForm(r *http.Request) (params map[string]string, err error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		params, _, err = ParseMultipartForm(r)
+		return
+	}
+	params = make(map[string]string)
+	if err = r.ParseForm(); err != nil {
+		return
+	}
+	for k, v := range r.PostForm {
+		if len(v) > 0 {
+			params[k] = v[0]
+		}
+	}
+	return
+}
+
+// parseBool returns the value of a true/false field and if it was set
+func parseBool(params map[string]string, key string) (value bool, set bool) {
+	v, set := params[key]
+	return set && (v == "true" || v == "1"), set
+}
+
+// loadUser returns the user if u is that user or an admin
+func loadUser(cx *goweb.Context, u *user.User, id string) (target *user.User, done bool) {
+	target, err := user.GetUser(id)
+	if err != nil {
+		if err == db.ErrNotFound {
+			cx.RespondWithNotFound()
+		} else {
+			cx.RespondWithErrorMessage("failed to load user "+id+": "+err.Error(), http.StatusInternalServerError)
+		}
+		return nil, true
+	}
+	if target.Uuid != u.Uuid && !u.Admin {
+		cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
+		return nil, true
+	}
+	return target, false
+}
+
+// OPTIONS: /user
+func (cr *UserController) Options(cx *goweb.Context) {
+	LogRequest(cx.Request)
+	cx.RespondWithOK()
+	return
+}
+
+// POST: /user, form fields username, password, fullname, email, admin
+// creates a local user, admin only
+func (cr *UserController) Create(cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	u, done := getAuthenticatedUser(cx)
+	if done {
+		return
+	}
+	if !u.Admin {
+		cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
+		return
+	}
+	params, err := parseUserForm(cx.Request)
+	if err != nil {
+		cx.RespondWithErrorMessage("failed to parse form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	username := strings.TrimSpace(params["username"])
+	if username == "" || username == "public" || strings.ContainsAny(username, ":,") || uuid.Parse(username) != nil {
+		cx.RespondWithErrorMessage("invalid username", http.StatusBadRequest)
+		return
+	}
+	if _, err := user.GetUser(username); err == nil {
+		cx.RespondWithErrorMessage("user "+username+" already exists", http.StatusBadRequest)
+		return
+	}
+	admin, _ := parseBool(params, "admin")
+	nu := &user.User{Uuid: uuid.New(), Username: username, Fullname: params["fullname"], Email: params["email"], Admin: admin, AdminGranted: admin}
+	if err = nu.SetPassword(params["password"]); err != nil {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = nu.Save(); err != nil {
+		cx.RespondWithErrorMessage("failed to save user "+username+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.Info("user %s created by %s", username, u.Username)
+	cx.RespondWithData(nu)
+	return
+}
+
+// GET: /user/{id}, uuid or username
+func (cr *UserController) Read(id string, cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	u, done := getAuthenticatedUser(cx)
+	if done {
+		return
+	}
+	target, done := loadUser(cx, u, id)
+	if done {
+		return
+	}
+	cx.RespondWithData(target)
+	return
+}
+
+// GET: /user, admin only
+func (cr *UserController) ReadMany(cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	u, done := getAuthenticatedUser(cx)
+	if done {
+		return
+	}
+	if !u.Admin {
+		cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
+		return
+	}
+	users := user.Users{}
+	if err := user.AdminGet(&users); err != nil {
+		cx.RespondWithErrorMessage("failed to load users: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cx.RespondWithData(users)
+	return
+}
+
+// PUT: /user/{id}, form fields password, old_password, fullname, email, and for admins admin and disabled
+// users changing their own password have to provide the old one
+func (cr *UserController) Update(id string, cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	u, done := getAuthenticatedUser(cx)
+	if done {
+		return
+	}
+	target, done := loadUser(cx, u, id)
+	if done {
+		return
+	}
+	params, err := parseUserForm(cx.Request)
+	if err != nil {
+		cx.RespondWithErrorMessage("failed to parse form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if password, ok := params["password"]; ok {
+		// admins can reset passwords of other users, everybody else needs the old password
+		if !u.Admin || target.Uuid == u.Uuid {
+			if target.Password != "" && !target.CheckPassword(params["old_password"]) {
+				cx.RespondWithErrorMessage("old_password does not match", http.StatusUnauthorized)
+				return
+			}
+		}
+		if err = target.SetPassword(password); err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if v, ok := params["fullname"]; ok {
+		target.Fullname = v
+	}
+	if v, ok := params["email"]; ok {
+		target.Email = v
+	}
+
+	_, set_admin := params["admin"]
+	_, set_disabled := params["disabled"]
+	if set_admin || set_disabled {
+		if !u.Admin {
+			cx.RespondWithErrorMessage("only admins can change admin and disabled", http.StatusUnauthorized)
+			return
+		}
+		// prevents admins from locking themselves out
+		if target.Uuid == u.Uuid {
+			cx.RespondWithErrorMessage("admins cannot change admin or disabled of themselves", http.StatusBadRequest)
+			return
+		}
+	}
+	if admin, ok := parseBool(params, "admin"); ok {
+		if !admin && target.IsConfigAdmin() {
+			cx.RespondWithErrorMessage("user "+target.Username+" is admin in the config", http.StatusBadRequest)
+			return
+		}
+		target.Admin = admin || target.IsConfigAdmin()
+		target.AdminGranted = admin
+	}
+	if disabled, ok := parseBool(params, "disabled"); ok {
+		target.Disabled = disabled
+	}
+
+	if err = target.Save(); err != nil {
+		cx.RespondWithErrorMessage("failed to save user "+target.Username+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// credentials and rights of the user changed, cached authentications are no longer valid
+	auth.InvalidateUser(target.Uuid)
+	logger.Info("user %s updated by %s", target.Username, u.Username)
+	cx.RespondWithData(target)
+	return
+}
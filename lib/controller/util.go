@@ -8,6 +8,7 @@ import (
 	"github.com/MG-RAST/AWE/lib/core"
 	e "github.com/MG-RAST/AWE/lib/errors"
 	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/logger/event"
 	"github.com/MG-RAST/AWE/lib/request"
 	"github.com/MG-RAST/AWE/lib/user"
 	"github.com/MG-RAST/golib/goweb"
@@ -363,6 +364,30 @@ func GetClientGroup(cx *goweb.Context) (cg *core.ClientGroup, done bool) {
 	return
 }
 
+// CheckClientGroupAddress rejects the request if the remote address is not in the ip_cidr list of the clientgroup
+func CheckClientGroupAddress(cx *goweb.Context, cg *core.ClientGroup, action string) (done bool) {
+	done = false
+	if cg == nil {
+		return
+	}
+	ip, err := request.RemoteIP(cx.Request)
+	if err == nil {
+		err = cg.CheckAddress(ip)
+	}
+	if err != nil {
+		remote := cx.Request.RemoteAddr
+		if ip != nil {
+			remote = ip.String()
+		}
+		logger.Event(event.CLIENT_REJECTED, "clientgroup="+cg.Name+";remote="+remote+";action="+action)
+		logger.Error("(CheckClientGroupAddress) %s rejected: %s", action, err.Error())
+		cx.RespondWithErrorMessage("remote address not allowed for clientgroup "+cg.Name, http.StatusForbidden)
+		done = true
+		return
+	}
+	return
+}
+
 func DecodeBase64(cx *goweb.Context, id string) (return_id string) {
 	if strings.HasPrefix(id, "base64:") {
 		id_b64 := strings.TrimPrefix(id, "base64:")
@@ -8,6 +8,7 @@ import (
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core"
 	//"github.com/MG-RAST/AWE/lib/core/cwl"
+	"github.com/MG-RAST/AWE/lib/db"
 	e "github.com/MG-RAST/AWE/lib/errors"
 	"github.com/MG-RAST/AWE/lib/logger"
 	"github.com/MG-RAST/AWE/lib/logger/event"
@@ -15,13 +16,13 @@ import (
 	"github.com/MG-RAST/AWE/lib/user"
 	"github.com/MG-RAST/golib/goweb"
 	//"github.com/davecgh/go-spew/spew"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"sort"
 	"strconv"
 	"strings"
-
-	mgo "gopkg.in/mgo.v2"
+	"time"
 )
 
 type WorkController struct{}
@@ -63,6 +64,9 @@ func (cr *WorkController) Read(id string, cx *goweb.Context) {
 				return
 			}
 		}
+		if CheckClientGroupAddress(cx, cg, "datatoken") {
+			return
+		}
 		// check that clientgroup auth token matches group of client
 		clientid := query.Value("client")
 		client, ok, xerr := core.QMgr.GetClient(clientid, true)
@@ -129,7 +133,7 @@ func (cr *WorkController) Read(id string, cx *goweb.Context) {
 	//}
 	acl, err := core.DBGetJobAcl(jobid)
 	if err != nil {
-		if err == mgo.ErrNotFound {
+		if err == db.ErrNotFound {
 			cx.RespondWithNotFound()
 		} else {
 			// In theory the db connection could be lost between
@@ -140,12 +144,18 @@ func (cr *WorkController) Read(id string, cx *goweb.Context) {
 	}
 
 	// User must have read permissions on job or be job owner or be an admin
-	rights := acl.Check(u.Uuid)
-	if acl.Owner != u.Uuid && rights["read"] == false && u.Admin == false {
+	rights := core.JobRights(acl, u)
+	if rights["read"] == false {
 		cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
 		return
 	}
 
+	if query.Has("report") && query.Value("follow") == "true" { // stream the output of a running workunit
+		if followReport(cx, work_id, query.Value("report")) {
+			return
+		}
+	}
+
 	if query.Has("report") { //retrieve report: stdout or stderr or worknotes
 		reportmsg, err := core.QMgr.GetReportMsg(work_id, query.Value("report"))
 		if err != nil {
@@ -281,6 +291,10 @@ func (cr *WorkController) ReadMany(cx *goweb.Context) {
 		}
 	}
 
+	if CheckClientGroupAddress(cx, cg, "checkout") {
+		return
+	}
+
 	// check that clientgroup auth token matches group of client
 	clientid := query.Value("client")
 	client, ok, err := core.QMgr.GetClient(clientid, true)
@@ -400,6 +414,11 @@ func (cr *WorkController) Update(id string, cx *goweb.Context) {
 		}
 	}
 
+	// results and live logs are subject to the same address restriction as checkouts
+	if CheckClientGroupAddress(cx, cg, "update") {
+		return
+	}
+
 	// check that clientgroup auth token matches group of client
 	clientid := query.Value("client")
 	client, ok, err := core.QMgr.GetClient(clientid, true)
@@ -416,6 +435,11 @@ func (cr *WorkController) Update(id string, cx *goweb.Context) {
 		return
 	}
 
+	if query.Has("livelog") { // output of a running workunit
+		updateLiveLog(cx, work_id, clientid, query)
+		return
+	}
+
 	// old-style
 	var notice *core.Notice
 	if query.Has("status") && query.Has("client") { //notify execution result: "done" or "fail"
@@ -425,13 +449,18 @@ func (cr *WorkController) Update(id string, cx *goweb.Context) {
 		//	return
 		//}
 
-		notice = &core.Notice{Id: work_id, Status: query.Value("status"), WorkerId: query.Value("client"), Notes: ""}
+		notice = &core.Notice{Id: work_id, Status: query.Value("status"), WorkerId: query.Value("client"), Notes: "", ExitStatus: -1}
 		// old-style
 		if query.Has("computetime") {
 			if comptime, err := strconv.Atoi(query.Value("computetime")); err == nil {
 				notice.ComputeTime = comptime
 			}
 		}
+		if query.Has("exitstatus") {
+			if exit_status, err := strconv.Atoi(query.Value("exitstatus")); err == nil {
+				notice.ExitStatus = exit_status
+			}
+		}
 	}
 
 	params, files, err := ParseMultipartForm(cx.Request)
@@ -510,8 +539,129 @@ func (cr *WorkController) Update(id string, cx *goweb.Context) {
 		}
 	}
 
+	// readers of the live logs continue with the saved logs
+	if work_str, err := work_id.String(); err == nil {
+		core.LiveLogs.Close(work_str)
+	}
+
 	core.QMgr.NotifyWorkStatus(*notice)
 	//}
 	cx.RespondWithData("ok")
 	return
 }
+
+// PUT: /work/{id}?client=&livelog=stdout|stderr&offset=
+// new output of a running workunit, the body is the output at position offset of the log file
+func updateLiveLog(cx *goweb.Context, work_id core.Workunit_Unique_Identifier, clientid string, query *Query) {
+	if conf.LIVE_LOG_BUFFER <= 0 {
+		cx.RespondWithErrorMessage("live logs are disabled on this server", http.StatusBadRequest)
+		return
+	}
+	logname := query.Value("livelog")
+	if logname != "stdout" && logname != "stderr" {
+		cx.RespondWithErrorMessage("livelog must be stdout or stderr", http.StatusBadRequest)
+		return
+	}
+	offset, err := strconv.ParseInt(query.Value("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		cx.RespondWithErrorMessage("offset must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	work, err := core.QMgr.GetWorkById(work_id)
+	if err != nil {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+		return
+	}
+	if work.Client != clientid {
+		cx.RespondWithErrorMessage("workunit is not checked out by client "+clientid, http.StatusBadRequest)
+		return
+	}
+
+	max_size := int64(conf.LIVE_LOG_BUFFER) * 1024
+	data, err := ioutil.ReadAll(io.LimitReader(cx.Request.Body, max_size+1))
+	if err != nil {
+		cx.RespondWithErrorMessage("error reading live log: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(len(data)) > max_size {
+		cx.RespondWithErrorMessage(fmt.Sprintf("live log exceeds %d KB", conf.LIVE_LOG_BUFFER), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	work_str, err := work_id.String()
+	if err != nil {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+		return
+	}
+	core.LiveLogs.Append(work_str, logname, work.CheckoutTime, offset, data)
+	cx.RespondWithData("ok")
+	return
+}
+
+// followReport streams stdout or stderr of a queued or running workunit as text until the workunit is finished,
+// returns false if there is nothing to follow
+func followReport(cx *goweb.Context, work_id core.Workunit_Unique_Identifier, logname string) bool {
+	if logname != "stdout" && logname != "stderr" {
+		return false
+	}
+	work_str, err := work_id.String()
+	if err != nil {
+		return false
+	}
+	_, _, _, _, _, live := core.LiveLogs.Read(work_str, logname, time.Time{}, 0)
+	if !live && !workunitInQueue(work_id) {
+		return false
+	}
+
+	flusher, _ := cx.ResponseWriter.(http.Flusher)
+	cx.ResponseWriter.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	cx.ResponseWriter.WriteHeader(http.StatusOK)
+
+	var from int64
+	var attempt time.Time
+	for {
+		data, next, skipped, current, changed, live := core.LiveLogs.Read(work_str, logname, attempt, from)
+		if live {
+			if !current.Equal(attempt) {
+				// the workunit was requeued, the output of the new attempt starts at offset 0
+				if from > 0 {
+					fmt.Fprintf(cx.ResponseWriter, "\n[... workunit restarted ...]\n")
+				}
+				attempt = current
+			}
+			if skipped > 0 {
+				fmt.Fprintf(cx.ResponseWriter, "\n[... %d bytes skipped ...]\n", skipped)
+			}
+			cx.ResponseWriter.Write(data)
+			from = next
+		} else if !workunitInQueue(work_id) {
+			// the workunit is finished, the rest is in the saved log
+			if report, err := core.QMgr.GetReportMsg(work_id, logname); err == nil {
+				if int64(len(report)) < from {
+					// a later attempt finished without live output
+					fmt.Fprintf(cx.ResponseWriter, "\n[... workunit restarted ...]\n")
+					from = 0
+				}
+				cx.ResponseWriter.Write([]byte(report[from:]))
+			}
+			return true
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		// without a buffer, e.g. the workunit has not started yet, the queue is polled
+		select {
+		case <-changed:
+		case <-time.After(2 * time.Second):
+		case <-cx.Request.Context().Done():
+			return true
+		}
+	}
+}
+
+func workunitInQueue(work_id core.Workunit_Unique_Identifier) bool {
+	_, err := core.QMgr.GetWorkById(work_id)
+	return err == nil
+}
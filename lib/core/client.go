@@ -31,11 +31,12 @@ type Client struct {
 	Tag             bool          `bson:"-" json:"-"`
 	Proxy           bool          `bson:"proxy" json:"proxy"`
 	SubClients      int           `bson:"subclients" json:"subclients"`
-	Online          bool          `bson:"online" json:"online"`                 // a state
-	Suspended       bool          `bson:"suspended" json:"suspended"`           // a state
-	Suspend_reason  string        `bson:"suspend_reason" json:"suspend_reason"` // a state
-	Status          string        `bson:"Status" json:"Status"`                 // 1) suspended? 2) busy ? 3) online (call is idle) 4) offline
-	Assigned_work   *WorkunitList `bson:"assigned_work" json:"assigned_work"`   // this is for exporting into json
+	Online          bool          `bson:"online" json:"online"`                   // a state
+	Suspended       bool          `bson:"suspended" json:"suspended"`             // a state
+	Suspend_reason  string        `bson:"suspend_reason" json:"suspend_reason"`   // a state
+	Status          string        `bson:"Status" json:"Status"`                   // 1) suspended? 2) draining? 3) busy ? 4) online (call is idle) 5) offline
+	Drain_requested bool          `bson:"drain_requested" json:"drain_requested"` // drain instruction is sent with the next heartbeat
+	Assigned_work   *WorkunitList `bson:"assigned_work" json:"assigned_work"`     // this is for exporting into json
 }
 
 // worker info that does not change at runtime
@@ -58,8 +59,28 @@ type WorkerRuntime struct {
 
 // changes at runtime
 type WorkerState struct {
-	Busy         bool          `bson:"busy" json:"busy"` // a state
-	Current_work *WorkunitList `bson:"current_work" json:"current_work"`
+	Busy         bool               `bson:"busy" json:"busy"`         // a state
+	Draining     bool               `bson:"draining" json:"draining"` // worker finishes its current work and exits
+	Current_work *WorkunitList      `bson:"current_work" json:"current_work"`
+	PredataCache *PredataCacheState `bson:"predata_cache,omitempty" json:"predata_cache,omitempty"`
+}
+
+// contents and statistics of the predata cache of a worker, reported with the heartbeat
+type PredataCacheState struct {
+	Size    int64              `bson:"size" json:"size"`
+	MaxSize int64              `bson:"max_size" json:"max_size"` // 0 means no limit
+	Hits    int                `bson:"hits" json:"hits"`
+	Misses  int                `bson:"misses" json:"misses"`
+	Evicted int                `bson:"evicted" json:"evicted"`
+	Md5     []string           `bson:"md5" json:"md5"` // md5 checksums of the cached shock predata, used for data-locality scheduling
+	Files   []PredataCacheFile `bson:"files" json:"files"`
+}
+
+type PredataCacheFile struct {
+	Name       string    `bson:"name" json:"name"`
+	Size       int64     `bson:"size" json:"size"`
+	LastAccess time.Time `bson:"last_access" json:"last_access"`
+	InUse      bool      `bson:"in_use" json:"in_use"`
 }
 
 func NewWorkerState() (ws *WorkerState) {
@@ -261,13 +282,18 @@ func (cl *Client) Update_Status(write_lock bool) (err error) {
 		defer cl.Unlock()
 	}
 
-	// 1) suspended? 2) busy ? 3) online (call is idle) 4) offline
+	// 1) suspended? 2) draining? 3) busy ? 4) online (call is idle) 5) offline
 
 	if cl.Suspended {
 		cl.Status = "suspended"
 		return
 	}
 
+	if cl.Draining || cl.Drain_requested {
+		cl.Status = "draining"
+		return
+	}
+
 	if cl.Busy {
 		cl.Status = "busy"
 		return
@@ -325,6 +351,19 @@ func (cl *Client) Get_Suspended(do_read_lock bool) (s bool, err error) {
 	return
 }
 
+func (cl *Client) Get_Draining(do_read_lock bool) (d bool, err error) {
+	if do_read_lock {
+		read_lock, xerr := cl.RLockNamed("Get_Draining")
+		if xerr != nil {
+			err = xerr
+			return
+		}
+		defer cl.RUnlockNamed(read_lock)
+	}
+	d = cl.Draining || cl.Drain_requested
+	return
+}
+
 func (cl *Client) Set_Online(o bool, write_lock bool) (err error) {
 	if write_lock {
 		err = cl.LockNamed("Set_Online")
@@ -341,6 +380,18 @@ func (cl *Client) Set_Online(o bool, write_lock bool) (err error) {
 	return
 }
 
+func (cl *Client) Set_Draining(d bool, write_lock bool) (err error) {
+	if write_lock {
+		err = cl.LockNamed("Set_Draining")
+		if err != nil {
+			return
+		}
+		defer cl.Unlock()
+	}
+	cl.Draining = d
+	return
+}
+
 func (cl *Client) Set_Busy(b bool, do_write_lock bool) (err error) {
 	if do_write_lock {
 		err = cl.LockNamed("Set_Busy")
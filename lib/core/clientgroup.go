@@ -9,9 +9,11 @@ import (
 	"github.com/MG-RAST/AWE/lib/user"
 	"github.com/MG-RAST/golib/uniuri"
 	"gopkg.in/mgo.v2/bson"
+	"net"
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -30,6 +32,9 @@ var (
 	CGNameRegex = regexp.MustCompile(`^[A-Za-z0-9\_\-\.]+$`)
 )
 
+// clientgroups created before IPv6 support stored this default, it allows all clients
+const CG_IP_CIDR_LEGACY_DEFAULT = "0.0.0.0/0"
+
 func CreateClientGroup(name string, u *user.User) (cg *ClientGroup, err error) {
 	q := bson.M{"name": name}
 	clientgroups := new(ClientGroups)
@@ -47,7 +52,7 @@ func CreateClientGroup(name string, u *user.User) (cg *ClientGroup, err error) {
 
 	cg = new(ClientGroup)
 	cg.Id = uuid.New()
-	cg.IP_CIDR = "0.0.0.0/0"
+	cg.IP_CIDR = "0.0.0.0/0,::/0"
 	cg.Name = name
 	cg.Expiration = t.AddDate(10, 0, 0)
 	cg.Acl.SetOwner(u.Uuid)
@@ -72,6 +77,69 @@ func (cg *ClientGroup) SetToken() {
 	return
 }
 
+// SetIPCIDR validates a comma separated list of CIDRs and stores it in normalized form.
+// Note that 0.0.0.0/0 alone is the legacy default and allows IPv6 clients as well.
+func (cg *ClientGroup) SetIPCIDR(ip_cidr string) (err error) {
+	networks, err := ParseCIDRList(ip_cidr)
+	if err != nil {
+		return
+	}
+	if len(networks) == 0 {
+		err = errors.New("(SetIPCIDR) ip_cidr must contain at least one CIDR")
+		return
+	}
+	list := []string{}
+	for _, network := range networks {
+		list = append(list, network.String())
+	}
+	cg.IP_CIDR = strings.Join(list, ",")
+	return
+}
+
+// CheckAddress returns an error if ip is not contained in the ip_cidr list of the clientgroup.
+// An empty list and the legacy default do not restrict the clients.
+func (cg *ClientGroup) CheckAddress(ip net.IP) (err error) {
+	if strings.TrimSpace(cg.IP_CIDR) == CG_IP_CIDR_LEGACY_DEFAULT {
+		return
+	}
+	networks, err := ParseCIDRList(cg.IP_CIDR)
+	if err != nil {
+		err = fmt.Errorf("(CheckAddress) clientgroup %s has invalid ip_cidr: %s", cg.Name, err.Error())
+		return
+	}
+	if len(networks) == 0 {
+		return
+	}
+	if ip == nil {
+		err = fmt.Errorf("(CheckAddress) remote address unknown")
+		return
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return
+		}
+	}
+	err = fmt.Errorf("(CheckAddress) address %s not allowed for clientgroup %s", ip.String(), cg.Name)
+	return
+}
+
+// ParseCIDRList parses a comma separated list of CIDRs, empty entries are ignored
+func ParseCIDRList(list string) (networks []*net.IPNet, err error) {
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		_, network, xerr := net.ParseCIDR(entry)
+		if xerr != nil {
+			err = fmt.Errorf("(ParseCIDRList) net.ParseCIDR returned: %s", xerr.Error())
+			return
+		}
+		networks = append(networks, network)
+	}
+	return
+}
+
 func (cg *ClientGroup) Save() (err error) {
 	cg.LastModified = time.Now()
 	err = dbUpsert(cg)
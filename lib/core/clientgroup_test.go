@@ -0,0 +1,67 @@
+package core
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseCIDRList(t *testing.T) {
+	networks, err := ParseCIDRList(" 10.0.0.0/8, ,192.168.1.0/24,2001:db8::/32,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 3 || networks[1].String() != "192.168.1.0/24" {
+		t.Fatalf("unexpected networks %v", networks)
+	}
+	for _, list := range []string{"10.0.0.1", "10.0.0.0/33", "10.0.0.0/8,foo"} {
+		if _, err = ParseCIDRList(list); err == nil {
+			t.Fatalf("invalid list %s accepted", list)
+		}
+	}
+}
+
+func TestClientGroupCheckAddress(t *testing.T) {
+	tests := []struct {
+		ip_cidr string
+		ip      string
+		allowed bool
+	}{
+		{"10.0.0.0/8", "10.1.2.3", true},
+		{"10.0.0.0/8", "11.1.2.3", false},
+		{"10.0.0.0/8,192.168.1.0/24", "192.168.1.7", true},
+		{"10.0.0.0/8,192.168.1.0/24", "192.168.2.7", false},
+		{"2001:db8::/32", "2001:db8::1", true},
+		{"2001:db8::/32", "10.1.2.3", false},
+		{"0.0.0.0/0", "10.1.2.3", true},
+		// the legacy default also allows IPv6 clients
+		{"0.0.0.0/0", "2001:db8::1", true},
+		{" 0.0.0.0/0 ", "2001:db8::1", true},
+		// 0.0.0.0/0 in a list is an explicit IPv4 restriction
+		{"0.0.0.0/0,10.0.0.0/8", "2001:db8::1", false},
+		{"", "10.1.2.3", true},
+	}
+	for _, test := range tests {
+		cg := &ClientGroup{Name: "test", IP_CIDR: test.ip_cidr}
+		err := cg.CheckAddress(net.ParseIP(test.ip))
+		if (err == nil) != test.allowed {
+			t.Fatalf("ip_cidr %q, address %s: allowed=%t, got error %v", test.ip_cidr, test.ip, test.allowed, err)
+		}
+	}
+
+	// unknown addresses and invalid lists are rejected
+	cg := &ClientGroup{Name: "test", IP_CIDR: "10.0.0.0/8"}
+	if err := cg.CheckAddress(nil); err == nil {
+		t.Fatalf("unknown address allowed")
+	}
+	cg.IP_CIDR = "10.0.0.0/33"
+	if err := cg.CheckAddress(net.ParseIP("10.1.2.3")); err == nil {
+		t.Fatalf("invalid ip_cidr allowed")
+	}
+
+	if err := cg.SetIPCIDR(" 10.1.2.3/8 ,192.168.1.0/24"); err != nil || cg.IP_CIDR != "10.0.0.0/8,192.168.1.0/24" {
+		t.Fatalf("unexpected ip_cidr %q: %v", cg.IP_CIDR, err)
+	}
+	if err := cg.SetIPCIDR(" , "); err == nil {
+		t.Fatalf("empty ip_cidr accepted")
+	}
+}
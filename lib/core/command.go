@@ -18,6 +18,7 @@ type Command struct {
 	Environ       Envs     `bson:"environ" json:"environ" mapstructure:"environ"`
 	HasPrivateEnv bool     `bson:"has_private_env" json:"has_private_env" mapstructure:"has_private_env"`
 	Description   string   `bson:"description" json:"description" mapstructure:"description"`
+	Walltime      int      `bson:"walltime" json:"walltime" mapstructure:"walltime"` // maximum runtime in seconds, 0 means no limit
 	ParsedArgs    []string `bson:"-" json:"-" mapstructure:"-"`
 	Local         bool     // indicates local execution, i.e. working directory is same as current working directory (do not delete !)
 }
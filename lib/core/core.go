@@ -1,6 +1,7 @@
 package core
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -96,13 +97,44 @@ type HeartbeatInstructions map[string]string //map[op]obj1,obj2 e.g. map[discard
 
 func CreateJobUpload(u *user.User, files FormFiles) (job *Job, err error) {
 
+	job, err = NewJobUpload(u, files)
+	if err != nil {
+		return
+	}
+
+	err = job.Mkdir()
+	if err != nil {
+		err = errors.New("(CreateJobUpload) error creating job directory, error=" + err.Error())
+		return
+	}
+
+	err = job.UpdateFile(files, "upload")
+	if err != nil {
+		err = errors.New("error in UpdateFile, error=" + err.Error())
+		return
+	}
+
+	err = job.Save()
+	if err != nil {
+		err = errors.New("error in job.Save(), error=" + err.Error())
+		return
+	}
+
+	logger.Debug(3, "OWNER4: %s", job.Acl.Owner)
+
+	return
+}
+
+// NewJobUpload parses an uploaded job document, nothing is written to disk or mongo
+func NewJobUpload(u *user.User, files FormFiles) (job *Job, err error) {
+
 	upload_file, has_upload := files["upload"]
 
 	if has_upload {
 		upload_file_path := upload_file.Path
 		job, err = ReadJobFile(upload_file_path)
 		if err != nil {
-			err = fmt.Errorf("(CreateJobUpload) Parsing: Failed (default and deprecated format) %s", err.Error())
+			err = fmt.Errorf("(NewJobUpload) Parsing: Failed (default and deprecated format) %s", err.Error())
 			logger.Debug(3, err.Error())
 			return
 		} else {
@@ -110,7 +142,7 @@ func CreateJobUpload(u *user.User, files FormFiles) (job *Job, err error) {
 		}
 
 	} else {
-		err = errors.New("(CreateJobUpload) has_upload is missing")
+		err = errors.New("(NewJobUpload) has_upload is missing")
 		return
 		// job, err = ParseAwf(files["awf"].Path)
 		// if err != nil {
@@ -130,28 +162,12 @@ func CreateJobUpload(u *user.User, files FormFiles) (job *Job, err error) {
 	job.Acl.SetOwner(u.Uuid)
 	logger.Debug(3, "OWNER2: %s", job.Acl.Owner)
 	job.Acl.Set(u.Uuid, acl.Rights{"read": true, "write": true, "delete": true})
-
-	logger.Debug(3, "OWNER3: %s", job.Acl.Owner)
-
-	err = job.Mkdir()
-	if err != nil {
-		err = errors.New("(CreateJobUpload) error creating job directory, error=" + err.Error())
-		return
-	}
-
-	err = job.UpdateFile(files, "upload")
+	err = ApplyProjectAcl(job)
 	if err != nil {
-		err = errors.New("error in UpdateFile, error=" + err.Error())
 		return
 	}
 
-	err = job.Save()
-	if err != nil {
-		err = errors.New("error in job.Save(), error=" + err.Error())
-		return
-	}
-
-	logger.Debug(3, "OWNER4: %s", job.Acl.Owner)
+	logger.Debug(3, "OWNER3: %s", job.Acl.Owner)
 
 	return
 }
@@ -201,6 +217,10 @@ func CreateJobImport(u *user.User, file FormFile) (job *Job, err error) {
 	// Once, job has been created, set job owner and add owner to all ACL's
 	job.Acl.SetOwner(u.Uuid)
 	job.Acl.Set(u.Uuid, acl.Rights{"read": true, "write": true, "delete": true})
+	err = ApplyProjectAcl(job)
+	if err != nil {
+		return
+	}
 
 	err = job.Mkdir()
 	if err != nil {
@@ -496,7 +516,7 @@ func NotifyWorkunitProcessedWithLogs(work *Workunit, perf *WorkPerf, sendstdlogs
 		target_url = fmt.Sprintf("%s/work/%s?client=%s", conf.SERVER_URL, work_id_b64, Self.Id) // client info is needed for authentication
 	} else {
 		// old AWE style result reporting (note that nodes had been created by the AWE server)
-		target_url = fmt.Sprintf("%s/work/%s?status=%s&client=%s&computetime=%d", conf.SERVER_URL, work_id_b64, work.State, Self.Id, work.ComputeTime)
+		target_url = fmt.Sprintf("%s/work/%s?status=%s&client=%s&computetime=%d&exitstatus=%d", conf.SERVER_URL, work_id_b64, work.State, Self.Id, work.ComputeTime, work.ExitStatus)
 	}
 	form := httpclient.NewForm()
 	hasreport := false
@@ -530,6 +550,7 @@ func NotifyWorkunitProcessedWithLogs(work *Workunit, perf *WorkPerf, sendstdlogs
 		cwl_result.Results = work.CWL_workunit.Outputs
 		cwl_result.Status = work.State
 		cwl_result.ComputeTime = work.ComputeTime
+		cwl_result.ExitStatus = work.ExitStatus
 
 		var result_bytes []byte
 		result_bytes, err = json.Marshal(cwl_result)
@@ -586,6 +607,46 @@ func NotifyWorkunitProcessedWithLogs(work *Workunit, perf *WorkPerf, sendstdlogs
 	return
 }
 
+// SendLiveLog sends new output of a running workunit, offset is the position of data in the log file
+func SendLiveLog(work *Workunit, logname string, offset int64, data []byte) (err error) {
+	var work_str string
+	work_str, err = work.String()
+	if err != nil {
+		err = fmt.Errorf("(SendLiveLog) workid.String() returned: %s", err.Error())
+		return
+	}
+
+	work_id_b64 := "base64:" + base64.StdEncoding.EncodeToString([]byte(work_str))
+	target_url := fmt.Sprintf("%s/work/%s?client=%s&livelog=%s&offset=%d", conf.SERVER_URL, work_id_b64, Self.Id, logname, offset)
+
+	headers := httpclient.Header{
+		"Content-Type":   []string{"application/octet-stream"},
+		"Content-Length": []string{strconv.Itoa(len(data))},
+	}
+	if conf.CLIENT_GROUP_TOKEN != "" {
+		headers["Authorization"] = []string{"CG_TOKEN " + conf.CLIENT_GROUP_TOKEN}
+	}
+	logger.Debug(3, "PUT %s", target_url)
+	res, err := httpclient.Put(target_url, headers, bytes.NewReader(data), nil)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	jsonstream, _ := ioutil.ReadAll(res.Body)
+	response := new(StandardResponse)
+	err = json.Unmarshal(jsonstream, response)
+	if err != nil {
+		err = fmt.Errorf("(SendLiveLog) failed to marshal response:\"%s\"", jsonstream)
+		return
+	}
+	if len(response.Error) > 0 {
+		err = errors.New(strings.Join(response.Error, ","))
+		return
+	}
+	return
+}
+
 // deprecated, see cache.UploadOutputData
 func PushOutputData(work *Workunit) (size int64, err error) {
 	for _, io := range work.Outputs {
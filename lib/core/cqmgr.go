@@ -26,6 +26,7 @@ type CQMgr struct {
 	coReq        chan CoReq  //workunit checkout request (WorkController -> qmgr.Handler)
 	feedback     chan Notice //workunit execution feedback (WorkController -> qmgr.Handler)
 	coSem        chan int    //semaphore for checkout (mutual exclusion between different clients)
+	predataIndex *PredataIndex
 }
 
 type Filter_work_stats struct {
@@ -33,6 +34,8 @@ type Filter_work_stats struct {
 	Skip_work         int
 	Wrong_clientgroup int
 	Wrong_app         int
+	Backoff           int
+	Locality          int
 }
 
 //--------mgr methods-------
@@ -76,6 +79,10 @@ func (qm *CQMgr) RemoveClient(id string, lock bool) (err error) {
 		logger.Error("(CheckClient) %s", err.Error())
 	}
 
+	if qm.predataIndex != nil {
+		qm.predataIndex.Remove(id)
+	}
+
 	err = qm.clientMap.Delete(id, lock)
 	return
 }
@@ -245,7 +252,114 @@ func (qm *CQMgr) ClientChecker() {
 			qm.DeleteClients(delete_clients)
 
 		}
+
+		qm.CheckWalltime()
+	}
+}
+
+// CheckWalltime requeues checked-out workunits that exceed their walltime plus grace period, even if the client is still alive
+func (qm *CQMgr) CheckWalltime() {
+
+	workunits, err := qm.workQueue.Checkout.GetWorkunits()
+	if err != nil {
+		logger.Error("(CheckWalltime) GetWorkunits: %s", err.Error())
+		return
+	}
+
+	grace := time.Duration(conf.WALLTIME_GRACE) * time.Second
+
+	for _, work := range workunits {
+		if !work.WalltimeExceeded(grace) {
+			continue
+		}
+
+		work_str, xerr := work.String()
+		if xerr != nil {
+			logger.Error("(CheckWalltime) work.String: %s", xerr.Error())
+			continue
+		}
+		reason := fmt.Sprintf("walltime of %d seconds exceeded", work.Cmd.Walltime)
+		logger.Event(event.WORK_TIMEOUT, "workid="+work_str+";clientid="+work.Client)
+		RecordJobEvent(work.JobId, event.WORK_TIMEOUT, "", work.Client, map[string]string{"workid": work_str})
+
+		work.Attempts = append(work.Attempts, &WorkAttempt{
+			Client:     work.Client,
+			State:      WORK_STAT_ERROR,
+			ExitStatus: -1,
+			Notes:      reason,
+			Time:       time.Now(),
+		})
+		// the old client is told to discard the workunit with its next heartbeat, notices it still sends are rejected
+		old_client := work.Client
+
+		retry := false
+		task, xerr := getWorkTask(work)
+		if xerr != nil {
+			logger.Error("(CheckWalltime) %s", xerr.Error())
+		} else {
+			retry, _, xerr = task.WorkFailed(work, -1, "")
+			if xerr != nil {
+				logger.Error("(CheckWalltime) %s", xerr.Error())
+			}
+		}
+		if retry {
+			qm.workQueue.StatusChange(Workunit_Unique_Identifier{}, work, WORK_STAT_QUEUED, reason)
+			logger.Event(event.WORK_REQUEUE, "workid="+work_str)
+			qm.unassignWork(old_client, work.Workunit_Unique_Identifier)
+			continue
+		}
+
+		// failure time exceeds limit, suspend job
+		qm.workQueue.StatusChange(Workunit_Unique_Identifier{}, work, WORK_STAT_SUSPEND, reason)
+		logger.Event(event.WORK_SUSPEND, "workid="+work_str)
+		qm.unassignWork(old_client, work.Workunit_Unique_Identifier)
+
+		task_str, _ := work.GetTask().String()
+		jerror := &JobError{
+			ClientFailed: old_client,
+			WorkFailed:   work_str,
+			TaskFailed:   task_str,
+			ServerNotes:  fmt.Sprintf("workunit failed %d time(s), %s", work.Failed, reason),
+			Status:       JOB_STAT_SUSPEND,
+		}
+		if err = QMgr.SuspendJob(work.JobId, jerror); err != nil {
+			logger.Error("(CheckWalltime:SuspendJob) job_id=%s; err=%s", work.JobId, err.Error())
+		}
+	}
+	return
+}
+
+// unassignWork removes a requeued or suspended workunit from the client that had it checked out
+func (qm *CQMgr) unassignWork(client_id string, work_id Workunit_Unique_Identifier) {
+	if client_id == "" {
+		return
+	}
+	client, ok, err := qm.GetClient(client_id, true)
+	if err != nil || !ok {
+		return
+	}
+	err = client.Assigned_work.Delete(work_id, true)
+	if err != nil {
+		logger.Error("(unassignWork) client %s: %s", client_id, err.Error())
+	}
+}
+
+// getWorkTask returns the task of the workunit from the job
+func getWorkTask(work *Workunit) (task *Task, err error) {
+	job, err := GetJob(work.JobId)
+	if err != nil {
+		return
+	}
+	task_id := work.GetTask()
+	for _, t := range job.TaskList() {
+		if t.Task_Unique_Identifier == task_id {
+			task = t
+			return
+		}
 	}
+	task_str, _ := task_id.String()
+	err = fmt.Errorf("(getWorkTask) task %s not found in job %s", task_str, work.JobId)
+	return
 }
 
 func (qm *CQMgr) DeleteClients(delete_clients []string) {
@@ -286,13 +400,23 @@ func (qm *CQMgr) ClientHeartBeat(id string, cg *ClientGroup, workerstate WorkerS
 
 	client.WorkerState = workerstate // TODO could do a comparsion with assigned state here
 
+	if qm.predataIndex != nil && workerstate.PredataCache != nil {
+		qm.predataIndex.Update(id, workerstate.PredataCache.Md5)
+	}
+
 	_ = client.Update_Status(false)
 
 	logger.Debug(3, "HeartBeatFrom:"+"clientid="+id)
 
-	//get suspended workunit that need the client to discard
+	if client.Drain_requested && !workerstate.Draining {
+		hbmsg["drain"] = id
+	}
+
+	//get suspended workunit that need the client to discard, or workunits that have been requeued
+	//(e.g. walltime exceeded) and are not checked out by this client anymore
 	current_work, xerr := client.Current_work.Get_list(false)
 	suspended := []string{}
+	adopting := isAdopting()
 
 	for _, work_id := range current_work {
 		work, ok, zerr := qm.workQueue.all.Get(work_id)
@@ -306,6 +430,9 @@ func (qm *CQMgr) ClientHeartBeat(id string, cg *ClientGroup, workerstate WorkerS
 
 		if work.State == WORK_STAT_SUSPEND {
 			suspended = append(suspended, work.Id)
+		} else if work.Client != id && !adopting {
+			// while a recovering server or a new leader adopts the running workunits they are queued without client
+			suspended = append(suspended, work.Id)
 		}
 
 	}
@@ -432,7 +559,7 @@ func (qm *CQMgr) GetAllClientsByUser(u *user.User) (clients []*Client, err error
 	dbFindClientGroups(q, clientgroups)
 	filtered_clientgroups := map[string]bool{}
 	for _, cg := range *clientgroups {
-		rights := cg.Acl.Check(u.Uuid)
+		rights := cg.Acl.Check(u.AclIds()...)
 		if (u.Uuid != "public" && (cg.Acl.Owner == u.Uuid || rights["read"] == true || u.Admin == true || cg.Acl.Owner == "public")) ||
 			(u.Uuid == "public" && conf.CLIENT_AUTH_REQ == false && cg.Acl.Owner == "public") {
 			filtered_clientgroups[cg.Name] = true
@@ -524,6 +651,39 @@ func (qm *CQMgr) SuspendClient(id string, client *Client, reason string, client_
 
 }
 
+// DrainClient asks the worker to finish its current work, deregister and exit
+func (qm *CQMgr) DrainClient(id string) (err error) {
+	client, ok, err := qm.GetClient(id, true)
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = errors.New(e.ClientNotFound)
+		return
+	}
+
+	err = client.LockNamed("DrainClient")
+	if err != nil {
+		return
+	}
+	defer client.Unlock()
+
+	client.Drain_requested = true
+	client.Update_Status(false)
+	logger.Event(event.CLIENT_DRAIN, "clientid="+id)
+	return
+}
+
+func (qm *CQMgr) DrainClientByUser(id string, u *user.User) (err error) {
+	// same clientgroup permissions as for reading the client
+	client, err := qm.GetClientByUser(id, u)
+	if err != nil {
+		return
+	}
+	err = qm.DrainClient(client.Id)
+	return
+}
+
 func (qm *CQMgr) SuspendAllClients(reason string) (count int, err error) {
 	clients, err := qm.ListClients()
 	if err != nil {
@@ -819,6 +979,16 @@ func (qm *CQMgr) CheckoutWorkunits(req_policy string, client_id string, client *
 		return
 	}
 
+	is_draining, err := client.Get_Draining(true)
+	if err != nil {
+		return
+	}
+
+	if is_draining {
+		err = errors.New(e.ClientDraining)
+		return
+	}
+
 	//if status == CLIENT_STAT_DELETED {
 	//	qm.RemoveClient(client_id, false)
 	//	return nil, errors.New(e.ClientDeleted)
@@ -951,7 +1121,11 @@ func (qm *CQMgr) popWorks(req CoReq) (client_specific_workunits []*Workunit, err
 
 		return
 	}
-	client_specific_workunits, err = qm.workQueue.selectWorkunits(filtered, req.policy, req.available, req.count)
+	var cached map[string]bool
+	if qm.predataIndex != nil {
+		cached = qm.predataIndex.Get(client_id)
+	}
+	client_specific_workunits, err = qm.workQueue.selectWorkunits(filtered, req.policy, req.available, req.count, cached)
 	if err != nil {
 		err = fmt.Errorf("(popWorks) selectWorkunits returned: %s", err.Error())
 		return
@@ -971,7 +1145,7 @@ func (qm *CQMgr) popWorks(req CoReq) (client_specific_workunits []*Workunit, err
 // client has to be read-locked
 func (qm *CQMgr) filterWorkByClient(client *Client) (workunits WorkList, s Filter_work_stats, err error) {
 
-	s = Filter_work_stats{0, 0, 0, 0}
+	s = Filter_work_stats{0, 0, 0, 0, 0, 0}
 
 	if client == nil {
 		err = fmt.Errorf("(filterWorkByClient) client == nil")
@@ -1010,6 +1184,18 @@ func (qm *CQMgr) filterWorkByClient(client *Client) (workunits WorkList, s Filte
 			s.Skip_work += 1
 			continue
 		}
+		//skip works that wait for a retry backoff
+		if workunit.NotBefore.After(time.Now()) {
+			logger.Debug(3, "2) workunit %s is not retried before %s", id, workunit.NotBefore)
+			s.Backoff += 1
+			continue
+		}
+		//skip works whose predata is cached by another client, for a limited time
+		if qm.reservedForPredataHolder(clientid, workunit) {
+			logger.Debug(3, "2) workunit %s is reserved for a client holding its predata", id)
+			s.Locality += 1
+			continue
+		}
 		//skip works that have dedicate client groups which this client doesn't belong to
 		if len(workunit.Info.ClientGroups) > 0 {
 			eligible_groups := strings.Split(workunit.Info.ClientGroups, ",")
@@ -1088,8 +1274,8 @@ func (qm *CQMgr) ShowWorkunitsByUser(status string, u *user.User) (workunits []*
 			jobid := work.JobId
 
 			if job, err := GetJob(jobid); err == nil {
-				rights := job.Acl.Check(u.Uuid)
-				if job.Acl.Owner == u.Uuid || rights["read"] == true {
+				rights := JobRights(job.Acl, u)
+				if rights["read"] == true {
 					if work.State == status || status == "" {
 						workunits = append(workunits, work)
 					}
@@ -104,6 +104,22 @@ func NewRequirement(class string, obj interface{}) (r Requirement, schemata []CW
 		}
 		return
 
+	case "WorkReuse":
+		r, err = NewWorkReuse(obj)
+		if err != nil {
+			err = fmt.Errorf("(NewRequirement) NewWorkReuse returns: %s", err.Error())
+			return
+		}
+		return
+
+	case "ToolTimeLimit":
+		r, err = NewToolTimeLimit(obj)
+		if err != nil {
+			err = fmt.Errorf("(NewRequirement) NewToolTimeLimit returns: %s", err.Error())
+			return
+		}
+		return
+
 	case "SubworkflowFeatureRequirement":
 		this_r := DummyRequirement{}
 		this_r.Class = "SubworkflowFeatureRequirement"
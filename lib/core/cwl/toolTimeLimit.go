@@ -0,0 +1,76 @@
+package cwl
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// http://www.commonwl.org/v1.1/CommandLineTool.html#ToolTimeLimit
+// Set an upper limit on the execution time of a CommandLineTool. A tool execution which exceeds the time limit may be preemptively terminated and considered failed.
+type ToolTimeLimit struct {
+	BaseRequirement `bson:",inline" yaml:",inline" json:",inline" mapstructure:",squash"`
+	Timelimit       int `yaml:"timelimit" bson:"timelimit" json:"timelimit" mapstructure:"timelimit"` // seconds, 0 means no limit
+}
+
+func (c ToolTimeLimit) GetId() string { return "None" }
+
+func NewToolTimeLimit(original interface{}) (r *ToolTimeLimit, err error) {
+	var requirement ToolTimeLimit
+	r = &requirement
+
+	original, err = MakeStringMap(original)
+	if err != nil {
+		return
+	}
+
+	original_map, ok := original.(map[string]interface{})
+	if !ok {
+		err = fmt.Errorf("(NewToolTimeLimit) type error, got %s", reflect.TypeOf(original))
+		return
+	}
+
+	// expressions are not supported
+	switch timelimit := original_map["timelimit"].(type) {
+	case int:
+		requirement.Timelimit = timelimit
+	case int64:
+		requirement.Timelimit = int(timelimit)
+	case float64:
+		requirement.Timelimit = int(timelimit)
+	case nil:
+	default:
+		err = fmt.Errorf("(NewToolTimeLimit) timelimit has to be an integer, got %s", reflect.TypeOf(timelimit))
+		return
+	}
+
+	if requirement.Timelimit < 0 {
+		err = fmt.Errorf("(NewToolTimeLimit) timelimit must not be negative")
+		return
+	}
+
+	requirement.Class = "ToolTimeLimit"
+	return
+}
+
+// GetTimeLimit returns the time limit in seconds, requirements take precedence over hints, 0 means no limit
+func GetTimeLimit(hints []Requirement, requirements *[]Requirement) (timelimit int) {
+
+	for i, _ := range hints {
+		r, ok := hints[i].(*ToolTimeLimit)
+		if ok {
+			timelimit = r.Timelimit
+		}
+	}
+
+	if requirements == nil {
+		return
+	}
+	for i, _ := range *requirements {
+		r, ok := (*requirements)[i].(*ToolTimeLimit)
+		if ok {
+			timelimit = r.Timelimit
+		}
+	}
+
+	return
+}
@@ -0,0 +1,48 @@
+package cwl
+
+import (
+	"github.com/mitchellh/mapstructure"
+)
+
+// http://www.commonwl.org/v1.1/CommandLineTool.html#WorkReuse
+// For implementations that support reusing output from past work (on the assumption that same code and same input produce same results), control whether to enable or disable the reuse behavior for a particular tool or step.
+type WorkReuse struct {
+	BaseRequirement `bson:",inline" yaml:",inline" json:",inline" mapstructure:",squash"`
+	EnableReuse     bool `yaml:"enableReuse" bson:"enableReuse" json:"enableReuse" mapstructure:"enableReuse"`
+}
+
+func (c WorkReuse) GetId() string { return "None" }
+
+func NewWorkReuse(original interface{}) (r *WorkReuse, err error) {
+	var requirement WorkReuse
+	r = &requirement
+
+	requirement.EnableReuse = true // default according to spec
+	err = mapstructure.Decode(original, &requirement)
+
+	requirement.Class = "WorkReuse"
+	return
+}
+
+// WorkReuseEnabled returns false if any WorkReuse hint or requirement disables reuse
+func WorkReuseEnabled(hints []Requirement, requirements *[]Requirement) bool {
+
+	for i, _ := range hints {
+		r, ok := hints[i].(*WorkReuse)
+		if ok && !r.EnableReuse {
+			return false
+		}
+	}
+
+	if requirements == nil {
+		return true
+	}
+	for i, _ := range *requirements {
+		r, ok := (*requirements)[i].(*WorkReuse)
+		if ok && !r.EnableReuse {
+			return false
+		}
+	}
+
+	return true
+}
@@ -181,15 +181,47 @@ func CreateTasks(job *Job, workflow string, steps []cwl.WorkflowStep) (tasks []*
 
 func CWL2AWE(_user *user.User, files FormFiles, job_input *cwl.Job_document, cwl_workflow *cwl.Workflow, collection *cwl.CWL_collection) (job *Job, err error) {
 
+	job, err = NewCWLJob(_user, job_input, cwl_workflow)
+	if err != nil {
+		return
+	}
+
+	err = job.Mkdir()
+	if err != nil {
+		err = errors.New("(CWL2AWE) error creating job directory, error=" + err.Error())
+		return
+	}
+
+	err = job.UpdateFile(files, "cwl") // TODO that may not make sense. Check if I should store AWE job.
+	if err != nil {
+		err = errors.New("error in UpdateFile, error=" + err.Error())
+		return
+	}
+
+	//spew.Dump(job)
+
+	logger.Debug(1, "job.Id: %s", job.Id)
+	err = job.Save()
+	if err != nil {
+		err = errors.New("error in job.Save(), error=" + err.Error())
+		return
+	}
+	return
+
+}
+
+// NewCWLJob creates the AWE job and its tasks for a CWL workflow, nothing is written to disk or mongo
+func NewCWLJob(_user *user.User, job_input *cwl.Job_document, cwl_workflow *cwl.Workflow) (job *Job, err error) {
+
 	//CommandLineTools := collection.CommandLineTools
 
 	// check that all expected workflow inputs exist and that they have the correct type
-	logger.Debug(1, "CWL2AWE starting")
+	logger.Debug(1, "NewCWLJob starting")
 
 	var job_input_new *cwl.Job_document
 	job_input_new, err = CWL_input_check(job_input, cwl_workflow)
 	if err != nil {
-		err = fmt.Errorf("(CWL2AWE) CWL_input_check returned: %s", err.Error())
+		err = fmt.Errorf("(NewCWLJob) CWL_input_check returned: %s", err.Error())
 		return
 	}
 
@@ -212,7 +244,7 @@ func CWL2AWE(_user *user.User, files FormFiles, job_input *cwl.Job_document, cwl
 			case "ShockRequirement":
 				sr, ok := r.(*cwl.ShockRequirement)
 				if !ok {
-					err = fmt.Errorf("(CWL2AWE) Could not assert ShockRequirement (type: %s)", reflect.TypeOf(r))
+					err = fmt.Errorf("(NewCWLJob) Could not assert ShockRequirement (type: %s)", reflect.TypeOf(r))
 					return
 				}
 
@@ -234,6 +266,10 @@ func CWL2AWE(_user *user.User, files FormFiles, job_input *cwl.Job_document, cwl
 	// Once, job has been created, set job owner and add owner to all ACL's
 	job.Acl.SetOwner(_user.Uuid)
 	job.Acl.Set(_user.Uuid, acl.Rights{"read": true, "write": true, "delete": true})
+	err = ApplyProjectAcl(job)
+	if err != nil {
+		return
+	}
 
 	// TODO first check that all resources are available: local files and remote links
 
@@ -262,26 +298,5 @@ func CWL2AWE(_user *user.User, files FormFiles, job_input *cwl.Job_document, cwl
 	}
 	logger.Debug(1, "Init called")
 
-	err = job.Mkdir()
-	if err != nil {
-		err = errors.New("(CWL2AWE) error creating job directory, error=" + err.Error())
-		return
-	}
-
-	err = job.UpdateFile(files, "cwl") // TODO that may not make sense. Check if I should store AWE job.
-	if err != nil {
-		err = errors.New("error in UpdateFile, error=" + err.Error())
-		return
-	}
-
-	//spew.Dump(job)
-
-	logger.Debug(1, "job.Id: %s", job.Id)
-	err = job.Save()
-	if err != nil {
-		err = errors.New("error in job.Save(), error=" + err.Error())
-		return
-	}
 	return
-
 }
@@ -16,6 +16,7 @@ type Notice struct {
 	Results     *cwl.Job_document          `bson:"results" json:"results" mapstructure:"results"`                            // subset of tool_results with Shock URLs
 	Status      string                     `bson:"status,omitempty" json:"status,omitempty" mapstructure:"status,omitempty"` // this is redundant as workunit already has state, but this is only used for transfer
 	ComputeTime int                        `bson:"computetime,omitempty" json:"computetime,omitempty" mapstructure:"computetime,omitempty"`
+	ExitStatus  int                        `bson:"exitstatus" json:"exitstatus" mapstructure:"exitstatus"` // -1 if unknown
 	Notes       string
 	Stderr      string
 }
@@ -80,6 +81,11 @@ func NewNotice(native interface{}) (workunit_result *Notice, err error) {
 		workunit_result.Status, _ = status.(string)
 		workunit_result.ComputeTime, _ = native_map["computetime"].(int)
 
+		workunit_result.ExitStatus = -1
+		if exit_status, ok := native_map["exitstatus"].(float64); ok { // json number
+			workunit_result.ExitStatus = int(exit_status)
+		}
+
 		return
 
 	default:
@@ -8,7 +8,6 @@ import (
 	"github.com/MG-RAST/AWE/lib/core/cwl"
 	"github.com/MG-RAST/AWE/lib/db"
 	"github.com/MG-RAST/AWE/lib/logger"
-	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"time"
 )
@@ -33,37 +32,31 @@ func HasInfoField(a string) bool {
 }
 
 func InitJobDB() {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	cj := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
-	cj.EnsureIndex(mgo.Index{Key: []string{"acl.owner"}, Background: true})
-	cj.EnsureIndex(mgo.Index{Key: []string{"acl.read"}, Background: true})
-	cj.EnsureIndex(mgo.Index{Key: []string{"acl.write"}, Background: true})
-	cj.EnsureIndex(mgo.Index{Key: []string{"acl.delete"}, Background: true})
-	cj.EnsureIndex(mgo.Index{Key: []string{"id"}, Unique: true})
-	cj.EnsureIndex(mgo.Index{Key: []string{"state"}, Background: true})
-	cj.EnsureIndex(mgo.Index{Key: []string{"expiration"}, Background: true})
-	cj.EnsureIndex(mgo.Index{Key: []string{"updatetime"}, Background: true})
+	cj := db.C(conf.DB_COLL_JOBS)
+	cj.EnsureIndex(db.Index{Key: []string{"acl.owner"}, Background: true})
+	cj.EnsureIndex(db.Index{Key: []string{"acl.read"}, Background: true})
+	cj.EnsureIndex(db.Index{Key: []string{"acl.write"}, Background: true})
+	cj.EnsureIndex(db.Index{Key: []string{"acl.delete"}, Background: true})
+	cj.EnsureIndex(db.Index{Key: []string{"id"}, Unique: true})
+	cj.EnsureIndex(db.Index{Key: []string{"state"}, Background: true})
+	cj.EnsureIndex(db.Index{Key: []string{"expiration"}, Background: true})
+	cj.EnsureIndex(db.Index{Key: []string{"updatetime"}, Background: true})
 	for _, v := range JobInfoIndexes {
-		cj.EnsureIndex(mgo.Index{Key: []string{"info." + v}, Background: true})
+		cj.EnsureIndex(db.Index{Key: []string{"info." + v}, Background: true})
 	}
-	cp := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_PERF)
-	cp.EnsureIndex(mgo.Index{Key: []string{"id"}, Unique: true})
+	cp := db.C(conf.DB_COLL_PERF)
+	cp.EnsureIndex(db.Index{Key: []string{"id"}, Unique: true})
 }
 
 func InitClientGroupDB() {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	cc := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_CGS)
-	cc.EnsureIndex(mgo.Index{Key: []string{"id"}, Unique: true})
-	cc.EnsureIndex(mgo.Index{Key: []string{"name"}, Unique: true})
-	cc.EnsureIndex(mgo.Index{Key: []string{"token"}, Unique: true})
+	cc := db.C(conf.DB_COLL_CGS)
+	cc.EnsureIndex(db.Index{Key: []string{"id"}, Unique: true})
+	cc.EnsureIndex(db.Index{Key: []string{"name"}, Unique: true})
+	cc.EnsureIndex(db.Index{Key: []string{"token"}, Unique: true})
 }
 
 func dbDelete(q bson.M, coll string) (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(coll)
+	c := db.C(coll)
 	_, err = c.RemoveAll(q)
 	return
 }
@@ -75,17 +68,15 @@ func dbUpsert(t interface{}) (err error) {
 			return errors.New(fmt.Sprintf("bson document size is greater than limit of %d bytes", DocumentMaxByte))
 		}
 	}
-	session := db.Connection.Session.Copy()
-	defer session.Close()
 	switch t := t.(type) {
 	case *Job:
-		c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+		c := db.C(conf.DB_COLL_JOBS)
 		_, err = c.Upsert(bson.M{"id": t.Id}, &t)
 	case *JobPerf:
-		c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_PERF)
+		c := db.C(conf.DB_COLL_PERF)
 		_, err = c.Upsert(bson.M{"id": t.Id}, &t)
 	case *ClientGroup:
-		c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_CGS)
+		c := db.C(conf.DB_COLL_CGS)
 		_, err = c.Upsert(bson.M{"id": t.Id}, &t)
 	default:
 		fmt.Printf("invalid database entry type\n")
@@ -94,9 +85,7 @@ func dbUpsert(t interface{}) (err error) {
 }
 
 func dbCount(q bson.M) (count int, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 	if count, err = c.Find(q).Count(); err != nil {
 		return 0, err
 	} else {
@@ -105,9 +94,7 @@ func dbCount(q bson.M) (count int, err error) {
 }
 
 func dbFind(q bson.M, results *Jobs, options map[string]int) (count int, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 	query := c.Find(q)
 	if count, err = query.Count(); err != nil {
 		return 0, err
@@ -139,14 +126,8 @@ func dbFind(q bson.M, results *Jobs, options map[string]int) (count int, err err
 // get a minimal subset of the job documents required for an admin overview
 // for all completed jobs younger than a month and all running jobs
 func dbAdminData(special string) (data []interface{}, err error) {
-	// get a DB connection
-	session := db.Connection.Session.Copy()
-
-	// close the connection when the function completes
-	defer session.Close()
-
 	// set the database and collection
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 
 	// get the completed jobs that have a completed time not older than one month
 	var completedjobs = bson.M{"state": "completed", "info.completedtime": bson.M{"$gt": time.Now().AddDate(0, -1, 0)}}
@@ -167,9 +148,7 @@ func dbFindSort(q bson.M, results *Jobs, options map[string]int, sortby string,
 	if sortby == "" {
 		return 0, errors.New("sortby must be an nonempty string")
 	}
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 	query := c.Find(q)
 	if count, err = query.Count(); err != nil {
 		return 0, err
@@ -213,17 +192,13 @@ func dbFindSort(q bson.M, results *Jobs, options map[string]int, sortby string,
 }
 
 func DbFindDistinct(q bson.M, d string) (results interface{}, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 	err = c.Find(q).Distinct("info."+d, &results)
 	return
 }
 
 func dbFindClientGroups(q bson.M, results *ClientGroups) (count int, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_CGS)
+	c := db.C(conf.DB_COLL_CGS)
 	query := c.Find(q)
 	if count, err = query.Count(); err != nil {
 		return 0, err
@@ -236,9 +211,7 @@ func dbFindSortClientGroups(q bson.M, results *ClientGroups, options map[string]
 	if sortby == "" {
 		return 0, errors.New("sortby must be an nonempty string")
 	}
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_CGS)
+	c := db.C(conf.DB_COLL_CGS)
 	query := c.Find(q)
 	if count, err = query.Count(); err != nil {
 		return 0, err
@@ -267,10 +240,7 @@ func dbUpdateJobState(job_id string, newState string, notes string) (err error)
 }
 
 func dbGetJobTasks(job_id string) (tasks []*Task, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 
 	selector := bson.M{"id": job_id}
 	fieldname := "tasks"
@@ -338,10 +308,7 @@ func dbGetJobTaskField(job_id string, task_id string, fieldname string, result *
 
 // TODO: warning: this does not cope with subfields such as "partinfo.index"
 func dbGetJobArrayField(job_id string, task_id string, array_name string, id_field string, fieldname string, result *StructContainer) (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 	selector := bson.M{"id": job_id}
 
 	projection := bson.M{array_name: bson.M{"$elemMatch": bson.M{id_field: task_id}}, array_name + "." + fieldname: 1}
@@ -385,10 +352,7 @@ func dbGetJobTask(job_id string, task_id string) (result *Task, err error) {
 	dummy_job := NewJob()
 	dummy_job.Init()
 
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 
 	selector := bson.M{"id": job_id}
 	projection := bson.M{"tasks": bson.M{"$elemMatch": bson.M{"taskid": task_id}}}
@@ -438,10 +402,7 @@ func dbGetJobFieldString(job_id string, fieldname string) (result string, err er
 
 func dbGetJobField(job_id string, fieldname string, result interface{}) (err error) {
 
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 	selector := bson.M{"id": job_id}
 
 	err = c.Find(selector).Select(bson.M{fieldname: 1}).One(&result)
@@ -457,10 +418,7 @@ type Job_Acl struct {
 }
 
 func DBGetJobAcl(job_id string) (_acl acl.Acl, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 	selector := bson.M{"id": job_id}
 
 	job := Job_Acl{}
@@ -476,10 +434,7 @@ func DBGetJobAcl(job_id string) (_acl acl.Acl, err error) {
 }
 
 func dbGetJobFieldTime(job_id string, fieldname string) (result time.Time, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 	selector := bson.M{"id": job_id}
 
 	err = c.Find(selector).Select(bson.M{fieldname: 1}).One(&result)
@@ -491,10 +446,7 @@ func dbGetJobFieldTime(job_id string, fieldname string) (result time.Time, err e
 }
 
 func dbPushJobTask(job_id string, task *Task) (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 	selector := bson.M{"id": job_id}
 
 	change := bson.M{"$push": bson.M{"tasks": task}}
@@ -508,10 +460,7 @@ func dbPushJobTask(job_id string, task *Task) (err error) {
 }
 
 func dbPushJobWorkflowInstance(job_id string, wi *WorkflowInstance) (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 	selector := bson.M{"id": job_id}
 
 	change := bson.M{"$push": bson.M{"workflow_instances": wi}}
@@ -545,10 +494,7 @@ func dbUpdateJobWorkflow_instancesField(job_id string, subworkflow_id string, fi
 }
 
 func dbUpdateJobWorkflow_instancesFields(job_id string, subworkflow_id string, update_value bson.M) (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 	selector := bson.M{"id": job_id, "workflow_instances.id": subworkflow_id}
 
 	err = c.Update(selector, bson.M{"$set": update_value})
@@ -560,10 +506,7 @@ func dbUpdateJobWorkflow_instancesFields(job_id string, subworkflow_id string, u
 }
 
 func dbUpdateJobFields(job_id string, update_value bson.M) (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 	selector := bson.M{"id": job_id}
 
 	err = c.Update(selector, bson.M{"$set": update_value})
@@ -600,10 +543,7 @@ func dbUpdateJobFieldNull(job_id string, fieldname string) (err error) {
 }
 
 func dbUpdateJobTaskFields(job_id string, task_id string, update_value bson.M) (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 	selector := bson.M{"id": job_id, "tasks.taskid": task_id}
 
 	err = c.Update(selector, bson.M{"$set": update_value})
@@ -658,10 +598,7 @@ func dbUpdateJobTaskIO(job_id string, task_id string, fieldname string, value []
 
 func dbIncrementJobTaskField(job_id string, task_id string, fieldname string, increment_value int) (err error) {
 
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 
 	selector := bson.M{"id": job_id, "tasks.taskid": task_id}
 
@@ -676,12 +613,25 @@ func dbIncrementJobTaskField(job_id string, task_id string, fieldname string, in
 	return
 }
 
-func DbUpdateJobField(job_id string, key string, value interface{}) (err error) {
+// dbUpdateJobTaskState sets state and completion date of a task and the number of remaining tasks of the job in one transaction
+func dbUpdateJobTaskState(job_id string, task_id string, state string, completed_date time.Time, remain_tasks int) (err error) {
+	err = db.RunTransaction(func(tx db.Store) (err error) {
+		c := tx.C(conf.DB_COLL_JOBS)
+		err = c.Update(bson.M{"id": job_id, "tasks.taskid": task_id}, bson.M{"$set": bson.M{"tasks.$.state": state, "tasks.$.completedDate": completed_date}})
+		if err != nil {
+			return
+		}
+		return c.Update(bson.M{"id": job_id}, bson.M{"$set": bson.M{"remaintasks": remain_tasks}})
+	})
+	if err != nil {
+		err = fmt.Errorf("(dbUpdateJobTaskState) job_id=%s, task_id=%s, state=%s: %s", job_id, task_id, state, err.Error())
+	}
+	return
+}
 
-	session := db.Connection.Session.Copy()
-	defer session.Close()
+func DbUpdateJobField(job_id string, key string, value interface{}) (err error) {
 
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 
 	query := bson.M{"id": job_id}
 	update_value := bson.M{key: value}
@@ -698,9 +648,7 @@ func DbUpdateJobField(job_id string, key string, value interface{}) (err error)
 
 func LoadJob(id string) (job *Job, err error) {
 	job = NewJob()
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	c := db.C(conf.DB_COLL_JOBS)
 
 	err = c.Find(bson.M{"id": id}).One(&job)
 	if err != nil {
@@ -726,9 +674,7 @@ func LoadJob(id string) (job *Job, err error) {
 
 func LoadJobPerf(id string) (perf *JobPerf, err error) {
 	perf = new(JobPerf)
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_PERF)
+	c := db.C(conf.DB_COLL_PERF)
 	if err = c.Find(bson.M{"id": id}).One(&perf); err == nil {
 		return perf, nil
 	}
@@ -737,9 +683,7 @@ func LoadJobPerf(id string) (perf *JobPerf, err error) {
 
 func LoadClientGroup(id string) (clientgroup *ClientGroup, err error) {
 	clientgroup = new(ClientGroup)
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_CGS)
+	c := db.C(conf.DB_COLL_CGS)
 	if err = c.Find(bson.M{"id": id}).One(&clientgroup); err == nil {
 		return clientgroup, nil
 	}
@@ -748,9 +692,7 @@ func LoadClientGroup(id string) (clientgroup *ClientGroup, err error) {
 
 func LoadClientGroupByName(name string) (clientgroup *ClientGroup, err error) {
 	clientgroup = new(ClientGroup)
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_CGS)
+	c := db.C(conf.DB_COLL_CGS)
 	if err = c.Find(bson.M{"name": name}).One(&clientgroup); err == nil {
 		return clientgroup, nil
 	}
@@ -759,9 +701,7 @@ func LoadClientGroupByName(name string) (clientgroup *ClientGroup, err error) {
 
 func LoadClientGroupByToken(token string) (clientgroup *ClientGroup, err error) {
 	clientgroup = new(ClientGroup)
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_CGS)
+	c := db.C(conf.DB_COLL_CGS)
 	if err = c.Find(bson.M{"token": token}).One(&clientgroup); err == nil {
 		return clientgroup, nil
 	}
@@ -0,0 +1,437 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+
+	"github.com/MG-RAST/AWE/lib/core/cwl"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+// result of a job submission with the dryrun option, nothing of this is stored
+type DryRun struct {
+	JobName        string        `json:"name"`
+	Pipeline       string        `json:"pipeline"`
+	ClientGroups   string        `json:"clientgroups"`
+	IsCWL          bool          `json:"is_cwl"`
+	Tasks          []*DryRunTask `json:"tasks"`
+	TotalTasks     int           `json:"total_tasks"`
+	TotalWorkunits int           `json:"total_workunits"` // sum of all known workunit estimates
+	TotalInputSize int64         `json:"total_input_size"`
+	Complete       bool          `json:"complete"` // false if some estimates depend on outputs of other tasks
+}
+
+type DryRunTask struct {
+	Id           string         `json:"id"`
+	Parent       string         `json:"parent,omitempty"` // subworkflow or scatter task this task belongs to
+	TaskType     string         `json:"task_type"`
+	DependsOn    []string       `json:"dependsOn"`
+	Workunits    int            `json:"workunits"` // estimated number of workunits, -1 if unknown
+	ClientGroups string         `json:"clientgroups"`
+	DockerImage  string         `json:"dockerimage"`
+	Inputs       []*DryRunInput `json:"inputs"`
+	InputSize    int64          `json:"input_size"` // sum of known input sizes
+	Notes        []string       `json:"notes,omitempty"`
+}
+
+type DryRunInput struct {
+	Name   string `json:"name"`
+	Source string `json:"source,omitempty"` // predecessor task or workflow input
+	Url    string `json:"url,omitempty"`
+	Size   int64  `json:"size"` // -1 if unknown
+}
+
+// DryRunJob estimates what AWE will do with a job that has not been registered.
+// For CWL jobs job.CWL_collection has to be set.
+func DryRunJob(job *Job) (dr *DryRun, err error) {
+
+	dr = &DryRun{
+		IsCWL:    job.IsCWL,
+		Complete: true,
+	}
+	if job.Info != nil {
+		dr.JobName = job.Info.Name
+		dr.Pipeline = job.Info.Pipeline
+		dr.ClientGroups = job.Info.ClientGroups
+	}
+
+	if job.IsCWL {
+		err = dr.addCWLTasks(job)
+		if err != nil {
+			err = fmt.Errorf("(DryRunJob) addCWLTasks returned: %s", err.Error())
+			return
+		}
+	} else {
+		for _, task := range job.Tasks {
+			var dt *DryRunTask
+			dt, err = dryRunAWETask(job, task)
+			if err != nil {
+				err = fmt.Errorf("(DryRunJob) dryRunAWETask returned: %s", err.Error())
+				return
+			}
+			dr.Tasks = append(dr.Tasks, dt)
+		}
+	}
+
+	counted := make(map[string]bool) // the same file may be input of multiple tasks
+	for _, dt := range dr.Tasks {
+		dr.TotalTasks += 1
+		if dt.Workunits < 0 {
+			dr.Complete = false
+		} else if dt.TaskType != TASK_TYPE_WORKFLOW {
+			dr.TotalWorkunits += dt.Workunits
+		}
+		for _, input := range dt.Inputs {
+			if input.Size < 0 {
+				dr.Complete = false
+			} else if input.Source == "" && !counted[input.Url] {
+				// count only data that enters the job from outside
+				dr.TotalInputSize += input.Size
+				if input.Url != "" {
+					counted[input.Url] = true
+				}
+			}
+		}
+	}
+
+	return
+}
+
+func dryRunAWETask(job *Job, task *Task) (dt *DryRunTask, err error) {
+
+	dt = &DryRunTask{
+		Id:           task.TaskName,
+		TaskType:     TASK_TYPE_NORMAL,
+		ClientGroups: task.ClientGroups,
+		Workunits:    task.TotalWork,
+	}
+	if dt.ClientGroups == "" {
+		dt.ClientGroups = job.Info.ClientGroups
+	}
+
+	job_prefix := job.Id + "_"
+	for _, dep := range task.DependsOn {
+		dt.DependsOn = append(dt.DependsOn, strings.TrimPrefix(dep, job_prefix))
+	}
+
+	if task.Cmd != nil {
+		if task.Cmd.Dockerimage != "" {
+			dt.DockerImage = task.Cmd.Dockerimage
+		} else {
+			dt.DockerImage = task.Cmd.DockerPull
+		}
+	}
+
+	inputs_available := true
+	for _, io := range task.Inputs {
+		di := &DryRunInput{
+			Name:   io.FileName,
+			Source: io.Origin,
+			Url:    io.Url,
+			Size:   -1,
+		}
+		dt.Inputs = append(dt.Inputs, di)
+
+		if io.Origin != "" {
+			// output of a predecessor task, does not exist yet
+			inputs_available = false
+			continue
+		}
+
+		io.DataToken = job.Info.DataToken
+		_, xerr := io.UpdateFileSize() // only in-memory
+		if xerr != nil {
+			inputs_available = false
+			dt.Notes = append(dt.Notes, fmt.Sprintf("input %s: %s", io.FileName, xerr.Error()))
+			continue
+		}
+		di.Size = io.Size
+		dt.InputSize += io.Size
+	}
+
+	if task.TotalWork == 1 && task.MaxWorkSize == 0 {
+		return
+	}
+
+	if len(task.Inputs) == 0 {
+		dt.Workunits = 1
+		return
+	}
+
+	if !inputs_available {
+		// number of workunits depends on index of an input that does not exist yet
+		dt.Workunits = -1
+		dt.Notes = append(dt.Notes, fmt.Sprintf("requested totalwork=%d, actual number depends on inputs", task.TotalWork))
+		return
+	}
+
+	// shock indexes are not created by a dry run, only existing indexes are used
+	workunits, _, xerr := task.EstimatePartIndex(false)
+	if xerr != nil {
+		dt.Workunits = -1
+		dt.Notes = append(dt.Notes, fmt.Sprintf("requested totalwork=%d, actual number depends on index: %s", task.TotalWork, xerr.Error()))
+		return
+	}
+	dt.Workunits = workunits
+	return
+}
+
+func (dr *DryRun) addCWLTasks(job *Job) (err error) {
+
+	if job.CWL_collection == nil {
+		err = fmt.Errorf("(addCWLTasks) job.CWL_collection == nil")
+		return
+	}
+
+	entrypoint := job.Entrypoint
+	if entrypoint == "" {
+		entrypoint = "#main"
+	}
+
+	_, ok := job.CWL_collection.Workflows[entrypoint]
+	if !ok {
+		err = fmt.Errorf("(addCWLTasks) workflow %s not found", entrypoint)
+		return
+	}
+
+	// job is not registered, workflow instances are not in the map yet
+	var main_instance *WorkflowInstance
+	for i, _ := range job.WorkflowInstances {
+		var wi WorkflowInstance
+		wi, err = NewWorkflowInstanceFromInterface(job.WorkflowInstances[i])
+		if err != nil {
+			err = fmt.Errorf("(addCWLTasks) object is not a WorkflowInstance: %s", err.Error())
+			return
+		}
+		if wi.Id == "::main::" {
+			main_instance = &wi
+			break
+		}
+	}
+	if main_instance == nil {
+		err = fmt.Errorf("(addCWLTasks) workflow instance ::main:: not found")
+		return
+	}
+
+	// workflow inputs by full id, e.g. #main/input1
+	inputs := make(map[string]cwl.CWLType)
+	for _, named := range main_instance.Inputs {
+		inputs[entrypoint+"/"+path.Base(named.Id)] = named.Value
+	}
+
+	err = dr.addCWLWorkflowTasks(job, job.Tasks, inputs, job.Info.ClientGroups)
+	return
+}
+
+// adds the tasks that NewCWLJob or CreateTasks created for the steps of a (sub-)workflow,
+// subworkflows are expanded into tasks the same way as in taskEnQueue
+func (dr *DryRun) addCWLWorkflowTasks(job *Job, tasks []*Task, inputs map[string]cwl.CWLType, clientgroups string) (err error) {
+
+	var schemata []cwl.CWLType_Type
+	schemata, err = job.CWL_collection.GetSchemata()
+	if err != nil {
+		err = fmt.Errorf("(addCWLWorkflowTasks) GetSchemata returned: %s", err.Error())
+		return
+	}
+
+	job_prefix := job.Id + "_"
+	step_ids := make(map[string]bool)
+	for _, task := range tasks {
+		if task.WorkflowStep == nil {
+			err = fmt.Errorf("(addCWLWorkflowTasks) task %s has no WorkflowStep", task.TaskName)
+			return
+		}
+		step_ids[task.WorkflowStep.Id] = true
+	}
+
+	for _, task := range tasks {
+		step := task.WorkflowStep
+
+		_, err = task.CollectDependencies()
+		if err != nil {
+			err = fmt.Errorf("(addCWLWorkflowTasks) CollectDependencies returned: %s", err.Error())
+			return
+		}
+
+		dt := &DryRunTask{
+			Id:           task.TaskName,
+			Parent:       task.Parent,
+			ClientGroups: clientgroups,
+			Workunits:    1,
+		}
+		dr.Tasks = append(dr.Tasks, dt)
+
+		// explicit dependencies and the steps that provide the inputs
+		deps := make(map[string]bool)
+		for _, dep := range task.DependsOn {
+			deps[strings.TrimPrefix(dep, job_prefix)] = true
+		}
+		step_inputs := make(map[string]cwl.CWLType) // resolved values, used for subworkflows and scatter
+		for _, step_input := range step.In {
+			for _, source := range getStepInputSources(step_input.Source) {
+
+				di := &DryRunInput{
+					Name: path.Base(step_input.Id),
+					Size: -1,
+				}
+				dt.Inputs = append(dt.Inputs, di)
+
+				if value, ok := inputs[source]; ok {
+					step_inputs[step_input.Id] = value
+					size, url := cwlObjectSize(value)
+					di.Url = url
+					di.Size = size
+					if size > 0 {
+						dt.InputSize += size
+					}
+					continue
+				}
+
+				di.Source = source
+				source_step := path.Dir(source)
+				if step_ids[source_step] {
+					deps[source_step] = true
+				}
+			}
+			if step_input.Source == nil && step_input.Default != nil {
+				if value, ok := step_input.Default.(cwl.CWLType); ok {
+					step_inputs[step_input.Id] = value
+				}
+			}
+		}
+		for dep, _ := range deps {
+			dt.DependsOn = append(dt.DependsOn, dep)
+		}
+
+		var process interface{}
+		process, _, err = cwl.GetProcess(step.Run, job.CWL_collection, job.CwlVersion, schemata)
+		if err != nil {
+			err = fmt.Errorf("(addCWLWorkflowTasks) cwl.GetProcess returned: %s", err.Error())
+			return
+		}
+
+		switch process.(type) {
+		case *cwl.CommandLineTool:
+			clt := process.(*cwl.CommandLineTool)
+			dt.TaskType = TASK_TYPE_NORMAL
+			dt.DockerImage = cwlDockerImage(clt.Hints, clt.Requirements)
+		case *cwl.ExpressionTool:
+			dt.TaskType = TASK_TYPE_NORMAL
+		case *cwl.Workflow:
+			dt.TaskType = TASK_TYPE_WORKFLOW
+			dt.Workunits = 0
+		default:
+			err = fmt.Errorf("(addCWLWorkflowTasks) process type %s not supported", reflect.TypeOf(process))
+			return
+		}
+
+		if len(step.Scatter) > 0 {
+			// a scatter task creates one child task per array element
+			dt.Notes = append(dt.Notes, fmt.Sprintf("scatter over %s", strings.Join(step.Scatter, ",")))
+			scatter_count := 1
+			for _, scatter_input := range step.Scatter {
+				value, ok := step_inputs[scatter_input]
+				if !ok {
+					value, ok = step_inputs[step.Id+"/"+path.Base(scatter_input)]
+				}
+				array, is_array := value.(*cwl.Array)
+				if !ok || !is_array {
+					scatter_count = -1
+					break
+				}
+				scatter_count *= len(*array)
+			}
+			if dt.TaskType == TASK_TYPE_WORKFLOW {
+				dt.Notes = append(dt.Notes, "scatter over subworkflow, children are not expanded")
+				dt.Workunits = -1
+			} else {
+				dt.Workunits = scatter_count
+			}
+			dt.TaskType = TASK_TYPE_SCATTER
+			continue
+		}
+
+		if dt.TaskType == TASK_TYPE_WORKFLOW {
+			sub_workflow := process.(*cwl.Workflow)
+
+			// map step inputs to subworkflow inputs
+			sub_inputs := make(map[string]cwl.CWLType)
+			for step_input_id, value := range step_inputs {
+				sub_inputs[sub_workflow.Id+"/"+path.Base(step_input_id)] = value
+			}
+
+			new_sub_workflow := task.TaskName
+			if len(task.Parent) > 0 {
+				new_sub_workflow = task.Parent + task.TaskName
+			}
+
+			var sub_workflow_tasks []*Task
+			sub_workflow_tasks, err = CreateTasks(job, new_sub_workflow, sub_workflow.Steps)
+			if err != nil {
+				err = fmt.Errorf("(addCWLWorkflowTasks) CreateTasks returned: %s", err.Error())
+				return
+			}
+
+			err = dr.addCWLWorkflowTasks(job, sub_workflow_tasks, sub_inputs, clientgroups)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func getStepInputSources(source interface{}) (sources []string) {
+	switch source.(type) {
+	case string:
+		sources = append(sources, source.(string))
+	case []string:
+		sources = append(sources, source.([]string)...)
+	case []interface{}:
+		for _, s := range source.([]interface{}) {
+			s_str, ok := s.(string)
+			if ok {
+				sources = append(sources, s_str)
+			}
+		}
+	}
+	return
+}
+
+// returns size of files, looks up shock nodes if size is unknown
+func cwlObjectSize(value cwl.CWLType) (size int64, url string) {
+	switch value.(type) {
+	case *cwl.File:
+		file := value.(*cwl.File)
+		url = file.Location
+		if file.Size > 0 {
+			size = int64(file.Size)
+			return
+		}
+		io := &IO{Url: file.Location, DataToken: file.Token}
+		if io.Url2Shock() != nil || io.Node == "" {
+			size = -1
+			return
+		}
+		_, err := io.UpdateFileSize()
+		if err != nil {
+			logger.Debug(1, "(cwlObjectSize) could not get size of %s: %s", file.Location, err.Error())
+			size = -1
+			return
+		}
+		size = io.Size
+	case *cwl.Array:
+		array := value.(*cwl.Array)
+		for i, _ := range *array {
+			element_size, _ := cwlObjectSize((*array)[i])
+			if element_size < 0 {
+				size = -1
+				return
+			}
+			size += element_size
+		}
+	}
+	return
+}
@@ -36,10 +36,13 @@ func (jr *JobReaper) Handle() {
 		// delete expired jobs
 		for _, j := range jobs {
 			logger.Event(event.JOB_EXPIRED, "jobid="+j.Id)
+			j.CleanupTemporaryNodes()
 			if err := j.Delete(); err != nil {
 				logger.Error("Err@job_delete: " + err.Error())
 			}
 		}
+		// retry shock nodes that could not be deleted before
+		RetryNodeCleanups()
 	}
 }
 
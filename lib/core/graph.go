@@ -0,0 +1,505 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/MG-RAST/AWE/lib/core/cwl"
+)
+
+const (
+	GRAPH_FORMAT_DOT     = "dot"
+	GRAPH_FORMAT_MERMAID = "mermaid"
+	GRAPH_FORMAT_JSON    = "json"
+)
+
+const (
+	GRAPH_CLUSTER_SCATTER  = "scatter"
+	GRAPH_CLUSTER_WORKFLOW = "workflow"
+)
+
+// fill colors of task nodes, by task state
+var GraphStateColors = map[string]string{
+	TASK_STAT_INIT:             "#e9ecef",
+	TASK_STAT_PENDING:          "#fff3bf",
+	TASK_STAT_READY:            "#ffe066",
+	TASK_STAT_QUEUED:           "#a5d8ff",
+	TASK_STAT_INPROGRESS:       "#4dabf7",
+	TASK_STAT_SUSPEND:          "#ffa94d",
+	TASK_STAT_FAILED:           "#ff8787",
+	TASK_STAT_FAILED_PERMANENT: "#e03131",
+	TASK_STAT_COMPLETED:        "#8ce99a",
+}
+
+const graph_default_color = "#ffffff" // e.g. offline graphs without state
+
+// TaskGraph is the DAG of the tasks of a job, or of the steps of a CWL workflow
+type TaskGraph struct {
+	Name     string          `json:"name"`
+	Nodes    []*GraphNode    `json:"nodes"`
+	Edges    []*GraphEdge    `json:"edges"`
+	Clusters []*GraphCluster `json:"clusters"`
+	node_map map[string]*GraphNode
+	edge_map map[string]*GraphEdge
+}
+
+type GraphNode struct {
+	Id       string `json:"id"`
+	Label    string `json:"label"`
+	State    string `json:"state"`
+	TaskType string `json:"task_type"`
+	Cluster  string `json:"cluster,omitempty"`
+}
+
+type GraphEdge struct {
+	From   string   `json:"from"`
+	To     string   `json:"to"`
+	Inputs []string `json:"inputs"` // names of the inputs connecting the two tasks
+}
+
+// a scatter or subworkflow task and its children
+type GraphCluster struct {
+	Id     string `json:"id"`
+	Label  string `json:"label"`
+	Type   string `json:"type"`
+	Parent string `json:"parent,omitempty"` // enclosing cluster
+}
+
+func NewTaskGraph(name string) *TaskGraph {
+	return &TaskGraph{
+		Name:     name,
+		Nodes:    []*GraphNode{},
+		Edges:    []*GraphEdge{},
+		Clusters: []*GraphCluster{},
+		node_map: make(map[string]*GraphNode),
+		edge_map: make(map[string]*GraphEdge),
+	}
+}
+
+func (g *TaskGraph) AddNode(id string, label string, state string, task_type string) (node *GraphNode) {
+	node, ok := g.node_map[id]
+	if ok {
+		return
+	}
+	node = &GraphNode{Id: id, Label: label, State: state, TaskType: task_type}
+	g.Nodes = append(g.Nodes, node)
+	g.node_map[id] = node
+	return
+}
+
+// AddEdge adds an edge or, if it exists, adds the input name to the existing edge
+func (g *TaskGraph) AddEdge(from string, to string, input string) {
+	key := from + " -> " + to
+	edge, ok := g.edge_map[key]
+	if !ok {
+		edge = &GraphEdge{From: from, To: to, Inputs: []string{}}
+		g.Edges = append(g.Edges, edge)
+		g.edge_map[key] = edge
+	}
+	if input == "" {
+		return
+	}
+	for _, existing := range edge.Inputs {
+		if existing == input {
+			return
+		}
+	}
+	edge.Inputs = append(edge.Inputs, input)
+}
+
+func (g *TaskGraph) AddCluster(id string, label string, cluster_type string, parent string) (cluster *GraphCluster) {
+	for _, cluster = range g.Clusters {
+		if cluster.Id == id {
+			return
+		}
+	}
+	cluster = &GraphCluster{Id: id, Label: label, Type: cluster_type, Parent: parent}
+	g.Clusters = append(g.Clusters, cluster)
+	return
+}
+
+// NewJobGraph creates the task graph of a job, nodes are tasks with their current state
+func NewJobGraph(job *Job) (g *TaskGraph, err error) {
+
+	name := job.Id
+	if job.Info != nil && job.Info.Name != "" {
+		name = job.Info.Name
+	}
+	g = NewTaskGraph(name)
+
+	job_prefix := job.Id + "_"
+
+	// subworkflow and scatter children, child id -> parent id
+	parents := make(map[string]string)
+	cluster_types := make(map[string]string)
+	// CWL step id -> task id, for resolving step input sources
+	step_tasks := make(map[string]string)
+
+	for _, task := range job.Tasks {
+		var task_str string
+		task_str, err = task.String()
+		if err != nil {
+			err = fmt.Errorf("(NewJobGraph) task.String returned: %s", err.Error())
+			return
+		}
+		id := strings.TrimPrefix(task_str, job_prefix)
+
+		var state string
+		state, err = task.GetState()
+		if err != nil {
+			err = fmt.Errorf("(NewJobGraph) task.GetState returned: %s", err.Error())
+			return
+		}
+
+		task_type := task.TaskType
+		if task_type == TASK_TYPE_UNKNOWN {
+			task_type = TASK_TYPE_NORMAL
+		}
+		g.AddNode(id, task.TaskName, state, task_type)
+
+		if task.WorkflowStep != nil && task.Scatter_parent == nil {
+			step_tasks[task.TaskName] = id
+		}
+
+		if task.Scatter_parent != nil {
+			var parent_str string
+			parent_str, err = task.Scatter_parent.String()
+			if err != nil {
+				err = fmt.Errorf("(NewJobGraph) Scatter_parent.String returned: %s", err.Error())
+				return
+			}
+			parents[id] = strings.TrimPrefix(parent_str, job_prefix)
+		}
+
+		for _, child := range task.Children {
+			var child_str string
+			child_str, err = child.String()
+			if err != nil {
+				err = fmt.Errorf("(NewJobGraph) child.String returned: %s", err.Error())
+				return
+			}
+			parents[strings.TrimPrefix(child_str, job_prefix)] = id
+		}
+
+		if task_type == TASK_TYPE_SCATTER {
+			cluster_types[id] = GRAPH_CLUSTER_SCATTER
+		} else if task_type == TASK_TYPE_WORKFLOW {
+			cluster_types[id] = GRAPH_CLUSTER_WORKFLOW
+		}
+	}
+
+	for _, task := range job.Tasks {
+		task_str, _ := task.String()
+		id := strings.TrimPrefix(task_str, job_prefix)
+
+		// AWE dependencies
+		for _, dep := range task.DependsOn {
+			g.AddEdge(strings.TrimPrefix(dep, job_prefix), id, "")
+		}
+		for _, io := range task.Inputs {
+			if io.Origin == "" {
+				continue
+			}
+			origin := strings.TrimPrefix(io.Origin, job_prefix)
+			if _, ok := g.node_map[origin]; !ok {
+				continue
+			}
+			g.AddEdge(origin, id, io.FileName)
+		}
+
+		// CWL dependencies
+		if task.WorkflowStep == nil {
+			continue
+		}
+		for _, step_input := range task.WorkflowStep.In {
+			for _, source := range getStepInputSources(step_input.Source) {
+				source_task, ok := step_tasks[path.Dir(source)]
+				if !ok || source_task == id {
+					continue
+				}
+				g.AddEdge(source_task, id, path.Base(step_input.Id))
+			}
+		}
+	}
+
+	// clusters, the scatter or subworkflow task is part of its own cluster
+	for id, cluster_type := range cluster_types {
+		g.AddCluster("cluster_"+id, g.node_map[id].Label, cluster_type, "")
+	}
+	for _, cluster := range g.Clusters {
+		id := strings.TrimPrefix(cluster.Id, "cluster_")
+		if parent, ok := parents[id]; ok {
+			cluster.Parent = "cluster_" + parent
+		}
+	}
+	for _, node := range g.Nodes {
+		if _, ok := cluster_types[node.Id]; ok {
+			node.Cluster = "cluster_" + node.Id
+		} else if parent, ok := parents[node.Id]; ok {
+			node.Cluster = "cluster_" + parent
+		}
+	}
+	sort.Slice(g.Clusters, func(i, j int) bool { return g.Clusters[i].Id < g.Clusters[j].Id })
+
+	return
+}
+
+// NewCWLGraph creates the graph of the steps of a CWL workflow, without a job. Used by awe-submitter.
+func NewCWLGraph(collection *cwl.CWL_collection, entrypoint string, cwl_version cwl.CWLVersion) (g *TaskGraph, err error) {
+
+	cwl_workflow, ok := collection.Workflows[entrypoint]
+	if !ok {
+		err = fmt.Errorf("(NewCWLGraph) workflow %s not found", entrypoint)
+		return
+	}
+
+	var schemata []cwl.CWLType_Type
+	schemata, err = collection.GetSchemata()
+	if err != nil {
+		err = fmt.Errorf("(NewCWLGraph) GetSchemata returned: %s", err.Error())
+		return
+	}
+
+	g = NewTaskGraph(entrypoint)
+	err = g.addCWLWorkflow(collection, cwl_version, schemata, cwl_workflow, "", "")
+	return
+}
+
+// prefix is used to make step ids of subworkflows unique, as the same subworkflow may be used in multiple steps
+func (g *TaskGraph) addCWLWorkflow(collection *cwl.CWL_collection, cwl_version cwl.CWLVersion, schemata []cwl.CWLType_Type, cwl_workflow *cwl.Workflow, prefix string, cluster string) (err error) {
+
+	// step id -> node id
+	step_nodes := make(map[string]string)
+	for _, step := range cwl_workflow.Steps {
+		if prefix == "" {
+			step_nodes[step.Id] = step.Id
+		} else {
+			step_nodes[step.Id] = prefix + "/" + path.Base(step.Id)
+		}
+	}
+
+	for s, _ := range cwl_workflow.Steps {
+		step := cwl_workflow.Steps[s]
+		id := step_nodes[step.Id]
+
+		var process interface{}
+		process, _, err = cwl.GetProcess(step.Run, collection, cwl_version, schemata)
+		if err != nil {
+			err = fmt.Errorf("(addCWLWorkflow) cwl.GetProcess returned: %s", err.Error())
+			return
+		}
+
+		task_type := TASK_TYPE_NORMAL
+		switch process.(type) {
+		case *cwl.CommandLineTool, *cwl.ExpressionTool:
+		case *cwl.Workflow:
+			task_type = TASK_TYPE_WORKFLOW
+		default:
+			err = fmt.Errorf("(addCWLWorkflow) process type %s not supported", reflect.TypeOf(process))
+			return
+		}
+		if len(step.Scatter) > 0 {
+			task_type = TASK_TYPE_SCATTER
+		}
+
+		node := g.AddNode(id, step.Id, "", task_type)
+		node.Cluster = cluster
+
+		for _, step_input := range step.In {
+			for _, source := range getStepInputSources(step_input.Source) {
+				source_node, ok := step_nodes[path.Dir(source)]
+				if !ok {
+					continue // workflow input
+				}
+				g.AddEdge(source_node, id, path.Base(step_input.Id))
+			}
+		}
+
+		switch task_type {
+		case TASK_TYPE_SCATTER:
+			node.Cluster = "cluster_" + id
+			g.AddCluster(node.Cluster, fmt.Sprintf("%s (scatter over %s)", step.Id, strings.Join(step.Scatter, ",")), GRAPH_CLUSTER_SCATTER, cluster)
+		case TASK_TYPE_WORKFLOW:
+			node.Cluster = "cluster_" + id
+			g.AddCluster(node.Cluster, step.Id, GRAPH_CLUSTER_WORKFLOW, cluster)
+			err = g.addCWLWorkflow(collection, cwl_version, schemata, process.(*cwl.Workflow), id, node.Cluster)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Render returns the graph in the requested format
+func (g *TaskGraph) Render(format string) (result string, err error) {
+	switch format {
+	case GRAPH_FORMAT_DOT:
+		result = g.Dot()
+	case GRAPH_FORMAT_MERMAID:
+		result = g.Mermaid()
+	default:
+		err = fmt.Errorf("(Render) graph format %s not supported, use %s or %s", format, GRAPH_FORMAT_DOT, GRAPH_FORMAT_MERMAID)
+	}
+	return
+}
+
+// graphviz node and cluster ids have to be unique and simple
+func (g *TaskGraph) shortIds() (node_ids map[string]string, cluster_ids map[string]string) {
+	node_ids = make(map[string]string)
+	for i, node := range g.Nodes {
+		node_ids[node.Id] = fmt.Sprintf("n%d", i)
+	}
+	cluster_ids = make(map[string]string)
+	for i, cluster := range g.Clusters {
+		cluster_ids[cluster.Id] = fmt.Sprintf("cluster_%d", i)
+	}
+	return
+}
+
+func graphStateColor(state string) string {
+	color, ok := GraphStateColors[state]
+	if !ok {
+		return graph_default_color
+	}
+	return color
+}
+
+func graphNodeLabel(node *GraphNode) (label string) {
+	label = node.Label
+	if node.State != "" {
+		label += "\n" + node.State
+	}
+	return
+}
+
+func (g *TaskGraph) Dot() string {
+	node_ids, cluster_ids := g.shortIds()
+	quote := func(s string) string {
+		s = strings.Replace(s, "\\", "\\\\", -1)
+		s = strings.Replace(s, "\"", "\\\"", -1)
+		return "\"" + strings.Replace(s, "\n", "\\n", -1) + "\""
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "digraph %s {\n", quote(g.Name))
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=\"rounded,filled\"];\n")
+
+	var write_cluster func(cluster string, indent string)
+	write_cluster = func(cluster string, indent string) {
+		for _, node := range g.Nodes {
+			if node.Cluster != cluster {
+				continue
+			}
+			shape := "box"
+			if node.TaskType == TASK_TYPE_SCATTER {
+				shape = "box3d"
+			} else if node.TaskType == TASK_TYPE_WORKFLOW {
+				shape = "folder"
+			}
+			fmt.Fprintf(&b, "%s%s [label=%s, shape=%s, fillcolor=%s];\n", indent, node_ids[node.Id], quote(graphNodeLabel(node)), shape, quote(graphStateColor(node.State)))
+		}
+		for _, sub := range g.Clusters {
+			if sub.Parent != cluster {
+				continue
+			}
+			fmt.Fprintf(&b, "%ssubgraph %s {\n", indent, cluster_ids[sub.Id])
+			fmt.Fprintf(&b, "%s  label=%s;\n", indent, quote(sub.Label))
+			if sub.Type == GRAPH_CLUSTER_SCATTER {
+				fmt.Fprintf(&b, "%s  style=dashed;\n", indent)
+			} else {
+				fmt.Fprintf(&b, "%s  style=solid;\n", indent)
+			}
+			write_cluster(sub.Id, indent+"  ")
+			fmt.Fprintf(&b, "%s}\n", indent)
+		}
+	}
+	write_cluster("", "  ")
+
+	for _, edge := range g.Edges {
+		from, ok_from := node_ids[edge.From]
+		to, ok_to := node_ids[edge.To]
+		if !ok_from || !ok_to {
+			continue
+		}
+		if len(edge.Inputs) == 0 {
+			fmt.Fprintf(&b, "  %s -> %s;\n", from, to)
+			continue
+		}
+		fmt.Fprintf(&b, "  %s -> %s [label=%s];\n", from, to, quote(strings.Join(edge.Inputs, ", ")))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (g *TaskGraph) Mermaid() string {
+	node_ids, cluster_ids := g.shortIds()
+	// mermaid does not allow quotes in labels
+	escape := func(s string) string {
+		s = strings.Replace(s, "\"", "#quot;", -1)
+		return strings.Replace(s, "\n", "<br/>", -1)
+	}
+	class_name := func(state string) string {
+		if state == "" {
+			return "none"
+		}
+		return strings.Replace(state, "-", "_", -1)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("graph LR\n")
+
+	var write_cluster func(cluster string, indent string)
+	write_cluster = func(cluster string, indent string) {
+		for _, node := range g.Nodes {
+			if node.Cluster != cluster {
+				continue
+			}
+			shape_open, shape_close := "[\"", "\"]"
+			if node.TaskType == TASK_TYPE_SCATTER {
+				shape_open, shape_close = "[[\"", "\"]]"
+			} else if node.TaskType == TASK_TYPE_WORKFLOW {
+				shape_open, shape_close = "[/\"", "\"/]"
+			}
+			fmt.Fprintf(&b, "%s%s%s%s%s:::%s\n", indent, node_ids[node.Id], shape_open, escape(graphNodeLabel(node)), shape_close, class_name(node.State))
+		}
+		for _, sub := range g.Clusters {
+			if sub.Parent != cluster {
+				continue
+			}
+			fmt.Fprintf(&b, "%ssubgraph %s[\"%s\"]\n", indent, cluster_ids[sub.Id], escape(sub.Label))
+			write_cluster(sub.Id, indent+"  ")
+			fmt.Fprintf(&b, "%send\n", indent)
+		}
+	}
+	write_cluster("", "  ")
+
+	for _, edge := range g.Edges {
+		from, ok_from := node_ids[edge.From]
+		to, ok_to := node_ids[edge.To]
+		if !ok_from || !ok_to {
+			continue
+		}
+		if len(edge.Inputs) == 0 {
+			fmt.Fprintf(&b, "  %s --> %s\n", from, to)
+			continue
+		}
+		fmt.Fprintf(&b, "  %s -->|\"%s\"| %s\n", from, escape(strings.Join(edge.Inputs, ", ")), to)
+	}
+
+	states := []string{}
+	for state, _ := range GraphStateColors {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	for _, state := range states {
+		fmt.Fprintf(&b, "  classDef %s fill:%s\n", class_name(state), GraphStateColors[state])
+	}
+	fmt.Fprintf(&b, "  classDef %s fill:%s\n", class_name(""), graph_default_color)
+	return b.String()
+}
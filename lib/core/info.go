@@ -21,6 +21,7 @@ type Info struct {
 	Auth          bool                   `bson:"auth" json:"auth" mapstructure:"auth"`
 	DataToken     string                 `bson:"datatoken" json:"-" mapstructure:"-"`
 	NoRetry       bool                   `bson:"noretry" json:"noretry" mapstructure:"noretry"`
+	NoReuse       bool                   `bson:"noreuse" json:"noreuse" mapstructure:"noreuse"` // disables reuse of outputs of previously completed tasks
 	UserAttr      map[string]interface{} `bson:"userattr" json:"userattr" mapstructure:"userattr"`
 	Description   string                 `bson:"description" json:"description" mapstructure:"description"`
 	Tracking      bool                   `bson:"tracking" json:"tracking" mapstructure:"tracking"`
@@ -3,12 +3,21 @@ package core
 import (
 	"errors"
 	"fmt"
+	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/shock"
 	"github.com/MG-RAST/golib/go-uuid/uuid"
 	"net/url"
 	"strings"
 )
 
+// storage backends, see lib/cache
+const (
+	STORAGE_SHOCK = "shock"
+	STORAGE_S3    = "s3"
+	STORAGE_FILE  = "file"
+	STORAGE_HTTP  = "http"
+)
+
 type IO struct {
 	FileName      string                   `bson:"filename" json:"filename" mapstructure:"filename"`
 	Name          string                   `bson:"name" json:"name" mapstructure:"name"`  // specifies abstract name of output as defined by the app
@@ -27,6 +36,7 @@ type IO struct {
 	DataToken     string                   `bson:"datatoken"  json:"-" mapstructure:"-"`
 	Intermediate  bool                     `bson:"Intermediate"  json:"-" mapstructure:"-"`
 	Temporary     bool                     `bson:"temporary"  json:"temporary" mapstructure:"temporary"`
+	Reused        bool                     `bson:"reused" json:"-" mapstructure:"-"` // output copied from the result of another job, see WorkReuse
 	ShockFilename string                   `bson:"shockfilename" json:"shockfilename" mapstructure:"shockfilename"`
 	ShockIndex    string                   `bson:"shockindex" json:"shockindex" mapstructure:"shockindex"` // on input it indicates that Shock node has to be indexed by AWE server
 	AttrFile      string                   `bson:"attrfile" json:"attrfile" mapstructure:"attrfile"`
@@ -89,6 +99,10 @@ func (io *IO) Url2Shock() (err error) {
 		return
 	}
 	u, _ := url.Parse(io.Url)
+	if u != nil && (u.Scheme == STORAGE_S3 || u.Scheme == STORAGE_FILE) {
+		// not a shock url, nothing to extract
+		return
+	}
 	if (u.Scheme == "") || (u.Host == "") || (u.Path == "") {
 		err = fmt.Errorf("(Url2Shock) Not a valid url: %s", io.Url)
 		return
@@ -106,6 +120,72 @@ func (io *IO) Url2Shock() (err error) {
 	return
 }
 
+// StorageScheme returns the storage backend of the IO: STORAGE_SHOCK, STORAGE_S3, STORAGE_FILE or STORAGE_HTTP.
+// Shock nodes and http(s) hosts without url are Shock, http(s) urls that are not Shock urls are plain HTTP.
+func (io *IO) StorageScheme() string {
+	if (io.Node != "") && (io.Node != "-") {
+		return STORAGE_SHOCK
+	}
+	location := io.Url
+	if location == "" {
+		location = io.Host
+	}
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme == "" {
+		return STORAGE_SHOCK
+	}
+	switch u.Scheme {
+	case STORAGE_S3, STORAGE_FILE:
+		return u.Scheme
+	case "http", "https":
+		if io.Url == "" {
+			return STORAGE_SHOCK
+		}
+		trimPath := strings.Trim(u.Path, "/")
+		cleanUuid := strings.Trim(strings.TrimPrefix(trimPath, "node"), "/")
+		if (cleanUuid != trimPath) && (uuid.Parse(cleanUuid) != nil) {
+			return STORAGE_SHOCK
+		}
+		return STORAGE_HTTP
+	}
+	return STORAGE_SHOCK
+}
+
+// OutputLocation returns the url of an output file in a non-Shock storage, base is e.g. s3://bucket/prefix or file:///lustre/awe
+func OutputLocation(base string, job_id string, filename string) string {
+	return strings.TrimSuffix(base, "/") + "/" + job_id + "/" + filename
+}
+
+// StorageForClientGroups returns the storage url configured in conf.STORAGE_CLIENTGROUPS for the clientgroups
+// of a task, it is only returned if all clientgroups use the same storage
+func StorageForClientGroups(clientgroups string) (storage_url string, err error) {
+	if conf.STORAGE_CLIENTGROUPS == "" || clientgroups == "" {
+		return
+	}
+	mapping := make(map[string]string)
+	for _, entry := range strings.Split(conf.STORAGE_CLIENTGROUPS, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			err = fmt.Errorf("(StorageForClientGroups) invalid entry in storage_clientgroups: %s", entry)
+			return
+		}
+		mapping[parts[0]] = parts[1]
+	}
+	for i, group := range strings.Split(clientgroups, ",") {
+		group_url, ok := mapping[strings.TrimSpace(group)]
+		if !ok || (i > 0 && group_url != storage_url) {
+			storage_url = ""
+			return
+		}
+		storage_url = group_url
+	}
+	return
+}
+
 func (io *IO) DataUrl() (dataurl string, err error) {
 	if io.Url != "" {
 		// parse and test url
@@ -225,7 +305,36 @@ func (io *IO) IndexFile(indextype string) (idxInfo shock.IdxInfo, err error) {
 	return
 }
 
+// LookupIndex returns an existing, complete index of the Shock node, unlike IndexFile it does not create it
+func (io *IO) LookupIndex(indextype string) (idxInfo shock.IdxInfo, err error) {
+	if indextype == "" {
+		err = fmt.Errorf("(LookupIndex) index type is empty")
+		return
+	}
+	var hasIndex bool
+	idxInfo, hasIndex = io.Indexes[indextype]
+	if hasIndex && idxInfo.Locked == nil && idxInfo.TotalUnits > 0 {
+		return
+	}
+
+	var node *shock.ShockNode
+	node, err = io.getShockNode()
+	if err != nil {
+		return
+	}
+	idxInfo, hasIndex = node.Indexes[indextype]
+	if !hasIndex || idxInfo.Locked != nil || idxInfo.TotalUnits == 0 {
+		err = fmt.Errorf("(LookupIndex) index %s of node %s does not exist yet", indextype, io.Node)
+		return
+	}
+	return
+}
+
 func (io *IO) DeleteNode() (err error) {
+	if io.StorageScheme() != STORAGE_SHOCK {
+		// files in other storage backends are not managed by AWE
+		return
+	}
 	err = shock.ShockDelete(io.Host, io.Node, io.DataToken)
 	return
 }
@@ -48,6 +48,7 @@ type JobError struct {
 	WorkNotes    string `bson:"worknotes" json:"worknotes"`
 	AppError     string `bson:"apperror" json:"apperror"`
 	Status       string `bson:"status" json:"status"`
+	Actor        string `bson:"-" json:"-"` // user who suspended the job, recorded in the job history
 }
 
 type Job struct {
@@ -653,6 +654,9 @@ func (job *Job) Delete() (err error) {
 	if err = job.Rmdir(); err != nil {
 		return err
 	}
+	if err = dbDeleteJobHistory(job.Id); err != nil {
+		logger.Error("(job.Delete) could not delete history of job %s: %s", job.Id, err.Error())
+	}
 	logger.Event(event.JOB_FULL_DELETE, "jobid="+job.Id)
 	return
 }
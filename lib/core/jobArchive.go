@@ -0,0 +1,387 @@
+package core
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/acl"
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/db"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/user"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// A job archive is a gzipped tarball with the job document (including tasks and CWL objects) and
+// the JobPerf as stored in mongo, and the stdout/stderr/worknotes logs of the workunits.
+const (
+	ARCHIVE_JOB  = "job.bson"
+	ARCHIVE_PERF = "perf.bson"
+	ARCHIVE_LOGS = "logs/"
+)
+
+// only jobs that are not processed can be moved between servers
+var JOB_STATS_ARCHIVABLE = []string{JOB_STAT_COMPLETED, JOB_STAT_SUSPEND, JOB_STAT_FAILED_PERMANENT}
+
+// JobArchiveRemap replaces client groups and Shock hosts of the source server with those of the target server
+type JobArchiveRemap struct {
+	ClientGroups map[string]string
+	ShockHosts   map[string]string
+}
+
+// ParseRemap parses "old1=new1,old2=new2"
+func ParseRemap(value string) (remap map[string]string, err error) {
+	remap = make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			err = fmt.Errorf("(ParseRemap) invalid mapping \"%s\", expected old=new", pair)
+			return
+		}
+		remap[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return
+}
+
+func dbGetRaw(coll string, id string) (raw bson.Raw, err error) {
+	c := db.C(coll)
+	err = c.Find(bson.M{"id": id}).One(&raw)
+	return
+}
+
+func addArchiveFile(tw *tar.Writer, name string, data []byte) (err error) {
+	err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()})
+	if err != nil {
+		return
+	}
+	_, err = tw.Write(data)
+	return
+}
+
+// sanitizeJob removes data tokens and private environment variables, they must not leave the server
+func sanitizeJob(job *Job) {
+	if job.Info != nil {
+		job.Info.DataToken = ""
+	}
+	for _, task := range job.Tasks {
+		if task.Info != nil {
+			task.Info.DataToken = ""
+		}
+		if task.Cmd != nil {
+			task.Cmd.Environ.Private = nil
+			task.Cmd.HasPrivateEnv = false
+		}
+		for _, ios := range [][]*IO{task.Inputs, task.Outputs, task.Predata} {
+			for _, io := range ios {
+				io.DataToken = ""
+			}
+		}
+	}
+}
+
+// WriteJobArchive writes the archive of a completed, suspended or failed job to w
+func WriteJobArchive(job *Job, w io.Writer) (err error) {
+	job_state, err := job.GetState(true)
+	if err != nil {
+		return
+	}
+	if !contains(JOB_STATS_ARCHIVABLE, job_state) {
+		err = fmt.Errorf("(WriteJobArchive) job %s is in state %s, only %s jobs can be archived", job.Id, job_state, strings.Join(JOB_STATS_ARCHIVABLE, ", "))
+		return
+	}
+
+	job_raw, err := dbGetRaw(conf.DB_COLL_JOBS, job.Id)
+	if err != nil {
+		err = fmt.Errorf("(WriteJobArchive) loading job document returned: %s", err.Error())
+		return
+	}
+	// the stored document is copied, the job in memory keeps its tokens
+	job_copy := NewJob()
+	err = job_raw.Unmarshal(job_copy)
+	if err != nil {
+		err = fmt.Errorf("(WriteJobArchive) bson.Unmarshal of job document returned: %s", err.Error())
+		return
+	}
+	sanitizeJob(job_copy)
+	job_data, err := bson.Marshal(job_copy)
+	if err != nil {
+		err = fmt.Errorf("(WriteJobArchive) bson.Marshal of job document returned: %s", err.Error())
+		return
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	// the job document comes first, the import needs it before the logs
+	err = addArchiveFile(tw, ARCHIVE_JOB, job_data)
+	if err != nil {
+		return
+	}
+
+	if perf_raw, xerr := dbGetRaw(conf.DB_COLL_PERF, job.Id); xerr == nil {
+		err = addArchiveFile(tw, ARCHIVE_PERF, perf_raw.Data)
+		if err != nil {
+			return
+		}
+	} else {
+		logger.Debug(1, "(WriteJobArchive) no perf for job %s: %s", job.Id, xerr.Error())
+	}
+
+	job_path, err := job.Path()
+	if err != nil {
+		return
+	}
+	tasks, err := job.GetTasks()
+	if err != nil {
+		return
+	}
+	for _, task := range tasks {
+		for rank := 0; rank <= task.TotalWork; rank++ {
+			work_id := New_Workunit_Unique_Identifier(task.Task_Unique_Identifier, rank)
+			for _, logname := range conf.WORKUNIT_LOGS {
+				logpath, xerr := getStdLogPathByWorkId(work_id, logname)
+				if xerr != nil {
+					continue
+				}
+				data, xerr := ioutil.ReadFile(logpath)
+				if xerr != nil {
+					continue
+				}
+				// logs of CWL subworkflow tasks are in subdirectories
+				err = addArchiveFile(tw, ARCHIVE_LOGS+strings.TrimPrefix(logpath, job_path+"/"), data)
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return
+	}
+	err = gw.Close()
+	return
+}
+
+func remapHost(host string, hosts map[string]string) string {
+	if host == "" {
+		return host
+	}
+	for old_host, new_host := range hosts {
+		if host == old_host || strings.HasPrefix(host, strings.TrimSuffix(old_host, "/")+"/") {
+			return new_host + strings.TrimPrefix(host, old_host)
+		}
+	}
+	return host
+}
+
+func remapClientGroups(clientgroups string, groups map[string]string) string {
+	if clientgroups == "" || len(groups) == 0 {
+		return clientgroups
+	}
+	list := strings.Split(clientgroups, ",")
+	for i, group := range list {
+		if new_group, ok := groups[strings.TrimSpace(group)]; ok {
+			list[i] = new_group
+		}
+	}
+	return strings.Join(list, ",")
+}
+
+// Remap applies the client group and Shock host mappings to the job and its tasks
+func (remap *JobArchiveRemap) Remap(job *Job) {
+	if job.Info != nil {
+		job.Info.ClientGroups = remapClientGroups(job.Info.ClientGroups, remap.ClientGroups)
+	}
+	job.ShockHost = remapHost(job.ShockHost, remap.ShockHosts)
+	if job.CWL_ShockRequirement != nil {
+		job.CWL_ShockRequirement.Shock_api_url = remapHost(job.CWL_ShockRequirement.Shock_api_url, remap.ShockHosts)
+	}
+	for _, task := range job.Tasks {
+		task.ClientGroups = remapClientGroups(task.ClientGroups, remap.ClientGroups)
+		for _, ios := range [][]*IO{task.Inputs, task.Outputs, task.Predata} {
+			for _, io := range ios {
+				io.Host = remapHost(io.Host, remap.ShockHosts)
+				io.Url = remapHost(io.Url, remap.ShockHosts)
+			}
+		}
+	}
+}
+
+// ImportJobArchive recreates a job written by WriteJobArchive, the job keeps its id and is owned by u
+func ImportJobArchive(u *user.User, file FormFile, remap *JobArchiveRemap) (job *Job, err error) {
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		err = fmt.Errorf("(ImportJobArchive) archive is not gzipped: %s", err.Error())
+		return
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		err = fmt.Errorf("(ImportJobArchive) reading archive returned: %s", err.Error())
+		return
+	}
+	if hdr.Name != ARCHIVE_JOB {
+		err = fmt.Errorf("(ImportJobArchive) invalid archive: expected %s as first entry, got %s", ARCHIVE_JOB, hdr.Name)
+		return
+	}
+	data, err := ioutil.ReadAll(tr)
+	if err != nil {
+		return
+	}
+	job = NewJob()
+	err = bson.Unmarshal(data, job)
+	if err != nil {
+		err = fmt.Errorf("(ImportJobArchive) bson.Unmarshal of job document returned: %s", err.Error())
+		return
+	}
+
+	// archives of other servers may still contain tokens, those are not valid for the new owner
+	sanitizeJob(job)
+
+	if job.Id == "" {
+		err = errors.New("(ImportJobArchive) invalid job import: missing job id")
+		return
+	}
+	// the id is used for the path of the job directory
+	if !IsValidUUID(job.Id) {
+		err = fmt.Errorf("(ImportJobArchive) invalid job import: job id %s is not a uuid", job.Id)
+		return
+	}
+	if job.Info == nil {
+		err = errors.New("(ImportJobArchive) invalid job import: missing job info")
+		return
+	}
+	if len(job.Tasks) == 0 {
+		err = errors.New("(ImportJobArchive) invalid job import: task list empty")
+		return
+	}
+	for _, task := range job.Tasks {
+		if task.JobId != job.Id {
+			err = fmt.Errorf("(ImportJobArchive) invalid job import: task %s belongs to job %s", task.Id, task.JobId)
+			return
+		}
+	}
+	if !contains(JOB_STATS_ARCHIVABLE, job.State) {
+		err = fmt.Errorf("(ImportJobArchive) invalid job import: job is in state %s", job.State)
+		return
+	}
+	count, err := dbCount(bson.M{"id": job.Id})
+	if err != nil {
+		return
+	}
+	if count > 0 {
+		err = fmt.Errorf("(ImportJobArchive) job %s already exists", job.Id)
+		return
+	}
+
+	if remap != nil {
+		remap.Remap(job)
+	}
+
+	// the owner on this server may be a different user
+	job.Acl = acl.Acl{}
+	job.Acl.SetOwner(u.Uuid)
+	job.Acl.Set(u.Uuid, acl.Rights{"read": true, "write": true, "delete": true})
+	err = ApplyProjectAcl(job)
+	if err != nil {
+		return
+	}
+	job.Registered = false
+
+	job_path, err := job.Path()
+	if err != nil {
+		return
+	}
+	err = job.Mkdir()
+	if err != nil {
+		err = fmt.Errorf("(ImportJobArchive) job.Mkdir returned: %s", err.Error())
+		return
+	}
+
+	var perf *JobPerf
+	for {
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			err = fmt.Errorf("(ImportJobArchive) reading archive returned: %s", err.Error())
+			return
+		}
+		switch {
+		case hdr.Name == ARCHIVE_PERF:
+			data, err = ioutil.ReadAll(tr)
+			if err != nil {
+				return
+			}
+			perf = new(JobPerf)
+			err = bson.Unmarshal(data, perf)
+			if err != nil {
+				err = fmt.Errorf("(ImportJobArchive) bson.Unmarshal of perf returned: %s", err.Error())
+				return
+			}
+		case strings.HasPrefix(hdr.Name, ARCHIVE_LOGS):
+			// entries must not write outside of the job directory
+			name := path.Clean(strings.TrimPrefix(hdr.Name, ARCHIVE_LOGS))
+			if !strings.HasPrefix(name, job.Id+"_") || strings.Contains(name, "..") {
+				logger.Warning("(ImportJobArchive) skipping log %s, it does not belong to job %s", hdr.Name, job.Id)
+				continue
+			}
+			log_path := path.Join(job_path, name)
+			err = os.MkdirAll(path.Dir(log_path), 0777)
+			if err != nil {
+				return
+			}
+			var log_file *os.File
+			log_file, err = os.Create(log_path)
+			if err != nil {
+				return
+			}
+			_, err = io.Copy(log_file, tr)
+			log_file.Close()
+			if err != nil {
+				return
+			}
+		default:
+			logger.Warning("(ImportJobArchive) skipping unknown archive entry %s", hdr.Name)
+		}
+	}
+
+	err = job.Save()
+	if err != nil {
+		err = fmt.Errorf("(ImportJobArchive) job.Save returned: %s", err.Error())
+		return
+	}
+	if perf != nil {
+		perf.Id = job.Id
+		err = dbUpsert(perf)
+		if err != nil {
+			err = fmt.Errorf("(ImportJobArchive) saving perf returned: %s", err.Error())
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/user"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func writeTestArchive(t *testing.T, dir string, job *Job) FormFile {
+	data, err := bson.Marshal(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file_path := path.Join(dir, "job.tar.gz")
+	f, err := os.Create(file_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	if err = tw.WriteHeader(&tar.Header{Name: ARCHIVE_JOB, Mode: 0644, Size: int64(len(data))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	gw.Close()
+	return FormFile{Name: "job.tar.gz", Path: file_path}
+}
+
+func TestImportJobArchiveId(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awe-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf.DATA_PATH = path.Join(dir, "data")
+	u := &user.User{Uuid: "owner"}
+
+	for _, id := range []string{"../../../../x", "abcdef", "00000000-0000-0000-0000-000000000000/../x"} {
+		job := NewJob()
+		job.Id = id
+		job.Info = NewInfo()
+		job.State = JOB_STAT_COMPLETED
+		task := &Task{}
+		task.JobId = id
+		task.Id = id + "_0"
+		job.Tasks = []*Task{task}
+		if _, err = ImportJobArchive(u, writeTestArchive(t, dir, job), nil); err == nil {
+			t.Fatalf("job id %s accepted", id)
+		}
+	}
+
+	// tasks must belong to the job
+	job := NewJob()
+	job.setId()
+	job.Info = NewInfo()
+	job.State = JOB_STAT_COMPLETED
+	task := &Task{}
+	task.JobId = "other"
+	task.Id = "other_0"
+	job.Tasks = []*Task{task}
+	if _, err = ImportJobArchive(u, writeTestArchive(t, dir, job), nil); err == nil {
+		t.Fatalf("task of another job accepted")
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if file.Name() != "job.tar.gz" {
+			t.Fatalf("import created %s", file.Name())
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/db"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/logger/event"
+	"github.com/MG-RAST/AWE/lib/user"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// JobEvent is an entry of the job history, Code is one of the event codes in lib/logger/event
+type JobEvent struct {
+	JobId       string            `bson:"job_id" json:"job_id"`
+	Time        time.Time         `bson:"time" json:"time"`
+	Code        string            `bson:"code" json:"code"`
+	Description string            `bson:"-" json:"description"`
+	User        string            `bson:"user,omitempty" json:"user,omitempty"`     // user who triggered the event
+	Client      string            `bson:"client,omitempty" json:"client,omitempty"` // client that triggered the event
+	Attributes  map[string]string `bson:"attributes,omitempty" json:"attributes,omitempty"`
+}
+
+func InitJobHistoryDB() {
+	c := db.C(conf.DB_COLL_HISTORY)
+	c.EnsureIndex(db.Index{Key: []string{"job_id", "time"}, Background: true})
+}
+
+// Actor returns the name under which a user is recorded in the job history
+func Actor(u *user.User) string {
+	if u == nil {
+		return ""
+	}
+	if u.Username != "" {
+		return u.Username
+	}
+	return u.Uuid
+}
+
+// RecordJobEvent stores an event in the job history, failures are logged but do not affect the caller
+func RecordJobEvent(jobid string, code string, username string, clientid string, attrs map[string]string) {
+	if jobid == "" {
+		return
+	}
+	je := &JobEvent{
+		JobId:      jobid,
+		Time:       time.Now(),
+		Code:       code,
+		User:       username,
+		Client:     clientid,
+		Attributes: attrs,
+	}
+	c := db.C(conf.DB_COLL_HISTORY)
+	if err := c.Insert(je); err != nil {
+		logger.Error("(RecordJobEvent) job %s, event %s: %s", jobid, code, err.Error())
+	}
+}
+
+// LogJobEvent writes the event to the event log and stores it in the job history
+func LogJobEvent(jobid string, code string, username string, clientid string, attrs map[string]string) {
+	parts := []string{"jobid=" + jobid}
+	if username != "" {
+		parts = append(parts, "user="+username)
+	}
+	if clientid != "" {
+		parts = append(parts, "clientid="+clientid)
+	}
+	keys := []string{}
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		parts = append(parts, key+"="+attrs[key])
+	}
+	logger.Event(code, strings.Join(parts, ";"))
+	RecordJobEvent(jobid, code, username, clientid, attrs)
+}
+
+// GetJobHistory returns a page of the job history, oldest event first
+func GetJobHistory(jobid string, limit int, offset int) (events []*JobEvent, total int, err error) {
+	c := db.C(conf.DB_COLL_HISTORY)
+	query := c.Find(bson.M{"job_id": jobid})
+	total, err = query.Count()
+	if err != nil {
+		return
+	}
+	events = []*JobEvent{}
+	err = query.Sort("time").Skip(offset).Limit(limit).All(&events)
+	if err != nil {
+		return
+	}
+	for _, je := range events {
+		je.Description = eventDescription(je.Code)
+	}
+	return
+}
+
+func dbDeleteJobHistory(jobid string) (err error) {
+	err = dbDelete(bson.M{"job_id": jobid}, conf.DB_COLL_HISTORY)
+	return
+}
+
+func eventDescription(code string) string {
+	for _, group := range []string{"server", "general"} {
+		if description, ok := event.EventDiscription[group][code]; ok {
+			return description
+		}
+	}
+	return ""
+}
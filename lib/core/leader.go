@@ -0,0 +1,297 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/db"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/golib/go-uuid/uuid"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const LEASE_ID = "leader"
+
+// Leadership is nil unless the server runs in high availability mode
+var Leadership *Leader
+
+// ServerLease is the lease document in the Leases collection. Only the holder of an unexpired lease
+// runs the queue; a standby server takes over the lease once it expired. ServerUuid does not change
+// on takeover, so the workers keep their work.
+type ServerLease struct {
+	Id         string    `bson:"_id" json:"id"`
+	Holder     string    `bson:"holder" json:"holder"`
+	Url        string    `bson:"url" json:"url"`
+	Expires    time.Time `bson:"expires" json:"expires"`
+	Term       int       `bson:"term" json:"term"`
+	ServerUuid string    `bson:"server_uuid" json:"server_uuid"`
+}
+
+// Leader campaigns for the lease and renews it while leading.
+// The servers compare their own clocks with the lease expiration, they should be synchronized.
+type Leader struct {
+	sync.RWMutex
+	instance string
+	lease    ServerLease
+	leading  bool
+	expires  time.Time // local view of the own lease, renewal requests that fail do not extend it
+	adopting bool
+	deadline *time.Timer // fires when the own lease expires without renewal
+	exit     func()
+	elected  chan struct{}
+	lost     chan struct{}
+}
+
+func InitLeader() {
+	Leadership = newLeader()
+}
+
+func newLeader() *Leader {
+	return &Leader{
+		instance: uuid.New(),
+		exit:     func() { os.Exit(1) },
+		elected:  make(chan struct{}),
+		lost:     make(chan struct{}),
+	}
+}
+
+func leaseDuration() time.Duration {
+	return time.Duration(conf.HA_LEASE) * time.Second
+}
+
+// leaseCollection fails before the lease could expire, a hanging database must not keep a leader alive
+func leaseCollection() db.Collection {
+	return db.CTimeout(conf.DB_COLL_LEASES, leaseDuration()/3)
+}
+
+// Campaign tries to acquire the lease every third of the lease duration and renews it once elected.
+// It returns when the leadership is lost, a server that lost the lease has to restart as standby.
+func (l *Leader) Campaign() {
+	for {
+		start := time.Now()
+		leading, err := l.acquire(start)
+		if err != nil {
+			logger.Error("(Campaign) %s", err.Error())
+		}
+
+		l.Lock()
+		if leading {
+			l.expires = start.Add(leaseDuration())
+			// another server may take over once the lease expired, this one must not continue then
+			if l.deadline == nil {
+				l.deadline = time.AfterFunc(time.Until(l.expires), l.expired)
+			} else {
+				l.deadline.Reset(time.Until(l.expires))
+			}
+			if !l.leading {
+				l.leading = true
+				logger.Info("(Campaign) elected leader for term %d, server uuid %s", l.lease.Term, l.lease.ServerUuid)
+				close(l.elected)
+			}
+		} else if l.leading && (err == nil || time.Now().After(l.expires)) {
+			l.leading = false
+			l.Unlock()
+			logger.Error("(Campaign) lost leadership, lease holder is %s (%s)", l.lease.Holder, l.lease.Url)
+			close(l.lost)
+			return
+		}
+		l.Unlock()
+
+		time.Sleep(leaseDuration() / 3)
+	}
+}
+
+// expired exits the process if the lease was not renewed in time
+func (l *Leader) expired() {
+	l.RLock()
+	renewed := time.Now().Before(l.expires)
+	l.RUnlock()
+	if renewed {
+		return
+	}
+	logger.Error("(Campaign) lease expired without renewal, exiting")
+	l.exit()
+}
+
+// acquire renews the lease of this server, takes over an expired lease or creates the first one
+func (l *Leader) acquire(now time.Time) (leading bool, err error) {
+	c := leaseCollection()
+	expires := now.Add(leaseDuration())
+
+	err = c.Update(bson.M{"_id": LEASE_ID, "holder": l.instance}, bson.M{"$set": bson.M{"url": conf.HA_URL, "expires": expires}})
+	if err == nil {
+		leading = true
+	} else if err == db.ErrNotFound {
+		err = c.Update(bson.M{"_id": LEASE_ID, "expires": bson.M{"$lt": now}}, bson.M{"$set": bson.M{"holder": l.instance, "url": conf.HA_URL, "expires": expires}, "$inc": bson.M{"term": 1}})
+		if err == nil {
+			leading = true
+		} else if err == db.ErrNotFound {
+			lease := ServerLease{Id: LEASE_ID, Holder: l.instance, Url: conf.HA_URL, Expires: expires, Term: 1, ServerUuid: uuid.New()}
+			err = c.Insert(lease)
+			if err == nil {
+				leading = true
+			} else if db.IsDup(err) {
+				// another server holds the lease
+				err = nil
+			}
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("(acquire) updating lease returned: %s", err.Error())
+		return
+	}
+
+	lease := ServerLease{}
+	err = c.Find(bson.M{"_id": LEASE_ID}).One(&lease)
+	if err != nil {
+		err = fmt.Errorf("(acquire) reading lease returned: %s", err.Error())
+		return
+	}
+	// the lease can only be taken from this server after it expired
+	leading = leading && lease.Holder == l.instance
+
+	l.Lock()
+	l.lease = lease
+	l.Unlock()
+	return
+}
+
+// Resign lets the lease expire, a standby server takes over without waiting for the lease duration
+func (l *Leader) Resign() (err error) {
+	c := leaseCollection()
+	err = c.Update(bson.M{"_id": LEASE_ID, "holder": l.instance}, bson.M{"$set": bson.M{"expires": time.Now()}})
+	if err == db.ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+// Elected is closed when this server becomes leader
+func (l *Leader) Elected() <-chan struct{} {
+	return l.elected
+}
+
+// Lost is closed when this server is not leader anymore
+func (l *Leader) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *Leader) IsLeader() bool {
+	l.RLock()
+	defer l.RUnlock()
+	return l.leading && time.Now().Before(l.expires)
+}
+
+// LeaderUrl returns the API url of the current leader, or an empty string if no server holds an unexpired lease
+func (l *Leader) LeaderUrl() string {
+	l.RLock()
+	defer l.RUnlock()
+	if l.lease.Holder == "" || time.Now().After(l.lease.Expires) {
+		return ""
+	}
+	return l.lease.Url
+}
+
+// ServerUuid is the uuid announced to the workers, it is the same for all terms
+func (l *Leader) ServerUuid() string {
+	l.RLock()
+	defer l.RUnlock()
+	return l.lease.ServerUuid
+}
+
+// TakeOver recovers the unfinished jobs and keeps the queue suspended for one lease period afterwards.
+// Workers of the previous leader report their current work with the heartbeat in the meantime,
+// the server adopts these workunits instead of handing them out again.
+// A queue that was suspended before stays suspended.
+func (l *Leader) TakeOver(qm ResourceMgr) (recovered int, total int, err error) {
+	l.Lock()
+	l.adopting = true
+	l.Unlock()
+	suspended := qm.QueueStatus() == "suspended"
+	if !suspended {
+		qm.SuspendQueue()
+	}
+
+	recovered, total, err = qm.RecoverJobs()
+
+	go func() {
+		time.Sleep(leaseDuration())
+		l.Lock()
+		l.adopting = false
+		l.Unlock()
+		if suspended {
+			logger.Info("(TakeOver) adoption of running workunits finished, queue stays suspended")
+			return
+		}
+		qm.ResumeQueue()
+		logger.Info("(TakeOver) adoption of running workunits finished, queue resumed")
+	}()
+	return
+}
+
+func (l *Leader) isAdopting() bool {
+	l.RLock()
+	defer l.RUnlock()
+	return l.adopting
+}
+
+func (qm *ServerMgr) ClientHeartBeat(id string, cg *ClientGroup, workerstate WorkerState) (hbmsg HeartbeatInstructions, err error) {
+	hbmsg, err = qm.CQMgr.ClientHeartBeat(id, cg, workerstate)
+	if err != nil {
+		return
+	}
+
+	if isAdopting() {
+		err = qm.adoptWorks(id)
+	}
+	return
+}
+
+// adoptWorks checks out the recovered workunits that the client is still running
+func (qm *ServerMgr) adoptWorks(client_id string) (err error) {
+	client, ok, err := qm.GetClient(client_id, true)
+	if err != nil || !ok {
+		return
+	}
+
+	err = client.LockNamed("adoptWorks")
+	if err != nil {
+		return
+	}
+	current_work, err := client.Current_work.Get_list(false)
+	if err != nil {
+		client.Unlock()
+		return
+	}
+
+	adopted := []*Workunit{}
+	for _, work_id := range current_work {
+		work, has_work, xerr := qm.workQueue.all.Get(work_id)
+		if xerr != nil || !has_work {
+			continue
+		}
+		if work.State != WORK_STAT_QUEUED {
+			continue
+		}
+		// the client is reset when leaving the queue, set it afterwards
+		qm.workQueue.StatusChange(work_id, work, WORK_STAT_CHECKOUT, "")
+		work.Client = client_id
+		work.CheckoutTime = time.Now()
+		err = client.Assigned_work.Add(work_id)
+		if err != nil {
+			client.Unlock()
+			return
+		}
+		adopted = append(adopted, work)
+	}
+	client.Unlock()
+
+	if len(adopted) > 0 {
+		logger.Info("(adoptWorks) client %s: adopted %d running workunits", client_id, len(adopted))
+		err = qm.UpdateJobTaskToInProgress(adopted)
+	}
+	return
+}
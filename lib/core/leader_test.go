@@ -0,0 +1,151 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/db"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// fakeQueue implements the queue functions used by TakeOver
+type fakeQueue struct {
+	ResourceMgr
+	suspended bool
+	resumed   chan struct{}
+}
+
+func (qm *fakeQueue) SuspendQueue() {
+	qm.suspended = true
+}
+
+func (qm *fakeQueue) ResumeQueue() {
+	qm.suspended = false
+	close(qm.resumed)
+}
+
+func (qm *fakeQueue) QueueStatus() string {
+	if qm.suspended {
+		return "suspended"
+	}
+	return "running"
+}
+
+func (qm *fakeQueue) RecoverJobs() (recovered int, total int, err error) {
+	return
+}
+
+func TestLeader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awe-leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := db.OpenEmbedded(path.Join(dir, "awe.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	db.SetStore(s)
+	conf.HA_LEASE = 1
+
+	first := newLeader()
+	second := newLeader()
+	now := time.Now()
+
+	// the first server creates the lease, the second one cannot take it
+	if leading, err := first.acquire(now); err != nil || !leading {
+		t.Fatalf("first server was not elected: %v", err)
+	}
+	server_uuid := first.ServerUuid()
+	if leading, err := second.acquire(now); err != nil || leading {
+		t.Fatalf("second server took an unexpired lease: %v", err)
+	}
+	if leading, err := first.acquire(now); err != nil || !leading {
+		t.Fatalf("first server could not renew the lease: %v", err)
+	}
+
+	// the lease is taken over after it expired, the server uuid stays the same
+	if leading, err := second.acquire(now.Add(2 * leaseDuration())); err != nil || !leading {
+		t.Fatalf("second server did not take over the expired lease: %v", err)
+	}
+	if second.ServerUuid() != server_uuid || second.lease.Term != 2 {
+		t.Fatalf("unexpected lease after takeover: %+v", second.lease)
+	}
+	if leading, err := first.acquire(now.Add(2 * leaseDuration())); err != nil || leading {
+		t.Fatalf("first server renewed a lease it lost: %v", err)
+	}
+
+	// after resigning the lease can be taken over at once
+	if err = second.Resign(); err != nil {
+		t.Fatal(err)
+	}
+	lease := ServerLease{}
+	if err = db.C(conf.DB_COLL_LEASES).Find(bson.M{"_id": LEASE_ID}).One(&lease); err != nil {
+		t.Fatal(err)
+	}
+	if lease.Expires.After(time.Now()) {
+		t.Fatalf("lease did not expire on resign")
+	}
+	// expirations are stored with millisecond precision
+	if leading, err := first.acquire(time.Now().Add(10 * time.Millisecond)); err != nil || !leading {
+		t.Fatalf("first server did not take over after resign: %v", err)
+	}
+}
+
+func TestLeaderTakeOver(t *testing.T) {
+	logger.Initialize("test")
+	conf.HA_LEASE = 1
+
+	// the queue is suspended while workunits are adopted and resumed afterwards
+	l := newLeader()
+	qm := &fakeQueue{resumed: make(chan struct{})}
+	if _, _, err := l.TakeOver(qm); err != nil {
+		t.Fatal(err)
+	}
+	if !qm.suspended || !l.isAdopting() {
+		t.Fatalf("queue not suspended during takeover")
+	}
+	select {
+	case <-qm.resumed:
+	case <-time.After(5 * leaseDuration()):
+		t.Fatalf("queue was not resumed")
+	}
+	if l.isAdopting() {
+		t.Fatalf("still adopting after takeover")
+	}
+
+	// a queue that was suspended before stays suspended
+	l = newLeader()
+	qm = &fakeQueue{suspended: true, resumed: make(chan struct{})}
+	if _, _, err := l.TakeOver(qm); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * leaseDuration())
+	if !qm.suspended || l.isAdopting() {
+		t.Fatalf("suspended queue was resumed by takeover")
+	}
+}
+
+func TestLeaderExpired(t *testing.T) {
+	logger.Initialize("test")
+	exited := make(chan struct{}, 1)
+	l := newLeader()
+	l.exit = func() { exited <- struct{}{} }
+
+	l.expires = time.Now().Add(time.Hour)
+	l.expired()
+	if len(exited) != 0 {
+		t.Fatalf("exit with a renewed lease")
+	}
+	l.expires = time.Now().Add(-time.Second)
+	l.expired()
+	if len(exited) != 1 {
+		t.Fatalf("no exit with an expired lease")
+	}
+}
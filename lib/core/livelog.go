@@ -0,0 +1,146 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+)
+
+// live logs keep the tail of stdout/stderr of running workunits, the worker sends new output while the tool is running
+// and the buffers are dropped when the workunit reports back, from then on the saved logs are used
+
+// buffers not updated for this long belong to workunits that never reported back
+const LIVE_LOG_EXPIRATION = time.Hour
+
+type LiveLog struct {
+	Attempt time.Time // checkout time of the workunit, a requeued workunit starts a new log
+	Start   int64     // offset of Data[0] in the log file
+	Data    []byte    // tail of the log, at most conf.LIVE_LOG_BUFFER KB
+	Updated time.Time
+	changed chan bool // closed and replaced on every change
+}
+
+// End returns the offset in the log file after the last buffered byte
+func (l *LiveLog) End() int64 {
+	return l.Start + int64(len(l.Data))
+}
+
+type LiveLogMap struct {
+	sync.Mutex
+	logs      map[string]*LiveLog
+	last_scan time.Time
+}
+
+var LiveLogs = NewLiveLogMap()
+
+func NewLiveLogMap() *LiveLogMap {
+	return &LiveLogMap{logs: make(map[string]*LiveLog), last_scan: time.Now()}
+}
+
+func liveLogKey(work_str string, logname string) string {
+	return work_str + "." + logname
+}
+
+// Append adds output of the attempt at position offset of the log file, a gap to the buffered output drops the buffer.
+// Output of a new attempt replaces the buffer, output of an earlier attempt is ignored.
+func (m *LiveLogMap) Append(work_str string, logname string, attempt time.Time, offset int64, data []byte) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.expire()
+
+	key := liveLogKey(work_str, logname)
+	l, ok := m.logs[key]
+	if ok && !l.Attempt.Equal(attempt) {
+		if attempt.Before(l.Attempt) {
+			return
+		}
+		close(l.changed)
+		ok = false
+	}
+	if !ok {
+		l = &LiveLog{Attempt: attempt, Start: offset, changed: make(chan bool)}
+		m.logs[key] = l
+	}
+	end := offset + int64(len(data))
+	switch {
+	case end <= l.End():
+		// already buffered, e.g. a retry of the worker
+		return
+	case offset > l.End():
+		// the worker skipped output
+		l.Start = offset
+		l.Data = append([]byte(nil), data...)
+	default:
+		l.Data = append(l.Data, data[l.End()-offset:]...)
+	}
+
+	max_size := conf.LIVE_LOG_BUFFER * 1024
+	if max_size > 0 && len(l.Data) > max_size {
+		cut := len(l.Data) - max_size
+		l.Start += int64(cut)
+		l.Data = append([]byte(nil), l.Data[cut:]...)
+	}
+	l.Updated = time.Now()
+	close(l.changed)
+	l.changed = make(chan bool)
+}
+
+// Read returns the buffered output after offset from, skipped is the number of bytes after from that are no longer
+// (or never were) buffered, changed is closed with the next update of the log.
+// from is an offset in the log of attempt, if the buffer belongs to another attempt the output is read from the start
+// and current is the attempt of the buffer.
+func (m *LiveLogMap) Read(work_str string, logname string, attempt time.Time, from int64) (data []byte, next int64, skipped int64, current time.Time, changed <-chan bool, ok bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	l, ok := m.logs[liveLogKey(work_str, logname)]
+	if !ok {
+		return
+	}
+	current = l.Attempt
+	if !current.Equal(attempt) {
+		from = 0
+	}
+	changed = l.changed
+	next = l.End()
+	if from < l.Start {
+		skipped = l.Start - from
+		from = l.Start
+	}
+	if from < next {
+		data = append([]byte(nil), l.Data[from-l.Start:]...)
+	} else {
+		next = from
+	}
+	return
+}
+
+// Close drops the buffers of the workunit and wakes up all readers
+func (m *LiveLogMap) Close(work_str string) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, logname := range conf.WORKUNIT_LOGS {
+		key := liveLogKey(work_str, logname)
+		if l, ok := m.logs[key]; ok {
+			close(l.changed)
+			delete(m.logs, key)
+		}
+	}
+}
+
+// expire drops stale buffers, at most once per minute, m must be locked
+func (m *LiveLogMap) expire() {
+	if time.Since(m.last_scan) < time.Minute {
+		return
+	}
+	m.last_scan = time.Now()
+	for key, l := range m.logs {
+		if time.Since(l.Updated) > LIVE_LOG_EXPIRATION {
+			close(l.changed)
+			delete(m.logs, key)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+)
+
+func TestLiveLogMap(t *testing.T) {
+	conf.LIVE_LOG_BUFFER = 1
+	m := NewLiveLogMap()
+	attempt := time.Now()
+
+	read := func(attempt time.Time, from int64) (string, int64, int64) {
+		data, next, skipped, _, _, ok := m.Read("w", "stdout", attempt, from)
+		if !ok {
+			t.Fatalf("no live log")
+		}
+		return string(data), next, skipped
+	}
+
+	if _, _, _, _, _, ok := m.Read("w", "stdout", attempt, 0); ok {
+		t.Fatalf("live log before first output")
+	}
+
+	m.Append("w", "stdout", attempt, 0, []byte("hello "))
+	m.Append("w", "stdout", attempt, 6, []byte("world\n"))
+	if data, next, skipped := read(attempt, 0); data != "hello world\n" || next != 12 || skipped != 0 {
+		t.Fatalf("unexpected log %q next=%d skipped=%d", data, next, skipped)
+	}
+	if data, next, _ := read(attempt, 6); data != "world\n" || next != 12 {
+		t.Fatalf("unexpected log %q next=%d", data, next)
+	}
+
+	// duplicates and overlapping retries of the worker are not buffered twice
+	m.Append("w", "stdout", attempt, 0, []byte("hello "))
+	m.Append("w", "stdout", attempt, 6, []byte("world\nfoo"))
+	if data, next, _ := read(attempt, 0); data != "hello world\nfoo" || next != 15 {
+		t.Fatalf("unexpected log after duplicates %q next=%d", data, next)
+	}
+
+	// a gap drops the buffer, readers are told how much they missed
+	m.Append("w", "stdout", attempt, 20, []byte("bar"))
+	if data, next, skipped := read(attempt, 15); data != "bar" || next != 23 || skipped != 5 {
+		t.Fatalf("unexpected log after gap %q next=%d skipped=%d", data, next, skipped)
+	}
+
+	// the buffer keeps the last conf.LIVE_LOG_BUFFER KB
+	big := make([]byte, 1500)
+	for i := range big {
+		big[i] = 'x'
+	}
+	m.Append("w", "stdout", attempt, 23, big)
+	data, next, skipped := read(attempt, 23)
+	if len(data) != 1024 || next != 1523 || skipped != 1523-1024-23 {
+		t.Fatalf("unexpected truncation: %d bytes next=%d skipped=%d", len(data), next, skipped)
+	}
+
+	// a requeued workunit starts a new log, late output of the earlier attempt is ignored
+	retry := attempt.Add(time.Minute)
+	m.Append("w", "stdout", retry, 0, []byte("again\n"))
+	m.Append("w", "stdout", attempt, 1523, []byte("late"))
+	restarted, next, _, current, _, _ := m.Read("w", "stdout", attempt, 1523)
+	if string(restarted) != "again\n" || next != 6 || !current.Equal(retry) {
+		t.Fatalf("unexpected log after restart %q next=%d", restarted, next)
+	}
+
+	// readers are woken up by changes and by Close
+	_, _, _, _, changed, _ := m.Read("w", "stdout", retry, 6)
+	m.Append("w", "stdout", retry, 6, []byte("more"))
+	select {
+	case <-changed:
+	default:
+		t.Fatalf("reader not notified")
+	}
+	_, _, _, _, changed, _ = m.Read("w", "stdout", retry, 10)
+	m.Close("w")
+	select {
+	case <-changed:
+	default:
+		t.Fatalf("reader not notified on close")
+	}
+	if _, _, _, _, _, ok := m.Read("w", "stdout", retry, 0); ok {
+		t.Fatalf("live log after close")
+	}
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/db"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/shock"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// number of failed attempts after which a node is not deleted anymore
+const NODE_CLEANUP_MAX_ATTEMPTS = 10
+
+// NodeCleanup is a Shock node of a job that is flagged as delete, temporary or intermediate.
+// Nodes that could not be deleted are stored in the NodeCleanup collection and retried by the JobReaper.
+type NodeCleanup struct {
+	Host        string    `bson:"host" json:"host"`
+	Node        string    `bson:"node" json:"node"`
+	JobId       string    `bson:"job_id" json:"job_id"`
+	TaskId      string    `bson:"task_id" json:"task_id"`
+	FileName    string    `bson:"filename" json:"filename"`
+	Reason      string    `bson:"reason" json:"reason"` // delete, temporary or intermediate
+	DataToken   string    `bson:"datatoken" json:"-"`
+	Error       string    `bson:"error" json:"error,omitempty"`
+	Attempts    int       `bson:"attempts" json:"attempts,omitempty"`
+	LastAttempt time.Time `bson:"last_attempt" json:"last_attempt,omitempty"`
+}
+
+func InitNodeCleanupDB() {
+	c := db.C(conf.DB_COLL_CLEANUP)
+	c.EnsureIndex(db.Index{Key: []string{"host", "node"}, Unique: true})
+	c.EnsureIndex(db.Index{Key: []string{"job_id"}, Background: true})
+}
+
+func dbUpsertNodeCleanup(nc *NodeCleanup) (err error) {
+	c := db.C(conf.DB_COLL_CLEANUP)
+	_, err = c.Upsert(bson.M{"host": nc.Host, "node": nc.Node}, nc)
+	return
+}
+
+func dbGetNodeCleanups(q bson.M, limit int) (list []*NodeCleanup, err error) {
+	c := db.C(conf.DB_COLL_CLEANUP)
+	list = []*NodeCleanup{}
+	err = c.Find(q).Sort("last_attempt").Limit(limit).All(&list)
+	return
+}
+
+func dbDeleteNodeCleanup(nc *NodeCleanup) (err error) {
+	err = dbDelete(bson.M{"host": nc.Host, "node": nc.Node}, conf.DB_COLL_CLEANUP)
+	return
+}
+
+// GetFailedNodeCleanups returns the nodes of the job that are waiting for another delete attempt
+func GetFailedNodeCleanups(jobid string) (list []*NodeCleanup, err error) {
+	return dbGetNodeCleanups(bson.M{"job_id": jobid}, 0)
+}
+
+func cleanupReason(io *IO) string {
+	if io.Delete {
+		return "delete"
+	}
+	if io.Temporary {
+		return "temporary"
+	}
+	if io.Intermediate {
+		return "intermediate"
+	}
+	return ""
+}
+
+// TemporaryNodes lists the Shock nodes of the job that are removed when the job completes, is deleted or expires.
+// Outputs of one task are often inputs of the next, every node is listed once.
+func (job *Job) TemporaryNodes() (nodes []*NodeCleanup) {
+	nodes = []*NodeCleanup{}
+	seen := make(map[string]bool)
+	// reused outputs belong to another job, they are also skipped where later tasks read them as input
+	for _, task := range job.TaskList() {
+		for _, io := range task.Outputs {
+			if io.Reused {
+				seen[io.Host+"/"+io.Node] = true
+			}
+		}
+		for _, node := range task.ReusedNodes {
+			seen[node] = true
+		}
+	}
+	for _, task := range job.TaskList() {
+		task_id, _ := task.String()
+		ios := append([]*IO{}, task.Outputs...)
+		ios = append(ios, task.Inputs...)
+		for _, io := range ios {
+			reason := cleanupReason(io)
+			if reason == "" || io.Node == "" || io.Node == "-" || io.StorageScheme() != STORAGE_SHOCK {
+				continue
+			}
+			key := io.Host + "/" + io.Node
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			nodes = append(nodes, &NodeCleanup{
+				Host:      io.Host,
+				Node:      io.Node,
+				JobId:     job.Id,
+				TaskId:    task_id,
+				FileName:  io.FileName,
+				Reason:    reason,
+				DataToken: io.DataToken,
+			})
+		}
+	}
+	return
+}
+
+// CleanupTemporaryNodes deletes the temporary Shock nodes of the job, failures are recorded for retry
+func (job *Job) CleanupTemporaryNodes() (deleted int, failed int) {
+	for _, nc := range job.TemporaryNodes() {
+		if nc.delete() {
+			deleted += 1
+			continue
+		}
+		failed += 1
+		if err := dbUpsertNodeCleanup(nc); err != nil {
+			logger.Error("(CleanupTemporaryNodes) could not record failed deletion of node %s: %s", nc.Node, err.Error())
+		}
+	}
+	if deleted > 0 || failed > 0 {
+		logger.Info("(CleanupTemporaryNodes) job %s: %d temporary nodes deleted, %d failed", job.Id, deleted, failed)
+	}
+	return
+}
+
+// RetryNodeCleanups retries deletions that failed before, it is called periodically by the JobReaper
+func RetryNodeCleanups() {
+	list, err := dbGetNodeCleanups(bson.M{}, 1000)
+	if err != nil {
+		logger.Error("(RetryNodeCleanups) dbGetNodeCleanups returned: %s", err.Error())
+		return
+	}
+	for _, nc := range list {
+		if nc.delete() || nc.Attempts >= NODE_CLEANUP_MAX_ATTEMPTS {
+			if nc.Attempts >= NODE_CLEANUP_MAX_ATTEMPTS {
+				logger.Error("(RetryNodeCleanups) giving up on node %s/node/%s of job %s after %d attempts: %s", nc.Host, nc.Node, nc.JobId, nc.Attempts, nc.Error)
+			}
+			if err := dbDeleteNodeCleanup(nc); err != nil {
+				logger.Error("(RetryNodeCleanups) dbDeleteNodeCleanup returned: %s", err.Error())
+			}
+			continue
+		}
+		if err := dbUpsertNodeCleanup(nc); err != nil {
+			logger.Error("(RetryNodeCleanups) dbUpsertNodeCleanup returned: %s", err.Error())
+		}
+	}
+}
+
+// delete returns true if the node is gone, otherwise the error is recorded in nc
+func (nc *NodeCleanup) delete() bool {
+	err := shock.ShockDelete(nc.Host, nc.Node, nc.DataToken)
+	if err == nil || strings.Contains(strings.ToLower(err.Error()), "not found") {
+		logger.Debug(2, "(NodeCleanup) deleted node %s/node/%s (%s)", nc.Host, nc.Node, nc.Reason)
+		return true
+	}
+	nc.Attempts += 1
+	nc.LastAttempt = time.Now()
+	nc.Error = err.Error()
+	logger.Warning("(NodeCleanup) failed to delete node %s/node/%s: %s", nc.Host, nc.Node, err.Error())
+	return false
+}
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestTemporaryNodes(t *testing.T) {
+	job := NewJob()
+	job.setId()
+
+	shock_host := "http://shock.example.org"
+	task0, err := NewTask(job, "", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task0.Outputs = []*IO{
+		{FileName: "keep.fna", Host: shock_host, Node: "keep"},
+		{FileName: "tmp.fna", Host: shock_host, Node: "tmp", Temporary: true},
+		{FileName: "del.fna", Host: shock_host, Node: "del", Delete: true},
+		{FileName: "reused.fna", Host: shock_host, Node: "reused", Intermediate: true, Reused: true},
+		{FileName: "s3.fna", Url: "s3://bucket/s3.fna", Temporary: true},
+		{FileName: "none.fna", Host: shock_host, Node: "-", Temporary: true},
+	}
+	task1, err := NewTask(job, "", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task1.Inputs = []*IO{
+		{FileName: "tmp.fna", Host: shock_host, Node: "tmp", Temporary: true},
+		{FileName: "reused.fna", Host: shock_host, Node: "reused", Intermediate: true},
+		{FileName: "input.fna", Host: shock_host, Node: "input", Delete: true},
+	}
+	task1.Outputs = []*IO{
+		{FileName: "final.fna", Host: shock_host, Node: "final", Intermediate: true},
+	}
+	job.Tasks = []*Task{task0, task1}
+
+	expected := map[string]string{"tmp": "temporary", "del": "delete", "input": "delete", "final": "intermediate"}
+	nodes := job.TemporaryNodes()
+	if len(nodes) != len(expected) {
+		t.Fatalf("expected %d nodes, got %d", len(expected), len(nodes))
+	}
+	for _, nc := range nodes {
+		reason, ok := expected[nc.Node]
+		if !ok {
+			t.Fatalf("node %s should not be deleted", nc.Node)
+		}
+		if nc.Reason != reason || nc.JobId != job.Id || nc.Host != shock_host {
+			t.Fatalf("unexpected cleanup entry %+v", nc)
+		}
+	}
+}
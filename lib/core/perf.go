@@ -39,9 +39,10 @@ type WorkPerf struct {
 	MaxMemoryTotalRss  int64   `bson:"max_memory_total_rss" json:"max_memory_total_rss"`
 	MaxMemoryTotalSwap int64   `bson:"max_memory_total_swap" json:"max_memory_total_swap"`
 	ClientId           string  `bson:"client_id" json:"client_id"`
-	PreDataSize        int64   `bson:"size_predata" json:"size_predata"` //predata moved over network
-	InFileSize         int64   `bson:"size_infile" json:"size_infile"`   //input file moved over network
-	OutFileSize        int64   `bson:"size_outfile" json:"size_outfile"` //outpuf file moved over network
+	PreDataSize        int64   `bson:"size_predata" json:"size_predata"`                 //predata moved over network
+	InFileSize         int64   `bson:"size_infile" json:"size_infile"`                   //input file moved over network
+	OutFileSize        int64   `bson:"size_outfile" json:"size_outfile"`                 //outpuf file moved over network
+	DockerImageId      string  `bson:"docker_image_id" json:"docker_image_id,omitempty"` // id (sha256 digest) of the docker image used
 }
 
 func NewJobPerf(id string) *JobPerf {
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+)
+
+// PredataIndex records which clients hold which predata files (by md5) in their predata cache,
+// it is updated with every heartbeat and used for data-locality scheduling
+type PredataIndex struct {
+	sync.RWMutex
+	clients map[string]map[string]bool // client id -> md5 set
+}
+
+func NewPredataIndex() *PredataIndex {
+	return &PredataIndex{clients: make(map[string]map[string]bool)}
+}
+
+func (pi *PredataIndex) Update(clientid string, md5s []string) {
+	pi.Lock()
+	defer pi.Unlock()
+	if len(md5s) == 0 {
+		delete(pi.clients, clientid)
+		return
+	}
+	set := make(map[string]bool, len(md5s))
+	for _, md5 := range md5s {
+		set[md5] = true
+	}
+	pi.clients[clientid] = set
+	return
+}
+
+func (pi *PredataIndex) Remove(clientid string) {
+	pi.Lock()
+	defer pi.Unlock()
+	delete(pi.clients, clientid)
+	return
+}
+
+// Get returns a copy of the md5 set of the client
+func (pi *PredataIndex) Get(clientid string) (set map[string]bool) {
+	pi.RLock()
+	defer pi.RUnlock()
+	set = make(map[string]bool, len(pi.clients[clientid]))
+	for md5, _ := range pi.clients[clientid] {
+		set[md5] = true
+	}
+	return
+}
+
+// Holders returns for every client that holds some of the md5s how many it holds
+func (pi *PredataIndex) Holders(md5s []string) (counts map[string]int) {
+	pi.RLock()
+	defer pi.RUnlock()
+	counts = make(map[string]int)
+	for id, set := range pi.clients {
+		count := 0
+		for _, md5 := range md5s {
+			if set[md5] {
+				count += 1
+			}
+		}
+		if count > 0 {
+			counts[id] = count
+		}
+	}
+	return
+}
+
+// PredataMd5s returns the md5 checksums of the predata files of the workunit, if known
+func (work *Workunit) PredataMd5s() (md5s []string) {
+	for _, io := range work.Predata {
+		if io.MD5 != "" {
+			md5s = append(md5s, io.MD5)
+		}
+	}
+	return
+}
+
+// reservedForPredataHolder returns true if another client that could check out the workunit holds more of its
+// predata than this client and the workunit has been queued for less than conf.PREDATA_LOCALITY_WAIT seconds
+func (qm *CQMgr) reservedForPredataHolder(clientid string, workunit *Workunit) bool {
+	if conf.PREDATA_LOCALITY_WAIT <= 0 || qm.predataIndex == nil {
+		return false
+	}
+	if time.Since(workunit.QueuedTime) >= time.Duration(conf.PREDATA_LOCALITY_WAIT)*time.Second {
+		return false
+	}
+	md5s := workunit.PredataMd5s()
+	if len(md5s) == 0 {
+		return false
+	}
+	counts := qm.predataIndex.Holders(md5s)
+	own := counts[clientid]
+	for id, count := range counts {
+		if id == clientid || count <= own {
+			continue
+		}
+		if qm.predataHolderEligible(id, workunit) {
+			return true
+		}
+	}
+	return false
+}
+
+// predataHolderEligible returns true if the client is online, not suspended or draining and may run the workunit
+func (qm *CQMgr) predataHolderEligible(clientid string, workunit *Workunit) bool {
+	client, ok, err := qm.GetClient(clientid, true)
+	if err != nil || !ok {
+		return false
+	}
+	read_lock, err := client.RLockNamed("predataHolderEligible")
+	if err != nil {
+		return false
+	}
+	defer client.RUnlockNamed(read_lock)
+
+	if !client.Online || client.Suspended || client.Draining || client.Drain_requested {
+		return false
+	}
+	if workunit.Info != nil && len(workunit.Info.ClientGroups) > 0 {
+		if !contains(strings.Split(workunit.Info.ClientGroups, ","), client.Group) {
+			return false
+		}
+	}
+	if workunit.Cmd != nil && !contains(client.Apps, workunit.Cmd.Name) && !contains(client.Apps, conf.ALL_APP) {
+		return false
+	}
+	return !client.Contains_Skip_work_nolock(workunit.Id)
+}
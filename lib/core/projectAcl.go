@@ -0,0 +1,89 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/MG-RAST/AWE/lib/acl"
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/db"
+	"github.com/MG-RAST/AWE/lib/user"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ProjectAcl holds the entries that are added to the acl of every new job of the project (Info.Project)
+type ProjectAcl struct {
+	Project string  `bson:"project" json:"project"`
+	Acl     acl.Acl `bson:"acl" json:"acl"`
+}
+
+func InitProjectAclDB() {
+	c := db.C(conf.DB_COLL_PROJECT_ACLS)
+	c.EnsureIndex(db.Index{Key: []string{"project"}, Unique: true})
+}
+
+// JobRights returns the rights of u on a job, entries of the groups of u and public entries apply as well,
+// admins have all rights
+func JobRights(job_acl acl.Acl, u *user.User) (rights acl.Rights) {
+	if u.Admin {
+		admin_acl := acl.Acl{Owner: u.Uuid}
+		return admin_acl.Check(u.Uuid)
+	}
+	rights = job_acl.Check(u.AclIds()...)
+	// public entries only grant read, a job owned by public is not owned by every user
+	public_acl := job_acl
+	public_acl.Owner = ""
+	if public_acl.Check("public")["read"] {
+		rights["read"] = true
+	}
+	return
+}
+
+// JobAclFilter returns the $or conditions selecting the jobs u can read
+func JobAclFilter(u *user.User) []bson.M {
+	ids := u.AclIds()
+	return []bson.M{
+		bson.M{"acl.read": bson.M{"$in": append(ids, "public")}},
+		bson.M{"acl.owner": bson.M{"$in": ids}},
+		bson.M{"acl.owners": bson.M{"$in": ids}},
+		bson.M{"acl": bson.M{"$exists": false}},
+	}
+}
+
+func GetProjectAcl(project string) (pacl *ProjectAcl, err error) {
+	c := db.C(conf.DB_COLL_PROJECT_ACLS)
+	pacl = &ProjectAcl{}
+	if err = c.Find(bson.M{"project": project}).One(pacl); err != nil {
+		pacl = nil
+	}
+	return
+}
+
+func (pacl *ProjectAcl) Save() (err error) {
+	c := db.C(conf.DB_COLL_PROJECT_ACLS)
+	_, err = c.Upsert(bson.M{"project": pacl.Project}, pacl)
+	return
+}
+
+func DeleteProjectAcl(project string) (err error) {
+	c := db.C(conf.DB_COLL_PROJECT_ACLS)
+	err = c.Remove(bson.M{"project": project})
+	return
+}
+
+// ApplyProjectAcl adds the default acl of the project of the job
+func ApplyProjectAcl(job *Job) (err error) {
+	if job.Info == nil || job.Info.Project == "" {
+		return
+	}
+	pacl, err := GetProjectAcl(job.Info.Project)
+	if err != nil {
+		if err == db.ErrNotFound {
+			err = nil
+		} else {
+			err = fmt.Errorf("(ApplyProjectAcl) loading acl of project %s returned: %s", job.Info.Project, err.Error())
+		}
+		return
+	}
+	job.Acl.Merge(pacl.Acl)
+	return
+}
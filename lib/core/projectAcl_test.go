@@ -0,0 +1,125 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/MG-RAST/AWE/lib/acl"
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/db"
+	"github.com/MG-RAST/AWE/lib/user"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestJobRights(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awe-acl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := db.OpenEmbedded(path.Join(dir, "awe.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	db.SetStore(s)
+
+	member := &user.User{Uuid: "member"}
+	group, err := user.NewGroup("lab", &user.User{Uuid: "manager"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	group.AddMember(member.Uuid)
+	if err = group.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	job_acl := acl.Acl{Owner: "owner"}
+	job_acl.Set(group.AclId(), acl.Rights{"read": true, "operate": true})
+	job_acl.Set("public", acl.Rights{"read": true, "write": true, "delete": true})
+
+	public_job := acl.Acl{Owner: "public"}
+
+	all := acl.Rights{"read": true, "write": true, "delete": true, "operate": true, "owner": true}
+	read := acl.Rights{"read": true, "write": false, "delete": false, "operate": false, "owner": false}
+	none := acl.Rights{"read": false, "write": false, "delete": false, "operate": false, "owner": false}
+	tests := []struct {
+		name   string
+		acl    acl.Acl
+		u      *user.User
+		rights acl.Rights
+	}{
+		{"admin", job_acl, &user.User{Uuid: "admin", Admin: true}, all},
+		{"owner", job_acl, &user.User{Uuid: "owner"}, all},
+		{"group member", job_acl, &user.User{Uuid: "member"}, acl.Rights{"read": true, "write": false, "delete": false, "operate": true, "owner": false}},
+		// public entries only grant read
+		{"other user", job_acl, &user.User{Uuid: "other"}, read},
+		// the anonymous user has the rights of the public entries, conf.ANON_WRITE etc. are checked by the controllers
+		{"anonymous", job_acl, &user.User{Uuid: "public"}, acl.Rights{"read": true, "write": true, "delete": true, "operate": true, "owner": false}},
+		{"anonymous owner", public_job, &user.User{Uuid: "public"}, all},
+		// a job owned by public is not owned by every user
+		{"public owner", public_job, &user.User{Uuid: "other"}, none},
+		{"private job", acl.Acl{Owner: "owner"}, &user.User{Uuid: "other"}, none},
+		{"private job of group", acl.Acl{Owner: "owner", Read: []string{group.AclId()}}, &user.User{Uuid: "member"}, read},
+	}
+	for _, test := range tests {
+		rights := JobRights(test.acl, test.u)
+		for k, v := range test.rights {
+			if rights[k] != v {
+				t.Fatalf("%s: expected %v, got %v", test.name, test.rights, rights)
+			}
+		}
+	}
+}
+
+func TestJobAclFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awe-acl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := db.OpenEmbedded(path.Join(dir, "awe.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	db.SetStore(s)
+
+	group, err := user.NewGroup("lab", &user.User{Uuid: "member"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := db.C(conf.DB_COLL_JOBS)
+	jobs := []bson.M{
+		{"id": "owned", "acl": acl.Acl{Owner: "member"}},
+		{"id": "coowned", "acl": acl.Acl{Owner: "other", Owners: []string{"member"}}},
+		{"id": "shared", "acl": acl.Acl{Owner: "other", Read: []string{"member"}}},
+		{"id": "group", "acl": acl.Acl{Owner: "other", Read: []string{group.AclId()}}},
+		{"id": "public", "acl": acl.Acl{Owner: "other", Read: []string{"public"}}},
+		{"id": "legacy"},
+		{"id": "private", "acl": acl.Acl{Owner: "other", Write: []string{"member"}}},
+	}
+	for _, job := range jobs {
+		if err = c.Insert(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	found := []bson.M{}
+	if err = c.Find(bson.M{"$or": JobAclFilter(&user.User{Uuid: "member"})}).All(&found); err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, job := range found {
+		ids = append(ids, job["id"].(string))
+	}
+	sort.Strings(ids)
+	expected := []string{"coowned", "group", "legacy", "owned", "public", "shared"}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("expected jobs %v, got %v", expected, ids)
+	}
+}
@@ -23,6 +23,9 @@ type ClientMgr interface {
 	ResumeSuspendedClientsByUser(*user.User) int
 	SuspendAllClients(string) (int, error)
 	SuspendAllClientsByUser(*user.User, string) (int, error)
+	DrainClient(string) error
+	DrainClientByUser(string, *user.User) error
+	RemoveClient(string, bool) error
 	ClientChecker()
 	UpdateSubClients(string, int) error
 	UpdateSubClientsByUser(string, int, *user.User)
@@ -53,6 +56,9 @@ type JobMgr interface {
 	DeleteZombieJobsByUser(*user.User, bool) int
 	RecoverJob(string, *Job) (bool, error)
 	RecoverJobs() (int, int, error)
+	RestoreCheckouts([]*WorkCheckout) (int, error)
+	Recover() (int, int, int, error)
+	Shutdown() error
 	FinalizeWorkPerf(Workunit_Unique_Identifier, string) error
 	SaveStdLog(Workunit_Unique_Identifier, string, string) error
 	GetReportMsg(Workunit_Unique_Identifier, string) (string, error)
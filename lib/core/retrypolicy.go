@@ -0,0 +1,165 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	e "github.com/MG-RAST/AWE/lib/errors"
+)
+
+// RetryPolicy defines how failed workunits of a task are retried. A task without policy uses the server defaults.
+type RetryPolicy struct {
+	MaxAttempts int   `bson:"max_attempts" json:"max_attempts" mapstructure:"max_attempts"` // number of failures before the workunit is suspended, 0 means conf.MAX_WORK_FAILURE
+	Backoff     int   `bson:"backoff" json:"backoff" mapstructure:"backoff"`                // seconds to wait before the first requeue, doubled with every further failure
+	MaxBackoff  int   `bson:"max_backoff" json:"max_backoff" mapstructure:"max_backoff"`    // upper limit of the backoff in seconds, 0 means no limit
+	RetryOn     []int `bson:"retry_on" json:"retry_on" mapstructure:"retry_on"`             // if not empty, only these exit codes are retried
+	NoRetryOn   []int `bson:"no_retry_on" json:"no_retry_on" mapstructure:"no_retry_on"`    // exit codes that are never retried
+	SameClient  bool  `bson:"same_client" json:"same_client" mapstructure:"same_client"`    // retry on the client that failed, by default that client is avoided
+}
+
+// a single execution of a workunit, as reported by the worker
+type WorkAttempt struct {
+	Client      string    `bson:"client" json:"client" mapstructure:"client"`
+	State       string    `bson:"state" json:"state" mapstructure:"state"`
+	ExitStatus  int       `bson:"exitstatus" json:"exitstatus" mapstructure:"exitstatus"` // -1 if unknown
+	ComputeTime int       `bson:"computetime" json:"computetime" mapstructure:"computetime"`
+	Notes       string    `bson:"notes" json:"notes" mapstructure:"notes"`
+	Time        time.Time `bson:"time" json:"time" mapstructure:"time"`
+}
+
+func (rp *RetryPolicy) Validate() (err error) {
+	if rp.MaxAttempts < 0 {
+		err = fmt.Errorf("(RetryPolicy/Validate) max_attempts must not be negative")
+		return
+	}
+	if rp.Backoff < 0 || rp.MaxBackoff < 0 {
+		err = fmt.Errorf("(RetryPolicy/Validate) backoff must not be negative")
+		return
+	}
+	for _, code := range rp.RetryOn {
+		if containsInt(rp.NoRetryOn, code) {
+			err = fmt.Errorf("(RetryPolicy/Validate) exit code %d is in retry_on and no_retry_on", code)
+			return
+		}
+	}
+	return
+}
+
+// GetMaxAttempts returns the number of failures after which the workunit is suspended, rp may be nil
+func (rp *RetryPolicy) GetMaxAttempts(noretry bool) int {
+	if noretry {
+		return 1
+	}
+	if rp == nil || rp.MaxAttempts <= 0 {
+		return conf.MAX_WORK_FAILURE
+	}
+	return rp.MaxAttempts
+}
+
+// Retryable checks the exit code of the failed process. Exit codes <= 0 indicate that the
+// process itself did not fail (e.g. data transfer errors), those are always retryable.
+// A checksum mismatch in the notes of the workunit is a corrupted transfer and also always retryable.
+func (rp *RetryPolicy) Retryable(exit_status int, notes string) (ok bool, reason string) {
+	ok = true
+	if rp == nil || exit_status <= 0 || strings.Contains(notes, e.ChecksumMismatch) {
+		return
+	}
+	if containsInt(rp.NoRetryOn, exit_status) {
+		ok = false
+		reason = fmt.Sprintf("exit code %d is in no_retry_on of retry policy", exit_status)
+		return
+	}
+	if len(rp.RetryOn) > 0 && !containsInt(rp.RetryOn, exit_status) {
+		ok = false
+		reason = fmt.Sprintf("exit code %d is not in retry_on of retry policy", exit_status)
+		return
+	}
+	return
+}
+
+// RetriesExitCode returns true if the policy explicitly retries the exit code, this overrides exit code 42 (failed-permanent)
+func (rp *RetryPolicy) RetriesExitCode(exit_status int) bool {
+	if rp == nil {
+		return false
+	}
+	return containsInt(rp.RetryOn, exit_status)
+}
+
+// BackoffDelay returns the time to wait before the workunit is requeued after the given number of failures
+func (rp *RetryPolicy) BackoffDelay(failed int) (delay time.Duration) {
+	if rp == nil || rp.Backoff <= 0 || failed < 1 {
+		return
+	}
+	seconds := rp.Backoff
+	for i := 1; i < failed; i++ {
+		seconds *= 2
+		if rp.MaxBackoff > 0 && seconds >= rp.MaxBackoff {
+			break
+		}
+	}
+	if rp.MaxBackoff > 0 && seconds > rp.MaxBackoff {
+		seconds = rp.MaxBackoff
+	}
+	delay = time.Duration(seconds) * time.Second
+	return
+}
+
+// AvoidClient returns true if a failed workunit should not be checked out again by the same client
+func (rp *RetryPolicy) AvoidClient() bool {
+	if rp == nil {
+		return true
+	}
+	return !rp.SameClient
+}
+
+// WorkFailed counts a failure of the workunit and decides with the retry policy of the task if it is requeued,
+// a requeued workunit gets the backoff of the policy. The count is saved with the task and restored when the
+// job is recovered, an error saving it does not change the decision.
+// exit_status is -1 if the failure is not caused by the process, e.g. an exceeded walltime.
+func (task *Task) WorkFailed(work *Workunit, exit_status int, notes string) (retry bool, reason string, err error) {
+	err = task.LockNamed("WorkFailed")
+	if err != nil {
+		return
+	}
+	defer task.Unlock()
+
+	work.Failed += 1
+
+	noretry := false
+	if task.Info != nil {
+		noretry = task.Info.NoRetry
+	}
+	retryable, reason := task.RetryPolicy.Retryable(exit_status, notes)
+	if retryable {
+		if work.Failed < task.RetryPolicy.GetMaxAttempts(noretry) {
+			retry = true
+			if delay := task.RetryPolicy.BackoffDelay(work.Failed); delay > 0 {
+				work.NotBefore = time.Now().Add(delay)
+			}
+		} else {
+			reason = fmt.Sprintf("workunit failed %d time(s)", work.Failed)
+		}
+	}
+
+	if task.WorkFailures == nil {
+		task.WorkFailures = make(map[string]int)
+	}
+	task.WorkFailures[strconv.Itoa(work.Rank)] = work.Failed
+	err = dbUpdateJobTaskField(task.JobId, task.Id, "work_failures", task.WorkFailures)
+	if err != nil {
+		err = fmt.Errorf("(WorkFailed) dbUpdateJobTaskField returned: %s", err.Error())
+	}
+	return
+}
+
+func containsInt(list []int, value int) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
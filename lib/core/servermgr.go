@@ -49,9 +49,10 @@ func NewServerMgr() *ServerMgr {
 			workQueue:    NewWorkQueue(),
 			suspendQueue: false,
 
-			coReq:    make(chan CoReq, conf.COREQ_LENGTH), // number of clients that wait in queue to get a workunit. If queue is full, other client will be rejected and have to come back later again
-			feedback: make(chan Notice),
-			coSem:    make(chan int, 1), //non-blocking buffered channel
+			coReq:        make(chan CoReq, conf.COREQ_LENGTH), // number of clients that wait in queue to get a workunit. If queue is full, other client will be rejected and have to come back later again
+			feedback:     make(chan Notice),
+			coSem:        make(chan int, 1), //non-blocking buffered channel
+			predataIndex: NewPredataIndex(),
 
 		},
 		lastUpdate: time.Now().Add(time.Second * -30),
@@ -654,6 +655,13 @@ func (qm *ServerMgr) handleWorkStatDone(client *Client, clientid string, task *T
 	qm.FinalizeTaskPerf(task)
 	logger.Event(event.TASK_DONE, "task_id="+task_str)
 
+	// make result available for identical tasks
+	err = saveWorkReuse(task)
+	if err != nil {
+		logger.Error("(handleWorkStatDone) saveWorkReuse returned: %s", err.Error())
+		err = nil
+	}
+
 	//update the info of the job which the task is belong to, could result in deletion of the
 	//task in the task map when the task is the final task of the job to be done.
 	err = qm.updateJobTask(task) //task state QUEUED -> COMPLETED
@@ -719,17 +727,6 @@ func (qm *ServerMgr) handleNoticeWorkDelivered(notice Notice) (err error) {
 		return
 	}
 
-	if notice.Results != nil { // TODO one workunit vs multiple !!!!!!!!!!!!!!!!!!!!!!!!!!!!!
-		err = task.SetStepOutput(notice.Results, true)
-		if err != nil {
-			err = fmt.Errorf("(handleNoticeWorkDelivered) task.SetStepOutput returned: %s", err.Error())
-			return
-		}
-	} else {
-		err = fmt.Errorf("(handleNoticeWorkDelivered) notice.Results is empty !?")
-		return
-	}
-
 	// *** Get workunit
 	var work *Workunit
 	var wok bool
@@ -745,6 +742,43 @@ func (qm *ServerMgr) handleNoticeWorkDelivered(notice Notice) (err error) {
 		err = fmt.Errorf("(handleNoticeWorkDelivered) workunit %s did not have state WORK_STAT_CHECKOUT or WORK_STAT_RESERVED (state is %s)", work_str, work.State)
 		return
 	}
+	if work.Client != clientid {
+		// e.g. the walltime was exceeded and the workunit has been requeued, the result of the old client is not used
+		err = fmt.Errorf("(handleNoticeWorkDelivered) workunit %s is checked out by client %s, not by %s", work_str, work.Client, clientid)
+		return
+	}
+
+	if notice.Results != nil { // TODO one workunit vs multiple !!!!!!!!!!!!!!!!!!!!!!!!!!!!!
+		err = task.SetStepOutput(notice.Results, true)
+		if err != nil {
+			err = fmt.Errorf("(handleNoticeWorkDelivered) task.SetStepOutput returned: %s", err.Error())
+			return
+		}
+	} else {
+		err = fmt.Errorf("(handleNoticeWorkDelivered) notice.Results is empty !?")
+		return
+	}
+
+	work.Attempts = append(work.Attempts, &WorkAttempt{
+		Client:      clientid,
+		State:       status,
+		ExitStatus:  notice.ExitStatus,
+		ComputeTime: computetime,
+		Notes:       notes,
+		Time:        time.Now(),
+	})
+
+	err = task.LockNamed("handleNoticeWorkDelivered/retry_policy")
+	if err != nil {
+		return
+	}
+	retry_policy := task.RetryPolicy
+	task.Unlock()
+
+	if status == WORK_STAT_FAILED_PERMANENT && retry_policy.RetriesExitCode(notice.ExitStatus) {
+		// retry policy overrides exit code 42
+		status = WORK_STAT_ERROR
+	}
 
 	reason := ""
 
@@ -758,20 +792,6 @@ func (qm *ServerMgr) handleNoticeWorkDelivered(notice Notice) (err error) {
 		return
 	}
 
-	err = task.LockNamed("handleNoticeWorkDelivered/noretry")
-	if err != nil {
-		return
-	}
-	noretry := task.Info.NoRetry
-	task.Unlock()
-
-	var MAX_FAILURE int
-	if noretry == true {
-		MAX_FAILURE = 1
-	} else {
-		MAX_FAILURE = conf.MAX_WORK_FAILURE
-	}
-
 	var task_state string
 	task_state, err = task.GetState()
 	if err != nil {
@@ -797,8 +817,11 @@ func (qm *ServerMgr) handleNoticeWorkDelivered(notice Notice) (err error) {
 	} else if status == WORK_STAT_FAILED_PERMANENT { // (special case !) failed and cannot be recovered
 
 		logger.Event(event.WORK_FAILED, "workid="+work_str+";clientid="+clientid)
+		RecordJobEvent(job_id, event.WORK_FAILED, "", clientid, map[string]string{"workid": work_str})
 		logger.Debug(3, "(handleNoticeWorkDelivered) work failed (status=%s) workid=%s clientid=%s", status, work_str, clientid)
-		work.Failed += 1
+		if _, _, xerr := task.WorkFailed(work, notice.ExitStatus, notes); xerr != nil {
+			logger.Error("(handleNoticeWorkDelivered) %s", xerr.Error())
+		}
 
 		qm.workQueue.StatusChange(Workunit_Unique_Identifier{}, work, WORK_STAT_FAILED_PERMANENT, "")
 
@@ -827,16 +850,26 @@ func (qm *ServerMgr) handleNoticeWorkDelivered(notice Notice) (err error) {
 		}
 	} else if status == WORK_STAT_ERROR { //workunit failed, requeue or put it to suspend list
 		logger.Event(event.WORK_FAIL, "workid="+work_str+";clientid="+clientid)
+		RecordJobEvent(job_id, event.WORK_FAIL, "", clientid, map[string]string{"workid": work_str})
 		logger.Debug(3, "(handleNoticeWorkDelivered) work failed (status=%s, notes: %s) workid=%s clientid=%s", status, notes, work_str, clientid)
 
-		work.Failed += 1
+		retry, no_retry_reason, xerr := task.WorkFailed(work, notice.ExitStatus, notes)
+		if xerr != nil {
+			logger.Error("(handleNoticeWorkDelivered) %s", xerr.Error())
+		}
 
-		if work.Failed < MAX_FAILURE {
+		if retry {
+			if work.NotBefore.After(time.Now()) {
+				logger.Debug(3, "(handleNoticeWorkDelivered) workunit %s is requeued with backoff until %s", work_str, work.NotBefore)
+			}
 			qm.workQueue.StatusChange(Workunit_Unique_Identifier{}, work, WORK_STAT_QUEUED, "")
 			logger.Event(event.WORK_REQUEUE, "workid="+work_str)
 		} else {
+			server_notes := no_retry_reason
+			suspend_reason := no_retry_reason
+
 			//failure time exceeds limit, suspend workunit, task, job
-			qm.workQueue.StatusChange(Workunit_Unique_Identifier{}, work, WORK_STAT_SUSPEND, "work.Failed >= MAX_FAILURE")
+			qm.workQueue.StatusChange(Workunit_Unique_Identifier{}, work, WORK_STAT_SUSPEND, suspend_reason)
 			logger.Event(event.WORK_SUSPEND, "workid="+work_str)
 
 			if err = task.SetState(TASK_STAT_SUSPEND, true); err != nil {
@@ -853,7 +886,7 @@ func (qm *ServerMgr) handleNoticeWorkDelivered(notice Notice) (err error) {
 				ClientFailed: clientid,
 				WorkFailed:   work_str,
 				TaskFailed:   task_str,
-				ServerNotes:  fmt.Sprintf("workunit failed %d time(s)", MAX_FAILURE),
+				ServerNotes:  server_notes,
 				WorkNotes:    notes,
 				AppError:     notice.Stderr,
 				Status:       JOB_STAT_SUSPEND,
@@ -875,9 +908,11 @@ func (qm *ServerMgr) handleNoticeWorkDelivered(notice Notice) (err error) {
 			return
 		}
 
-		err = client.Append_Skip_work(work_id, true)
-		if err != nil {
-			return
+		if retry_policy.AvoidClient() {
+			err = client.Append_Skip_work(work_id, true)
+			if err != nil {
+				return
+			}
 		}
 		err = client.Increment_total_failed(true)
 		if err != nil {
@@ -1869,6 +1904,38 @@ func (qm *ServerMgr) taskEnQueue(task *Task, job *Job) (err error) {
 		return
 	}
 
+	if !skip_workunit {
+		// check if an identical task has been computed before
+		var reuse_key string
+		reuse_key, err = qm.getReuseKey(task, job)
+		if err != nil {
+			// the task is computed again
+			logger.Error("(taskEnQueue) getReuseKey returned, task %s will not be reused: %s", task.Id, err.Error())
+			reuse_key = ""
+			err = nil
+		}
+		if reuse_key != "" {
+			err = task.SetReuseKey(reuse_key, true)
+			if err != nil {
+				err = fmt.Errorf("(taskEnQueue) SetReuseKey: %s", err.Error())
+				return
+			}
+			var reused bool
+			reused, err = qm.reuseTask(task, reuse_key)
+			if err != nil {
+				err = fmt.Errorf("(taskEnQueue) reuseTask: %s", err.Error())
+				return
+			}
+			if reused {
+				err = qm.completeReusedTask(task)
+				if err != nil {
+					err = fmt.Errorf("(taskEnQueue) completeReusedTask: %s", err.Error())
+				}
+				return
+			}
+		}
+	}
+
 	err = qm.createOutputNode(task)
 	if err != nil {
 		err = fmt.Errorf("(taskEnQueue) createOutputNode: %s", err.Error())
@@ -2447,8 +2514,24 @@ func (qm *ServerMgr) createOutputNode(task *Task) (err error) {
 	}
 	defer task.Unlock()
 
+	clientgroups := ""
+	if task.Info != nil {
+		clientgroups = task.Info.ClientGroups
+	}
+	storage_url, err := StorageForClientGroups(clientgroups)
+	if err != nil {
+		err = fmt.Errorf("(createOutputNode) StorageForClientGroups returned: %s", err.Error())
+		return
+	}
+
 	var modified bool
 	for _, io := range task.Outputs {
+		if io.Type != "update" && storage_url != "" && io.Url == "" && io.StorageScheme() == STORAGE_SHOCK {
+			// tasks restricted to a clientgroup with its own storage do not write to Shock
+			io.Host = storage_url
+			io.Node = ""
+			modified = true
+		}
 		if io.Type == "update" {
 			// this an update output, it will update an existing shock node and not create a new one (it will update metadata of the shock node)
 			if (io.Node == "") || (io.Node == "-") {
@@ -2514,6 +2597,13 @@ func (qm *ServerMgr) createOutputNode(task *Task) (err error) {
 				}
 				logger.Debug(2, "(createOutputNode) outout %s in task %s is an update of node %s", io.FileName, task.Id, io.Node)
 			}
+		} else if io.StorageScheme() != STORAGE_SHOCK {
+			// the worker writes the output to this location, no node needs to be created
+			if io.Url == "" {
+				io.Url = OutputLocation(io.Host, task.JobId, io.FileName)
+				modified = true
+			}
+			logger.Debug(2, "(createOutputNode) task %s: output %s stored in %s", task.Id, io.FileName, io.Url)
 		} else {
 			// POST empty shock node for this output
 			logger.Debug(2, "(createOutputNode) posting output Shock node for file %s in task %s", io.FileName, task.Id)
@@ -3179,13 +3269,7 @@ func (qm *ServerMgr) updateJobTask(task *Task) (err error) {
 	qm.LogJobPerf(jobid)
 	qm.removeActJob(jobid)
 	//delete tasks in task map
-	//delete from shock output flagged for deletion
-
-	modified := 0
 	for i, task := range job.TaskList() {
-		// delete nodes that have been flagged to be deleted
-		modified += task.DeleteOutput()
-		modified += task.DeleteInput()
 		//combined_id := jobid + "_" + task.Id
 
 		id, _ := task.GetId("updateJobTask." + strconv.Itoa(i))
@@ -3193,10 +3277,8 @@ func (qm *ServerMgr) updateJobTask(task *Task) (err error) {
 		qm.TaskMap.Delete(id)
 	}
 
-	if modified > 0 {
-		// save only is something has changed
-		job.Save() // TODO avoid this, try partial updates
-	}
+	//delete from shock nodes flagged as delete, temporary or intermediate
+	job.CleanupTemporaryNodes()
 
 	//set expiration from conf if not set
 	nullTime := time.Time{}
@@ -3227,6 +3309,7 @@ func (qm *ServerMgr) updateJobTask(task *Task) (err error) {
 	}
 	//log event about job done (JD)
 	logger.Event(event.JOB_DONE, "jobid="+job.Id+";name="+job.Info.Name+";project="+job.Info.Project+";user="+job.Info.User)
+	RecordJobEvent(job.Id, event.JOB_DONE, "", "", nil)
 
 	return
 }
@@ -3374,6 +3457,7 @@ func (qm *ServerMgr) SuspendJob(jobid string, jerror *JobError) (err error) {
 		reason = jerror.WorkNotes
 	}
 	logger.Event(this_event, "jobid="+jobid+";reason="+reason)
+	RecordJobEvent(jobid, this_event, jerror.Actor, jerror.ClientFailed, map[string]string{"reason": reason})
 	return
 }
 
@@ -3384,13 +3468,14 @@ func (qm *ServerMgr) DeleteJobByUser(jobid string, u *user.User, full bool) (err
 		return
 	}
 	// User must have delete permissions on job or be job owner or be an admin
-	rights := job.Acl.Check(u.Uuid)
-	if job.Acl.Owner != u.Uuid && rights["delete"] == false && u.Admin == false {
+	rights := JobRights(job.Acl, u)
+	if rights["delete"] == false {
 		return errors.New(e.UnAuth)
 	}
 	if err = job.SetState(JOB_STAT_DELETED, nil); err != nil {
 		return
 	}
+	job.CleanupTemporaryNodes()
 	//delete queueing workunits
 	var workunit_list []*Workunit
 	workunit_list, err = qm.workQueue.GetAll()
@@ -3426,6 +3511,7 @@ func (qm *ServerMgr) DeleteJobByUser(jobid string, u *user.User, full bool) (err
 		return job.Delete()
 	} else {
 		logger.Event(event.JOB_DELETED, "jobid="+jobid)
+		RecordJobEvent(jobid, event.JOB_DELETED, Actor(u), "", nil)
 	}
 	return
 }
@@ -3482,9 +3568,9 @@ func (qm *ServerMgr) ResumeSuspendedJobByUser(id string, u *user.User) (err erro
 		return
 	}
 
-	// User must have write permissions on job or be job owner or be an admin
-	rights := dbjob.Acl.Check(u.Uuid)
-	if dbjob.Acl.Owner != u.Uuid && rights["write"] == false && u.Admin == false {
+	// User must have operate permissions on job or be job owner or be an admin
+	rights := JobRights(dbjob.Acl, u)
+	if rights["operate"] == false {
 		err = errors.New(e.UnAuth)
 		return
 	}
@@ -3534,6 +3620,7 @@ func (qm *ServerMgr) ResumeSuspendedJobByUser(id string, u *user.User) (err erro
 		return
 	}
 	logger.Debug(1, "Resumed job %s", id)
+	LogJobEvent(id, event.JOB_RESUME, Actor(u), "", nil)
 	return
 }
 
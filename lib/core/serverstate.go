@@ -0,0 +1,240 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/db"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const SERVER_STATE_ID = "server"
+
+// the start of the server is recorded next to the state
+const SERVER_START_ID = "start"
+
+// time the workers of a restarted server have to register again before their restored workunits are requeued
+const CHECKOUT_RESTORE_WAIT = 5 * time.Minute
+
+// ServerState is saved when the server shuts down. A server started with recover keeps the uuid,
+// so the workers do not discard their work, and restores the checked out workunits.
+type ServerState struct {
+	Id         string          `bson:"_id" json:"id"`
+	ServerUuid string          `bson:"server_uuid" json:"server_uuid"`
+	Shutdown   time.Time       `bson:"shutdown" json:"shutdown"`
+	Checkouts  []*WorkCheckout `bson:"checkouts" json:"checkouts"`
+}
+
+type serverStart struct {
+	Id   string    `bson:"_id" json:"id"`
+	Time time.Time `bson:"time" json:"time"`
+}
+
+type WorkCheckout struct {
+	WorkId       string    `bson:"work_id" json:"work_id"`
+	Client       string    `bson:"client" json:"client"`
+	CheckoutTime time.Time `bson:"checkout_time" json:"checkout_time"`
+}
+
+// recovery is active while a restarted server recovers its jobs and restores the checked out workunits
+var recovery struct {
+	sync.RWMutex
+	active bool
+}
+
+func setRecovering(active bool) {
+	recovery.Lock()
+	recovery.active = active
+	recovery.Unlock()
+}
+
+// isAdopting is true while the workunits that the workers are still running are taken over,
+// during the recovery of a restarted server and after a new leader was elected.
+// The workers are not told to discard them in the meantime.
+func isAdopting() bool {
+	recovery.RLock()
+	active := recovery.active
+	recovery.RUnlock()
+	return active || (Leadership != nil && Leadership.isAdopting())
+}
+
+func dbSaveServerState(state *ServerState) (err error) {
+	c := db.C(conf.DB_COLL_SERVER_STATE)
+	_, err = c.Upsert(bson.M{"_id": state.Id}, state)
+	return
+}
+
+// InitServerState removes a saved state that must not be used: the server does not recover, or the
+// state is older than the previous start, i.e. the server that recovered with it did not shut down cleanly.
+func InitServerState(recover bool) (err error) {
+	c := db.C(conf.DB_COLL_SERVER_STATE)
+	previous := serverStart{}
+	if err = c.Find(bson.M{"_id": SERVER_START_ID}).One(&previous); err != nil && err != db.ErrNotFound {
+		err = fmt.Errorf("(InitServerState) loading previous start returned: %s", err.Error())
+		return
+	}
+	state, err := LoadServerState()
+	if err != nil {
+		return
+	}
+	if state != nil && (!recover || state.Shutdown.Before(previous.Time)) {
+		logger.Info("(InitServerState) discarding server state saved at %s", state.Shutdown)
+		if err = dbDelete(bson.M{"_id": SERVER_STATE_ID}, conf.DB_COLL_SERVER_STATE); err != nil {
+			err = fmt.Errorf("(InitServerState) removing server state returned: %s", err.Error())
+		}
+	}
+	return
+}
+
+// RecordServerStart is called once the jobs are recovered, a state saved before is outdated from now on
+func RecordServerStart() (err error) {
+	c := db.C(conf.DB_COLL_SERVER_STATE)
+	_, err = c.Upsert(bson.M{"_id": SERVER_START_ID}, serverStart{Id: SERVER_START_ID, Time: time.Now()})
+	return
+}
+
+// LoadServerState returns the state saved by the last shutdown, or nil if there is none
+func LoadServerState() (state *ServerState, err error) {
+	c := db.C(conf.DB_COLL_SERVER_STATE)
+	state = &ServerState{}
+	err = c.Find(bson.M{"_id": SERVER_STATE_ID}).One(state)
+	if err == db.ErrNotFound {
+		state = nil
+		err = nil
+	}
+	return
+}
+
+// Shutdown stops handing out workunits and saves which client runs which workunit
+func (qm *ServerMgr) Shutdown() (err error) {
+	qm.SuspendQueue()
+
+	workunits, err := qm.workQueue.all.GetWorkunits()
+	if err != nil {
+		err = fmt.Errorf("(Shutdown) GetWorkunits returned: %s", err.Error())
+		return
+	}
+
+	state := &ServerState{Id: SERVER_STATE_ID, ServerUuid: Server_UUID, Shutdown: time.Now(), Checkouts: []*WorkCheckout{}}
+	for _, work := range workunits {
+		if work.Client == "" || (work.State != WORK_STAT_CHECKOUT && work.State != WORK_STAT_RESERVED) {
+			continue
+		}
+		work_str, xerr := work.Workunit_Unique_Identifier.String()
+		if xerr != nil {
+			continue
+		}
+		state.Checkouts = append(state.Checkouts, &WorkCheckout{WorkId: work_str, Client: work.Client, CheckoutTime: work.CheckoutTime})
+	}
+
+	err = dbSaveServerState(state)
+	if err != nil {
+		err = fmt.Errorf("(Shutdown) dbSaveServerState returned: %s", err.Error())
+		return
+	}
+	logger.Info("(Shutdown) saved %d checked out workunits", len(state.Checkouts))
+	return
+}
+
+// Recover recovers the unfinished jobs and restores the workunits that were checked out at the last shutdown.
+// Until then the queue is suspended and heartbeating workers keep their work, their workunits are adopted
+// as during a TakeOver. A queue that was suspended before stays suspended.
+func (qm *ServerMgr) Recover() (recovered int, total int, restored int, err error) {
+	setRecovering(true)
+	suspended := qm.QueueStatus() == "suspended"
+	if !suspended {
+		qm.SuspendQueue()
+	}
+	defer func() {
+		setRecovering(false)
+		if !suspended {
+			qm.ResumeQueue()
+		}
+	}()
+
+	// the checkouts of the jobs that were recovered are restored even if some jobs failed
+	recovered, total, err = qm.RecoverJobs()
+	if err != nil {
+		err = fmt.Errorf("(Recover) RecoverJobs returned: %s", err.Error())
+	}
+
+	state, xerr := LoadServerState()
+	if xerr != nil {
+		if err == nil {
+			err = fmt.Errorf("(Recover) LoadServerState returned: %s", xerr.Error())
+		}
+		return
+	}
+	if state != nil {
+		restored, xerr = qm.RestoreCheckouts(state.Checkouts)
+		if xerr != nil && err == nil {
+			err = fmt.Errorf("(Recover) RestoreCheckouts returned: %s", xerr.Error())
+		}
+	}
+	return
+}
+
+// RestoreCheckouts checks out the recovered workunits again to the clients that were running them.
+// The clients register again with the next heartbeat and deliver their results as usual,
+// workunits of clients that do not come back are requeued after CHECKOUT_RESTORE_WAIT.
+func (qm *ServerMgr) RestoreCheckouts(checkouts []*WorkCheckout) (restored int, err error) {
+	works := []*Workunit{}
+	for _, checkout := range checkouts {
+		work_id, xerr := New_Workunit_Unique_Identifier_FromString(checkout.WorkId)
+		if xerr != nil {
+			logger.Error("(RestoreCheckouts) invalid workunit id %s: %s", checkout.WorkId, xerr.Error())
+			continue
+		}
+		work, ok, xerr := qm.workQueue.all.Get(work_id)
+		if xerr != nil || !ok {
+			continue
+		}
+		if work.State != WORK_STAT_QUEUED {
+			continue
+		}
+		// the client is reset when leaving the queue, set it afterwards
+		qm.workQueue.StatusChange(work_id, work, WORK_STAT_CHECKOUT, "")
+		work.Client = checkout.Client
+		work.CheckoutTime = checkout.CheckoutTime
+		works = append(works, work)
+	}
+	restored = len(works)
+
+	if restored > 0 {
+		err = qm.UpdateJobTaskToInProgress(works)
+		go qm.requeueRestoredCheckouts(works)
+	}
+
+	// the state is used only once, after a crash the server starts with a new uuid and the workers discard their work
+	if xerr := dbDelete(bson.M{"_id": SERVER_STATE_ID}, conf.DB_COLL_SERVER_STATE); xerr != nil && err == nil {
+		err = fmt.Errorf("(RestoreCheckouts) removing server state returned: %s", xerr.Error())
+	}
+	return
+}
+
+// requeueRestoredCheckouts requeues the restored workunits whose client did not come back,
+// or came back without the workunit in its current work
+func (qm *ServerMgr) requeueRestoredCheckouts(works []*Workunit) {
+	time.Sleep(CHECKOUT_RESTORE_WAIT)
+	for _, work := range works {
+		if work.State != WORK_STAT_CHECKOUT {
+			continue
+		}
+		client, has_client, err := qm.GetClient(work.Client, true)
+		if err != nil {
+			continue
+		}
+		if has_client {
+			has_work, xerr := client.Current_work.Has(work.Workunit_Unique_Identifier)
+			if xerr != nil || has_work {
+				continue
+			}
+		}
+		work_str, _ := work.Workunit_Unique_Identifier.String()
+		logger.Info("(requeueRestoredCheckouts) client %s is not running workunit %s anymore, requeue it", work.Client, work_str)
+		qm.workQueue.StatusChange(work.Workunit_Unique_Identifier, work, WORK_STAT_QUEUED, "")
+	}
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/db"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+func TestInitServerState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awe-serverstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := db.OpenEmbedded(path.Join(dir, "awe.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	db.SetStore(s)
+
+	save := func(shutdown time.Time) {
+		if err := dbSaveServerState(&ServerState{Id: SERVER_STATE_ID, ServerUuid: "uuid", Shutdown: shutdown}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(recover bool, kept bool) {
+		if err := InitServerState(recover); err != nil {
+			t.Fatal(err)
+		}
+		state, err := LoadServerState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (state != nil) != kept {
+			t.Fatalf("recover=%t: expected state kept=%t", recover, kept)
+		}
+	}
+
+	// a clean shutdown after the last start is used when recovering
+	if err = RecordServerStart(); err != nil {
+		t.Fatal(err)
+	}
+	save(time.Now().Add(time.Second))
+	check(true, true)
+
+	// a server started without recover discards it
+	check(false, false)
+
+	// a state saved before the last start is outdated, that server did not shut down cleanly
+	save(time.Now().Add(-time.Minute))
+	check(true, false)
+}
+
+func TestRecoverHeartBeat(t *testing.T) {
+	logger.Initialize("test")
+	dir, err := ioutil.TempDir("", "awe-serverstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := db.OpenEmbedded(path.Join(dir, "awe.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	db.SetStore(s)
+	JM = NewJobMap()
+
+	job := NewJob()
+	job.setId()
+	job.Info = NewInfo()
+	task, err := NewTask(job, "", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.Cmd = NewCommand("ls")
+	job.Tasks = []*Task{task}
+	if err = dbUpsert(job); err != nil {
+		t.Fatal(err)
+	}
+
+	// a recovered workunit is queued without client, the worker that runs it heartbeats
+	qm := NewServerMgr()
+	work, err := NewWorkunit(qm, task, 0, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = qm.workQueue.Add(work); err != nil {
+		t.Fatal(err)
+	}
+	heartbeat := func(client_id string) HeartbeatInstructions {
+		if _, ok, _ := qm.GetClient(client_id, true); !ok {
+			client := NewClient()
+			client.Id = client_id
+			if err := qm.AddClient(client, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ws := NewWorkerState()
+		ws.Current_work.Init("Current_work")
+		ws.Current_work.Add(work.Workunit_Unique_Identifier)
+		hbmsg, err := qm.ClientHeartBeat(client_id, nil, *ws)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hbmsg
+	}
+
+	// during the recovery the worker keeps the workunit and it is checked out to the worker again
+	setRecovering(true)
+	if hbmsg := heartbeat("worker"); hbmsg["discard"] != "" {
+		t.Fatalf("worker told to discard %s during recovery", hbmsg["discard"])
+	}
+	setRecovering(false)
+	if work.State != WORK_STAT_CHECKOUT || work.Client != "worker" {
+		t.Fatalf("workunit not adopted: state=%s client=%s", work.State, work.Client)
+	}
+	if hbmsg := heartbeat("worker"); hbmsg["discard"] != "" {
+		t.Fatalf("worker told to discard %s after recovery", hbmsg["discard"])
+	}
+
+	// another worker with the same workunit discards it
+	if hbmsg := heartbeat("other"); hbmsg["discard"] != work.Id {
+		t.Fatalf("other worker not told to discard the workunit: %v", hbmsg)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	logger.Initialize("test")
+	dir, err := ioutil.TempDir("", "awe-serverstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := db.OpenEmbedded(path.Join(dir, "awe.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	db.SetStore(s)
+	JM = NewJobMap()
+
+	state := &ServerState{Id: SERVER_STATE_ID, ServerUuid: "uuid", Shutdown: time.Now()}
+	state.Checkouts = []*WorkCheckout{{WorkId: "00000000-0000-0000-0000-000000000000_0_0", Client: "worker"}}
+	if err = dbSaveServerState(state); err != nil {
+		t.Fatal(err)
+	}
+
+	// the queue is resumed once the checkouts are restored, the state is used only once
+	qm := NewServerMgr()
+	if _, _, _, err = qm.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if qm.QueueStatus() != "running" || isAdopting() {
+		t.Fatalf("recovery did not finish")
+	}
+	if saved, err := LoadServerState(); err != nil || saved != nil {
+		t.Fatalf("server state kept after recovery: %v", err)
+	}
+
+	// a queue that was suspended before stays suspended
+	qm = NewServerMgr()
+	qm.SuspendQueue()
+	if _, _, _, err = qm.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if qm.QueueStatus() != "suspended" {
+		t.Fatalf("suspended queue was resumed by recovery")
+	}
+}
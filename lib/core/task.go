@@ -69,6 +69,10 @@ type TaskRaw struct {
 	Children            []Task_Unique_Identifier `bson:"children" json:"children"`             // CWL-only, list of all children in a subworkflow task
 	Children_ptr        []*Task                  `bson:"-" json:"-"`                           // CWL-only
 	Finalizing          bool                     `bson:"-" json:"-"`                           // CWL-only, a lock mechanism for subworkflows and scatter tasks
+	ReuseKey            string                   `bson:"reusekey" json:"reusekey"`             // key for work reuse, empty if task is not reusable
+	ReusedNodes         []string                 `bson:"reused_nodes" json:"-"`                // CWL-only, shock nodes (host/node) of a step output reused from another job
+	RetryPolicy         *RetryPolicy             `bson:"retry_policy" json:"retry_policy"`     // optional, overrides server defaults for failed workunits
+	WorkFailures        map[string]int           `bson:"work_failures" json:"work_failures"`   // failures per workunit rank, kept when the job is recovered
 }
 
 type Task struct {
@@ -194,6 +198,14 @@ func (task *TaskRaw) InitRaw(job *Job) (changed bool, err error) {
 	}
 	task.Info = job.Info
 
+	if task.RetryPolicy != nil {
+		err = task.RetryPolicy.Validate()
+		if err != nil {
+			err = fmt.Errorf("(InitRaw) task %s: %s", task_str, err.Error())
+			return
+		}
+	}
+
 	if task.TotalWork <= 0 {
 		task.TotalWork = 1
 	}
@@ -711,36 +723,41 @@ func (task *TaskRaw) SetState(new_state string, write_lock bool) (err error) {
 		return
 	}
 
-	err = dbUpdateJobTaskString(jobid, taskid, "state", new_state)
-	if err != nil {
-		return
+	// the number of remaining tasks of the job changes when a task completes, or when a
+	// completed task is marked as something different
+	remain_inc := 0
+	completed_date := time.Time{}
+	if new_state == TASK_STAT_COMPLETED {
+		remain_inc = -1
+		completed_date = time.Now()
+	} else if old_state == TASK_STAT_COMPLETED {
+		remain_inc = 1
 	}
 
-	logger.Debug(3, "(Task/SetState) %s new state: \"%s\" (old state \"%s\")", taskid, new_state, old_state)
-	task.State = new_state
-
-	if new_state == TASK_STAT_COMPLETED {
-		err = job.IncrementRemainTasks(-1)
+	if remain_inc == 0 {
+		err = dbUpdateJobTaskString(jobid, taskid, "state", new_state)
 		if err != nil {
 			return
 		}
-		err = task.SetCompletedDate(time.Now(), false)
-		if err != nil {
-			return
-		}
-	} else if old_state == TASK_STAT_COMPLETED {
-		// in case a completed task is marked as something different
-		err = job.IncrementRemainTasks(1)
+	} else {
+		// the job stays locked until the transaction is committed, so the count cannot change in between
+		err = job.LockNamed("Task/SetState")
 		if err != nil {
 			return
 		}
-		initTime := time.Time{}
-		err = task.SetCompletedDate(initTime, false)
+		defer job.Unlock()
+		remain_tasks := job.RemainTasks + remain_inc
+		err = dbUpdateJobTaskState(jobid, taskid, new_state, completed_date, remain_tasks)
 		if err != nil {
 			return
 		}
+		job.RemainTasks = remain_tasks
+		task.CompletedDate = completed_date
 	}
 
+	logger.Debug(3, "(Task/SetState) %s new state: \"%s\" (old state \"%s\")", taskid, new_state, old_state)
+	task.State = new_state
+
 	return
 }
 
@@ -783,7 +800,8 @@ func (task *Task) CreateOutputIndexes() (err error) {
 
 // check that part index is valid before initalizing it
 // refactored out of InitPartIndex deal with potentailly long write lock
-func (task *Task) checkPartIndex() (newPartition *PartInfo, totalunits int, isSingle bool, err error) {
+// without create_index a missing index is an error, nothing is created in shock
+func (task *Task) checkPartIndex(create_index bool) (newPartition *PartInfo, totalunits int, isSingle bool, err error) {
 	lock, err := task.RLockNamed("checkPartIndex")
 	if err != nil {
 		return
@@ -823,6 +841,16 @@ func (task *Task) checkPartIndex() (newPartition *PartInfo, totalunits int, isSi
 		newPartition.Index = conf.DEFAULT_INDEX
 	}
 
+	if !create_index {
+		var idxInfo shock.IdxInfo
+		idxInfo, err = inputIO.LookupIndex(newPartition.Index)
+		if err != nil {
+			return
+		}
+		totalunits = int(idxInfo.TotalUnits)
+		return
+	}
+
 	idxInfo, err := inputIO.IndexFile(newPartition.Index)
 	if err != nil {
 		// bad state - set as not multi-workunit
@@ -846,12 +874,13 @@ func (task *Task) InitPartIndex() (err error) {
 		return
 	}
 
-	newPartition, totalunits, isSingle, err := task.checkPartIndex()
+	totalwork, newPartition, err := task.EstimatePartIndex(true)
 	if err != nil {
 		return
 	}
-	if isSingle {
-		// its a single workunit, skip init
+
+	// need only 1 workunit
+	if totalwork == 1 {
 		err = task.setSingleWorkunit(true)
 		return
 	}
@@ -862,43 +891,72 @@ func (task *Task) InitPartIndex() (err error) {
 	}
 	defer task.Unlock()
 
+	if totalwork != task.TotalWork {
+		err = task.setTotalWork(totalwork, false)
+		if err != nil {
+			return
+		}
+	}
+
+	// done, set it
+	err = task.setPartition(newPartition, false)
+	return
+}
+
+// computes number of workunits and partition of a task without modifying the task
+// used by InitPartIndex and by job dry runs, the latter do not create missing indexes
+func (task *Task) EstimatePartIndex(create_index bool) (totalwork int, newPartition *PartInfo, err error) {
+	if task.TotalWork == 1 && task.MaxWorkSize == 0 {
+		// only 1 workunit requested
+		totalwork = 1
+		newPartition = task.Partition
+		return
+	}
+
+	newPartition, totalunits, isSingle, err := task.checkPartIndex(create_index)
+	if err != nil {
+		return
+	}
+	if isSingle {
+		// its a single workunit
+		totalwork = 1
+		newPartition = nil
+		return
+	}
+
+	lock, err := task.RLockNamed("EstimatePartIndex")
+	if err != nil {
+		return
+	}
+	defer task.RUnlockNamed(lock)
+
+	totalwork = task.TotalWork
+
 	// adjust total work based on needs
 	if newPartition.MaxPartSizeMB > 0 {
 		// this implementation for chunkrecord indexer only
 		chunkmb := int(conf.DEFAULT_CHUNK_SIZE / 1048576)
-		var totalwork int
+		var size_totalwork int
 		if totalunits*chunkmb%newPartition.MaxPartSizeMB == 0 {
-			totalwork = totalunits * chunkmb / newPartition.MaxPartSizeMB
+			size_totalwork = totalunits * chunkmb / newPartition.MaxPartSizeMB
 		} else {
-			totalwork = totalunits*chunkmb/newPartition.MaxPartSizeMB + 1
+			size_totalwork = totalunits*chunkmb/newPartition.MaxPartSizeMB + 1
 		}
-		if totalwork < task.TotalWork {
+		if size_totalwork > totalwork {
 			// use bigger splits (specified by size or totalwork)
-			totalwork = task.TotalWork
-		}
-		if totalwork != task.TotalWork {
-			err = task.setTotalWork(totalwork, false)
-			if err != nil {
-				return
-			}
+			totalwork = size_totalwork
 		}
 	}
-	if totalunits < task.TotalWork {
-		err = task.setTotalWork(totalunits, false)
-		if err != nil {
-			return
-		}
+	if totalunits < totalwork {
+		totalwork = totalunits
 	}
 
-	// need only 1 workunit
-	if task.TotalWork == 1 {
-		err = task.setSingleWorkunit(false)
+	if totalwork == 1 {
+		newPartition = nil
 		return
 	}
 
-	// done, set it
 	newPartition.TotalIndex = totalunits
-	err = task.setPartition(newPartition, false)
 	return
 }
 
@@ -1076,6 +1134,15 @@ func (task *Task) SetResetTask(info *Info) (err error) {
 	}
 	task.ComputeTime = 0
 
+	// reset failure counts of the workunits
+	if len(task.WorkFailures) > 0 {
+		err = dbUpdateJobTaskField(task.JobId, task.Id, "work_failures", nil)
+		if err != nil {
+			return
+		}
+		task.WorkFailures = nil
+	}
+
 	// reset completedate
 	err = task.SetCompletedDate(time.Time{}, false)
 
@@ -1100,7 +1167,10 @@ func (task *Task) SetResetTask(info *Info) (err error) {
 		if io.Type == "update" {
 			continue
 		}
-		if dataUrl, _ := io.DataUrl(); dataUrl != "" {
+		if io.Reused {
+			// the node belongs to the job that computed it
+			io.Reused = false
+		} else if dataUrl, _ := io.DataUrl(); dataUrl != "" {
 			// delete dataUrl if is shock node
 			if strings.HasSuffix(dataUrl, shock.DATA_SUFFIX) {
 				err = shock.ShockDelete(io.Host, io.Node, io.DataToken)
@@ -1370,6 +1440,15 @@ func (task *Task) ValidateInputs(qm *ServerMgr) (err error) {
 			}
 
 			io.Node = preTaskIO.Node
+			if preTaskIO.StorageScheme() != STORAGE_SHOCK {
+				io.Url = preTaskIO.Url
+			}
+		}
+
+		// inputs in other storage backends are read by the worker directly
+		if io.StorageScheme() != STORAGE_SHOCK {
+			logger.Debug(3, "(ValidateInputs) input in %s storage: task=%s, file=%s, url=%s", io.StorageScheme(), task.Id, io.FileName, io.Url)
+			continue
 		}
 
 		// make sure we have node id
@@ -1422,6 +1501,11 @@ func (task *Task) ValidateOutputs() (err error) {
 
 	for _, io := range task.Outputs {
 
+		// outputs in other storage backends are not validated
+		if io.StorageScheme() != STORAGE_SHOCK {
+			continue
+		}
+
 		// force build data url
 		io.Url = ""
 		_, err = io.DataUrl()
@@ -1475,6 +1559,18 @@ func (task *Task) ValidatePredata() (err error) {
 			if mod {
 				modified = true
 			}
+			// md5 is needed for data-locality scheduling
+			if io.MD5 == "" {
+				node, xerr := io.getShockNode()
+				if xerr != nil {
+					err = fmt.Errorf("input file %s getShockNode returns: %s", io.FileName, xerr.Error())
+					return
+				}
+				if md5, ok := node.File.Checksum["md5"]; ok {
+					io.MD5 = md5
+					modified = true
+				}
+			}
 			// build url if missing
 			if io.Url == "" {
 				_, err = io.DataUrl()
@@ -1495,42 +1591,6 @@ func (task *Task) ValidatePredata() (err error) {
 	return
 }
 
-func (task *Task) DeleteOutput() (modified int) {
-	modified = 0
-	task_state := task.State
-	if task_state == TASK_STAT_COMPLETED ||
-		task_state == TASK_STAT_SKIPPED ||
-		task_state == TASK_STAT_FAIL_SKIP {
-		for _, io := range task.Outputs {
-			if io.Delete {
-				if err := io.DeleteNode(); err != nil {
-					logger.Warning("failed to delete shock node %s: %s", io.Node, err.Error())
-				}
-				modified += 1
-			}
-		}
-	}
-	return
-}
-
-func (task *Task) DeleteInput() (modified int) {
-	modified = 0
-	task_state := task.State
-	if task_state == TASK_STAT_COMPLETED ||
-		task_state == TASK_STAT_SKIPPED ||
-		task_state == TASK_STAT_FAIL_SKIP {
-		for _, io := range task.Inputs {
-			if io.Delete {
-				if err := io.DeleteNode(); err != nil {
-					logger.Warning("failed to delete shock node %s: %s", io.Node, err.Error())
-				}
-				modified += 1
-			}
-		}
-	}
-	return
-}
-
 func (task *Task) DeleteLogs(logname string, writelock bool) (err error) {
 	if writelock {
 		err = task.LockNamed("setTotalWork")
@@ -7,6 +7,7 @@ import (
 	"sort"
 	//"sync"
 	"fmt"
+	"time"
 )
 
 type WorkQueue struct {
@@ -197,6 +198,7 @@ func (wq *WorkQueue) StatusChange(id Workunit_Unique_Identifier, workunit *Worku
 		if err != nil {
 			return
 		}
+		workunit.QueuedTime = time.Now()
 		wq.Queue.Set(workunit)
 
 	case WORK_STAT_SUSPEND:
@@ -227,11 +229,16 @@ func (wq *WorkQueue) StatusChange(id Workunit_Unique_Identifier, workunit *Worku
 
 //select workunits, return a slice of ids based on given queuing policy and requested count
 //if available is a positive value, filter by workunit input size
-func (wq *WorkQueue) selectWorkunits(workunits WorkList, policy string, available int64, count int) (selected []*Workunit, err error) {
+//cached is the md5 set of the predata cache of the client, workunits using it are preferred among those with same priority
+func (wq *WorkQueue) selectWorkunits(workunits WorkList, policy string, available int64, count int, cached map[string]bool) (selected []*Workunit, err error) {
 	logger.Debug(3, "starting selectWorkunits")
 
 	if policy == "FCFS" {
-		sort.Sort(byFCFS{workunits})
+		if len(cached) > 0 {
+			sort.Sort(byPredataLocality{workunits, cached})
+		} else {
+			sort.Sort(byFCFS{workunits})
+		}
 	}
 	added := 0
 	for _, work := range workunits {
@@ -282,3 +289,32 @@ func (s byFCFS) Less(i, j int) (ret bool) {
 	}
 	return
 }
+
+type byPredataLocality struct {
+	WorkList
+	cached map[string]bool
+}
+
+//compare priority first, then number of cached predata files, then FCFS
+func (s byPredataLocality) Less(i, j int) (ret bool) {
+	p_i := s.WorkList[i].Info.Priority
+	p_j := s.WorkList[j].Info.Priority
+	if p_i != p_j {
+		return p_i > p_j
+	}
+	c_i := s.countCached(s.WorkList[i])
+	c_j := s.countCached(s.WorkList[j])
+	if c_i != c_j {
+		return c_i > c_j
+	}
+	return s.WorkList[i].Info.SubmitTime.Before(s.WorkList[j].Info.SubmitTime)
+}
+
+func (s byPredataLocality) countCached(work *Workunit) (count int) {
+	for _, md5 := range work.PredataMd5s() {
+		if s.cached[md5] {
+			count += 1
+		}
+	}
+	return
+}
@@ -0,0 +1,614 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core/cwl"
+	"github.com/MG-RAST/AWE/lib/db"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/logger/event"
+	"github.com/MG-RAST/AWE/lib/shock"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// WorkReuse records the outputs of a completed task. A later task with the same key
+// (same command or tool, same inputs, same docker image) can reuse these outputs instead of computing them again.
+// Results are only reused within the jobs of the same owner.
+type WorkReuse struct {
+	Key        string      `bson:"key" json:"key"`
+	Owner      string      `bson:"owner" json:"owner"`
+	JobId      string      `bson:"job_id" json:"job_id"`
+	TaskId     string      `bson:"task_id" json:"task_id"`
+	Outputs    []*IO       `bson:"outputs" json:"outputs"`         // old-style AWE
+	StepOutput interface{} `bson:"step_output" json:"step_output"` // CWL-only
+	CreatedOn  time.Time   `bson:"created_on" json:"created_on"`
+	LastUsed   time.Time   `bson:"last_used" json:"last_used"`
+	Count      int         `bson:"count" json:"count"` // number of times this result has been reused
+}
+
+// the content that is hashed to get the reuse key
+type workReuseKeyContent struct {
+	Owner       string                 `json:"owner"`
+	Tool        interface{}            `json:"tool,omitempty"`
+	Inputs      interface{}            `json:"inputs,omitempty"`
+	Cmd         *workReuseCommand      `json:"cmd,omitempty"`
+	Predata     []string               `json:"predata,omitempty"`
+	Outputs     []string               `json:"outputs,omitempty"`
+	Partition   *PartInfo              `json:"partition,omitempty"`
+	DockerImage string                 `json:"docker_image,omitempty"`
+	UserAttr    map[string]interface{} `json:"userattr,omitempty"`
+}
+
+type workReuseCommand struct {
+	Name       string            `json:"name"`
+	Args       string            `json:"args"`
+	ArgsArray  []string          `json:"args_array"`
+	Cmd_script []string          `json:"cmd_script"`
+	Environ    map[string]string `json:"environ"`
+}
+
+func InitWorkReuseDB() {
+	c := db.C(conf.DB_COLL_REUSE)
+	c.EnsureIndex(db.Index{Key: []string{"key"}, Unique: true})
+	c.EnsureIndex(db.Index{Key: []string{"job_id"}, Background: true})
+}
+
+func dbFindWorkReuse(key string) (wr *WorkReuse, ok bool, err error) {
+	c := db.C(conf.DB_COLL_REUSE)
+
+	wr = &WorkReuse{}
+	err = c.Find(bson.M{"key": key}).One(wr)
+	if err != nil {
+		wr = nil
+		if err == db.ErrNotFound {
+			err = nil
+			return
+		}
+		err = fmt.Errorf("(dbFindWorkReuse) c.Find returned: %s", err.Error())
+		return
+	}
+	ok = true
+	return
+}
+
+func dbUpsertWorkReuse(wr *WorkReuse) (err error) {
+	c := db.C(conf.DB_COLL_REUSE)
+	_, err = c.Upsert(bson.M{"key": wr.Key}, wr)
+	return
+}
+
+func dbTouchWorkReuse(key string) (err error) {
+	c := db.C(conf.DB_COLL_REUSE)
+	err = c.Update(bson.M{"key": key}, bson.M{"$set": bson.M{"last_used": time.Now()}, "$inc": bson.M{"count": 1}})
+	return
+}
+
+func DeleteWorkReuse(key string) (err error) {
+	err = dbDelete(bson.M{"key": key}, conf.DB_COLL_REUSE)
+	return
+}
+
+// getReuseKey computes a deterministic key for a task of type TASK_TYPE_NORMAL.
+// An empty key means that the task cannot be reused.
+func (qm *ServerMgr) getReuseKey(task *Task, job *Job) (key string, err error) {
+
+	if !conf.WORK_REUSE {
+		return
+	}
+
+	if job.Info != nil && job.Info.NoReuse {
+		return
+	}
+
+	content := workReuseKeyContent{}
+	image := ""
+	token := ""
+	if job.Acl.Owner == "" {
+		// anonymous jobs could read the outputs of each other
+		return
+	}
+	content.Owner = job.Acl.Owner
+
+	if task.WorkflowStep != nil {
+
+		if !stepWorkReuseEnabled(task.WorkflowStep.Hints) || !stepWorkReuseEnabled(task.WorkflowStep.Requirements) {
+			logger.Debug(3, "(getReuseKey) WorkReuse disabled for step %s", task.WorkflowStep.Id)
+			return
+		}
+
+		var schemata []cwl.CWLType_Type
+		schemata, err = job.CWL_collection.GetSchemata()
+		if err != nil {
+			err = fmt.Errorf("(getReuseKey) job.CWL_collection.GetSchemata() returned: %s", err.Error())
+			return
+		}
+
+		var process interface{}
+		process, _, err = cwl.GetProcess(task.WorkflowStep.Run, job.CWL_collection, job.CwlVersion, schemata)
+		if err != nil {
+			err = fmt.Errorf("(getReuseKey) cwl.GetProcess returned: %s", err.Error())
+			return
+		}
+
+		clt, ok := process.(*cwl.CommandLineTool)
+		if !ok {
+			// ExpressionTools are evaluated cheaply, no need to reuse them
+			return
+		}
+
+		if !cwl.WorkReuseEnabled(clt.Hints, clt.Requirements) {
+			logger.Debug(3, "(getReuseKey) WorkReuse disabled for tool %s", clt.Id)
+			return
+		}
+
+		var workflow_instance *WorkflowInstance
+		workflow_instance, err = job.GetWorkflowInstance(task.Parent, true)
+		if err != nil {
+			err = fmt.Errorf("(getReuseKey) GetWorkflowInstance returned %s", err.Error())
+			return
+		}
+		workflow_input_map := workflow_instance.Inputs.GetMap()
+
+		var workunit_input_map cwl.JobDocMap
+		workunit_input_map, err = qm.GetStepInputObjects(job, task.Task_Unique_Identifier, workflow_input_map, task.WorkflowStep)
+		if err != nil {
+			err = fmt.Errorf("(getReuseKey) GetStepInputObjects returned: %s", err.Error())
+			return
+		}
+
+		content.Tool = clt
+		content.Inputs = workunit_input_map
+		image = cwlDockerImage(clt.Hints, clt.Requirements)
+		if job.Info != nil {
+			token = job.Info.DataToken
+		}
+
+	} else {
+
+		if task.Cmd == nil {
+			return
+		}
+
+		// inputs are identified by shock node and checksum (if known)
+		inputs := []string{}
+		for _, io := range task.Inputs {
+			if io.Type == "update" {
+				// node is modified in place, cannot be reused
+				return
+			}
+			inputs = append(inputs, workReuseIOString(io))
+		}
+		content.Inputs = inputs
+
+		for _, io := range task.Predata {
+			content.Predata = append(content.Predata, workReuseIOString(io))
+		}
+
+		for _, io := range task.Outputs {
+			if io.Type == "update" {
+				return
+			}
+			content.Outputs = append(content.Outputs, io.FileName+"|"+io.Host+"|"+io.AttrFile)
+		}
+
+		content.Cmd = &workReuseCommand{
+			Name:       task.Cmd.Name,
+			Args:       task.Cmd.Args,
+			ArgsArray:  task.Cmd.ArgsArray,
+			Cmd_script: task.Cmd.Cmd_script,
+			Environ:    task.Cmd.Environ.Public,
+		}
+		content.Partition = task.Partition
+		content.UserAttr = task.UserAttr
+
+		image = task.Cmd.Dockerimage
+		if image == "" {
+			image = task.Cmd.DockerPull
+		}
+		if task.Info != nil {
+			token = task.Info.DataToken
+		}
+	}
+
+	// a tag can point to a different image later, the key uses the digest
+	if image != "" {
+		content.DockerImage, err = getDockerImageDigest(image, token)
+		if err != nil {
+			logger.Debug(1, "(getReuseKey) could not resolve docker image %s, task will not be reused: %s", image, err.Error())
+			err = nil
+			return
+		}
+	}
+
+	var content_bytes []byte
+	content_bytes, err = json.Marshal(content)
+	if err != nil {
+		err = fmt.Errorf("(getReuseKey) json.Marshal returned: %s", err.Error())
+		return
+	}
+
+	sum := sha256.Sum256(content_bytes)
+	key = hex.EncodeToString(sum[:])
+	return
+}
+
+// reuseTask looks up a completed result for the key and, if it is still available, copies its outputs into the task.
+func (qm *ServerMgr) reuseTask(task *Task, key string) (reused bool, err error) {
+
+	var wr *WorkReuse
+	var ok bool
+	wr, ok, err = dbFindWorkReuse(key)
+	if err != nil {
+		err = fmt.Errorf("(reuseTask) dbFindWorkReuse returned: %s", err.Error())
+		return
+	}
+	if !ok {
+		return
+	}
+
+	// make sure the shock nodes of the previous result still exist
+	available, xerr := wr.outputsAvailable(task.Info.DataToken)
+	if xerr != nil {
+		logger.Debug(1, "(reuseTask) outputs of task %s are not available: %s", wr.TaskId, xerr.Error())
+	}
+	if !available {
+		// keep the result if shock could not be reached or denied access, only drop it if a node is gone
+		if shock.IsNodeNotFound(xerr) {
+			err = DeleteWorkReuse(key)
+			if err != nil {
+				err = fmt.Errorf("(reuseTask) DeleteWorkReuse returned: %s", err.Error())
+			}
+		}
+		return
+	}
+
+	if task.WorkflowStep != nil {
+		if wr.StepOutput == nil {
+			return
+		}
+		var step_output *cwl.Job_document
+		step_output, err = cwl.NewJob_documentFromNamedTypes(wr.StepOutput)
+		if err != nil {
+			err = fmt.Errorf("(reuseTask) cwl.NewJob_documentFromNamedTypes returned: %s", err.Error())
+			return
+		}
+		err = task.SetStepOutput(step_output, true)
+		if err != nil {
+			err = fmt.Errorf("(reuseTask) task.SetStepOutput returned: %s", err.Error())
+			return
+		}
+		err = task.setReusedNodes(stepOutputNodes(step_output))
+		if err != nil {
+			err = fmt.Errorf("(reuseTask) setReusedNodes returned: %s", err.Error())
+			return
+		}
+	} else {
+		err = task.setReusedOutputs(wr.Outputs)
+		if err != nil {
+			err = fmt.Errorf("(reuseTask) setReusedOutputs returned: %s", err.Error())
+			return
+		}
+	}
+
+	err = dbTouchWorkReuse(key)
+	if err != nil {
+		err = fmt.Errorf("(reuseTask) dbTouchWorkReuse returned: %s", err.Error())
+		return
+	}
+
+	logger.Debug(1, "(reuseTask) task %s reuses outputs of task %s", task.Id, wr.TaskId)
+	reused = true
+	return
+}
+
+// saveWorkReuse stores the outputs of a completed task under its reuse key
+func saveWorkReuse(task *Task) (err error) {
+
+	if task.ReuseKey == "" {
+		return
+	}
+
+	var job *Job
+	job, err = GetJob(task.JobId)
+	if err != nil {
+		err = fmt.Errorf("(saveWorkReuse) GetJob returned: %s", err.Error())
+		return
+	}
+
+	wr := &WorkReuse{
+		Key:       task.ReuseKey,
+		Owner:     job.Acl.Owner,
+		JobId:     task.JobId,
+		TaskId:    task.Id,
+		CreatedOn: time.Now(),
+	}
+
+	if task.WorkflowStep != nil {
+		if task.StepOutput == nil {
+			return
+		}
+		wr.StepOutput = *task.StepOutput
+	} else {
+		for _, io := range task.Outputs {
+			if cleanupReason(io) != "" {
+				// these nodes will be deleted when the job completes
+				return
+			}
+		}
+		wr.Outputs = task.Outputs
+	}
+
+	err = dbUpsertWorkReuse(wr)
+	if err != nil {
+		err = fmt.Errorf("(saveWorkReuse) dbUpsertWorkReuse returned: %s", err.Error())
+		return
+	}
+	return
+}
+
+func (task *Task) setReusedOutputs(outputs []*IO) (err error) {
+	err = task.LockNamed("setReusedOutputs")
+	if err != nil {
+		return
+	}
+	defer task.Unlock()
+
+	for _, io := range task.Outputs {
+		var found *IO
+		for _, cached := range outputs {
+			if cached.FileName == io.FileName {
+				found = cached
+				break
+			}
+		}
+		if found == nil {
+			err = fmt.Errorf("output %s not found in reused outputs", io.FileName)
+			return
+		}
+		io.Host = found.Host
+		io.Node = found.Node
+		io.Url = found.Url
+		io.Size = found.Size
+		io.MD5 = found.MD5
+		// the nodes belong to the job that computed them, they are not removed by the node cleanup of this job
+		io.Reused = true
+	}
+
+	err = dbUpdateJobTaskIO(task.JobId, task.Id, "outputs", task.Outputs)
+	if err != nil {
+		err = fmt.Errorf("unable to save task outputs to mongodb, task=%s: %s", task.Id, err.Error())
+		return
+	}
+	task.RemainWork = 0
+	err = dbUpdateJobTaskInt(task.JobId, task.Id, "remainwork", 0)
+	return
+}
+
+// outputsAvailable checks that all shock nodes referenced by this result still exist
+func (wr *WorkReuse) outputsAvailable(token string) (ok bool, err error) {
+
+	nodes := []*IO{}
+	for _, io := range wr.Outputs {
+		nodes = append(nodes, io)
+	}
+
+	if wr.StepOutput != nil {
+		var step_output *cwl.Job_document
+		step_output, err = cwl.NewJob_documentFromNamedTypes(wr.StepOutput)
+		if err != nil {
+			return
+		}
+		nodes = append(nodes, stepOutputNodes(step_output)...)
+	}
+
+	for _, io := range nodes {
+		if io.Host == "" || io.Node == "" || io.Node == "-" {
+			continue
+		}
+		_, err = shock.ShockGet(io.Host, io.Node, token)
+		if err != nil {
+			return
+		}
+	}
+	ok = true
+	return
+}
+
+// stepOutputNodes returns the shock nodes of the files in a CWL step output
+func stepOutputNodes(step_output *cwl.Job_document) (nodes []*IO) {
+	for _, named := range *step_output {
+		for _, location := range cwlFileLocations(named.Value) {
+			io := &IO{Url: location}
+			if io.Url2Shock() == nil && io.Node != "" {
+				nodes = append(nodes, io)
+			}
+		}
+	}
+	return
+}
+
+// setReusedNodes records the shock nodes of a reused CWL step output, they belong to another job
+// and are not removed by the node cleanup of this job
+func (task *Task) setReusedNodes(nodes []*IO) (err error) {
+	err = task.LockNamed("setReusedNodes")
+	if err != nil {
+		return
+	}
+	defer task.Unlock()
+
+	reused := []string{}
+	for _, io := range nodes {
+		reused = append(reused, io.Host+"/"+io.Node)
+	}
+	err = dbUpdateJobTaskField(task.JobId, task.Id, "reused_nodes", reused)
+	if err != nil {
+		return
+	}
+	task.ReusedNodes = reused
+	return
+}
+
+func cwlFileLocations(value cwl.CWLType) (locations []string) {
+	switch value.(type) {
+	case *cwl.File:
+		file := value.(*cwl.File)
+		if file.Location != "" {
+			locations = append(locations, file.Location)
+		}
+	case *cwl.Array:
+		array := value.(*cwl.Array)
+		for i, _ := range *array {
+			locations = append(locations, cwlFileLocations((*array)[i])...)
+		}
+	}
+	return
+}
+
+func workReuseIOString(io *IO) string {
+	checksum := io.MD5
+	if checksum == "" {
+		checksum = io.Node
+	}
+	if io.Url != "" && (io.Node == "" || io.Node == "-") {
+		return io.FileName + "|" + io.Url
+	}
+	return io.FileName + "|" + io.Host + "|" + checksum + "|" + io.ShockFilename + "|" + io.ShockIndex
+}
+
+// step hints and requirements are not parsed, look for class WorkReuse in generic form
+func stepWorkReuseEnabled(list []interface{}) bool {
+	for _, item := range list {
+		item_map, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		class, _ := item_map["class"].(string)
+		if class != "WorkReuse" {
+			continue
+		}
+		enable, has := item_map["enableReuse"]
+		if !has {
+			continue
+		}
+		enable_bool, ok := enable.(bool)
+		if ok && !enable_bool {
+			return false
+		}
+	}
+	return true
+}
+
+func cwlDockerImage(hints []cwl.Requirement, requirements *[]cwl.Requirement) (image string) {
+	all := []cwl.Requirement{}
+	all = append(all, hints...)
+	if requirements != nil {
+		all = append(all, *requirements...)
+	}
+	for _, r := range all {
+		docker, ok := r.(*cwl.DockerRequirement)
+		if !ok {
+			continue
+		}
+		if docker.DockerImageId != "" {
+			image = docker.DockerImageId
+		} else {
+			image = docker.DockerPull
+		}
+	}
+	return
+}
+
+// getDockerImageDigest returns the checksum of the docker image stored in the Shock docker repository.
+// Images with a pinned digest (name@sha256:...) and image ids (sha256:...) are returned as is.
+func getDockerImageDigest(name string, token string) (digest string, err error) {
+
+	if strings.Contains(name, "@sha256:") || strings.HasPrefix(name, "sha256:") {
+		digest = name
+		return
+	}
+
+	if strings.HasSuffix(name, ":latest") || !strings.Contains(name, ":") {
+		err = fmt.Errorf("image %s has no fixed tag", name)
+		return
+	}
+
+	sc := shock.ShockClient{Host: conf.SHOCK_DOCKER_IMAGE_REPOSITORY, Token: token}
+	var response *shock.ShockQueryResponse
+	response, err = sc.Query(url.Values{"type": {"dockerimage"}, "name": {name}})
+	if err != nil {
+		return
+	}
+	if len(response.Data) != 1 {
+		err = fmt.Errorf("found %d images with name %s", len(response.Data), name)
+		return
+	}
+	node := response.Data[0]
+	md5, ok := node.File.Checksum["md5"]
+	if !ok || md5 == "" {
+		err = fmt.Errorf("image node %s has no md5 checksum", node.Id)
+		return
+	}
+	digest = name + "@md5:" + md5
+	return
+}
+
+func (task *TaskRaw) SetReuseKey(key string, writelock bool) (err error) {
+	if writelock {
+		err = task.LockNamed("SetReuseKey")
+		if err != nil {
+			return
+		}
+		defer task.Unlock()
+	}
+	if task.ReuseKey == key {
+		return
+	}
+	err = dbUpdateJobTaskString(task.JobId, task.Id, "reusekey", key)
+	if err != nil {
+		return
+	}
+	task.ReuseKey = key
+	return
+}
+
+// completeReusedTask marks a task complete whose outputs have been taken from a previous result
+func (qm *ServerMgr) completeReusedTask(task *Task) (err error) {
+
+	now := time.Now()
+	err = task.SetCreatedDate(now)
+	if err != nil {
+		return
+	}
+	err = task.SetStartedDate(now)
+	if err != nil {
+		return
+	}
+
+	err = qm.CreateTaskPerf(task)
+	if err != nil {
+		err = fmt.Errorf("(completeReusedTask) CreateTaskPerf returned: %s", err.Error())
+		return
+	}
+
+	err = task.SetState(TASK_STAT_COMPLETED, true)
+	if err != nil {
+		err = fmt.Errorf("(completeReusedTask) task.SetState returned: %s", err.Error())
+		return
+	}
+
+	qm.FinalizeTaskPerf(task)
+	logger.Event(event.TASK_REUSED, "task_id="+task.Id)
+
+	err = qm.updateJobTask(task) //task state READY -> COMPLETED
+	if err != nil {
+		err = fmt.Errorf("(completeReusedTask) updateJobTask returned: %s", err.Error())
+	}
+	return
+}
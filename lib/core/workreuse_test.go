@@ -0,0 +1,199 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core/cwl"
+	"github.com/MG-RAST/AWE/lib/db"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+func newReuseTestTask(t *testing.T, owner string) (job *Job, task *Task) {
+	job = NewJob()
+	job.setId()
+	job.Info = NewInfo()
+	job.Acl.Owner = owner
+	task, err := NewTask(job, "", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.Info = job.Info
+	task.RWMutex.Init("task")
+	task.Cmd = NewCommand("bowtie2")
+	task.Cmd.Args = "-x index -U @reads.fq"
+	task.Inputs = []*IO{{FileName: "reads.fq", Host: "http://shock.example.org", Node: "reads", MD5: "0123"}}
+	task.Outputs = []*IO{{FileName: "out.sam", Host: "http://shock.example.org"}}
+	job.Tasks = []*Task{task}
+	return
+}
+
+func TestReuseKey(t *testing.T) {
+	logger.Initialize("test")
+	conf.WORK_REUSE = true
+	qm := &ServerMgr{}
+
+	key := func(task *Task, job *Job) string {
+		k, err := qm.getReuseKey(task, job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	job, task := newReuseTestTask(t, "alice")
+	first := key(task, job)
+	if first == "" {
+		t.Fatalf("task is not reusable")
+	}
+	job, task = newReuseTestTask(t, "alice")
+	if key(task, job) != first {
+		t.Fatalf("identical tasks of another job have different keys")
+	}
+
+	// owner, arguments and inputs are part of the key
+	job, task = newReuseTestTask(t, "bob")
+	if key(task, job) == first {
+		t.Fatalf("tasks of different owners have the same key")
+	}
+	job, task = newReuseTestTask(t, "alice")
+	task.Cmd.Args = "-x other -U @reads.fq"
+	if key(task, job) == first {
+		t.Fatalf("tasks with different arguments have the same key")
+	}
+	job, task = newReuseTestTask(t, "alice")
+	task.Inputs[0].MD5 = "4567"
+	if key(task, job) == first {
+		t.Fatalf("tasks with different inputs have the same key")
+	}
+
+	// anonymous jobs, tasks that update their inputs and jobs with reuse disabled are not reused
+	job, task = newReuseTestTask(t, "")
+	if key(task, job) != "" {
+		t.Fatalf("anonymous task is reusable")
+	}
+	job, task = newReuseTestTask(t, "alice")
+	task.Inputs[0].Type = "update"
+	if key(task, job) != "" {
+		t.Fatalf("task updating its input is reusable")
+	}
+	job, task = newReuseTestTask(t, "alice")
+	job.Info.NoReuse = true
+	if key(task, job) != "" {
+		t.Fatalf("task of a job with noreuse is reusable")
+	}
+
+	// the image is identified by digest, a tag can change
+	for _, image := range []string{"ubuntu", "ubuntu:latest", "ubuntu:18.04"} {
+		job, task = newReuseTestTask(t, "alice")
+		task.Cmd.DockerPull = image
+		if key(task, job) != "" {
+			t.Fatalf("task with unpinned image %s is reusable", image)
+		}
+	}
+	job, task = newReuseTestTask(t, "alice")
+	task.Cmd.DockerPull = "ubuntu@sha256:aaaa"
+	pinned := key(task, job)
+	job, task = newReuseTestTask(t, "alice")
+	task.Cmd.DockerPull = "ubuntu@sha256:bbbb"
+	other := key(task, job)
+	if pinned == "" || other == "" || pinned == other || pinned == first {
+		t.Fatalf("pinned images not part of the key")
+	}
+}
+
+func TestReuseTask(t *testing.T) {
+	logger.Initialize("test")
+	dir, err := ioutil.TempDir("", "awe-reuse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := db.OpenEmbedded(path.Join(dir, "awe.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	db.SetStore(s)
+	conf.WORK_REUSE = true
+	qm := &ServerMgr{}
+
+	// no result has been stored for the key yet
+	job, task := newReuseTestTask(t, "alice")
+	if err = dbUpsert(job); err != nil {
+		t.Fatal(err)
+	}
+	reused, err := qm.reuseTask(task, "key")
+	if err != nil || reused {
+		t.Fatalf("task reused without result: %v", err)
+	}
+
+	// outputs without a shock host are not checked against shock
+	wr := &WorkReuse{
+		Key:     "key",
+		Owner:   "alice",
+		JobId:   "other",
+		TaskId:  "other_0",
+		Outputs: []*IO{{FileName: "out.sam", Node: "sam", Url: "http://example.org/out.sam", Size: 42}},
+	}
+	if err = dbUpsertWorkReuse(wr); err != nil {
+		t.Fatal(err)
+	}
+	reused, err = qm.reuseTask(task, "key")
+	if err != nil || !reused {
+		t.Fatalf("task not reused: %v", err)
+	}
+	output := task.Outputs[0]
+	if output.Node != "sam" || output.Size != 42 || !output.Reused || task.RemainWork != 0 {
+		t.Fatalf("unexpected output %+v", output)
+	}
+	wr, ok, err := dbFindWorkReuse("key")
+	if err != nil || !ok || wr.Count != 1 {
+		t.Fatalf("reuse not counted: %v", err)
+	}
+
+	// a reused output is not removed with the temporary nodes of the job
+	output.Temporary = true
+	if nodes := job.TemporaryNodes(); len(nodes) != 0 {
+		t.Fatalf("reused node is removed: %+v", nodes[0])
+	}
+}
+
+func TestReusedStepOutput(t *testing.T) {
+	logger.Initialize("test")
+	dir, err := ioutil.TempDir("", "awe-reuse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := db.OpenEmbedded(path.Join(dir, "awe.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	db.SetStore(s)
+
+	job, task := newReuseTestTask(t, "alice")
+	if err = dbUpsert(job); err != nil {
+		t.Fatal(err)
+	}
+	node := "b2c4a3a4-7a9e-4c2b-9d6e-1f0e6c1a2b3c"
+	file := &cwl.File{Location: "http://shock.example.org/node/" + node + "?download"}
+	step_output := &cwl.Job_document{cwl.NewNamedCWLType("out", file)}
+	if err = task.setReusedNodes(stepOutputNodes(step_output)); err != nil {
+		t.Fatal(err)
+	}
+	if len(task.ReusedNodes) != 1 || task.ReusedNodes[0] != "http://shock.example.org/"+node {
+		t.Fatalf("unexpected reused nodes %v", task.ReusedNodes)
+	}
+
+	// the node of the reused step output is protected where a later task uses it
+	task.Outputs = nil
+	task.Inputs = []*IO{{FileName: "out", Host: "http://shock.example.org", Node: node, Intermediate: true}}
+	if nodes := job.TemporaryNodes(); len(nodes) != 0 {
+		t.Fatalf("reused node is removed: %+v", nodes[0])
+	}
+}
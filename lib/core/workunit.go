@@ -17,6 +17,7 @@ import (
 	//"reflect"
 	//"regexp"
 	"regexp/syntax"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -57,6 +58,9 @@ type Workunit struct {
 	UserAttr                   map[string]interface{} `bson:"userattr,omitempty" json:"userattr,omitempty" mapstructure:"userattr,omitempty"`
 	ShockHost                  string                 `bson:"shockhost,omitempty" json:"shockhost,omitempty" mapstructure:"shockhost,omitempty"` // specifies default Shock host for outputs
 	CWL_workunit               *CWL_workunit          `bson:"cwl,omitempty" json:"cwl,omitempty" mapstructure:"cwl,omitempty"`
+	Attempts                   []*WorkAttempt         `bson:"attempts,omitempty" json:"attempts,omitempty" mapstructure:"attempts,omitempty"`          // server only, every reported execution of this workunit
+	NotBefore                  time.Time              `bson:"not_before,omitempty" json:"not_before,omitempty" mapstructure:"not_before,omitempty"`    // server only, retry backoff: workunit is not checked out before this time
+	QueuedTime                 time.Time              `bson:"queued_time,omitempty" json:"queued_time,omitempty" mapstructure:"queued_time,omitempty"` // server only, time the workunit was (re)queued
 	WorkPath                   string                 // this is the working directory. If empty, it will be computed.
 	WorkPerf                   *WorkPerf
 }
@@ -76,7 +80,7 @@ func NewWorkunit(qm *ServerMgr, task *Task, rank int, job *Job) (workunit *Worku
 		TotalWork:  task.TotalWork, //keep this info in workunit for load balancing
 		Partition:  task.Partition,
 		State:      WORK_STAT_INIT,
-		Failed:     0,
+		Failed:     task.WorkFailures[strconv.Itoa(rank)],
 		UserAttr:   task.UserAttr,
 		ExitStatus: -1,
 
@@ -142,6 +146,10 @@ func NewWorkunit(qm *ServerMgr, task *Task, rank int, job *Job) (workunit *Worku
 				err = fmt.Errorf("(NewWorkunit) CommandLineTool misses CwlVersion")
 				return
 			}
+			timelimit := cwl.GetTimeLimit(clt.Hints, clt.Requirements)
+			if timelimit > 0 && workunit.Cmd != nil {
+				workunit.Cmd.Walltime = timelimit
+			}
 			//requirements = clt.Requirements
 		case *cwl.ExpressionTool:
 			var et *cwl.ExpressionTool
@@ -292,6 +300,15 @@ func (work *Workunit) SetState(new_state string, reason string) (err error) {
 	return
 }
 
+// WalltimeExceeded returns true if the workunit has been checked out longer than its walltime plus grace period
+func (work *Workunit) WalltimeExceeded(grace time.Duration) bool {
+	if work.Cmd == nil || work.Cmd.Walltime <= 0 || work.CheckoutTime.IsZero() {
+		return false
+	}
+	walltime := time.Duration(work.Cmd.Walltime) * time.Second
+	return time.Since(work.CheckoutTime) > walltime+grace
+}
+
 func (work *Workunit) Path() (path string, err error) {
 	if work.WorkPath == "" {
 		id := work.Workunit_Unique_Identifier.JobId
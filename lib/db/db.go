@@ -1,11 +1,15 @@
-// Package db to connect to mongodb
+// Package db to connect to mongodb or the embedded database.
+//
+// Documents, queries and struct tags use the mgo bson package (gopkg.in/mgo.v2/bson) with both backends.
+// The mongodb backend talks to the server with the official driver (go.mongodb.org/mongo-driver), the mgo
+// driver is not used anymore. Keeping mgo/bson as the codec is deliberate, moving to the bson package of the
+// official driver would change the types of ids, dates and documents in every package.
 package db
 
 import (
 	"errors"
 	"fmt"
 	"github.com/MG-RAST/AWE/lib/conf"
-	mgo "gopkg.in/mgo.v2"
 	"time"
 )
 
@@ -15,49 +19,146 @@ const (
 )
 
 var (
-	Connection connection
-	DbTimeout  = time.Second * time.Duration(conf.MONGODB_TIMEOUT)
+	DbTimeout = time.Second * time.Duration(conf.MONGODB_TIMEOUT)
+	store     Store
+
+	// ErrNotFound is returned when no document matches, the message is the same as the one of mgo
+	ErrNotFound = errors.New("not found")
 )
 
-type connection struct {
-	dbname   string
-	username string
-	password string
-	Session  *mgo.Session
-	DB       *mgo.Database
+// Store is the persistence layer of the server, queries and updates use the mongodb syntax
+type Store interface {
+	C(name string) Collection
+	// RunTransaction runs f atomically, all operations of the transaction have to use tx
+	RunTransaction(f func(tx Store) error) error
+	Drop() error
+	Close() error
+}
+
+// Collection is the subset of the mgo collection API used by AWE
+type Collection interface {
+	Find(query interface{}) Query
+	Insert(docs ...interface{}) error
+	Update(selector interface{}, update interface{}) error
+	UpdateAll(selector interface{}, update interface{}) (info *ChangeInfo, err error)
+	Upsert(selector interface{}, update interface{}) (info *ChangeInfo, err error)
+	Remove(selector interface{}) error
+	RemoveAll(selector interface{}) (info *ChangeInfo, err error)
+	EnsureIndex(index Index) error
+}
+
+// Query is the subset of the mgo query API used by AWE, the modifiers change and return the query
+type Query interface {
+	Select(selector interface{}) Query
+	Sort(fields ...string) Query
+	Skip(n int) Query
+	Limit(n int) Query
+	Count() (n int, err error)
+	One(result interface{}) error
+	All(result interface{}) error
+	Distinct(key string, result interface{}) error
+}
+
+// Index describes an index, keys prefixed with "-" are sorted in descending order
+type Index struct {
+	Key        []string
+	Unique     bool
+	Background bool
+}
+
+// ChangeInfo reports the number of documents changed by an update or remove
+type ChangeInfo struct {
+	Updated    int
+	Removed    int
+	UpsertedId interface{}
+}
+
+type dupKeyError struct {
+	msg string
+}
+
+func (e *dupKeyError) Error() string {
+	return e.msg
+}
+
+// IsDup returns true if err was caused by a violated unique index
+func IsDup(err error) bool {
+	_, ok := err.(*dupKeyError)
+	return ok
 }
 
 func Initialize() (err error) {
-	c := connection{}
+	if store != nil {
+		store.Close()
+		store = nil
+	}
 
-	// test connection
-	canDial := false
-	for i := 0; i < DialAttempts; i++ {
-		s, err := mgo.DialWithTimeout(conf.MONGODB_HOST, DialTimeout)
-		if err == nil {
-			s.Close()
-			canDial = true
-			break
+	if conf.DB_BACKEND == "embedded" {
+		store, err = OpenEmbedded(conf.DB_PATH)
+		if err != nil {
+			err = fmt.Errorf("(db.Initialize) OpenEmbedded returned: %s", err.Error())
 		}
-	}
-	if !canDial {
-		return errors.New(fmt.Sprintf("no reachable mongodb server(s) at %s", conf.MONGODB_HOST))
+		return
 	}
 
-	// get handle
-	s, err := mgo.DialWithTimeout(conf.MONGODB_HOST, DbTimeout)
-	if err != nil {
-		return errors.New(fmt.Sprintf("no reachable mongodb server(s) at %s", conf.MONGODB_HOST))
+	if conf.MONGODB_TIMEOUT > 0 {
+		DbTimeout = time.Second * time.Duration(conf.MONGODB_TIMEOUT)
 	}
-	c.Session = s
-	c.DB = c.Session.DB(conf.MONGODB_DATABASE)
-	if conf.MONGODB_USER != "" && conf.MONGODB_PASSWD != "" {
-		c.DB.Login(conf.MONGODB_USER, conf.MONGODB_PASSWD)
+
+	for i := 0; i < DialAttempts; i++ {
+		store, err = OpenMongo()
+		if err == nil {
+			return
+		}
 	}
-	Connection = c
+	err = fmt.Errorf("no reachable mongodb server(s) at %s: %s", mongoHosts(), err.Error())
 	return
 }
 
+// SetStore replaces the backend, e.g. with an embedded store in tests
+func SetStore(s Store) {
+	store = s
+}
+
+// C returns a collection of the configured backend
+func C(name string) Collection {
+	return store.C(name)
+}
+
+// CTimeout returns a collection whose operations fail after timeout instead of the configured
+// timeout, the embedded database has no timeout
+func CTimeout(name string, timeout time.Duration) Collection {
+	if ms, ok := store.(*mongoStore); ok && ms.session == nil {
+		return (&mongoStore{client: ms.client, database: ms.database, transactions: ms.transactions, timeout: timeout}).C(name)
+	}
+	return store.C(name)
+}
+
+// RunTransaction runs f in a transaction of the configured backend, see Store
+func RunTransaction(f func(tx Store) error) error {
+	return store.RunTransaction(f)
+}
+
 func Drop() error {
-	return Connection.DB.DropDatabase()
+	return store.Drop()
+}
+
+// CollectionNames returns the names of all collections, only the mongodb backend supports this
+func CollectionNames() (names []string, err error) {
+	ms, ok := store.(*mongoStore)
+	if !ok {
+		err = errors.New("(CollectionNames) only supported by the mongodb backend")
+		return
+	}
+	return ms.collectionNames()
+}
+
+// RenameCollection renames a collection, only the mongodb backend supports this
+func RenameCollection(from string, to string) (err error) {
+	ms, ok := store.(*mongoStore)
+	if !ok {
+		err = errors.New("(RenameCollection) only supported by the mongodb backend")
+		return
+	}
+	return ms.renameCollection(from, to)
 }
@@ -0,0 +1,639 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The embedded backend stores every collection in a bbolt bucket, documents are keyed by their _id.
+// Queries scan the collection unless a unique index matches the equality conditions of the query,
+// which is fast enough for single host installations and tests.
+
+const (
+	indexMetaBucket   = "_indexes"
+	indexBucketPrefix = "_index:"
+)
+
+type embeddedStore struct {
+	bolt    *bolt.DB
+	lock    sync.RWMutex
+	indexes map[string][][]string // unique indexes of each collection
+}
+
+// embeddedTx runs all operations of a transaction in one bbolt transaction
+type embeddedTx struct {
+	store *embeddedStore
+	tx    *bolt.Tx
+}
+
+type embeddedCollection struct {
+	store *embeddedStore
+	name  string
+	tx    *bolt.Tx // set within a transaction
+}
+
+type embeddedQuery struct {
+	coll     *embeddedCollection
+	query    interface{}
+	selector interface{}
+	sort     []string
+	skip     int
+	limit    int
+}
+
+type embeddedDoc struct {
+	key []byte
+	doc bson.M
+}
+
+// OpenEmbedded opens or creates the database file of the embedded backend
+func OpenEmbedded(path string) (s Store, err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	b, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		err = fmt.Errorf("(OpenEmbedded) opening %s returned: %s", path, err.Error())
+		return
+	}
+	es := &embeddedStore{bolt: b, indexes: map[string][][]string{}}
+	err = b.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(indexMetaBucket))
+		if meta == nil {
+			return nil
+		}
+		return meta.ForEach(func(k, v []byte) error {
+			tmp := strings.SplitN(string(k), ":", 2)
+			if len(tmp) == 2 {
+				es.indexes[tmp[0]] = append(es.indexes[tmp[0]], strings.Split(tmp[1], ","))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		b.Close()
+		return
+	}
+	s = es
+	return
+}
+
+func (s *embeddedStore) C(name string) Collection {
+	return &embeddedCollection{store: s, name: name}
+}
+
+// RunTransaction runs f in a single bbolt write transaction, which is rolled back if f returns an error
+func (s *embeddedStore) RunTransaction(f func(tx Store) error) error {
+	return s.bolt.Update(func(tx *bolt.Tx) error {
+		return f(&embeddedTx{store: s, tx: tx})
+	})
+}
+
+func (s *embeddedStore) Drop() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.indexes = map[string][][]string{}
+	return s.bolt.Update(func(tx *bolt.Tx) error {
+		names := [][]byte{}
+		tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, append([]byte{}, name...))
+			return nil
+		})
+		for _, name := range names {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *embeddedStore) Close() error {
+	return s.bolt.Close()
+}
+
+func (t *embeddedTx) C(name string) Collection {
+	return &embeddedCollection{store: t.store, name: name, tx: t.tx}
+}
+
+func (t *embeddedTx) RunTransaction(f func(tx Store) error) error {
+	return f(t)
+}
+
+func (t *embeddedTx) Drop() error {
+	return errors.New("(embeddedTx.Drop) not supported within a transaction")
+}
+
+func (t *embeddedTx) Close() error {
+	return errors.New("(embeddedTx.Close) cannot close a transaction")
+}
+
+// write runs f in the transaction of the collection or in a new write transaction
+func (c *embeddedCollection) write(f func(tx *bolt.Tx) error) error {
+	if c.tx != nil {
+		return f(c.tx)
+	}
+	return c.store.bolt.Update(f)
+}
+
+// read runs f in the transaction of the collection or in a new read-only transaction
+func (c *embeddedCollection) read(f func(tx *bolt.Tx) error) error {
+	if c.tx != nil {
+		return f(c.tx)
+	}
+	return c.store.bolt.View(f)
+}
+
+func (c *embeddedCollection) uniqueIndexes() [][]string {
+	c.store.lock.RLock()
+	defer c.store.lock.RUnlock()
+	return c.store.indexes[c.name]
+}
+
+func indexBucketName(coll string, keys []string) []byte {
+	return []byte(indexBucketPrefix + coll + ":" + strings.Join(keys, ","))
+}
+
+// indexKey encodes the values of the index keys, missing fields are null like in mongodb
+func indexKey(doc bson.M, keys []string) (key []byte, err error) {
+	var buf bytes.Buffer
+	for _, k := range keys {
+		v, _ := getPath(doc, strings.Split(k, "."))
+		b, err := bson.Marshal(bson.M{"v": v})
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+	}
+	return buf.Bytes(), nil
+}
+
+func dupError(coll string, keys []string) error {
+	return &dupKeyError{msg: fmt.Sprintf("E11000 duplicate key error collection: %s index: %s", coll, strings.Join(keys, ","))}
+}
+
+// put stores the document and updates the unique indexes, old is the previous version of the document
+func (c *embeddedCollection) put(tx *bolt.Tx, key []byte, doc bson.M, old bson.M) (err error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return
+	}
+	for _, keys := range c.uniqueIndexes() {
+		ib, err := tx.CreateBucketIfNotExists(indexBucketName(c.name, keys))
+		if err != nil {
+			return err
+		}
+		ik, err := indexKey(doc, keys)
+		if err != nil {
+			return err
+		}
+		if existing := ib.Get(ik); existing != nil && !bytes.Equal(existing, key) {
+			return dupError(c.name, keys)
+		}
+		if old != nil {
+			if oik, err := indexKey(old, keys); err == nil && !bytes.Equal(oik, ik) {
+				ib.Delete(oik)
+			}
+		}
+		if err = ib.Put(ik, key); err != nil {
+			return err
+		}
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(c.name))
+	if err != nil {
+		return
+	}
+	return b.Put(key, data)
+}
+
+func (c *embeddedCollection) delete(tx *bolt.Tx, d embeddedDoc) (err error) {
+	for _, keys := range c.uniqueIndexes() {
+		if ib := tx.Bucket(indexBucketName(c.name, keys)); ib != nil {
+			if ik, err := indexKey(d.doc, keys); err == nil {
+				ib.Delete(ik)
+			}
+		}
+	}
+	return tx.Bucket([]byte(c.name)).Delete(d.key)
+}
+
+func docKey(id interface{}) (key []byte, err error) {
+	return bson.Marshal(bson.M{"_id": id})
+}
+
+// find returns the documents matching the query, in the order of their _id
+func (c *embeddedCollection) find(tx *bolt.Tx, query bson.M, first bool) (docs []embeddedDoc, err error) {
+	b := tx.Bucket([]byte(c.name))
+	if b == nil {
+		return
+	}
+	check := func(k, v []byte) (done bool, err error) {
+		doc := bson.M{}
+		if err = bson.Unmarshal(v, &doc); err != nil {
+			return true, fmt.Errorf("(embedded.find) decoding document of %s returned: %s", c.name, err.Error())
+		}
+		if matchDoc(doc, query) {
+			docs = append(docs, embeddedDoc{key: append([]byte{}, k...), doc: doc})
+			return first, nil
+		}
+		return false, nil
+	}
+
+	// use a unique index if the query has string equality conditions for all its keys
+	for _, keys := range c.uniqueIndexes() {
+		values := bson.M{}
+		for _, k := range keys {
+			if s, ok := query[k].(string); ok {
+				values[k] = s
+			}
+		}
+		if len(values) != len(keys) {
+			continue
+		}
+		ib := tx.Bucket(indexBucketName(c.name, keys))
+		if ib == nil {
+			continue
+		}
+		ik, xerr := indexKey(expandDoc(values), keys)
+		if xerr != nil {
+			continue
+		}
+		if key := ib.Get(ik); key != nil {
+			if v := b.Get(key); v != nil {
+				_, err = check(key, v)
+			}
+		}
+		return
+	}
+
+	cur := b.Cursor()
+	for k, v := cur.First(); k != nil; k, v = cur.Next() {
+		done, xerr := check(k, v)
+		if xerr != nil {
+			return nil, xerr
+		}
+		if done {
+			break
+		}
+	}
+	return
+}
+
+// expandDoc turns dotted keys into nested documents
+func expandDoc(flat bson.M) (doc bson.M) {
+	doc = bson.M{}
+	for k, v := range flat {
+		setPath(doc, strings.Split(k, "."), v)
+	}
+	return
+}
+
+func (c *embeddedCollection) Find(query interface{}) Query {
+	return &embeddedQuery{coll: c, query: query}
+}
+
+func (c *embeddedCollection) Insert(docs ...interface{}) error {
+	return c.write(func(tx *bolt.Tx) error {
+		for _, d := range docs {
+			doc, err := toDoc(d)
+			if err != nil {
+				return err
+			}
+			if _, ok := doc["_id"]; !ok {
+				doc["_id"] = bson.NewObjectId()
+			}
+			key, err := docKey(doc["_id"])
+			if err != nil {
+				return err
+			}
+			if b := tx.Bucket([]byte(c.name)); b != nil && b.Get(key) != nil {
+				return dupError(c.name, []string{"_id"})
+			}
+			if err = c.put(tx, key, doc, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// update applies the update to the first or all matching documents, or inserts a document for upserts
+func (c *embeddedCollection) update(selector interface{}, update interface{}, all bool, upsert bool) (info *ChangeInfo, err error) {
+	query, err := toDoc(selector)
+	if err != nil {
+		return
+	}
+	up, err := toDoc(update)
+	if err != nil {
+		return
+	}
+	info = &ChangeInfo{}
+	err = c.write(func(tx *bolt.Tx) error {
+		docs, err := c.find(tx, query, !all)
+		if err != nil {
+			return err
+		}
+		for _, d := range docs {
+			old, _ := toDoc(d.doc)
+			if err = applyUpdate(d.doc, up, query, false); err != nil {
+				return err
+			}
+			if err = c.put(tx, d.key, d.doc, old); err != nil {
+				return err
+			}
+			info.Updated++
+		}
+		if len(docs) > 0 || !upsert {
+			return nil
+		}
+
+		doc, err := upsertDoc(query)
+		if err != nil {
+			return err
+		}
+		if err = applyUpdate(doc, up, query, true); err != nil {
+			return err
+		}
+		if _, ok := doc["_id"]; !ok {
+			doc["_id"] = bson.NewObjectId()
+		}
+		key, err := docKey(doc["_id"])
+		if err != nil {
+			return err
+		}
+		info.UpsertedId = doc["_id"]
+		return c.put(tx, key, doc, nil)
+	})
+	if err == nil && info.Updated == 0 && !upsert && !all {
+		err = ErrNotFound
+	}
+	return
+}
+
+func (c *embeddedCollection) Update(selector interface{}, update interface{}) (err error) {
+	_, err = c.update(selector, update, false, false)
+	return
+}
+
+func (c *embeddedCollection) UpdateAll(selector interface{}, update interface{}) (info *ChangeInfo, err error) {
+	return c.update(selector, update, true, false)
+}
+
+func (c *embeddedCollection) Upsert(selector interface{}, update interface{}) (info *ChangeInfo, err error) {
+	return c.update(selector, update, false, true)
+}
+
+func (c *embeddedCollection) remove(selector interface{}, all bool) (info *ChangeInfo, err error) {
+	query, err := toDoc(selector)
+	if err != nil {
+		return
+	}
+	info = &ChangeInfo{}
+	err = c.write(func(tx *bolt.Tx) error {
+		docs, err := c.find(tx, query, !all)
+		if err != nil {
+			return err
+		}
+		for _, d := range docs {
+			if err = c.delete(tx, d); err != nil {
+				return err
+			}
+			info.Removed++
+		}
+		return nil
+	})
+	return
+}
+
+func (c *embeddedCollection) Remove(selector interface{}) (err error) {
+	info, err := c.remove(selector, false)
+	if err == nil && info.Removed == 0 {
+		err = ErrNotFound
+	}
+	return
+}
+
+func (c *embeddedCollection) RemoveAll(selector interface{}) (info *ChangeInfo, err error) {
+	return c.remove(selector, true)
+}
+
+// EnsureIndex creates unique indexes, other indexes are not needed by the embedded backend
+func (c *embeddedCollection) EnsureIndex(index Index) (err error) {
+	if !index.Unique {
+		return
+	}
+	keys := []string{}
+	for _, k := range index.Key {
+		keys = append(keys, strings.TrimPrefix(strings.TrimPrefix(k, "-"), "+"))
+	}
+	for _, existing := range c.uniqueIndexes() {
+		if strings.Join(existing, ",") == strings.Join(keys, ",") {
+			return
+		}
+	}
+
+	err = c.write(func(tx *bolt.Tx) error {
+		ib, err := tx.CreateBucketIfNotExists(indexBucketName(c.name, keys))
+		if err != nil {
+			return err
+		}
+		if b := tx.Bucket([]byte(c.name)); b != nil {
+			err = b.ForEach(func(k, v []byte) error {
+				doc := bson.M{}
+				if err := bson.Unmarshal(v, &doc); err != nil {
+					return err
+				}
+				ik, err := indexKey(doc, keys)
+				if err != nil {
+					return err
+				}
+				if ib.Get(ik) != nil {
+					return dupError(c.name, keys)
+				}
+				return ib.Put(ik, append([]byte{}, k...))
+			})
+			if err != nil {
+				return err
+			}
+		}
+		meta, err := tx.CreateBucketIfNotExists([]byte(indexMetaBucket))
+		if err != nil {
+			return err
+		}
+		return meta.Put([]byte(c.name+":"+strings.Join(keys, ",")), []byte{1})
+	})
+	if err != nil {
+		return
+	}
+	c.store.lock.Lock()
+	c.store.indexes[c.name] = append(c.store.indexes[c.name], keys)
+	c.store.lock.Unlock()
+	return
+}
+
+func (q *embeddedQuery) Select(selector interface{}) Query {
+	q.selector = selector
+	return q
+}
+
+func (q *embeddedQuery) Sort(fields ...string) Query {
+	q.sort = fields
+	return q
+}
+
+func (q *embeddedQuery) Skip(n int) Query {
+	q.skip = n
+	return q
+}
+
+func (q *embeddedQuery) Limit(n int) Query {
+	q.limit = n
+	return q
+}
+
+// run returns the matching documents after sort, skip, limit and projection
+func (q *embeddedQuery) run(project_docs bool) (docs []bson.M, err error) {
+	query, err := toDoc(q.query)
+	if err != nil {
+		return
+	}
+	var found []embeddedDoc
+	err = q.coll.read(func(tx *bolt.Tx) (err error) {
+		found, err = q.coll.find(tx, query, false)
+		return
+	})
+	if err != nil {
+		return
+	}
+	for _, d := range found {
+		docs = append(docs, d.doc)
+	}
+	if len(q.sort) > 0 {
+		sortDocs(docs, q.sort)
+	}
+	if q.skip > 0 {
+		if q.skip >= len(docs) {
+			docs = nil
+		} else {
+			docs = docs[q.skip:]
+		}
+	}
+	if q.limit > 0 && q.limit < len(docs) {
+		docs = docs[:q.limit]
+	}
+	if project_docs && q.selector != nil {
+		selector, xerr := toDoc(q.selector)
+		if xerr != nil {
+			return nil, xerr
+		}
+		for i := range docs {
+			docs[i] = project(docs[i], selector)
+		}
+	}
+	return
+}
+
+func (q *embeddedQuery) Count() (n int, err error) {
+	docs, err := q.run(false)
+	n = len(docs)
+	return
+}
+
+// decode converts a document to the result type like mgo does
+func decode(doc bson.M, result interface{}) (err error) {
+	if r, ok := result.(*interface{}); ok {
+		*r = doc
+		return
+	}
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return
+	}
+	return bson.Unmarshal(data, result)
+}
+
+func (q *embeddedQuery) One(result interface{}) (err error) {
+	docs, err := q.run(true)
+	if err != nil {
+		return
+	}
+	if len(docs) == 0 {
+		return ErrNotFound
+	}
+	return decode(docs[0], result)
+}
+
+func (q *embeddedQuery) All(result interface{}) (err error) {
+	docs, err := q.run(true)
+	if err != nil {
+		return
+	}
+	slicev := reflect.ValueOf(result)
+	if slicev.Kind() != reflect.Ptr || slicev.Elem().Kind() != reflect.Slice {
+		return errors.New("(embedded.All) result argument must be a slice address")
+	}
+	slicev = slicev.Elem()
+	slicev = slicev.Slice(0, 0)
+	elemt := slicev.Type().Elem()
+	for _, doc := range docs {
+		elemp := reflect.New(elemt)
+		if err = decode(doc, elemp.Interface()); err != nil {
+			return
+		}
+		slicev = reflect.Append(slicev, elemp.Elem())
+	}
+	reflect.ValueOf(result).Elem().Set(slicev)
+	return
+}
+
+func (q *embeddedQuery) Distinct(key string, result interface{}) (err error) {
+	docs, err := q.run(false)
+	if err != nil {
+		return
+	}
+	values := []interface{}{}
+	for _, doc := range docs {
+		for _, v := range lookup(doc, strings.Split(key, ".")) {
+			if _, isarr := v.([]interface{}); isarr {
+				continue
+			}
+			if !matchEq(values, v) || (v == nil && !containsNil(values)) {
+				values = append(values, v)
+			}
+		}
+	}
+	data, err := bson.Marshal(bson.M{"values": values})
+	if err != nil {
+		return
+	}
+	raw := bson.Raw{}
+	if err = bson.Unmarshal(data, &raw); err != nil {
+		return
+	}
+	var doc struct {
+		Values bson.Raw `bson:"values"`
+	}
+	if err = raw.Unmarshal(&doc); err != nil {
+		return
+	}
+	return doc.Values.Unmarshal(result)
+}
+
+func containsNil(values []interface{}) bool {
+	for _, v := range values {
+		if v == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+type testTask struct {
+	TaskId    string `bson:"taskid"`
+	State     string `bson:"state"`
+	Remaining int    `bson:"remaining"`
+}
+
+type testJob struct {
+	Id    string      `bson:"id"`
+	State string      `bson:"state"`
+	Owner string      `bson:"owner"`
+	Read  []string    `bson:"read"`
+	Tasks []*testTask `bson:"tasks"`
+}
+
+func openTestStore(t *testing.T) (s Store, cleanup func()) {
+	dir, err := ioutil.TempDir("", "awe-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = OpenEmbedded(path.Join(dir, "awe.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEmbeddedFindAndUpdate(t *testing.T) {
+	s, cleanup := openTestStore(t)
+	defer cleanup()
+	c := s.C("Jobs")
+	if err := c.EnsureIndex(Index{Key: []string{"id"}, Unique: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, j := range []*testJob{
+		{Id: "j1", State: "queued", Owner: "alice", Read: []string{"alice", "bob"}, Tasks: []*testTask{{TaskId: "0", State: "init"}, {TaskId: "1", State: "init"}}},
+		{Id: "j2", State: "completed", Owner: "bob", Read: []string{"bob"}},
+		{Id: "j3", State: "suspend", Owner: "carol", Read: []string{"public"}},
+	} {
+		if _, err := c.Upsert(bson.M{"id": j.Id}, j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := c.Insert(&testJob{Id: "j1"})
+	if !IsDup(err) {
+		t.Fatalf("expected duplicate key error, got %v", err)
+	}
+
+	jobs := []*testJob{}
+	q := bson.M{"state": bson.M{"$nin": []string{"completed", "deleted"}}, "$or": []bson.M{{"read": bson.M{"$in": []string{"bob", "public"}}}, {"owner": "bob"}}}
+	if err := c.Find(q).Sort("-id").All(&jobs); err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].Id != "j3" || jobs[1].Id != "j1" {
+		t.Fatalf("unexpected result of $or/$in query: %+v", jobs)
+	}
+	if n, err := c.Find(bson.M{"tasks.taskid": "1"}).Count(); err != nil || n != 1 {
+		t.Fatalf("expected one job with task 1, got %d (%v)", n, err)
+	}
+
+	// positional updates of array elements
+	if err := c.Update(bson.M{"id": "j1", "tasks.taskid": "1"}, bson.M{"$set": bson.M{"tasks.$.state": "queued"}, "$inc": bson.M{"tasks.$.remaining": 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Update(bson.M{"id": "j1"}, bson.M{"$push": bson.M{"tasks": &testTask{TaskId: "2", State: "init"}}}); err != nil {
+		t.Fatal(err)
+	}
+	job := &testJob{}
+	if err := c.Find(bson.M{"id": "j1"}).One(&job); err != nil {
+		t.Fatal(err)
+	}
+	if len(job.Tasks) != 3 || job.Tasks[0].State != "init" || job.Tasks[1].State != "queued" || job.Tasks[1].Remaining != 2 {
+		t.Fatalf("unexpected tasks after update: %+v %+v %+v", job.Tasks[0], job.Tasks[1], job.Tasks[2])
+	}
+
+	// $elemMatch projection returns the matching element only
+	result := bson.M{}
+	if err := c.Find(bson.M{"id": "j1"}).Select(bson.M{"tasks": bson.M{"$elemMatch": bson.M{"taskid": "1"}}}).One(&result); err != nil {
+		t.Fatal(err)
+	}
+	tasks, _ := result["tasks"].([]interface{})
+	if len(tasks) != 1 || tasks[0].(bson.M)["state"] != "queued" || result["owner"] != nil {
+		t.Fatalf("unexpected projection: %v", result)
+	}
+
+	if err := c.Update(bson.M{"id": "j9"}, bson.M{"$set": bson.M{"state": "x"}}); err != ErrNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if info, err := c.RemoveAll(bson.M{"state": "completed"}); err != nil || info.Removed != 1 {
+		t.Fatalf("RemoveAll: %+v %v", info, err)
+	}
+	if err := c.Find(bson.M{"id": "j2"}).One(&job); err != ErrNotFound {
+		t.Fatalf("expected j2 to be removed, got %v", err)
+	}
+	// the unique index entry of a removed document is free again
+	if err := c.Insert(&testJob{Id: "j2"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmbeddedUpsertAndPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awe-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "awe.db")
+
+	s, err := OpenEmbedded(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := s.C("Versions")
+	c.EnsureIndex(Index{Key: []string{"name"}, Unique: true})
+	for i := 0; i < 2; i++ {
+		if _, err := c.Upsert(bson.M{"name": "Job"}, bson.M{"$set": bson.M{"name": "Job", "version": 2}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	c.Insert(bson.M{"name": "Client", "version": 1, "time": now})
+	s.Close()
+
+	// indexes and documents survive reopening
+	s, err = OpenEmbedded(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c = s.C("Versions")
+	if n, _ := c.Find(nil).Count(); n != 2 {
+		t.Fatalf("expected 2 documents, got %d", n)
+	}
+	if err := c.Insert(bson.M{"name": "Job"}); !IsDup(err) {
+		t.Fatalf("expected duplicate key error after reopening, got %v", err)
+	}
+	var names []string
+	if err := c.Find(bson.M{"version": bson.M{"$gte": 1}, "time": bson.M{"$exists": false}}).Distinct("name", &names); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "Job" {
+		t.Fatalf("unexpected distinct names: %v", names)
+	}
+	var distinct interface{}
+	if err := c.Find(nil).Distinct("version", &distinct); err != nil {
+		t.Fatal(err)
+	}
+	if values, ok := distinct.([]interface{}); !ok || len(values) != 2 {
+		t.Fatalf("unexpected distinct versions: %#v", distinct)
+	}
+}
+
+func TestEmbeddedTransaction(t *testing.T) {
+	s, cleanup := openTestStore(t)
+	defer cleanup()
+	c := s.C("Jobs")
+	if err := c.Insert(&testJob{Id: "j1", State: "in-progress", Tasks: []*testTask{{TaskId: "0", State: "queued", Remaining: 2}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := s.RunTransaction(func(tx Store) error {
+		tc := tx.C("Jobs")
+		if err := tc.Update(bson.M{"id": "j1", "tasks.taskid": "0"}, bson.M{"$set": bson.M{"tasks.$.state": "completed"}}); err != nil {
+			return err
+		}
+		// the transaction sees its own changes
+		if n, err := tc.Find(bson.M{"tasks.state": "completed"}).Count(); err != nil || n != 1 {
+			t.Errorf("expected the update within the transaction, got %d (%v)", n, err)
+		}
+		return tc.Update(bson.M{"id": "j9"}, bson.M{"$inc": bson.M{"tasks.0.remaining": -1}})
+	})
+	if err != ErrNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	job := &testJob{}
+	if err = c.Find(bson.M{"id": "j1"}).One(job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Tasks[0].State != "queued" {
+		t.Fatalf("update of failed transaction was not rolled back: %+v", job.Tasks[0])
+	}
+
+	err = s.RunTransaction(func(tx Store) error {
+		tc := tx.C("Jobs")
+		if err := tc.Update(bson.M{"id": "j1", "tasks.taskid": "0"}, bson.M{"$set": bson.M{"tasks.$.state": "completed"}}); err != nil {
+			return err
+		}
+		return tc.Update(bson.M{"id": "j1"}, bson.M{"$inc": bson.M{"tasks.0.remaining": -1}})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Find(bson.M{"id": "j1"}).One(job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Tasks[0].State != "completed" || job.Tasks[0].Remaining != 1 {
+		t.Fatalf("unexpected task after transaction: %+v", job.Tasks[0])
+	}
+}
@@ -0,0 +1,773 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The embedded backend evaluates the subset of the mongodb query language used by AWE on decoded
+// documents (bson.M), see https://docs.mongodb.com/manual/reference/operator/
+
+// toDoc converts a document, query or update to its decoded bson form
+func toDoc(v interface{}) (doc bson.M, err error) {
+	doc = bson.M{}
+	if v == nil {
+		return
+	}
+	b, err := bson.Marshal(v)
+	if err != nil {
+		err = fmt.Errorf("(toDoc) bson.Marshal returned: %s", err.Error())
+		return
+	}
+	if err = bson.Unmarshal(b, &doc); err != nil {
+		err = fmt.Errorf("(toDoc) bson.Unmarshal returned: %s", err.Error())
+	}
+	return
+}
+
+// lookup returns the values at the dotted path, arrays on the path are traversed and arrays at the end
+// of the path yield the array and its elements, like mongodb does for queries
+func lookup(v interface{}, parts []string) (values []interface{}) {
+	if len(parts) == 0 {
+		values = append(values, v)
+		if arr, ok := v.([]interface{}); ok {
+			values = append(values, arr...)
+		}
+		return
+	}
+	switch t := v.(type) {
+	case bson.M:
+		if child, ok := t[parts[0]]; ok {
+			values = lookup(child, parts[1:])
+		}
+	case []interface{}:
+		if i, err := strconv.Atoi(parts[0]); err == nil {
+			if i >= 0 && i < len(t) {
+				values = append(values, lookup(t[i], parts[1:])...)
+			}
+		}
+		for _, e := range t {
+			if m, ok := e.(bson.M); ok {
+				values = append(values, lookup(m, parts)...)
+			}
+		}
+	}
+	return
+}
+
+// getPath returns the value at the dotted path without traversing arrays implicitly
+func getPath(v interface{}, parts []string) (value interface{}, ok bool) {
+	value = v
+	for _, p := range parts {
+		switch t := value.(type) {
+		case bson.M:
+			if value, ok = t[p]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, false
+			}
+			value = t[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// setPath sets the value at the dotted path and returns the updated container
+func setPath(container interface{}, parts []string, value interface{}) (result interface{}, err error) {
+	if len(parts) == 0 {
+		return value, nil
+	}
+	switch c := container.(type) {
+	case nil:
+		return setPath(bson.M{}, parts, value)
+	case bson.M:
+		if c[parts[0]], err = setPath(c[parts[0]], parts[1:], value); err != nil {
+			return
+		}
+		return c, nil
+	case []interface{}:
+		i, xerr := strconv.Atoi(parts[0])
+		if xerr != nil || i < 0 {
+			return nil, fmt.Errorf("(setPath) cannot use the part %s to traverse an array", parts[0])
+		}
+		for len(c) <= i {
+			c = append(c, nil)
+		}
+		if c[i], err = setPath(c[i], parts[1:], value); err != nil {
+			return
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("(setPath) cannot create field %s in a %T", parts[0], container)
+}
+
+// unsetPath removes the field at the dotted path, array elements are set to null
+func unsetPath(container interface{}, parts []string) {
+	if len(parts) == 0 {
+		return
+	}
+	switch c := container.(type) {
+	case bson.M:
+		if len(parts) == 1 {
+			delete(c, parts[0])
+			return
+		}
+		unsetPath(c[parts[0]], parts[1:])
+	case []interface{}:
+		i, err := strconv.Atoi(parts[0])
+		if err != nil || i < 0 || i >= len(c) {
+			return
+		}
+		if len(parts) == 1 {
+			c[i] = nil
+			return
+		}
+		unsetPath(c[i], parts[1:])
+	}
+}
+
+// matchDoc returns true if the document matches the query
+func matchDoc(doc bson.M, query bson.M) bool {
+	for k, v := range query {
+		switch k {
+		case "$or", "$and", "$nor":
+			list, _ := v.([]interface{})
+			matched := 0
+			for _, q := range list {
+				if qm, ok := q.(bson.M); ok && matchDoc(doc, qm) {
+					matched++
+				}
+			}
+			if (k == "$or" && matched == 0) || (k == "$and" && matched != len(list)) || (k == "$nor" && matched > 0) {
+				return false
+			}
+		default:
+			if !matchCondition(lookup(doc, strings.Split(k, ".")), v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// operators returns the condition if all its keys are operators
+func operators(cond interface{}) (ops bson.M, ok bool) {
+	ops, ok = cond.(bson.M)
+	if !ok || len(ops) == 0 {
+		return nil, false
+	}
+	for k := range ops {
+		if !strings.HasPrefix(k, "$") {
+			return nil, false
+		}
+	}
+	return ops, true
+}
+
+func matchCondition(values []interface{}, cond interface{}) bool {
+	ops, ok := operators(cond)
+	if !ok {
+		return matchEq(values, cond)
+	}
+	for op, arg := range ops {
+		if !matchOperator(values, op, arg, ops) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchOperator(values []interface{}, op string, arg interface{}, ops bson.M) bool {
+	switch op {
+	case "$eq":
+		return matchEq(values, arg)
+	case "$ne":
+		return !matchEq(values, arg)
+	case "$in":
+		list, _ := arg.([]interface{})
+		for _, a := range list {
+			if matchEq(values, a) {
+				return true
+			}
+		}
+		return false
+	case "$nin":
+		return !matchOperator(values, "$in", arg, ops)
+	case "$all":
+		list, _ := arg.([]interface{})
+		for _, a := range list {
+			if !matchEq(values, a) {
+				return false
+			}
+		}
+		return len(list) > 0
+	case "$gt", "$gte", "$lt", "$lte":
+		for _, v := range values {
+			c, ok := compare(v, arg)
+			if !ok {
+				continue
+			}
+			if (op == "$gt" && c > 0) || (op == "$gte" && c >= 0) || (op == "$lt" && c < 0) || (op == "$lte" && c <= 0) {
+				return true
+			}
+		}
+		return false
+	case "$exists":
+		return (len(values) > 0) == truthy(arg)
+	case "$regex":
+		pattern := fmt.Sprint(arg)
+		if r, ok := arg.(bson.RegEx); ok {
+			pattern = r.Pattern
+			ops = bson.M{"$options": r.Options}
+		}
+		re, err := compileRegex(pattern, fmt.Sprint(ops["$options"]))
+		if err != nil {
+			return false
+		}
+		for _, v := range values {
+			if s, ok := v.(string); ok && re.MatchString(s) {
+				return true
+			}
+		}
+		return false
+	case "$options":
+		// used by $regex
+		return true
+	case "$not":
+		return !matchCondition(values, arg)
+	case "$size":
+		for _, v := range values {
+			if arr, ok := v.([]interface{}); ok {
+				if c, ok := compare(len(arr), arg); ok && c == 0 {
+					return true
+				}
+			}
+		}
+		return false
+	case "$elemMatch":
+		for _, v := range values {
+			arr, ok := v.([]interface{})
+			if !ok {
+				continue
+			}
+			for _, e := range arr {
+				if matchElement(e, arg) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	// unsupported operators never match
+	return false
+}
+
+// matchElement matches an array element against the condition of $elemMatch
+func matchElement(e interface{}, cond interface{}) bool {
+	if _, ok := operators(cond); ok {
+		return matchCondition([]interface{}{e}, cond)
+	}
+	m, ok := e.(bson.M)
+	q, qok := cond.(bson.M)
+	return ok && qok && matchDoc(m, q)
+}
+
+func compileRegex(pattern string, options string) (*regexp.Regexp, error) {
+	flags := ""
+	for _, o := range options {
+		if o == 'i' || o == 'm' || o == 's' {
+			flags += string(o)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// matchEq matches values against a value, null matches missing fields and regular expressions match strings
+func matchEq(values []interface{}, cond interface{}) bool {
+	if cond == nil && len(values) == 0 {
+		return true
+	}
+	if r, ok := cond.(bson.RegEx); ok {
+		return matchOperator(values, "$regex", r, nil)
+	}
+	for _, v := range values {
+		if equal(v, cond) {
+			return true
+		}
+	}
+	return false
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	}
+	if f, ok := toFloat(v); ok {
+		return f != 0
+	}
+	return true
+}
+
+func toFloat(v interface{}) (f float64, ok bool) {
+	switch t := v.(type) {
+	case int:
+		return float64(t), true
+	case int32:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case float32:
+		return float64(t), true
+	case float64:
+		return t, true
+	}
+	return 0, false
+}
+
+func equal(a interface{}, b interface{}) bool {
+	if c, ok := compare(a, b); ok {
+		return c == 0
+	}
+	switch at := a.(type) {
+	case []interface{}:
+		bt, ok := b.([]interface{})
+		if !ok || len(at) != len(bt) {
+			return false
+		}
+		for i := range at {
+			if !equal(at[i], bt[i]) {
+				return false
+			}
+		}
+		return true
+	case bson.M:
+		bt, ok := b.(bson.M)
+		if !ok || len(at) != len(bt) {
+			return false
+		}
+		for k, v := range at {
+			if w, ok := bt[k]; !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compare compares scalars of the same kind, ok is false for values that cannot be compared
+func compare(a interface{}, b interface{}) (c int, ok bool) {
+	if af, aok := toFloat(a); aok {
+		bf, bok := toFloat(b)
+		if !bok {
+			return 0, false
+		}
+		return compareOrdered(af < bf, af > bf), true
+	}
+	switch at := a.(type) {
+	case string:
+		if bt, ok := b.(string); ok {
+			return strings.Compare(at, bt), true
+		}
+	case bson.ObjectId:
+		if bt, ok := b.(bson.ObjectId); ok {
+			return strings.Compare(string(at), string(bt)), true
+		}
+	case time.Time:
+		if bt, ok := b.(time.Time); ok {
+			return compareOrdered(at.Before(bt), at.After(bt)), true
+		}
+	case bool:
+		if bt, ok := b.(bool); ok {
+			return compareOrdered(!at && bt, at && !bt), true
+		}
+	case nil:
+		if b == nil {
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+func compareOrdered(less bool, greater bool) int {
+	if less {
+		return -1
+	}
+	if greater {
+		return 1
+	}
+	return 0
+}
+
+// typeRank orders values of different kinds for sorting, like the bson comparison order of mongodb
+func typeRank(v interface{}) int {
+	if _, ok := toFloat(v); ok {
+		return 1
+	}
+	switch v.(type) {
+	case nil:
+		return 0
+	case string:
+		return 2
+	case bson.M:
+		return 3
+	case []interface{}:
+		return 4
+	case bson.ObjectId:
+		return 5
+	case bool:
+		return 6
+	case time.Time:
+		return 7
+	}
+	return 8
+}
+
+func sortValue(doc bson.M, path string) interface{} {
+	values := lookup(doc, strings.Split(path, "."))
+	if len(values) == 0 {
+		return nil
+	}
+	// the array itself is the first value, sort by its first element
+	if arr, ok := values[0].([]interface{}); ok {
+		if len(arr) == 0 {
+			return nil
+		}
+		return arr[0]
+	}
+	return values[0]
+}
+
+// sortDocs sorts by the fields, a "-" prefix sorts descending
+func sortDocs(docs []bson.M, fields []string) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, f := range fields {
+			desc := strings.HasPrefix(f, "-")
+			f = strings.TrimPrefix(strings.TrimPrefix(f, "-"), "+")
+			a := sortValue(docs[i], f)
+			b := sortValue(docs[j], f)
+			c, ok := compare(a, b)
+			if !ok {
+				c = typeRank(a) - typeRank(b)
+			}
+			if c == 0 {
+				continue
+			}
+			if desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// projTree holds the included paths of a projection, a nil subtree includes the whole field
+type projTree map[string]projTree
+
+func (t projTree) add(parts []string) {
+	sub, ok := t[parts[0]]
+	if len(parts) == 1 {
+		t[parts[0]] = nil
+		return
+	}
+	if ok && sub == nil {
+		// the whole field is included already
+		return
+	}
+	if !ok {
+		sub = projTree{}
+		t[parts[0]] = sub
+	}
+	sub.add(parts[1:])
+}
+
+func (t projTree) apply(v interface{}) (result interface{}, ok bool) {
+	switch c := v.(type) {
+	case bson.M:
+		out := bson.M{}
+		for k, sub := range t {
+			child, ok := c[k]
+			if !ok {
+				continue
+			}
+			if sub == nil {
+				out[k] = child
+			} else if pc, ok := sub.apply(child); ok {
+				out[k] = pc
+			}
+		}
+		return out, true
+	case []interface{}:
+		out := []interface{}{}
+		for _, e := range c {
+			if pe, ok := t.apply(e); ok {
+				if _, isdoc := pe.(bson.M); isdoc {
+					out = append(out, pe)
+				}
+			}
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// project applies a projection with included or excluded fields and $elemMatch
+func project(doc bson.M, selector bson.M) bson.M {
+	if len(selector) == 0 {
+		return doc
+	}
+	include := false
+	for k, v := range selector {
+		if _, ok := v.(bson.M); ok || (k != "_id" && truthy(v)) {
+			include = true
+			break
+		}
+	}
+	if !include {
+		// exclusion of fields
+		out, _ := toDoc(doc)
+		for k := range selector {
+			unsetPath(out, strings.Split(k, "."))
+		}
+		return out
+	}
+
+	t := projTree{}
+	if v, ok := selector["_id"]; !ok || truthy(v) {
+		t["_id"] = nil
+	}
+	elem_match := bson.M{}
+	for k, v := range selector {
+		if m, ok := v.(bson.M); ok {
+			if cond, ok := m["$elemMatch"]; ok {
+				elem_match[k] = cond
+			}
+			continue
+		}
+		if k != "_id" && truthy(v) {
+			t.add(strings.Split(k, "."))
+		}
+	}
+	p, _ := t.apply(doc)
+	out := p.(bson.M)
+	// $elemMatch returns the first matching element only
+	for k, cond := range elem_match {
+		delete(out, k)
+		arr, ok := doc[k].([]interface{})
+		if !ok {
+			continue
+		}
+		for _, e := range arr {
+			if matchElement(e, cond) {
+				out[k] = []interface{}{e}
+				break
+			}
+		}
+	}
+	return out
+}
+
+// isOperatorUpdate returns true if the update uses update operators instead of replacing the document
+func isOperatorUpdate(update bson.M) bool {
+	for k := range update {
+		if strings.HasPrefix(k, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePositional replaces the positional operator "$" in the path with the index of the first
+// array element matched by the selector
+func resolvePositional(doc bson.M, path string, selector bson.M) (resolved string, err error) {
+	i := strings.Index(path+".", ".$.")
+	if i < 0 {
+		return path, nil
+	}
+	prefix := path[:i]
+	arr, ok := getPath(doc, strings.Split(prefix, "."))
+	elements, isarr := arr.([]interface{})
+	if !ok || !isarr {
+		return "", fmt.Errorf("(resolvePositional) %s is not an array", prefix)
+	}
+	sub := bson.M{}
+	var own interface{}
+	for k, v := range selector {
+		if k == prefix {
+			own = v
+		} else if strings.HasPrefix(k, prefix+".") {
+			sub[strings.TrimPrefix(k, prefix+".")] = v
+		}
+	}
+	if len(sub) == 0 && own == nil {
+		return "", fmt.Errorf("(resolvePositional) the positional operator did not find the match needed from the query")
+	}
+	for n, e := range elements {
+		if own != nil {
+			if ops, ok := operators(own); ok {
+				if m, ok := ops["$elemMatch"]; ok {
+					if !matchElement(e, m) {
+						continue
+					}
+				} else if !matchCondition([]interface{}{e}, own) {
+					continue
+				}
+			} else if !equal(e, own) {
+				continue
+			}
+		}
+		if len(sub) > 0 {
+			m, ok := e.(bson.M)
+			if !ok || !matchDoc(m, sub) {
+				continue
+			}
+		}
+		return prefix + "." + strconv.Itoa(n) + path[i+2:], nil
+	}
+	return "", fmt.Errorf("(resolvePositional) the positional operator did not find the match needed from the query")
+}
+
+// applyUpdate applies the update to the document, insert is true if the document is created by an upsert
+func applyUpdate(doc bson.M, update bson.M, selector bson.M, insert bool) (err error) {
+	if !isOperatorUpdate(update) {
+		id := doc["_id"]
+		for k := range doc {
+			delete(doc, k)
+		}
+		for k, v := range update {
+			doc[k] = v
+		}
+		if id != nil {
+			doc["_id"] = id
+		}
+		return
+	}
+	for op, fields := range update {
+		fm, ok := fields.(bson.M)
+		if !ok {
+			return fmt.Errorf("(applyUpdate) %s requires a document", op)
+		}
+		for path, value := range fm {
+			if path, err = resolvePositional(doc, path, selector); err != nil {
+				return
+			}
+			parts := strings.Split(path, ".")
+			current, _ := getPath(doc, parts)
+			switch op {
+			case "$set":
+				_, err = setPath(doc, parts, value)
+			case "$setOnInsert":
+				if insert {
+					_, err = setPath(doc, parts, value)
+				}
+			case "$unset":
+				unsetPath(doc, parts)
+			case "$inc":
+				var sum interface{}
+				if sum, err = addNumbers(current, value); err == nil {
+					_, err = setPath(doc, parts, sum)
+				}
+			case "$push", "$addToSet":
+				arr, _ := current.([]interface{})
+				if current != nil && arr == nil {
+					return fmt.Errorf("(applyUpdate) %s on %s which is not an array", op, path)
+				}
+				values := []interface{}{value}
+				if m, ok := value.(bson.M); ok {
+					if each, ok := m["$each"].([]interface{}); ok {
+						values = each
+					}
+				}
+				for _, v := range values {
+					if op == "$addToSet" && matchEq(arr, v) {
+						continue
+					}
+					arr = append(arr, v)
+				}
+				_, err = setPath(doc, parts, arr)
+			case "$pull":
+				arr, _ := current.([]interface{})
+				kept := []interface{}{}
+				for _, e := range arr {
+					if !matchElement(e, value) && !equal(e, value) {
+						kept = append(kept, e)
+					}
+				}
+				if current != nil {
+					_, err = setPath(doc, parts, kept)
+				}
+			default:
+				return fmt.Errorf("(applyUpdate) update operator %s is not supported", op)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// addNumbers adds like $inc, integers stay integers
+func addNumbers(a interface{}, b interface{}) (sum interface{}, err error) {
+	if a == nil {
+		return b, nil
+	}
+	switch at := a.(type) {
+	case int:
+		switch bt := b.(type) {
+		case int:
+			return at + bt, nil
+		case int64:
+			return int64(at) + bt, nil
+		}
+	case int64:
+		switch bt := b.(type) {
+		case int:
+			return at + int64(bt), nil
+		case int64:
+			return at + bt, nil
+		}
+	}
+	af, aok := toFloat(a)
+	bf, bok := toFloat(b)
+	if !aok || !bok {
+		return nil, fmt.Errorf("(addNumbers) cannot increment a %T by a %T", a, b)
+	}
+	return af + bf, nil
+}
+
+// upsertDoc creates the document inserted by an upsert from the equality conditions of the selector
+func upsertDoc(selector bson.M) (doc bson.M, err error) {
+	doc = bson.M{}
+	for k, v := range selector {
+		if strings.HasPrefix(k, "$") {
+			continue
+		}
+		if _, isop := operators(v); isop {
+			continue
+		}
+		if _, err = setPath(doc, strings.Split(k, "."), v); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,528 @@
+package db
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/logger"
+	mbson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The mongodb backend uses the official driver. Documents are still encoded with the mgo bson package,
+// so struct tags and query documents of AWE work unchanged, the driver only sees raw bson.
+// This is on purpose: gopkg.in/mgo.v2/bson is kept as the codec of all packages (and of the embedded
+// backend), only the bson subpackage is vendored, the mgo driver itself is no longer used.
+
+type mongoStore struct {
+	client       *mongo.Client
+	database     *mongo.Database
+	session      mongo.SessionContext // set within a transaction
+	transactions bool                 // false for standalone servers, which do not support transactions
+	timeout      time.Duration        // overrides DbTimeout, see CTimeout
+}
+
+type mongoCollection struct {
+	store *mongoStore
+	name  string
+}
+
+type mongoQuery struct {
+	coll     *mongoCollection
+	query    interface{}
+	selector interface{}
+	sort     []string
+	skip     int
+	limit    int
+}
+
+// mongoHosts returns the configured servers for messages, without credentials
+func mongoHosts() string {
+	if conf.MONGODB_URI == "" {
+		return conf.MONGODB_HOST
+	}
+	uri := conf.MONGODB_URI
+	if i := strings.Index(uri, "@"); i >= 0 {
+		if j := strings.Index(uri, "://"); j >= 0 && j < i {
+			uri = uri[:j+3] + uri[i+1:]
+		}
+	}
+	return uri
+}
+
+// mongoOptions creates the client options from the Mongodb section of the configuration
+func mongoOptions() (opts *options.ClientOptions, err error) {
+	opts = options.Client()
+	if conf.MONGODB_URI != "" {
+		opts.ApplyURI(conf.MONGODB_URI)
+	} else {
+		opts.ApplyURI("mongodb://" + conf.MONGODB_HOST)
+		if conf.MONGODB_USER != "" && conf.MONGODB_PASSWD != "" {
+			auth_source := conf.MONGODB_AUTH_SOURCE
+			if auth_source == "" {
+				auth_source = conf.MONGODB_DATABASE
+			}
+			opts.SetAuth(options.Credential{Username: conf.MONGODB_USER, Password: conf.MONGODB_PASSWD, AuthSource: auth_source})
+		}
+	}
+	if conf.MONGODB_REPLICA_SET != "" {
+		opts.SetReplicaSet(conf.MONGODB_REPLICA_SET)
+	}
+	if conf.MONGODB_TLS || conf.MONGODB_TLS_CA_FILE != "" {
+		tls_config := &tls.Config{MinVersion: tls.VersionTLS12}
+		if conf.MONGODB_TLS_CA_FILE != "" {
+			pem, xerr := ioutil.ReadFile(conf.MONGODB_TLS_CA_FILE)
+			if xerr != nil {
+				err = fmt.Errorf("(mongoOptions) reading tls_ca_file returned: %s", xerr.Error())
+				return
+			}
+			tls_config.RootCAs = x509.NewCertPool()
+			if !tls_config.RootCAs.AppendCertsFromPEM(pem) {
+				err = fmt.Errorf("(mongoOptions) no certificates found in %s", conf.MONGODB_TLS_CA_FILE)
+				return
+			}
+		}
+		opts.SetTLSConfig(tls_config)
+	}
+	opts.SetConnectTimeout(DialTimeout)
+	opts.SetServerSelectionTimeout(DialTimeout)
+	err = opts.Validate()
+	return
+}
+
+// OpenMongo connects to the configured mongodb servers
+func OpenMongo() (s Store, err error) {
+	opts, err := mongoOptions()
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DialTimeout)
+	defer cancel()
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return
+	}
+	if err = client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(context.Background())
+		return
+	}
+	ms := &mongoStore{client: client, database: client.Database(conf.MONGODB_DATABASE)}
+
+	// transactions need a replica set or a sharded cluster
+	var is_master struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if xerr := ms.database.RunCommand(ctx, mbson.D{{Key: "isMaster", Value: 1}}).Decode(&is_master); xerr == nil {
+		ms.transactions = is_master.SetName != "" || is_master.Msg == "isdbgrid"
+	}
+	if !ms.transactions {
+		logger.Warning("(OpenMongo) mongodb at %s is a standalone server, updates of jobs and tasks run without transactions", mongoHosts())
+	}
+	s = ms
+	return
+}
+
+// context returns the session of the transaction or a context with the configured timeout
+func (s *mongoStore) context() (ctx context.Context, cancel context.CancelFunc) {
+	if s.session != nil {
+		return s.session, func() {}
+	}
+	if s.timeout > 0 {
+		return context.WithTimeout(context.Background(), s.timeout)
+	}
+	return context.WithTimeout(context.Background(), DbTimeout)
+}
+
+func (s *mongoStore) C(name string) Collection {
+	return &mongoCollection{store: s, name: name}
+}
+
+func (s *mongoStore) RunTransaction(f func(tx Store) error) (err error) {
+	if s.session != nil || !s.transactions {
+		return f(s)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DbTimeout)
+	defer cancel()
+	return s.client.UseSession(ctx, func(sc mongo.SessionContext) error {
+		// WithTransaction retries f on transient errors
+		_, xerr := sc.WithTransaction(sc, func(tc mongo.SessionContext) (interface{}, error) {
+			return nil, f(&mongoStore{client: s.client, database: s.database, session: tc, transactions: true})
+		})
+		return xerr
+	})
+}
+
+func (s *mongoStore) Drop() error {
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.database.Drop(ctx)
+}
+
+func (s *mongoStore) Close() error {
+	if s.session != nil {
+		return errors.New("(mongoStore.Close) cannot close a transaction")
+	}
+	return s.client.Disconnect(context.Background())
+}
+
+func (s *mongoStore) collectionNames() (names []string, err error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.database.ListCollectionNames(ctx, mbson.D{})
+}
+
+func (s *mongoStore) renameCollection(from string, to string) (err error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	name := s.database.Name()
+	cmd := mbson.D{{Key: "renameCollection", Value: name + "." + from}, {Key: "to", Value: name + "." + to}}
+	return s.client.Database("admin").RunCommand(ctx, cmd).Err()
+}
+
+// toRaw encodes a document with the mgo bson package
+func toRaw(doc interface{}) (raw mbson.Raw, err error) {
+	if doc == nil {
+		doc = bson.M{}
+	}
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		err = fmt.Errorf("(toRaw) bson.Marshal returned: %s", err.Error())
+		return
+	}
+	raw = mbson.Raw(data)
+	return
+}
+
+// decodeRaw decodes a document returned by the driver into result like mgo does
+func decodeRaw(raw mbson.Raw, result interface{}) (err error) {
+	if r, ok := result.(*interface{}); ok {
+		doc := bson.M{}
+		if err = bson.Unmarshal(raw, &doc); err != nil {
+			return
+		}
+		*r = doc
+		return
+	}
+	return bson.Unmarshal(raw, result)
+}
+
+// convertError maps driver errors to the ones returned by all backends
+func convertError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return &dupKeyError{msg: err.Error()}
+	}
+	return err
+}
+
+// convertId converts ids generated by the driver to the mgo type
+func convertId(id interface{}) interface{} {
+	if oid, ok := id.(primitive.ObjectID); ok {
+		return bson.ObjectId(string(oid[:]))
+	}
+	return id
+}
+
+// isReplacement returns true if the update is a whole document instead of update operators
+func isReplacement(update mbson.Raw) bool {
+	elements, err := update.Elements()
+	if err != nil || len(elements) == 0 {
+		return false
+	}
+	return !strings.HasPrefix(elements[0].Key(), "$")
+}
+
+func (c *mongoCollection) collection() *mongo.Collection {
+	return c.store.database.Collection(c.name)
+}
+
+func (c *mongoCollection) Find(query interface{}) Query {
+	return &mongoQuery{coll: c, query: query}
+}
+
+func (c *mongoCollection) Insert(docs ...interface{}) (err error) {
+	raws := make([]interface{}, len(docs))
+	for i, d := range docs {
+		if raws[i], err = toRaw(d); err != nil {
+			return
+		}
+	}
+	ctx, cancel := c.store.context()
+	defer cancel()
+	_, err = c.collection().InsertMany(ctx, raws)
+	return convertError(err)
+}
+
+// update runs updates with operators and replacements of the whole document
+func (c *mongoCollection) update(selector interface{}, update interface{}, all bool, upsert bool) (info *ChangeInfo, err error) {
+	filter, err := toRaw(selector)
+	if err != nil {
+		return
+	}
+	up, err := toRaw(update)
+	if err != nil {
+		return
+	}
+	ctx, cancel := c.store.context()
+	defer cancel()
+	var result *mongo.UpdateResult
+	switch {
+	case isReplacement(up):
+		if all {
+			err = errors.New("(mongoCollection.update) UpdateAll needs update operators")
+			return
+		}
+		result, err = c.collection().ReplaceOne(ctx, filter, up, options.Replace().SetUpsert(upsert))
+	case all:
+		result, err = c.collection().UpdateMany(ctx, filter, up, options.Update().SetUpsert(upsert))
+	default:
+		result, err = c.collection().UpdateOne(ctx, filter, up, options.Update().SetUpsert(upsert))
+	}
+	if err != nil {
+		err = convertError(err)
+		return
+	}
+	info = &ChangeInfo{Updated: int(result.MatchedCount), UpsertedId: convertId(result.UpsertedID)}
+	if !all && !upsert && result.MatchedCount == 0 {
+		err = ErrNotFound
+	}
+	return
+}
+
+func (c *mongoCollection) Update(selector interface{}, update interface{}) (err error) {
+	_, err = c.update(selector, update, false, false)
+	return
+}
+
+func (c *mongoCollection) UpdateAll(selector interface{}, update interface{}) (info *ChangeInfo, err error) {
+	return c.update(selector, update, true, false)
+}
+
+func (c *mongoCollection) Upsert(selector interface{}, update interface{}) (info *ChangeInfo, err error) {
+	return c.update(selector, update, false, true)
+}
+
+func (c *mongoCollection) Remove(selector interface{}) (err error) {
+	filter, err := toRaw(selector)
+	if err != nil {
+		return
+	}
+	ctx, cancel := c.store.context()
+	defer cancel()
+	result, err := c.collection().DeleteOne(ctx, filter)
+	if err != nil {
+		return convertError(err)
+	}
+	if result.DeletedCount == 0 {
+		err = ErrNotFound
+	}
+	return
+}
+
+func (c *mongoCollection) RemoveAll(selector interface{}) (info *ChangeInfo, err error) {
+	filter, err := toRaw(selector)
+	if err != nil {
+		return
+	}
+	ctx, cancel := c.store.context()
+	defer cancel()
+	result, err := c.collection().DeleteMany(ctx, filter)
+	if err != nil {
+		err = convertError(err)
+		return
+	}
+	info = &ChangeInfo{Removed: int(result.DeletedCount)}
+	return
+}
+
+// sortDocument converts mgo style sort fields, e.g. "-updatetime", to a sort document
+func sortDocument(fields []string) (sort mbson.D) {
+	for _, field := range fields {
+		order := 1
+		if strings.HasPrefix(field, "-") {
+			order = -1
+		}
+		field = strings.TrimPrefix(strings.TrimPrefix(field, "-"), "+")
+		if field != "" {
+			sort = append(sort, mbson.E{Key: field, Value: order})
+		}
+	}
+	return
+}
+
+func (c *mongoCollection) EnsureIndex(index Index) (err error) {
+	keys := sortDocument(index.Key)
+	if len(keys) == 0 {
+		return errors.New("(EnsureIndex) index has no keys")
+	}
+	opts := options.Index()
+	if index.Unique {
+		opts.SetUnique(true)
+	}
+	ctx, cancel := c.store.context()
+	defer cancel()
+	_, err = c.collection().Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys, Options: opts})
+	return convertError(err)
+}
+
+func (q *mongoQuery) Select(selector interface{}) Query {
+	q.selector = selector
+	return q
+}
+
+func (q *mongoQuery) Sort(fields ...string) Query {
+	q.sort = fields
+	return q
+}
+
+func (q *mongoQuery) Skip(n int) Query {
+	q.skip = n
+	return q
+}
+
+func (q *mongoQuery) Limit(n int) Query {
+	q.limit = n
+	return q
+}
+
+func (q *mongoQuery) findOptions() (opts *options.FindOptions, err error) {
+	opts = options.Find()
+	if q.selector != nil {
+		var projection mbson.Raw
+		if projection, err = toRaw(q.selector); err != nil {
+			return
+		}
+		opts.SetProjection(projection)
+	}
+	if len(q.sort) > 0 {
+		opts.SetSort(sortDocument(q.sort))
+	}
+	if q.skip > 0 {
+		opts.SetSkip(int64(q.skip))
+	}
+	if q.limit > 0 {
+		opts.SetLimit(int64(q.limit))
+	}
+	return
+}
+
+func (q *mongoQuery) Count() (n int, err error) {
+	filter, err := toRaw(q.query)
+	if err != nil {
+		return
+	}
+	opts := options.Count()
+	if q.skip > 0 {
+		opts.SetSkip(int64(q.skip))
+	}
+	if q.limit > 0 {
+		opts.SetLimit(int64(q.limit))
+	}
+	ctx, cancel := q.coll.store.context()
+	defer cancel()
+	count, err := q.coll.collection().CountDocuments(ctx, filter, opts)
+	n = int(count)
+	return
+}
+
+func (q *mongoQuery) One(result interface{}) (err error) {
+	q.limit = 1
+	return q.each(func(raw mbson.Raw) (bool, error) {
+		return false, decodeRaw(raw, result)
+	}, true)
+}
+
+func (q *mongoQuery) All(result interface{}) (err error) {
+	resultv := reflect.ValueOf(result)
+	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
+		return errors.New("(mongoQuery.All) result argument must be a slice address")
+	}
+	slicev := resultv.Elem().Slice(0, 0)
+	elemt := slicev.Type().Elem()
+	err = q.each(func(raw mbson.Raw) (bool, error) {
+		elemp := reflect.New(elemt)
+		if err := decodeRaw(raw, elemp.Interface()); err != nil {
+			return false, err
+		}
+		slicev = reflect.Append(slicev, elemp.Elem())
+		return true, nil
+	}, false)
+	if err != nil {
+		return
+	}
+	resultv.Elem().Set(slicev)
+	return
+}
+
+// each calls f with every document of the result until f returns false
+func (q *mongoQuery) each(f func(raw mbson.Raw) (bool, error), one bool) (err error) {
+	filter, err := toRaw(q.query)
+	if err != nil {
+		return
+	}
+	opts, err := q.findOptions()
+	if err != nil {
+		return
+	}
+	ctx, cancel := q.coll.store.context()
+	defer cancel()
+	cursor, err := q.coll.collection().Find(ctx, filter, opts)
+	if err != nil {
+		return convertError(err)
+	}
+	defer cursor.Close(ctx)
+	found := false
+	for cursor.Next(ctx) {
+		found = true
+		more, xerr := f(cursor.Current)
+		if xerr != nil || !more {
+			return xerr
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return
+	}
+	if one && !found {
+		err = ErrNotFound
+	}
+	return
+}
+
+func (q *mongoQuery) Distinct(key string, result interface{}) (err error) {
+	filter, err := toRaw(q.query)
+	if err != nil {
+		return
+	}
+	ctx, cancel := q.coll.store.context()
+	defer cancel()
+	cmd := mbson.D{{Key: "distinct", Value: q.coll.name}, {Key: "key", Value: key}, {Key: "query", Value: filter}}
+	raw, err := q.coll.store.database.RunCommand(ctx, cmd).Raw()
+	if err != nil {
+		return
+	}
+	var doc struct {
+		Values bson.Raw `bson:"values"`
+	}
+	if err = bson.Unmarshal(raw, &doc); err != nil {
+		return
+	}
+	return doc.Values.Unmarshal(result)
+}
@@ -15,6 +15,7 @@ const (
 	ClientNotSuspended       = "Client not suspended"
 	ClientDeleted            = "Client deleted"
 	ClientBusy               = "Client busy"
+	ClientDraining           = "Client draining"
 	ClientGroupBadName       = "Clientgroup name in token does not match that in the client."
 	InvalidFileTypeForFilter = "Invalid file type for filter"
 	InvalidIndex             = "Invalid Index"
@@ -26,4 +27,5 @@ const (
 	QueueSuspend             = "Server queue is suspended"
 	UnAuth                   = "User Unauthorized"
 	ServerNotFound           = "Server not found"
+	ChecksumMismatch         = "Checksum mismatch"
 )
@@ -0,0 +1,337 @@
+package prov
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+)
+
+// Document is a W3C PROV-JSON document (https://www.w3.org/Submission/prov-json/)
+type Document struct {
+	Prefix            map[string]string `json:"prefix"`
+	Entity            map[string]Record `json:"entity,omitempty"`
+	Activity          map[string]Record `json:"activity,omitempty"`
+	Agent             map[string]Record `json:"agent,omitempty"`
+	Used              map[string]Record `json:"used,omitempty"`
+	WasGeneratedBy    map[string]Record `json:"wasGeneratedBy,omitempty"`
+	WasAssociatedWith map[string]Record `json:"wasAssociatedWith,omitempty"`
+	WasDerivedFrom    map[string]Record `json:"wasDerivedFrom,omitempty"`
+	WasInformedBy     map[string]Record `json:"wasInformedBy,omitempty"`
+	namespaces        map[string]string // namespace -> prefix
+	relations         int
+}
+
+type Record map[string]interface{}
+
+// dataItem is an input, predata or output file of a task
+type dataItem struct {
+	Iri      string
+	FileName string
+	Url      string
+	Node     string
+	MD5      string
+	Size     int64
+}
+
+// workItem is a workunit of a task as recorded in the job perf
+type workItem struct {
+	Id   string
+	Rank int
+	Perf *core.WorkPerf
+}
+
+func newDataItem(io *core.IO) (item *dataItem) {
+	item = &dataItem{FileName: io.FileName, Size: io.Size}
+	if io.StorageScheme() == core.STORAGE_SHOCK {
+		if io.Node == "" || io.Node == "-" {
+			return nil
+		}
+		item.Node = io.Node
+		item.Iri = strings.TrimSuffix(io.Host, "/") + "/node/" + io.Node
+		item.Url, _ = io.DataUrl()
+	} else {
+		if io.Url == "" {
+			return nil
+		}
+		item.Iri = io.Url
+		item.Url = io.Url
+	}
+	// only recorded checksums are exported, Shock is not queried for every file
+	item.MD5 = io.MD5
+	return
+}
+
+func dataItems(ios []*core.IO) (items []*dataItem) {
+	for _, io := range ios {
+		if io.NoFile {
+			continue
+		}
+		if item := newDataItem(io); item != nil {
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+// workItems returns the workunits of the task, sorted by rank
+func workItems(perf *core.JobPerf, task_str string) (items []*workItem) {
+	if perf == nil {
+		return
+	}
+	for work_str, work_perf := range perf.Pworks {
+		if !strings.HasPrefix(work_str, task_str+"_") {
+			continue
+		}
+		rank, err := strconv.Atoi(strings.TrimPrefix(work_str, task_str+"_"))
+		if err != nil {
+			continue
+		}
+		items = append(items, &workItem{Id: work_str, Rank: rank, Perf: work_perf})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Rank < items[j].Rank })
+	return
+}
+
+// dockerImageIds returns the distinct docker image ids the workunits ran with
+func dockerImageIds(works []*workItem) (ids []string) {
+	seen := make(map[string]bool)
+	for _, work := range works {
+		id := work.Perf.DockerImageId
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return
+}
+
+// toolDescription returns the command or CWL tool definition of the task
+func toolDescription(task *core.Task) (tool Record) {
+	tool = Record{}
+	if task.Cmd != nil {
+		if task.Cmd.Name != "" {
+			tool["name"] = task.Cmd.Name
+		}
+		if task.Cmd.Args != "" {
+			tool["args"] = task.Cmd.Args
+		}
+		if len(task.Cmd.ArgsArray) > 0 {
+			tool["args_array"] = task.Cmd.ArgsArray
+		}
+		if len(task.Cmd.Environ.Public) > 0 {
+			tool["environ"] = task.Cmd.Environ.Public
+		}
+		if task.Cmd.Dockerimage != "" {
+			tool["dockerimage"] = task.Cmd.Dockerimage
+		}
+		if task.Cmd.DockerPull != "" {
+			tool["dockerpull"] = task.Cmd.DockerPull
+		}
+	}
+	if task.WorkflowStep != nil {
+		tool["cwl_step"] = task.WorkflowStep.Id
+		if task.WorkflowStep.Run != nil {
+			tool["cwl_tool"] = task.WorkflowStep.Run
+		}
+	}
+	return
+}
+
+func timeString(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func taskString(task *core.Task) string {
+	task_str, err := task.String()
+	if err != nil {
+		return task.Id
+	}
+	return task_str
+}
+
+// checkCompleted returns an error if the job did not complete, provenance of other jobs is incomplete
+func checkCompleted(job *core.Job) (err error) {
+	if job.State != core.JOB_STAT_COMPLETED {
+		err = fmt.Errorf("job %s is %s, provenance can only be exported for completed jobs", job.Id, job.State)
+	}
+	return
+}
+
+func loadJobPerf(job *core.Job) (perf *core.JobPerf) {
+	perf, err := core.LoadJobPerf(job.Id)
+	if err != nil {
+		return nil
+	}
+	return
+}
+
+func newDocument() (doc *Document) {
+	doc = &Document{
+		Prefix:            map[string]string{"awe": strings.TrimSuffix(conf.API_URL, "/") + "/"},
+		Entity:            map[string]Record{},
+		Activity:          map[string]Record{},
+		Agent:             map[string]Record{},
+		Used:              map[string]Record{},
+		WasGeneratedBy:    map[string]Record{},
+		WasAssociatedWith: map[string]Record{},
+		WasDerivedFrom:    map[string]Record{},
+		WasInformedBy:     map[string]Record{},
+		namespaces:        map[string]string{},
+	}
+	return
+}
+
+// qname converts an IRI into a qualified name, a prefix is added for every namespace
+func (doc *Document) qname(iri string, base string) string {
+	i := strings.LastIndex(iri, "/")
+	namespace, local := iri[:i+1], iri[i+1:]
+	prefix, ok := doc.namespaces[namespace]
+	if !ok {
+		prefix = base
+		for n := 1; ; n++ {
+			if _, taken := doc.Prefix[prefix]; !taken {
+				break
+			}
+			prefix = fmt.Sprintf("%s%d", base, n)
+		}
+		doc.Prefix[prefix] = namespace
+		doc.namespaces[namespace] = prefix
+	}
+	return prefix + ":" + local
+}
+
+func (doc *Document) relation(relations map[string]Record, record Record) {
+	doc.relations += 1
+	relations[fmt.Sprintf("_:r%d", doc.relations)] = record
+}
+
+func (doc *Document) addData(item *dataItem) (id string) {
+	base := "data"
+	if item.Node != "" {
+		base = "shock"
+	}
+	id = doc.qname(item.Iri, base)
+	if _, ok := doc.Entity[id]; ok {
+		return
+	}
+	entity := Record{"prov:label": item.FileName}
+	if item.Url != "" {
+		entity["prov:location"] = item.Url
+	}
+	if item.MD5 != "" {
+		entity["awe:md5"] = item.MD5
+	}
+	if item.Size > 0 {
+		entity["awe:size"] = item.Size
+	}
+	doc.Entity[id] = entity
+	return
+}
+
+// ExportProv builds a PROV-JSON graph of the job: tasks are activities that used input entities
+// and generated output entities following a plan (command or CWL tool), workers are software agents.
+func ExportProv(job *core.Job) (doc *Document, err error) {
+	if err = checkCompleted(job); err != nil {
+		return
+	}
+	doc = buildProv(job, loadJobPerf(job))
+	return
+}
+
+func buildProv(job *core.Job, perf *core.JobPerf) (doc *Document) {
+	doc = newDocument()
+
+	job_id := "awe:job/" + job.Id
+	job_activity := Record{"prov:type": "awe:Job", "prov:label": job.Info.Name}
+	if !job.Info.SubmitTime.IsZero() {
+		job_activity["prov:startTime"] = timeString(job.Info.SubmitTime)
+	}
+	if !job.Info.CompletedTime.IsZero() {
+		job_activity["prov:endTime"] = timeString(job.Info.CompletedTime)
+	}
+	job_activity["awe:state"] = job.State
+	if job.Info.Pipeline != "" {
+		job_activity["awe:pipeline"] = job.Info.Pipeline
+	}
+	if job.IsCWL && job.CWL_job_input != nil {
+		job_activity["awe:cwl_job_input"] = job.CWL_job_input
+	}
+	doc.Activity[job_id] = job_activity
+
+	if job.Info.User != "" {
+		user_id := "awe:user/" + job.Info.User
+		doc.Agent[user_id] = Record{"prov:type": "prov:Person"}
+		doc.relation(doc.WasAssociatedWith, Record{"prov:activity": job_id, "prov:agent": user_id})
+	}
+
+	for _, task := range job.Tasks {
+		task_str := taskString(task)
+		task_id := "awe:task/" + task_str
+		activity := Record{"prov:type": "awe:Task", "prov:label": task.Id, "awe:job": job_id}
+		if !task.StartedDate.IsZero() {
+			activity["prov:startTime"] = timeString(task.StartedDate)
+		}
+		if !task.CompletedDate.IsZero() {
+			activity["prov:endTime"] = timeString(task.CompletedDate)
+		}
+		activity["awe:state"] = task.State
+		doc.Activity[task_id] = activity
+		doc.relation(doc.WasInformedBy, Record{"prov:informed": task_id, "prov:informant": job_id})
+
+		works := workItems(perf, task_str)
+
+		plan_id := "awe:tool/" + task_str
+		plan := Record{"prov:type": "prov:Plan"}
+		for key, value := range toolDescription(task) {
+			plan["awe:"+key] = value
+		}
+		if image_ids := dockerImageIds(works); len(image_ids) > 0 {
+			plan["awe:docker_image_id"] = image_ids
+		}
+		doc.Entity[plan_id] = plan
+
+		inputs := []string{}
+		for _, item := range append(dataItems(task.Inputs), dataItems(task.Predata)...) {
+			data_id := doc.addData(item)
+			inputs = append(inputs, data_id)
+			doc.relation(doc.Used, Record{"prov:activity": task_id, "prov:entity": data_id})
+		}
+		for _, item := range dataItems(task.Outputs) {
+			data_id := doc.addData(item)
+			generated := Record{"prov:entity": data_id, "prov:activity": task_id}
+			if !task.CompletedDate.IsZero() {
+				generated["prov:time"] = timeString(task.CompletedDate)
+			}
+			doc.relation(doc.WasGeneratedBy, generated)
+			for _, input_id := range inputs {
+				doc.relation(doc.WasDerivedFrom, Record{"prov:generatedEntity": data_id, "prov:usedEntity": input_id, "prov:activity": task_id})
+			}
+		}
+
+		if len(works) == 0 {
+			doc.relation(doc.WasAssociatedWith, Record{"prov:activity": task_id, "prov:plan": plan_id})
+		}
+		for _, work := range works {
+			association := Record{"prov:activity": task_id, "prov:plan": plan_id, "awe:workunit": work.Id}
+			if work.Perf.ClientId != "" {
+				client_id := "awe:client/" + work.Perf.ClientId
+				doc.Agent[client_id] = Record{"prov:type": "prov:SoftwareAgent"}
+				association["prov:agent"] = client_id
+			}
+			association["awe:runtime"] = work.Perf.Runtime
+			if work.Perf.MaxMemUsage > 0 {
+				association["awe:max_mem_usage"] = work.Perf.MaxMemUsage
+			}
+			if work.Perf.DockerImageId != "" {
+				association["awe:docker_image_id"] = work.Perf.DockerImageId
+			}
+			doc.relation(doc.WasAssociatedWith, association)
+		}
+	}
+	return
+}
@@ -0,0 +1,206 @@
+package prov
+
+import (
+	"strings"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+)
+
+// Crate is the ro-crate-metadata.json of an RO-Crate (https://www.researchobject.org/ro-crate/)
+// following the Workflow Run Crate profile
+type Crate struct {
+	Context []interface{} `json:"@context"`
+	Graph   []Record      `json:"@graph"`
+	ids     map[string]bool
+}
+
+func ref(id string) Record {
+	return Record{"@id": id}
+}
+
+func (crate *Crate) add(entity Record) {
+	id := entity["@id"].(string)
+	if crate.ids[id] {
+		return
+	}
+	crate.ids[id] = true
+	crate.Graph = append(crate.Graph, entity)
+}
+
+// ExportROCrate builds RO-Crate metadata of the job: every task is a CreateAction with the tool as instrument,
+// the input files and parameters as object and the output files as result.
+func ExportROCrate(job *core.Job) (crate *Crate, err error) {
+	if err = checkCompleted(job); err != nil {
+		return
+	}
+	crate = buildROCrate(job, loadJobPerf(job))
+	return
+}
+
+func buildROCrate(job *core.Job, perf *core.JobPerf) (crate *Crate) {
+	api_url := strings.TrimSuffix(conf.API_URL, "/")
+	crate = &Crate{
+		Context: []interface{}{
+			"https://w3id.org/ro/crate/1.1/context",
+			"https://w3id.org/ro/terms/workflow-run/context",
+			map[string]string{"md5": "https://w3id.org/ro/terms/workflow-run#md5", "awe": api_url + "/"},
+		},
+		Graph: []Record{},
+		ids:   map[string]bool{},
+	}
+	job_url := api_url + "/job/" + job.Id
+
+	crate.add(Record{
+		"@id":        "ro-crate-metadata.json",
+		"@type":      "CreativeWork",
+		"about":      ref("./"),
+		"conformsTo": ref("https://w3id.org/ro/crate/1.1"),
+	})
+
+	root := Record{
+		"@id":         "./",
+		"@type":       "Dataset",
+		"name":        job.Info.Name,
+		"identifier":  job_url,
+		"description": "AWE job " + job.Id,
+		"mentions":    ref(job_url),
+	}
+	if !job.Info.CompletedTime.IsZero() {
+		root["datePublished"] = timeString(job.Info.CompletedTime)
+	}
+	crate.add(root)
+
+	job_action := Record{
+		"@id":   job_url,
+		"@type": "CreateAction",
+		"name":  "AWE job " + job.Info.Name,
+	}
+	if !job.Info.StartedTime.IsZero() {
+		job_action["startTime"] = timeString(job.Info.StartedTime)
+	}
+	if !job.Info.CompletedTime.IsZero() {
+		job_action["endTime"] = timeString(job.Info.CompletedTime)
+	}
+	if job.Info.User != "" {
+		job_action["agent"] = ref(api_url + "/user/" + job.Info.User)
+		crate.add(Record{"@id": api_url + "/user/" + job.Info.User, "@type": "Person", "name": job.Info.User})
+	}
+	crate.add(job_action)
+
+	has_part := []Record{}
+	for _, task := range job.Tasks {
+		task_str := taskString(task)
+		works := workItems(perf, task_str)
+
+		tool_id := api_url + "/job/" + job.Id + "#tool/" + task_str
+		tool := Record{"@id": tool_id, "@type": "SoftwareApplication", "name": task.Id}
+		if task.Cmd != nil && task.Cmd.Name != "" {
+			tool["name"] = task.Cmd.Name
+		}
+		description := toolDescription(task)
+		if cwl_tool, ok := description["cwl_tool"]; ok {
+			tool["awe:cwl_tool"] = cwl_tool
+		}
+		image_refs := []Record{}
+		if task.Cmd != nil && (task.Cmd.Dockerimage != "" || task.Cmd.DockerPull != "") {
+			image_name := task.Cmd.DockerPull
+			if image_name == "" {
+				image_name = task.Cmd.Dockerimage
+			}
+			image_ids := dockerImageIds(works)
+			if len(image_ids) == 0 {
+				image_ids = []string{""}
+			}
+			for _, image_id := range image_ids {
+				image := Record{"@type": "ContainerImage", "name": image_name, "additionalType": ref("https://w3id.org/ro/terms/workflow-run#DockerImage")}
+				if image_id != "" {
+					image["@id"] = "#container/" + image_id
+					image["sha256"] = strings.TrimPrefix(image_id, "sha256:")
+				} else {
+					image["@id"] = "#container/" + image_name
+				}
+				crate.add(image)
+				image_refs = append(image_refs, ref(image["@id"].(string)))
+			}
+		}
+		crate.add(tool)
+
+		object := []Record{}
+		for _, item := range append(dataItems(task.Inputs), dataItems(task.Predata)...) {
+			object = append(object, ref(crate.addFile(item)))
+		}
+		if args, ok := description["args"]; ok {
+			param_id := tool_id + "/args"
+			crate.add(Record{"@id": param_id, "@type": "PropertyValue", "name": "args", "value": args})
+			object = append(object, ref(param_id))
+		}
+		if args_array, ok := description["args_array"]; ok {
+			param_id := tool_id + "/args_array"
+			crate.add(Record{"@id": param_id, "@type": "PropertyValue", "name": "args_array", "value": args_array})
+			object = append(object, ref(param_id))
+		}
+		if environ, ok := description["environ"]; ok {
+			param_id := tool_id + "/environ"
+			crate.add(Record{"@id": param_id, "@type": "PropertyValue", "name": "environ", "value": environ})
+			object = append(object, ref(param_id))
+		}
+
+		result := []Record{}
+		for _, item := range dataItems(task.Outputs) {
+			file_id := crate.addFile(item)
+			result = append(result, ref(file_id))
+			has_part = append(has_part, ref(file_id))
+		}
+
+		action := Record{
+			"@id":        api_url + "/job/" + job.Id + "#task/" + task_str,
+			"@type":      "CreateAction",
+			"name":       "task " + task.Id,
+			"instrument": ref(tool_id),
+			"object":     object,
+			"result":     result,
+			"isPartOf":   ref(job_url),
+		}
+		if len(image_refs) > 0 {
+			action["containerImage"] = image_refs
+		}
+		if !task.StartedDate.IsZero() {
+			action["startTime"] = timeString(task.StartedDate)
+		}
+		if !task.CompletedDate.IsZero() {
+			action["endTime"] = timeString(task.CompletedDate)
+		}
+		agents := []Record{}
+		for _, work := range works {
+			if work.Perf.ClientId == "" {
+				continue
+			}
+			client_id := api_url + "/client/" + work.Perf.ClientId
+			crate.add(Record{"@id": client_id, "@type": "SoftwareApplication", "name": "AWE client " + work.Perf.ClientId})
+			agents = append(agents, ref(client_id))
+		}
+		if len(agents) > 0 {
+			action["agent"] = agents
+		}
+		crate.add(action)
+	}
+	root["hasPart"] = has_part
+	return
+}
+
+func (crate *Crate) addFile(item *dataItem) (id string) {
+	id = item.Iri
+	file := Record{"@id": id, "@type": "File", "name": item.FileName}
+	if item.Url != "" && item.Url != id {
+		file["contentUrl"] = item.Url
+	}
+	if item.Size > 0 {
+		file["contentSize"] = item.Size
+	}
+	if item.MD5 != "" {
+		file["md5"] = item.MD5
+	}
+	crate.add(file)
+	return
+}
@@ -6,12 +6,15 @@ const (
 	CLIENT_REGISTRATION = "CR" //client registered (for the first time)
 	CLIENT_AUTO_REREGI  = "CA" //client automatically re-registered
 	CLIENT_UNREGISTER   = "CU" //client unregistered
+	CLIENT_DRAIN        = "CD" //client asked to finish its work and exit
 	WORK_CHECKOUT       = "WC" //workunit checkout
 	WORK_FAIL           = "WF" //workunit fails running
 	WORK_FAILED         = "W!" //workunit fails running (not recoverable)
+	WORK_TIMEOUT        = "WT" //workunit exceeded its walltime
 	//server only events
 	SERVER_START         = "SS" //awe-server start
 	SERVER_RECOVER       = "SR" //awe-server start with recover option  (-recover)
+	SERVER_SHUTDOWN      = "SD" //awe-server shut down, checked out workunits saved
 	DEBUG_LEVEL          = "DL" //debug level changed
 	QUEUE_RESUME         = "QR" //awe-server queue resumed if suspended
 	QUEUE_SUSPEND        = "QS" //awe-server queue suspended, not handing out work
@@ -23,12 +26,20 @@ const (
 	WORK_SUSPEND         = "WP" //workunit suspend after failing for conf.Max_Failure times
 	TASK_DONE            = "TD" //task done (all the workunits in the task have finished)
 	TASK_SKIPPED         = "TS" //task skipped (skip option > 0)
+	TASK_REUSED          = "TU" //task completed by reusing the outputs of an identical task
 	JOB_DONE             = "JD" //job done (all the tasks in the job have finished)
 	JOB_SUSPEND          = "JP" //job suspended
 	JOB_DELETED          = "JL" //job deleted
 	JOB_EXPIRED          = "JE" //job expired
 	JOB_FULL_DELETE      = "JR" //job removed form mongodb (deleted fully)
 	JOB_FAILED_PERMANENT = "JF" //job failed permanently
+	JOB_RESUME           = "JU" //suspended job resumed
+	JOB_RECOVER          = "JV" //job recovered from mongodb into the queue
+	JOB_RECOMPUTE        = "JC" //job recomputed from a task
+	JOB_RESUBMIT         = "JB" //job resubmitted, all tasks are computed again
+	JOB_UPDATE           = "JM" //job attribute changed (priority, clientgroup, pipeline, expiration, token)
+	JOB_ACL              = "JA" //job acl changed
+	CLIENT_REJECTED      = "CJ" //client request rejected, remote address not in ip_cidr of clientgroup
 	//client only events
 	WORK_START     = "WS" //workunit command start running
 	WORK_END       = "WE" //workunit command finish running
@@ -56,6 +67,7 @@ var EventDiscription = map[string]map[string]string{
 		"WC": "workunit checkout",
 		"WF": "workunit fails running",
 		"W!": "workunit failed running (not recoverable)",
+		"WT": "workunit exceeded its walltime",
 	},
 	"server": map[string]string{
 		"SS": "awe-server start",
@@ -71,12 +83,20 @@ var EventDiscription = map[string]map[string]string{
 		"WP": "workunit suspend after failing for conf.Max_Failure times",
 		"TD": "task done (all the workunits in the task have finished)",
 		"TS": "task skipped (skip option > 0)",
+		"TU": "task completed by reusing the outputs of an identical task",
 		"JD": "job done (all the tasks in the job have finished)",
 		"JP": "job suspended",
 		"JL": "job deleted",
 		"JE": "job expired",
 		"JR": "job removed form mongodb (deleted fully)",
 		"JF": "job failed permanently",
+		"JU": "suspended job resumed",
+		"JV": "job recovered from mongodb into the queue",
+		"JC": "job recomputed from a task",
+		"JB": "job resubmitted, all tasks are computed again",
+		"JM": "job attribute changed (priority, clientgroup, pipeline, expiration, token)",
+		"JA": "job acl changed",
+		"CJ": "client request rejected, remote address not in ip_cidr of clientgroup",
 	},
 	"client": map[string]string{
 		"WS": "workunit command start running",
@@ -2,13 +2,17 @@ package request
 
 import (
 	"errors"
+	"fmt"
 	"github.com/MG-RAST/AWE/lib/auth"
+	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core"
 	e "github.com/MG-RAST/AWE/lib/errors"
 	"github.com/MG-RAST/AWE/lib/logger"
 	"github.com/MG-RAST/AWE/lib/user"
 	"github.com/MG-RAST/golib/goweb"
+	"net"
 	"net/http"
+	"strings"
 )
 
 func Authenticate(req *http.Request) (u *user.User, err error) {
@@ -18,6 +22,13 @@ func Authenticate(req *http.Request) (u *user.User, err error) {
 	}
 	header := req.Header.Get("Authorization")
 	u, err = auth.Authenticate(header)
+	if err != nil {
+		return
+	}
+	if !u.ScopeAllows(req.Method) {
+		u = nil
+		err = errors.New(e.UnAuth + " (token scope " + user.SCOPE_READ + " allows read requests only)")
+	}
 	return
 }
 
@@ -31,6 +42,59 @@ func AuthenticateClientGroup(req *http.Request) (cg *core.ClientGroup, err error
 	return
 }
 
+// RemoteIP returns the address of the client. The X-Forwarded-For header is only used if
+// the request comes from a proxy listed in conf.TRUSTED_PROXIES, the header is then read
+// from right to left and the first address that is not a trusted proxy is returned.
+func RemoteIP(req *http.Request) (ip net.IP, err error) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+		err = nil
+	}
+	ip = net.ParseIP(host)
+	if ip == nil {
+		err = fmt.Errorf("(RemoteIP) could not parse remote address %s", req.RemoteAddr)
+		return
+	}
+	if conf.TRUSTED_PROXIES == "" {
+		return
+	}
+	proxies, err := core.ParseCIDRList(conf.TRUSTED_PROXIES)
+	if err != nil {
+		err = fmt.Errorf("(RemoteIP) trusted_proxies invalid: %s", err.Error())
+		return
+	}
+	if !containsIP(proxies, ip) {
+		return
+	}
+	forwarded := strings.Split(strings.Join(req.Header["X-Forwarded-For"], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		entry := strings.TrimSpace(forwarded[i])
+		if entry == "" {
+			continue
+		}
+		forwarded_ip := net.ParseIP(entry)
+		if forwarded_ip == nil {
+			err = fmt.Errorf("(RemoteIP) could not parse X-Forwarded-For address %s", entry)
+			return
+		}
+		ip = forwarded_ip
+		if !containsIP(proxies, ip) {
+			return
+		}
+	}
+	return
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func RetrieveToken(req *http.Request) (token string, err error) {
 	if _, ok := req.Header["Datatoken"]; !ok {
 		err = errors.New("no token received")
@@ -0,0 +1,57 @@
+package request
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+)
+
+func TestRemoteIP(t *testing.T) {
+	tests := []struct {
+		trusted   string
+		remote    string
+		forwarded []string
+		ip        string
+	}{
+		{"", "10.1.2.3:4567", nil, "10.1.2.3"},
+		{"", "[2001:db8::1]:4567", nil, "2001:db8::1"},
+		// the header is ignored without trusted proxies or from an untrusted address
+		{"", "10.1.2.3:4567", []string{"192.168.1.7"}, "10.1.2.3"},
+		{"172.16.0.0/12", "10.1.2.3:4567", []string{"192.168.1.7"}, "10.1.2.3"},
+		// a trusted proxy
+		{"172.16.0.0/12", "172.16.0.1:4567", []string{"192.168.1.7"}, "192.168.1.7"},
+		{"172.16.0.0/12", "172.16.0.1:4567", nil, "172.16.0.1"},
+		// a chain of proxies, addresses added by the client itself are not used
+		{"172.16.0.0/12", "172.16.0.1:4567", []string{"1.2.3.4, 192.168.1.7, 172.16.0.2"}, "192.168.1.7"},
+		{"172.16.0.0/12", "172.16.0.1:4567", []string{"1.2.3.4", "192.168.1.7"}, "192.168.1.7"},
+		// the client was a trusted proxy as well
+		{"172.16.0.0/12", "172.16.0.1:4567", []string{"172.16.0.3, 172.16.0.2"}, "172.16.0.3"},
+	}
+	defer func() { conf.TRUSTED_PROXIES = "" }()
+	for _, test := range tests {
+		conf.TRUSTED_PROXIES = test.trusted
+		req := &http.Request{RemoteAddr: test.remote, Header: http.Header{}}
+		for _, value := range test.forwarded {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		ip, err := RemoteIP(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip.String() != test.ip {
+			t.Fatalf("trusted %q, remote %s, forwarded %v: expected %s, got %s", test.trusted, test.remote, test.forwarded, test.ip, ip.String())
+		}
+	}
+
+	// invalid addresses from a trusted proxy are an error
+	conf.TRUSTED_PROXIES = "172.16.0.0/12"
+	req := &http.Request{RemoteAddr: "172.16.0.1:4567", Header: http.Header{"X-Forwarded-For": {"unknown"}}}
+	if _, err := RemoteIP(req); err == nil {
+		t.Fatalf("invalid X-Forwarded-For accepted")
+	}
+	req = &http.Request{RemoteAddr: "somewhere", Header: http.Header{}}
+	if _, err := RemoteIP(req); err == nil {
+		t.Fatalf("invalid remote address accepted")
+	}
+}
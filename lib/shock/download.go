@@ -0,0 +1,310 @@
+package shock
+
+import (
+	"compress/gzip"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	e "github.com/MG-RAST/AWE/lib/errors"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/golib/httpclient"
+)
+
+var errRangeNotSupported = errors.New("server does not support range requests")
+
+// ChecksumError is returned if a downloaded file does not match the md5 checksum of the Shock node
+type ChecksumError struct {
+	Url      string
+	Expected string
+	Actual   string
+}
+
+func (ce *ChecksumError) Error() string {
+	return fmt.Sprintf("%s: url=%s, expected md5=%s, got md5=%s", e.ChecksumMismatch, ce.Url, ce.Expected, ce.Actual)
+}
+
+// IsChecksumError also detects checksum errors that have been converted to strings, e.g. in workunit notes
+func IsChecksumError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(*ChecksumError); ok {
+		return true
+	}
+	return strings.Contains(err.Error(), e.ChecksumMismatch)
+}
+
+// StatusError is returned if a download is answered with an unexpected HTTP status
+type StatusError struct {
+	Url        string
+	StatusCode int
+	Body       string
+}
+
+func (se *StatusError) Error() string {
+	return fmt.Sprintf("url=%s, status=%d, res=%s", se.Url, se.StatusCode, se.Body)
+}
+
+// IsPermanentError returns true if a retry of the download cannot succeed, i.e. the token is not
+// authorized or the file does not exist. Checksum mismatches and network errors are worth a retry.
+func IsPermanentError(err error) bool {
+	se, ok := err.(*StatusError)
+	if !ok {
+		return false
+	}
+	switch se.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return true
+	}
+	return false
+}
+
+// FetchFileResumable downloads url to filename. The data is written to filename.part first, an interrupted
+// download is continued with a range request. If expected_size is known and threads > 1 the file is downloaded
+// in threads ranges in parallel (filename.part.N). If expected_md5 is set, the downloaded (compressed) data is
+// verified and a *ChecksumError is returned on mismatch, the partial file is removed in that case.
+func FetchFileResumable(filename string, url string, token string, uncompress string, expected_md5 string, expected_size int64, threads int) (size int64, md5sum string, err error) {
+	logger.Debug(1, "(FetchFileResumable) fetching file name=%s, url=%s", filename, url)
+
+	if uncompress != "" && uncompress != "gzip" {
+		err = errors.New("(FetchFileResumable) uncompress method unknown: " + uncompress)
+		return
+	}
+
+	part_file := filename + ".part"
+	var user *httpclient.Auth
+	if token != "" {
+		user = httpclient.GetUserByTokenAuth(token)
+	}
+
+	// a sequential download that was interrupted is continued sequentially
+	sequential := true
+	if threads > 1 && expected_size > 0 {
+		if fi, serr := os.Stat(part_file); serr != nil || fi.Size() == 0 {
+			sequential = false
+		}
+	}
+
+	if !sequential {
+		err = fetchParallel(part_file, url, user, expected_size, threads)
+		if err == errRangeNotSupported {
+			logger.Debug(1, "(FetchFileResumable) %s, falling back to single download", err.Error())
+			sequential = true
+		} else if err != nil {
+			if _, ok := err.(*StatusError); !ok {
+				err = fmt.Errorf("(FetchFileResumable) fetchParallel returned: %s", err.Error())
+			}
+			return
+		}
+	}
+	if sequential {
+		err = fetchRange(part_file, url, user, 0, -1, false)
+		if err != nil {
+			if _, ok := err.(*StatusError); !ok {
+				err = fmt.Errorf("(FetchFileResumable) fetchRange returned: %s", err.Error())
+			}
+			return
+		}
+	}
+
+	md5sum, err = FileMd5(part_file)
+	if err != nil {
+		return
+	}
+	if expected_md5 != "" && md5sum != expected_md5 {
+		os.Remove(part_file)
+		err = &ChecksumError{Url: url, Expected: expected_md5, Actual: md5sum}
+		return
+	}
+
+	if uncompress == "" {
+		var fi os.FileInfo
+		fi, err = os.Stat(part_file)
+		if err != nil {
+			return
+		}
+		size = fi.Size()
+		err = os.Rename(part_file, filename)
+		return
+	}
+
+	size, err = gunzipFile(part_file, filename)
+	if err != nil {
+		err = fmt.Errorf("(FetchFileResumable) gunzipFile returned: %s", err.Error())
+		return
+	}
+	os.Remove(part_file)
+	return
+}
+
+// fetchRange downloads bytes start..end (end < 0 means until the end) of url and appends them to part_file,
+// data already in part_file is not downloaded again. With strict, a server ignoring the range is an error.
+func fetchRange(part_file string, url string, user *httpclient.Auth, start int64, end int64, strict bool) (err error) {
+	file, err := os.OpenFile(part_file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return
+	}
+	offset := start + fi.Size()
+	if end >= 0 && offset > end {
+		return
+	}
+
+	header := httpclient.Header{}
+	if offset > 0 || end >= 0 {
+		if end >= 0 {
+			header["Range"] = []string{fmt.Sprintf("bytes=%d-%d", offset, end)}
+		} else {
+			header["Range"] = []string{fmt.Sprintf("bytes=%d-", offset)}
+		}
+	}
+
+	res, err := httpclient.Get(url, header, nil, user)
+	if err != nil {
+		err = errors.New("(fetchRange) httpclient.Get returned: " + err.Error())
+		return
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		if offset > start || end >= 0 {
+			if strict {
+				err = errRangeNotSupported
+				return
+			}
+			// range ignored, start over
+			err = file.Truncate(0)
+			if err != nil {
+				return
+			}
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// file is complete, the checksum will tell
+		return
+	default:
+		resbody, _ := ioutil.ReadAll(res.Body)
+		err = &StatusError{Url: url, StatusCode: res.StatusCode, Body: string(resbody)}
+		return
+	}
+
+	_, err = io.Copy(file, res.Body)
+	return
+}
+
+func fetchParallel(part_file string, url string, user *httpclient.Auth, size int64, threads int) (err error) {
+	segment_size := (size + int64(threads) - 1) / int64(threads)
+	segments := []string{}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var errs []error
+	for i := 0; i < threads; i++ {
+		start := int64(i) * segment_size
+		if start >= size {
+			break
+		}
+		end := start + segment_size - 1
+		if end >= size {
+			end = size - 1
+		}
+		segment := fmt.Sprintf("%s.%d", part_file, i)
+		segments = append(segments, segment)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if serr := fetchRange(segment, url, user, start, end, true); serr != nil {
+				lock.Lock()
+				errs = append(errs, serr)
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, serr := range errs {
+		if serr == errRangeNotSupported {
+			for _, segment := range segments {
+				os.Remove(segment)
+			}
+			err = errRangeNotSupported
+			return
+		}
+	}
+	if len(errs) > 0 {
+		err = errs[0]
+		return
+	}
+
+	// concatenate segments
+	file, err := os.Create(part_file)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	for _, segment := range segments {
+		var segment_file *os.File
+		segment_file, err = os.Open(segment)
+		if err != nil {
+			return
+		}
+		_, err = io.Copy(file, segment_file)
+		segment_file.Close()
+		if err != nil {
+			return
+		}
+	}
+	for _, segment := range segments {
+		os.Remove(segment)
+	}
+	return
+}
+
+// FileMd5 returns the md5 checksum of a local file
+func FileMd5(file_path string) (md5sum string, err error) {
+	file, err := os.Open(file_path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	h := md5.New()
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return
+	}
+	md5sum = fmt.Sprintf("%x", h.Sum(nil))
+	return
+}
+
+func gunzipFile(src string, dst string) (size int64, err error) {
+	src_file, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer src_file.Close()
+	gr, err := gzip.NewReader(src_file)
+	if err != nil {
+		return
+	}
+	defer gr.Close()
+	dst_file, err := os.Create(dst)
+	if err != nil {
+		return
+	}
+	defer dst_file.Close()
+	size, err = io.Copy(dst_file, gr)
+	return
+}
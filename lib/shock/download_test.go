@@ -0,0 +1,94 @@
+package shock
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+func TestFetchFileResumable(t *testing.T) {
+	logger.Initialize("test")
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	md5sum := fmt.Sprintf("%x", md5.Sum(data))
+
+	var lock sync.Mutex
+	ranges := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		lock.Unlock()
+		http.ServeContent(w, r, "data", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "fetchfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// parallel range download
+	target := path.Join(dir, "parallel")
+	size, got_md5, err := FetchFileResumable(target, srv.URL, "", "", md5sum, int64(len(data)), 4)
+	if err != nil {
+		t.Fatalf("parallel download returned: %s", err.Error())
+	}
+	if size != int64(len(data)) || got_md5 != md5sum {
+		t.Fatalf("parallel download: size=%d md5=%s", size, got_md5)
+	}
+	if len(ranges) != 4 {
+		t.Fatalf("expected 4 range requests, got %v", ranges)
+	}
+
+	// resume from an existing partial file
+	ranges = []string{}
+	target = path.Join(dir, "resume")
+	if err = ioutil.WriteFile(target+".part", data[:1000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, got_md5, err = FetchFileResumable(target, srv.URL, "", "", md5sum, int64(len(data)), 1)
+	if err != nil {
+		t.Fatalf("resumed download returned: %s", err.Error())
+	}
+	if got_md5 != md5sum || len(ranges) != 1 || ranges[0] != "bytes=1000-" {
+		t.Fatalf("resumed download: md5=%s ranges=%v", got_md5, ranges)
+	}
+
+	// corrupted partial file
+	target = path.Join(dir, "corrupt")
+	if err = ioutil.WriteFile(target+".part", bytes.Repeat([]byte("x"), 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = FetchFileResumable(target, srv.URL, "", "", md5sum, int64(len(data)), 1)
+	if !IsChecksumError(err) {
+		t.Fatalf("expected checksum error, got %v", err)
+	}
+	if _, xerr := os.Stat(target + ".part"); !os.IsNotExist(xerr) {
+		t.Fatalf("partial file should be removed after checksum error")
+	}
+	if _, _, err = FetchFileResumable(target, srv.URL, "", "", md5sum, int64(len(data)), 1); err != nil {
+		t.Fatalf("download after checksum error returned: %s", err.Error())
+	}
+
+	// a missing file is not retried
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	_, _, err = FetchFileResumable(path.Join(dir, "missing"), missing.URL, "", "", "", 0, 1)
+	if !IsPermanentError(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+	if IsPermanentError(&ChecksumError{}) {
+		t.Fatalf("checksum error should not be permanent")
+	}
+}
@@ -480,10 +480,7 @@ func (sc *ShockClient) PostNodeWithToken(filename string, numParts int) (nodeid
 	}
 	//create "parts" for output splits
 	if numParts > 1 {
-		opts["upload_type"] = "parts"
-		opts["file_name"] = filename
-		opts["parts"] = strconv.Itoa(numParts)
-		_, err = sc.CreateOrUpdate(opts, node.Id, nil)
+		err = sc.SetParts(node.Id, filename, numParts)
 		if err != nil {
 			nodeid = node.Id
 			err = fmt.Errorf("(PostNodeWithToken) (CreateOrUpdate) failed (%s, %s): %v", sc.Host, node.Id, err)
@@ -493,6 +490,72 @@ func (sc *ShockClient) PostNodeWithToken(filename string, numParts int) (nodeid
 	return node.Id, nil
 }
 
+// SetParts prepares the node for a partial upload of numParts parts
+func (sc *ShockClient) SetParts(nodeid string, filename string, numParts int) (err error) {
+	opts := Opts{}
+	opts["upload_type"] = "parts"
+	opts["file_name"] = filename
+	opts["parts"] = strconv.Itoa(numParts)
+	_, err = sc.CreateOrUpdate(opts, nodeid, nil)
+	return
+}
+
+// PutPart uploads part number part (starting at 1) of a node prepared with SetParts, the part is read from r
+func (sc *ShockClient) PutPart(nodeid string, part int, r io.Reader, size int64) (err error) {
+	if sc.Host == "" {
+		err = errors.New("(PutPart) host is not defined in Shock node")
+		return
+	}
+	url := sc.Host + "/node/" + nodeid
+
+	form := httpclient.NewForm()
+	form.AddFileReader(strconv.Itoa(part), r, size)
+	err = form.Create()
+	if err != nil {
+		return
+	}
+	headers := httpclient.Header{
+		"Content-Type":   []string{form.ContentType},
+		"Content-Length": []string{strconv.FormatInt(form.Length, 10)},
+	}
+	var user *httpclient.Auth
+	if sc.Token != "" {
+		user = httpclient.GetUserByTokenAuth(sc.Token)
+	}
+
+	var res *http.Response
+	res, err = httpclient.Do("PUT", url, headers, form.Reader, user)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	jsonstream, _ := ioutil.ReadAll(res.Body)
+	response := new(ShockResponse)
+	if err = json.Unmarshal(jsonstream, response); err != nil {
+		err = fmt.Errorf("(PutPart) failed to marshal response:\"%s\"", jsonstream)
+		return
+	}
+	if len(response.Errs) > 0 {
+		err = fmt.Errorf("(PutPart) url=%s, part=%d, error=%s", url, part, strings.Join(response.Errs, ","))
+		return
+	}
+	return
+}
+
+// UploadedParts returns the parts of a partial upload node that Shock has received, indexed by part number
+func (node *ShockNode) UploadedParts() (parts map[int]bool) {
+	parts = make(map[int]bool)
+	if node.Parts == nil {
+		return
+	}
+	for i, part := range node.Parts.Parts {
+		if len(part) > 0 {
+			parts[i+1] = true
+		}
+	}
+	return
+}
+
 func (sc *ShockClient) Get_node_download_url(node ShockNode) (download_url string, err error) {
 
 	var myurl *url.URL
@@ -539,6 +602,21 @@ func (sc *ShockClient) Get_node(node_id string) (node *ShockNode, err error) {
 
 // old-style functions that probably should to be refactored
 
+// NodeNotFoundError is returned by ShockGet if Shock answers 404, other errors may be transient
+type NodeNotFoundError struct {
+	Host string
+	Node string
+}
+
+func (nf *NodeNotFoundError) Error() string {
+	return fmt.Sprintf("(ShockGet) node not found: %s/node/%s", nf.Host, nf.Node)
+}
+
+func IsNodeNotFound(err error) bool {
+	_, ok := err.(*NodeNotFoundError)
+	return ok
+}
+
 func ShockGet(host string, nodeid string, token string) (node *ShockNode, err error) {
 	if host == "" || nodeid == "" {
 		err = errors.New("(ShockGet) empty shock host or node id")
@@ -583,6 +661,10 @@ func ShockGet(host string, nodeid string, token string) (node *ShockNode, err er
 		return
 	}
 	if len(response.Errs) > 0 {
+		if response.Code == http.StatusNotFound {
+			err = &NodeNotFoundError{Host: host, Node: nodeid}
+			return
+		}
 		err = fmt.Errorf("(ShockGet) (response) %s", strings.Join(response.Errs, ","))
 		return
 	}
@@ -0,0 +1,142 @@
+package user
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/db"
+	"github.com/MG-RAST/golib/go-uuid/uuid"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// acl entries of groups are GROUP_PREFIX + group id
+const GROUP_PREFIX = "group:"
+
+// Group is a team of users that can be used in job and clientgroup acls
+type Group struct {
+	Id      string    `bson:"id" json:"id"`
+	Name    string    `bson:"name" json:"name"`
+	Owner   string    `bson:"owner" json:"owner"`     // uuid of the user managing the group
+	Members []string  `bson:"members" json:"members"` // user uuids
+	Created time.Time `bson:"created" json:"created"`
+}
+
+func initGroupDB() (err error) {
+	c := db.C(conf.DB_COLL_GROUPS)
+	if err = c.EnsureIndex(db.Index{Key: []string{"id"}, Unique: true}); err != nil {
+		return
+	}
+	if err = c.EnsureIndex(db.Index{Key: []string{"name"}, Unique: true}); err != nil {
+		return
+	}
+	err = c.EnsureIndex(db.Index{Key: []string{"members"}, Background: true})
+	return
+}
+
+// NewGroup creates a group with the owner as first member
+func NewGroup(name string, owner *User) (g *Group, err error) {
+	if name == "" || strings.ContainsAny(name, ",:") {
+		err = errors.New("(NewGroup) group name must not be empty or contain ',' or ':'")
+		return
+	}
+	g = &Group{Id: uuid.New(), Name: name, Owner: owner.Uuid, Members: []string{owner.Uuid}, Created: time.Now()}
+	c := db.C(conf.DB_COLL_GROUPS)
+	if err = c.Insert(g); err != nil {
+		g = nil
+		if db.IsDup(err) {
+			err = errors.New("(NewGroup) group " + name + " already exists")
+		}
+	}
+	return
+}
+
+// GetGroup finds a group by id or name
+func GetGroup(id string) (g *Group, err error) {
+	c := db.C(conf.DB_COLL_GROUPS)
+	g = &Group{}
+	if err = c.Find(bson.M{"$or": []bson.M{bson.M{"id": id}, bson.M{"name": id}}}).One(g); err != nil {
+		g = nil
+	}
+	return
+}
+
+// GetGroups returns the groups of a member, or all groups if member is empty
+func GetGroups(member string) (groups []*Group, err error) {
+	c := db.C(conf.DB_COLL_GROUPS)
+	query := bson.M{}
+	if member != "" {
+		query["members"] = member
+	}
+	groups = []*Group{}
+	err = c.Find(query).Sort("name").All(&groups)
+	return
+}
+
+func (g *Group) Save() (err error) {
+	c := db.C(conf.DB_COLL_GROUPS)
+	_, err = c.Upsert(bson.M{"id": g.Id}, g)
+	return
+}
+
+// Delete removes the group, acl entries of the group no longer match anybody
+func (g *Group) Delete() (err error) {
+	c := db.C(conf.DB_COLL_GROUPS)
+	err = c.Remove(bson.M{"id": g.Id})
+	return
+}
+
+// AclId returns the acl entry of the group
+func (g *Group) AclId() string {
+	return GROUP_PREFIX + g.Id
+}
+
+func (g *Group) HasMember(uuid string) bool {
+	for _, member := range g.Members {
+		if member == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Group) AddMember(uuid string) {
+	if !g.HasMember(uuid) {
+		g.Members = append(g.Members, uuid)
+	}
+	return
+}
+
+func (g *Group) RemoveMember(uuid string) {
+	members := []string{}
+	for _, member := range g.Members {
+		if member != uuid {
+			members = append(members, member)
+		}
+	}
+	g.Members = members
+	return
+}
+
+// AclIds returns the acl entries that apply to the user: the uuid and the groups of the user.
+// The groups are looked up once, every request has its own copy of the user.
+func (u *User) AclIds() (ids []string) {
+	if u.acl_ids != nil {
+		return u.acl_ids
+	}
+	ids = []string{u.Uuid}
+	if u.Uuid == "" || u.Uuid == "public" {
+		return
+	}
+	// without the groups only the entries of the user apply
+	groups, err := GetGroups(u.Uuid)
+	if err != nil {
+		return
+	}
+	for _, g := range groups {
+		ids = append(ids, g.AclId())
+	}
+	u.acl_ids = ids
+	return
+}
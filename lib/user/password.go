@@ -0,0 +1,75 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/MG-RAST/AWE/lib/db"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const MIN_PASSWORD_LENGTH = 8
+
+// isPasswordHash returns true if the stored password is a bcrypt hash, older records contain the plaintext
+func isPasswordHash(password string) bool {
+	return strings.HasPrefix(password, "$2a$") || strings.HasPrefix(password, "$2b$") || strings.HasPrefix(password, "$2y$")
+}
+
+func HashPassword(password string) (hash string, err error) {
+	if len(password) < MIN_PASSWORD_LENGTH {
+		err = fmt.Errorf("(HashPassword) password must have at least %d characters", MIN_PASSWORD_LENGTH)
+		return
+	}
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		err = fmt.Errorf("(HashPassword) bcrypt.GenerateFromPassword returned: %s", err.Error())
+		return
+	}
+	hash = string(b)
+	return
+}
+
+// SetPassword replaces the password of the user, an empty password disables basic auth for the user
+func (u *User) SetPassword(password string) (err error) {
+	if u.ServiceAccount {
+		return errors.New("(SetPassword) service accounts can only authenticate with API tokens")
+	}
+	if password == "" {
+		u.Password = ""
+		return
+	}
+	u.Password, err = HashPassword(password)
+	return
+}
+
+// CheckPassword returns true if password matches the one of the user, users without password never match
+func (u *User) CheckPassword(password string) bool {
+	if u.Password == "" || password == "" || !isPasswordHash(u.Password) {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) == nil
+}
+
+// migratePasswords replaces plaintext passwords of existing users with bcrypt hashes
+func migratePasswords(c db.Collection) (err error) {
+	users := Users{}
+	if err = c.Find(bson.M{"password": bson.M{"$nin": []interface{}{"", nil}}}).All(&users); err != nil {
+		return
+	}
+	for _, u := range users {
+		if isPasswordHash(u.Password) {
+			continue
+		}
+		// bypass the length check, the user chose this password before it existed
+		b, xerr := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+		if xerr != nil {
+			return fmt.Errorf("(migratePasswords) hashing password of %s returned: %s", u.Username, xerr.Error())
+		}
+		if err = c.Update(bson.M{"uuid": u.Uuid}, bson.M{"$set": bson.M{"password": string(b)}}); err != nil {
+			return fmt.Errorf("(migratePasswords) updating %s returned: %s", u.Username, err.Error())
+		}
+	}
+	return
+}
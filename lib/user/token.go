@@ -0,0 +1,226 @@
+package user
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/db"
+	"github.com/MG-RAST/golib/go-uuid/uuid"
+	"github.com/MG-RAST/golib/uniuri"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// API token scopes, from least to most privileged
+const (
+	SCOPE_READ   = "read"   // GET requests only
+	SCOPE_SUBMIT = "submit" // everything a user can do, without admin rights
+	SCOPE_ADMIN  = "admin"  // admin rights if the owner is an admin
+)
+
+var scopeRank = map[string]int{SCOPE_READ: 1, SCOPE_SUBMIT: 2, SCOPE_ADMIN: 3}
+
+// the secret is only returned on creation, the server keeps a sha256 hash of it
+const API_TOKEN_PREFIX = "awe_"
+
+// last_used is written at most this often per token
+const API_TOKEN_TOUCH = time.Minute
+
+type ApiToken struct {
+	Id        string     `bson:"id" json:"id"`
+	Hash      string     `bson:"hash" json:"-"`
+	UserUuid  string     `bson:"user_uuid" json:"user_uuid"`
+	Username  string     `bson:"username" json:"username"`
+	Name      string     `bson:"name" json:"name"`
+	Scope     string     `bson:"scope" json:"scope"`
+	CreatedBy string     `bson:"created_by" json:"created_by"`
+	Created   time.Time  `bson:"created" json:"created"`
+	Expires   *time.Time `bson:"expires,omitempty" json:"expires,omitempty"`
+	LastUsed  *time.Time `bson:"last_used,omitempty" json:"last_used,omitempty"`
+}
+
+// ValidScope returns true if scope is one of read, submit and admin
+func ValidScope(scope string) bool {
+	_, ok := scopeRank[scope]
+	return ok
+}
+
+// ScopeIncludes returns true if a token with scope may do everything a token with scope other may do,
+// the empty scope (not authenticated with an API token) includes all scopes
+func ScopeIncludes(scope string, other string) bool {
+	if scope == "" {
+		return true
+	}
+	return scopeRank[scope] >= scopeRank[other]
+}
+
+// ScopeAllows returns true if a request with method is allowed for the scope of the user
+func (u *User) ScopeAllows(method string) bool {
+	if u.Scope != SCOPE_READ {
+		return true
+	}
+	return method == "GET" || method == "HEAD" || method == "OPTIONS"
+}
+
+func hashApiToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func initApiTokenDB() (err error) {
+	c := db.C(conf.DB_COLL_API_TOKENS)
+	if err = c.EnsureIndex(db.Index{Key: []string{"hash"}, Unique: true}); err != nil {
+		return
+	}
+	if err = c.EnsureIndex(db.Index{Key: []string{"id"}, Unique: true}); err != nil {
+		return
+	}
+	err = c.EnsureIndex(db.Index{Key: []string{"user_uuid"}, Background: true})
+	return
+}
+
+// NewApiToken creates a token for u, secret is the value to use in the Authorization header
+func NewApiToken(u *User, name string, scope string, expires *time.Time, created_by string) (token *ApiToken, secret string, err error) {
+	if !ValidScope(scope) {
+		err = fmt.Errorf("(NewApiToken) invalid scope \"%s\", use one of %s, %s, %s", scope, SCOPE_READ, SCOPE_SUBMIT, SCOPE_ADMIN)
+		return
+	}
+	if scope == SCOPE_ADMIN && !u.Admin {
+		err = errors.New("(NewApiToken) only admins can create tokens with admin scope")
+		return
+	}
+	if expires != nil && expires.Before(time.Now()) {
+		err = errors.New("(NewApiToken) expiration is in the past")
+		return
+	}
+	secret = API_TOKEN_PREFIX + uniuri.NewLen(40)
+	token = &ApiToken{
+		Id:        uuid.New(),
+		Hash:      hashApiToken(secret),
+		UserUuid:  u.Uuid,
+		Username:  u.Username,
+		Name:      name,
+		Scope:     scope,
+		CreatedBy: created_by,
+		Created:   time.Now(),
+		Expires:   expires,
+	}
+
+	c := db.C(conf.DB_COLL_API_TOKENS)
+	err = c.Insert(token)
+	if err != nil {
+		token = nil
+		secret = ""
+		err = fmt.Errorf("(NewApiToken) Insert returned: %s", err.Error())
+	}
+	return
+}
+
+// CheckNewApiToken checks that u may create a token with scope and expiration for owner. A token cannot
+// be used to create a more powerful token or one that lives longer, the expiration is capped at the
+// expiration of the token of u. Service accounts are never admins, they cannot have tokens with admin scope.
+func (u *User) CheckNewApiToken(owner *User, scope string, expires *time.Time) (capped *time.Time, err error) {
+	if !ScopeIncludes(u.Scope, scope) {
+		err = fmt.Errorf("(CheckNewApiToken) a token with scope %s cannot create tokens with scope %s", u.Scope, scope)
+		return
+	}
+	if owner.Uuid != u.Uuid && !u.Admin {
+		err = errors.New("(CheckNewApiToken) only admins can create tokens for other users")
+		return
+	}
+	if owner.ServiceAccount && scope == SCOPE_ADMIN {
+		err = errors.New("(CheckNewApiToken) service accounts cannot have tokens with admin scope")
+		return
+	}
+	capped = expires
+	if u.TokenExpires != nil && (expires == nil || expires.After(*u.TokenExpires)) {
+		capped = u.TokenExpires
+	}
+	return
+}
+
+// FindApiToken returns the token for a secret, expired tokens are returned as well
+func FindApiToken(secret string) (token *ApiToken, err error) {
+	if !strings.HasPrefix(secret, API_TOKEN_PREFIX) {
+		err = db.ErrNotFound
+		return
+	}
+	c := db.C(conf.DB_COLL_API_TOKENS)
+	token = &ApiToken{}
+	if err = c.Find(bson.M{"hash": hashApiToken(secret)}).One(token); err != nil {
+		token = nil
+	}
+	return
+}
+
+func GetApiToken(id string) (token *ApiToken, err error) {
+	c := db.C(conf.DB_COLL_API_TOKENS)
+	token = &ApiToken{}
+	if err = c.Find(bson.M{"id": id}).One(token); err != nil {
+		token = nil
+	}
+	return
+}
+
+// GetApiTokens returns the tokens of a user, or of all users if user_uuid is empty
+func GetApiTokens(user_uuid string) (tokens []*ApiToken, err error) {
+	c := db.C(conf.DB_COLL_API_TOKENS)
+	query := bson.M{}
+	if user_uuid != "" {
+		query["user_uuid"] = user_uuid
+	}
+	tokens = []*ApiToken{}
+	err = c.Find(query).Sort("created").All(&tokens)
+	return
+}
+
+// DeleteApiToken revokes the token
+func DeleteApiToken(id string) (err error) {
+	c := db.C(conf.DB_COLL_API_TOKENS)
+	err = c.Remove(bson.M{"id": id})
+	return
+}
+
+func (t *ApiToken) Expired() bool {
+	return t.Expires != nil && time.Now().After(*t.Expires)
+}
+
+// Touch records the use of the token
+func (t *ApiToken) Touch() (err error) {
+	now := time.Now()
+	if t.LastUsed != nil && now.Sub(*t.LastUsed) < API_TOKEN_TOUCH {
+		return
+	}
+	t.LastUsed = &now
+	c := db.C(conf.DB_COLL_API_TOKENS)
+	err = c.Update(bson.M{"id": t.Id}, bson.M{"$set": bson.M{"last_used": now}})
+	return
+}
+
+// GetServiceAccount returns the service account with the given name, it is created if it does not exist
+func GetServiceAccount(name string) (u *User, err error) {
+	c := db.C(conf.DB_COLL_USERS)
+	u = &User{}
+	err = c.Find(bson.M{"username": name}).One(u)
+	if err == nil {
+		if !u.ServiceAccount {
+			u = nil
+			err = fmt.Errorf("(GetServiceAccount) user %s exists and is not a service account", name)
+		}
+		return
+	}
+	if err != db.ErrNotFound {
+		u = nil
+		return
+	}
+	u = &User{Uuid: uuid.New(), Username: name, ServiceAccount: true}
+	err = u.Save()
+	if err != nil {
+		u = nil
+	}
+	return
+}
@@ -1,10 +1,12 @@
 package user
 
 import (
+	"errors"
+	"time"
+
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/db"
 	"github.com/MG-RAST/golib/go-uuid/uuid"
-	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -20,21 +22,40 @@ type User struct {
 	Password     string      `bson:"password" json:"-"`
 	Admin        bool        `bson:"admin" json:"admin"`
 	CustomFields interface{} `bson:"custom_fields" json:"custom_fields"`
+
+	ServiceAccount bool       `bson:"service_account,omitempty" json:"service_account,omitempty"` // can only authenticate with API tokens
+	Disabled       bool       `bson:"disabled,omitempty" json:"disabled,omitempty"`               // disabled users cannot authenticate
+	AdminGranted   bool       `bson:"admin_granted,omitempty" json:"admin_granted,omitempty"`     // admin set with the API instead of the config
+	OidcSubject    string     `bson:"oidc_subject,omitempty" json:"oidc_subject,omitempty"`       // issuer and subject of an OpenID Connect user
+	Scope          string     `bson:"-" json:"-"`                                                 // scope of the API token used for the request
+	TokenExpires   *time.Time `bson:"-" json:"-"`                                                 // expiration of the token used for the request, if any
+	acl_ids        []string   // uuid and groups, see AclIds
 }
 
 func Initialize() (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Users")
-	if err = c.EnsureIndex(mgo.Index{Key: []string{"uuid"}, Unique: true}); err != nil {
+	c := db.C("Users")
+	if err = c.EnsureIndex(db.Index{Key: []string{"uuid"}, Unique: true}); err != nil {
+		return err
+	}
+	if err = c.EnsureIndex(db.Index{Key: []string{"username"}, Unique: true}); err != nil {
+		return err
+	}
+	if err = c.EnsureIndex(db.Index{Key: []string{"oidc_subject"}, Background: true}); err != nil {
 		return err
 	}
-	if err = c.EnsureIndex(mgo.Index{Key: []string{"username"}, Unique: true}); err != nil {
+	if err = initApiTokenDB(); err != nil {
+		return err
+	}
+	if err = initGroupDB(); err != nil {
+		return err
+	}
+
+	if err = migratePasswords(c); err != nil {
 		return err
 	}
 
-	// Setting admin users based on config file.  First, set all users to Admin = false
-	if _, err = c.UpdateAll(bson.M{}, bson.M{"$set": bson.M{"admin": false}}); err != nil {
+	// Setting admin users based on config file.  First, set all users to Admin = false, except those made admin with the API
+	if _, err = c.UpdateAll(bson.M{"admin_granted": bson.M{"$ne": true}}, bson.M{"$set": bson.M{"admin": false}}); err != nil {
 		return err
 	}
 
@@ -54,7 +75,10 @@ func Initialize() (err error) {
 }
 
 func New(username string, password string, isAdmin bool) (u *User, err error) {
-	u = &User{Uuid: uuid.New(), Username: username, Password: password, Admin: isAdmin}
+	u = &User{Uuid: uuid.New(), Username: username, Admin: isAdmin}
+	if err = u.SetPassword(password); err != nil {
+		return nil, err
+	}
 	err = u.Save()
 	if err != nil {
 		u = nil
@@ -63,9 +87,7 @@ func New(username string, password string, isAdmin bool) (u *User, err error) {
 }
 
 func FindByUuid(uuid string) (u *User, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Users")
+	c := db.C("Users")
 	u = &User{Uuid: uuid}
 	if err = c.Find(bson.M{"uuid": u.Uuid}).One(&u); err != nil {
 		return nil, err
@@ -74,28 +96,58 @@ func FindByUuid(uuid string) (u *User, err error) {
 }
 
 func FindByUsernamePassword(username string, password string) (u *User, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Users")
+	c := db.C("Users")
 	u = &User{}
-	if err = c.Find(bson.M{"username": username, "password": password}).One(&u); err != nil {
+	if err = c.Find(bson.M{"username": username}).One(&u); err != nil {
 		return nil, err
 	}
+	if u.Disabled || u.ServiceAccount || !u.CheckPassword(password) {
+		return nil, errors.New("(FindByUsernamePassword) invalid password for user " + username)
+	}
 	return
 }
 
+// GetUser finds a user by uuid or username
+func GetUser(id string) (u *User, err error) {
+	c := db.C("Users")
+	u = &User{}
+	if err = c.Find(bson.M{"$or": []bson.M{bson.M{"uuid": id}, bson.M{"username": id}}}).One(&u); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// IsConfigAdmin returns true if the user is listed as admin in the config
+func (u *User) IsConfigAdmin() bool {
+	for _, v := range conf.AdminUsers {
+		if v == u.Username {
+			return true
+		}
+	}
+	return false
+}
+
 func AdminGet(u *Users) (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Users")
+	c := db.C("Users")
 	err = c.Find(nil).All(u)
 	return
 }
 
+// SetMongoInfo loads or creates the user with the username of u, it is used by the globus and oauth logins.
+// Accounts of service accounts and OIDC users cannot be used this way.
 func (u *User) SetMongoInfo() (err error) {
-	if uu, admin, err := dbGetInfo(u.Username); err == nil {
-		u.Uuid = uu
-		u.Admin = admin
+	if db_u, err := dbGetInfo(u.Username); err == nil {
+		if db_u.ServiceAccount {
+			return errors.New("username " + u.Username + " belongs to a service account")
+		}
+		if db_u.OidcSubject != "" {
+			return errors.New("username " + u.Username + " belongs to an OIDC account")
+		}
+		if db_u.Disabled {
+			return errors.New("user " + u.Username + " is disabled")
+		}
+		u.Uuid = db_u.Uuid
+		u.Admin = db_u.Admin
 		return nil
 	} else {
 		// this is a new user
@@ -114,21 +166,57 @@ func (u *User) SetMongoInfo() (err error) {
 	return
 }
 
-func dbGetInfo(username string) (uuid string, admin bool, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Users")
-	u := User{}
-	if err = c.Find(bson.M{"username": username}).One(&u); err != nil {
-		return "", false, err
+// SetOidcInfo loads or creates the user with the OIDC subject of u. OIDC users are never merged with
+// other accounts, if the username is taken the subject is used instead. admin is the grant of the
+// identity provider, it is revoked when the provider no longer grants it unless it was set with the API.
+func (u *User) SetOidcInfo(admin bool) (err error) {
+	if u.OidcSubject == "" {
+		return errors.New("(SetOidcInfo) user has no OIDC subject")
+	}
+	c := db.C("Users")
+	db_u := &User{}
+	err = c.Find(bson.M{"oidc_subject": u.OidcSubject}).One(db_u)
+	if err == nil {
+		if db_u.Disabled {
+			return errors.New("user " + db_u.Username + " is disabled")
+		}
+		admin = admin || db_u.AdminGranted
+		if db_u.Admin != admin {
+			db_u.Admin = admin
+			if err = db_u.Save(); err != nil {
+				return
+			}
+		}
+		u.Uuid = db_u.Uuid
+		u.Username = db_u.Username
+		u.Admin = db_u.Admin
+		return
 	}
-	return u.Uuid, u.Admin, nil
+	if err != db.ErrNotFound {
+		return
+	}
+
+	// this is a new user
+	if _, xerr := dbGetInfo(u.Username); xerr == nil {
+		u.Username = u.OidcSubject
+	}
+	u.Uuid = uuid.New()
+	u.Admin = admin
+	err = u.Save()
+	return
+}
+
+func dbGetInfo(username string) (u *User, err error) {
+	c := db.C("Users")
+	u = &User{}
+	if err = c.Find(bson.M{"username": username}).One(u); err != nil {
+		return nil, err
+	}
+	return
 }
 
 func (u *User) Save() (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Users")
+	c := db.C("Users")
 	_, err = c.Upsert(bson.M{"uuid": u.Uuid}, &u)
 	return
 }
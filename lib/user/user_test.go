@@ -0,0 +1,181 @@
+package user
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TestPasswords runs on the embedded backend, no mongodb is needed
+func TestPasswords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awe-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := db.OpenEmbedded(path.Join(dir, "awe.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	db.SetStore(s)
+
+	// a record from before passwords were hashed
+	if err = db.C("Users").Insert(&User{Uuid: "00000000-0000-0000-0000-000000000001", Username: "legacy", Password: "plaintext"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := GetUser("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isPasswordHash(legacy.Password) {
+		t.Fatalf("password of legacy user was not migrated")
+	}
+	if _, err = FindByUsernamePassword("legacy", "plaintext"); err != nil {
+		t.Fatalf("legacy user cannot log in after migration: %s", err.Error())
+	}
+
+	if _, err = New("alice", "short", false); err == nil {
+		t.Fatalf("password shorter than %d characters was accepted", MIN_PASSWORD_LENGTH)
+	}
+	if _, err = New("alice", "correct horse", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = FindByUsernamePassword("alice", "wrong password"); err == nil {
+		t.Fatalf("wrong password was accepted")
+	}
+	u, err := FindByUsernamePassword("alice", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u.Disabled = true
+	if err = u.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = FindByUsernamePassword("alice", "correct horse"); err == nil {
+		t.Fatalf("disabled user was accepted")
+	}
+	if n, _ := db.C("Users").Find(bson.M{"disabled": true}).Count(); n != 1 {
+		t.Fatalf("expected one disabled user, got %d", n)
+	}
+}
+
+func TestOidcUsers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awe-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := db.OpenEmbedded(path.Join(dir, "awe.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	db.SetStore(s)
+	if err = Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	local, err := New("alice", "correct horse", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the username of a local account is not taken over
+	u := &User{Username: "alice", OidcSubject: "https://idp.example.org|1"}
+	if err = u.SetOidcInfo(true); err != nil {
+		t.Fatal(err)
+	}
+	if u.Uuid == local.Uuid || u.Username == "alice" || !u.Admin {
+		t.Fatalf("unexpected oidc user %+v", u)
+	}
+
+	// the same subject is the same user, even with a new username, admin is revoked with the group
+	again := &User{Username: "alice2", OidcSubject: "https://idp.example.org|1"}
+	if err = again.SetOidcInfo(false); err != nil {
+		t.Fatal(err)
+	}
+	if again.Uuid != u.Uuid || again.Admin {
+		t.Fatalf("unexpected oidc user on second login %+v", again)
+	}
+	stored, err := FindByUuid(u.Uuid)
+	if err != nil || stored.Admin {
+		t.Fatalf("admin was not revoked")
+	}
+
+	// another issuer with the same sub is another user
+	other := &User{Username: "bob", OidcSubject: "https://other.example.org|1"}
+	if err = other.SetOidcInfo(false); err != nil {
+		t.Fatal(err)
+	}
+	if other.Uuid == u.Uuid || other.Username != "bob" {
+		t.Fatalf("unexpected oidc user of other issuer %+v", other)
+	}
+
+	// a globus or oauth login with the same username does not take over the OIDC account
+	login := &User{Username: "bob"}
+	if err = login.SetMongoInfo(); err == nil || login.Uuid == other.Uuid {
+		t.Fatalf("OIDC account taken over by username %+v", login)
+	}
+	login = &User{Username: "carol"}
+	if err = login.SetMongoInfo(); err != nil || login.Uuid == "" {
+		t.Fatalf("new user not created: %v", err)
+	}
+}
+
+func TestCheckNewApiToken(t *testing.T) {
+	alice := &User{Uuid: "alice", Username: "alice"}
+	admin := &User{Uuid: "admin", Username: "admin", Admin: true}
+	service := &User{Uuid: "service", Username: "service", ServiceAccount: true}
+
+	// a token cannot create a token with a higher scope
+	alice.Scope = SCOPE_READ
+	if _, err := alice.CheckNewApiToken(alice, SCOPE_SUBMIT, nil); err == nil {
+		t.Fatal("read token created a submit token")
+	}
+	if _, err := alice.CheckNewApiToken(alice, SCOPE_READ, nil); err != nil {
+		t.Fatal(err)
+	}
+	alice.Scope = ""
+	if _, err := alice.CheckNewApiToken(service, SCOPE_READ, nil); err == nil {
+		t.Fatal("user created a token for another user")
+	}
+	if _, err := admin.CheckNewApiToken(service, SCOPE_ADMIN, nil); err == nil {
+		t.Fatal("service account got a token with admin scope")
+	}
+	if _, err := admin.CheckNewApiToken(service, SCOPE_SUBMIT, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// a token cannot create a token that lives longer
+	now := time.Now()
+	soon := now.Add(time.Hour)
+	later := now.Add(24 * time.Hour)
+	alice.TokenExpires = &soon
+	for _, expires := range []*time.Time{nil, &later} {
+		capped, err := alice.CheckNewApiToken(alice, SCOPE_READ, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if capped == nil || !capped.Equal(soon) {
+			t.Fatalf("expiration %v was not capped at %v", capped, soon)
+		}
+	}
+	earlier := now.Add(time.Minute)
+	if capped, _ := alice.CheckNewApiToken(alice, SCOPE_READ, &earlier); capped == nil || !capped.Equal(earlier) {
+		t.Fatalf("earlier expiration was changed to %v", capped)
+	}
+	alice.TokenExpires = nil
+	if capped, _ := alice.CheckNewApiToken(alice, SCOPE_READ, nil); capped != nil {
+		t.Fatalf("token without expiration got %v", capped)
+	}
+}
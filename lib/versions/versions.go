@@ -2,11 +2,11 @@ package versions
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core"
 	"github.com/MG-RAST/AWE/lib/db"
-	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"os"
 	"strconv"
@@ -22,10 +22,8 @@ type Versions []Version
 var VersionMap = make(map[string]int)
 
 func Initialize() (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Versions")
-	c.EnsureIndex(mgo.Index{Key: []string{"name"}, Unique: true})
+	c := db.C("Versions")
+	c.EnsureIndex(db.Index{Key: []string{"name"}, Unique: true})
 	var versions = new(Versions)
 	err = c.Find(bson.M{}).All(versions)
 	if err != nil {
@@ -47,9 +45,7 @@ func Initialize() (err error) {
 
 func Print() (err error) {
 	fmt.Printf("##### Versions ####\n")
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Versions")
+	c := db.C("Versions")
 	var versions = new(Versions)
 	if err = c.Find(bson.M{}).All(versions); err != nil {
 		return err
@@ -62,9 +58,7 @@ func Print() (err error) {
 }
 
 func PushVersionsToDatabase() (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Versions")
+	c := db.C("Versions")
 	for k, v := range conf.VERSIONS {
 		if _, err = c.Upsert(bson.M{"name": k}, bson.M{"$set": bson.M{"name": k, "version": v}}); err != nil {
 			return err
@@ -82,9 +76,7 @@ func RunVersionUpdates() (err error) {
 	// the Job version in the config file exists and is greater than or equal to 2.
 	if (ok1 && confVersionJob >= 2) && (!ok2 || (ok2 && dbVersionJob < 2)) {
 		consoleReader := bufio.NewReader(os.Stdin)
-		session := db.Connection.Session.Copy()
-		defer session.Close()
-		c := session.DB(conf.MONGODB_DATABASE).C("Jobs")
+		c := db.C("Jobs")
 		jCount, err := c.Find(bson.M{}).Count()
 		if err != nil {
 			return err
@@ -95,11 +87,11 @@ func RunVersionUpdates() (err error) {
 			fmt.Print("Would you like to run the update to convert these jobs to the version 2 Job struct? (y/n): ")
 			text, _ := consoleReader.ReadString('\n')
 			if text[0] == 'y' {
+				// the update renames collections, which only the mongodb backend supports
 				var job = new(core.Job)
-				var jobDep = new(core.JobDep)
-				cnames, err := session.DB(conf.MONGODB_DATABASE).CollectionNames()
+				cnames, err := db.CollectionNames()
 				if err != nil {
-					return err
+					return errors.New("updating the Job struct version requires the mongodb backend: " + err.Error())
 				}
 				// Get temporary name for new Jobs collection
 				cname_tmp := ""
@@ -130,26 +122,31 @@ func RunVersionUpdates() (err error) {
 					num++
 				}
 
-				// Create handle to new collection
-				cNew := session.DB(conf.MONGODB_DATABASE).C(cname_tmp)
-				iter := c.Find(bson.M{}).Iter()
-				defer iter.Close()
-				for iter.Next(jobDep) {
-					job, err = core.JobDepToJob(jobDep)
-					if err != nil {
+				// Create handle to new collection, jobs are converted in batches
+				cNew := db.C(cname_tmp)
+				for skip := 0; ; skip += 100 {
+					jobDeps := []*core.JobDep{}
+					if err = c.Find(bson.M{}).Sort("_id").Skip(skip).Limit(100).All(&jobDeps); err != nil {
 						return err
 					}
-					err = cNew.Insert(&job)
-					if err != nil {
-						return err
+					if len(jobDeps) == 0 {
+						break
+					}
+					for _, jobDep := range jobDeps {
+						job, err = core.JobDepToJob(jobDep)
+						if err != nil {
+							return err
+						}
+						err = cNew.Insert(&job)
+						if err != nil {
+							return err
+						}
 					}
 				}
-				err = session.Run(bson.D{{"renameCollection", conf.MONGODB_DATABASE + ".Jobs"}, {"to", conf.MONGODB_DATABASE + "." + cname_old}}, bson.M{})
-				if err != nil {
+				if err = db.RenameCollection("Jobs", cname_old); err != nil {
 					return err
 				}
-				err = session.Run(bson.D{{"renameCollection", conf.MONGODB_DATABASE + "." + cname_tmp}, {"to", conf.MONGODB_DATABASE + ".Jobs"}}, bson.M{})
-				if err != nil {
+				if err = db.RenameCollection(cname_tmp, "Jobs"); err != nil {
 					return err
 				}
 			} else {
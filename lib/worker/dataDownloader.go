@@ -556,7 +556,9 @@ func movePreData(workunit *core.Workunit) (size int64, err error) {
 		// get shock and local md5sums
 		isShockPredata := true
 		node_md5 := ""
-		if io.Node == "-" {
+		node_size := int64(0)
+		scheme := io.StorageScheme()
+		if scheme != core.STORAGE_SHOCK {
 			isShockPredata = false
 		} else {
 			node, err := shock.ShockGet(io.Host, io.Node, workunit.Info.DataToken)
@@ -565,37 +567,62 @@ func movePreData(workunit *core.Workunit) (size int64, err error) {
 			}
 			// rename file to be md5sum
 			node_md5 = node.File.Checksum["md5"]
+			node_size = node.File.Size
 			file_path = path.Join(predata_directory, node_md5)
 		}
 
+		// protect the file from cache eviction until the workunit is done
+		if predataCache != nil {
+			predataCache.Pin(workunit.Workunit_Unique_Identifier, file_path)
+		}
+
 		// file does not exist or its md5sum is wrong
 		if !isFileExisting(file_path) {
+			if predataCache != nil {
+				predataCache.Miss()
+				if xerr := predataCache.Evict(node_size); xerr != nil {
+					logger.Error("(movePreData) predataCache.Evict returned: %s", xerr.Error())
+				}
+			}
 			logger.Debug(2, "mover: fetching predata from url: "+dataUrl)
 			logger.Event(event.PRE_IN, "workid="+workunit.Id+" url="+dataUrl)
 
-			var md5sum string
-			file_path_part := file_path + ".part" // temporary name
-			// this gets file from any downloadable url, not just shock
-			size, md5sum, err = shock.FetchFile(file_path_part, dataUrl, workunit.Info.DataToken, io.Uncompress, isShockPredata)
-			if err != nil {
-				return 0, errors.New("error in fetchFile: " + err.Error())
-			}
-			os.Rename(file_path_part, file_path)
-			if err != nil {
-				return 0, errors.New("error renaming after download of preData: " + err.Error())
-			}
-			if isShockPredata {
-				if node_md5 != md5sum {
-					return 0, errors.New("error downloaded file md5 does not mach shock md5, node: " + io.Node)
-				} else {
-					logger.Debug(2, "mover: predata "+name+" has md5sum "+md5sum)
+			if scheme == core.STORAGE_S3 || scheme == core.STORAGE_FILE {
+				file_path_part := file_path + ".part" // temporary name
+				backend, xerr := cache.GetBackend(scheme)
+				if xerr != nil {
+					return 0, xerr
 				}
+				size, err = backend.Fetch(dataUrl, file_path_part, workunit.Info.DataToken, io.Uncompress)
+				if err != nil {
+					return 0, errors.New("error in fetchFile: " + err.Error())
+				}
+				err = os.Rename(file_path_part, file_path)
+				if err != nil {
+					return 0, errors.New("error renaming after download of preData: " + err.Error())
+				}
+			} else {
+				// this gets file from any downloadable url, not just shock. The download is resumed
+				// from file_path.part and verified against the md5 of shock predata.
+				threads := 1
+				if isShockPredata && conf.DOWNLOAD_PARALLEL > 0 && node_size > int64(conf.DOWNLOAD_PARALLEL)*1024*1024 {
+					threads = conf.DOWNLOAD_THREADS
+				}
+				var md5sum string
+				size, md5sum, err = shock.FetchFileResumable(file_path, dataUrl, workunit.Info.DataToken, io.Uncompress, node_md5, node_size, threads)
+				if err != nil {
+					return 0, errors.New("error in fetchFile: " + err.Error())
+				}
+				logger.Debug(2, "mover: predata "+name+" has md5sum "+md5sum)
 			}
 		} else {
 			logger.Debug(2, "mover: predata already exists: "+name)
+			if predataCache != nil {
+				predataCache.Hit()
+			}
 		}
 
-		// timstamp for last access - future caching
+		// timestamp for last access, used for cache eviction
 		accessfile, err := os.Create(file_path + ".access")
 		if err != nil {
 			return 0, errors.New("error creating predata access file: " + err.Error())
@@ -90,6 +90,7 @@ func deliverer_run(control chan int) (err error) { // TODO return all errors
 		// detect e.ClientNotFound
 		do_retry := true
 		retry_count := 0
+		reregistered := false
 		for do_retry {
 			response, err := core.NotifyWorkunitProcessedWithLogs(workunit, perfstat, conf.PRINT_APP_MSG)
 			if err != nil {
@@ -99,6 +100,11 @@ func deliverer_run(control chan int) (err error) { // TODO return all errors
 			} else {
 				error_message := strings.Join(response.Error, ",")
 				if strings.Contains(error_message, e.ClientNotFound) { // TODO need better method than string search. Maybe a field awe_status.
+					// a restarted server restores the checkouts, it accepts the result once the client is registered again
+					if !reregistered && ReRegisterWithSelf(conf.SERVER_URL) == nil {
+						reregistered = true
+						continue
+					}
 					//mark this work in Current_work map as false, something needs to be done in the future
 					//to clean this kind of work that has been proccessed but its result can't be sent to server!
 					//core.Self.Current_work_false(work.Id) //server doesn't know this yet
@@ -155,6 +161,9 @@ func deliverer_run(control chan int) (err error) { // TODO return all errors
 		logger.Error("Could not remove work_id %s", work_id)
 	}
 	workmap.Delete(work_id)
+	if predataCache != nil {
+		predataCache.Unpin(work_id)
+	}
 	core.Self.Busy = false
 	return
 }
@@ -0,0 +1,113 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/logger/event"
+	"github.com/MG-RAST/golib/httpclient"
+)
+
+var drainOnce sync.Once
+
+// checkoutLock is held by the workStealer from the checkout request until the workunit is in the workmap,
+// so Drain cannot see an empty workmap while a checked out workunit is on its way
+var checkoutLock sync.Mutex
+
+// Draining is true once the worker stopped checking out new work
+func Draining() bool {
+	if core.Self == nil {
+		return false
+	}
+	read_lock, err := core.Self.RLockNamed("Draining")
+	if err != nil {
+		return false
+	}
+	defer core.Self.RUnlockNamed(read_lock)
+	return core.Self.Draining
+}
+
+// stopCheckouts sets the worker to draining, a checkout in progress has reached the workmap afterwards
+func stopCheckouts() {
+	checkoutLock.Lock()
+	defer checkoutLock.Unlock()
+	if err := core.Self.Set_Draining(true, true); err != nil {
+		logger.Error("(Drain) Set_Draining returned: %s", err.Error())
+	}
+}
+
+// waitForWork returns once all workunits are computed and delivered
+func waitForWork(interval time.Duration) {
+	for {
+		keys, err := workmap.GetKeys()
+		if err == nil && len(keys) == 0 {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Drain stops the workStealer, waits until the current workunits are computed and delivered,
+// deregisters from the server and exits. It is triggered by the drain instruction of the server,
+// SIGUSR1 or SIGTERM.
+func Drain(reason string) {
+	drainOnce.Do(func() {
+		fmt.Printf("draining (%s), finishing current work...\n", reason)
+		logger.Info("(Drain) draining: %s", reason)
+		stopCheckouts()
+		waitForWork(5 * time.Second)
+
+		if Client_mode == "online" {
+			err := deregister(conf.SERVER_URL, core.Self.Id)
+			if err != nil {
+				logger.Error("(Drain) %s", err.Error())
+			} else {
+				logger.Event(event.CLIENT_UNREGISTER, "clientid="+core.Self.Id)
+			}
+		}
+		fmt.Printf("drained, exiting...\n")
+		logger.Info("(Drain) drained, exiting")
+		time.Sleep(time.Second) // let the logger write the last messages
+		os.Exit(0)
+	})
+}
+
+func deregister(host string, clientid string) (err error) {
+	targeturl := fmt.Sprintf("%s/client/%s?deregister", host, clientid)
+
+	var headers httpclient.Header
+	if conf.CLIENT_GROUP_TOKEN != "" {
+		headers = httpclient.Header{"Authorization": []string{"CG_TOKEN " + conf.CLIENT_GROUP_TOKEN}}
+	}
+
+	res, err := httpclient.Put(targeturl, headers, nil, nil)
+	if err != nil {
+		err = fmt.Errorf("(deregister) httpclient.Put failed: %s", err.Error())
+		return
+	}
+	defer res.Body.Close()
+
+	jsonstream, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		err = fmt.Errorf("(deregister) ioutil.ReadAll failed: %s", err.Error())
+		return
+	}
+	response := core.StandardResponse{}
+	if err = json.Unmarshal(jsonstream, &response); err != nil {
+		err = fmt.Errorf("(deregister) json.Unmarshal response failed: %s", err.Error())
+		return
+	}
+	if len(response.Error) > 0 {
+		err = fmt.Errorf("(deregister) errors in response: %s", strings.Join(response.Error, ","))
+		return
+	}
+	return
+}
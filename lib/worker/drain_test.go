@@ -0,0 +1,52 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+func TestDrain(t *testing.T) {
+	logger.Initialize("test")
+	core.Self = core.NewClient()
+	workmap = NewWorkMap()
+	work_id := core.New_Workunit_Unique_Identifier(core.Task_Unique_Identifier{JobId: "job", TaskName: "task"}, 0)
+
+	// a checkout in progress reaches the workmap before drain looks at it
+	checkoutLock.Lock()
+	stopped := make(chan struct{})
+	go func() {
+		stopCheckouts()
+		close(stopped)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if Draining() {
+		t.Fatal("draining during a checkout")
+	}
+	workmap.Set(work_id, ID_WORKSTEALER, "test")
+	checkoutLock.Unlock()
+	<-stopped
+	if !Draining() {
+		t.Fatal("not draining")
+	}
+
+	// drain waits until the workunit is delivered
+	drained := make(chan struct{})
+	go func() {
+		waitForWork(10 * time.Millisecond)
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		t.Fatal("drained with a workunit in the workmap")
+	case <-time.After(100 * time.Millisecond):
+	}
+	workmap.Delete(work_id)
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("not drained after the workunit was delivered")
+	}
+}
@@ -128,6 +128,8 @@ func SendHeartBeat() (err error) {
 			StopClient()
 		} else if op == "clean" {
 			CleanDisk()
+		} else if op == "drain" {
+			go Drain("drain requested by server")
 		}
 	}
 	return
@@ -138,7 +140,21 @@ func heartbeating(host string, clientid string) (msg core.HeartbeatInstructions,
 	targeturl := fmt.Sprintf("%s/client/%s?heartbeat", host, clientid)
 	//res, err := http.Get(targeturl)
 
+	if predataCache != nil {
+		cache_state, xerr := predataCache.State()
+		if xerr != nil {
+			logger.Error("(heartbeating) predataCache.State returned: %s", xerr.Error())
+		} else {
+			core.Self.WorkerState.PredataCache = cache_state
+		}
+	}
+
+	read_lock, err := core.Self.RLockNamed("heartbeating")
+	if err != nil {
+		return
+	}
 	worker_state_b, err := json.Marshal(core.Self.WorkerState)
+	core.Self.RUnlockNamed(read_lock)
 	if err != nil {
 		err = fmt.Errorf("(heartbeating) json.Marshal failed: %s", err.Error())
 		return
@@ -0,0 +1,105 @@
+package worker
+
+import (
+	"os"
+	"path"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+// logStreamer sends new stdout/stderr output of a running workunit to the server every conf.LIVE_LOG_INTERVAL seconds
+type logStreamer struct {
+	workunit  *core.Workunit
+	work_path string
+	offsets   map[string]int64 // position in the log file up to which output was sent
+	stop_chan chan bool
+	done_chan chan bool
+}
+
+var liveLogFiles = map[string]string{
+	"stdout": conf.STDOUT_FILENAME,
+	"stderr": conf.STDERR_FILENAME,
+}
+
+// startLogStreamer returns nil if live logs are disabled
+func startLogStreamer(workunit *core.Workunit) *logStreamer {
+	if conf.LIVE_LOG_INTERVAL <= 0 || Client_mode == "offline" {
+		return nil
+	}
+	work_path, err := workunit.Path()
+	if err != nil {
+		logger.Error("(startLogStreamer) workunit.Path() returned: %s", err.Error())
+		return nil
+	}
+	ls := &logStreamer{
+		workunit:  workunit,
+		work_path: work_path,
+		offsets:   make(map[string]int64),
+		stop_chan: make(chan bool),
+		done_chan: make(chan bool),
+	}
+	go ls.run()
+	return ls
+}
+
+// Stop waits until the last upload is finished, the final logs are sent with the workunit result
+func (ls *logStreamer) Stop() {
+	if ls == nil {
+		return
+	}
+	close(ls.stop_chan)
+	<-ls.done_chan
+}
+
+func (ls *logStreamer) run() {
+	defer close(ls.done_chan)
+	ticker := time.NewTicker(time.Duration(conf.LIVE_LOG_INTERVAL) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ls.stop_chan:
+			return
+		case <-ticker.C:
+			for logname := range liveLogFiles {
+				ls.send(logname)
+			}
+		}
+	}
+}
+
+// send uploads the output written since the last upload, if there is more than conf.LIVE_LOG_CHUNK KB only the newest part is sent
+func (ls *logStreamer) send(logname string) {
+	file, err := os.Open(path.Join(ls.work_path, liveLogFiles[logname]))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return
+	}
+	offset := ls.offsets[logname]
+	size := fi.Size()
+	if size <= offset {
+		return
+	}
+	max_size := int64(conf.LIVE_LOG_CHUNK) * 1024
+	if max_size > 0 && size-offset > max_size {
+		offset = size - max_size
+	}
+	data := make([]byte, size-offset)
+	n, _ := file.ReadAt(data, offset)
+	if n == 0 {
+		return
+	}
+	err = core.SendLiveLog(ls.workunit, logname, offset, data[:n])
+	if err != nil {
+		// the same output is sent again with the next upload
+		logger.Debug(1, "(logStreamer) SendLiveLog %s returned: %s", logname, err.Error())
+		return
+	}
+	ls.offsets[logname] = offset + int64(n)
+}
@@ -0,0 +1,238 @@
+package worker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+// PredataCache keeps track of the files in DATA_PATH/predata and evicts the least recently
+// used ones (according to the .access timestamps) if the cache exceeds conf.PREDATA_CACHE_MAX
+// or the filesystem has less than conf.PREDATA_MIN_FREE available.
+type PredataCache struct {
+	core.RWMutex
+	Directory string
+	hits      int
+	misses    int
+	evicted   int
+	pinned    map[core.Workunit_Unique_Identifier][]string // files used by workunits on this worker
+}
+
+type predataCacheEntry struct {
+	name        string
+	path        string
+	size        int64
+	last_access time.Time
+}
+
+var predataCache *PredataCache
+
+var md5Regex = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func NewPredataCache(directory string) (pc *PredataCache) {
+	pc = &PredataCache{Directory: directory}
+	pc.pinned = make(map[core.Workunit_Unique_Identifier][]string)
+	pc.RWMutex.Init("PredataCache")
+	return
+}
+
+func (pc *PredataCache) Hit() {
+	err := pc.LockNamed("Hit")
+	if err != nil {
+		return
+	}
+	defer pc.Unlock()
+	pc.hits += 1
+}
+
+func (pc *PredataCache) Miss() {
+	err := pc.LockNamed("Miss")
+	if err != nil {
+		return
+	}
+	defer pc.Unlock()
+	pc.misses += 1
+}
+
+// Pin protects a cache file from eviction while the workunit is on this worker
+func (pc *PredataCache) Pin(work_id core.Workunit_Unique_Identifier, file_path string) (err error) {
+	err = pc.LockNamed("Pin")
+	if err != nil {
+		return
+	}
+	defer pc.Unlock()
+	pc.pinned[work_id] = append(pc.pinned[work_id], path.Base(file_path))
+	return
+}
+
+func (pc *PredataCache) Unpin(work_id core.Workunit_Unique_Identifier) (err error) {
+	err = pc.LockNamed("Unpin")
+	if err != nil {
+		return
+	}
+	defer pc.Unlock()
+	delete(pc.pinned, work_id)
+	return
+}
+
+// isPinned expects the lock to be held
+func (pc *PredataCache) isPinned(name string) bool {
+	for _, names := range pc.pinned {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dropStalePins removes pins of workunits that are not processed anymore (e.g. discarded), expects the lock to be held
+func (pc *PredataCache) dropStalePins() {
+	if workmap == nil {
+		return
+	}
+	for work_id, _ := range pc.pinned {
+		_, ok, err := workmap.Get(work_id)
+		if err == nil && !ok {
+			delete(pc.pinned, work_id)
+		}
+	}
+}
+
+// entries lists the cached files, temporary .part downloads (and .part.N segments) and .access files are skipped
+func (pc *PredataCache) entries() (entries []*predataCacheEntry, total int64, err error) {
+	files, err := ioutil.ReadDir(pc.Directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasSuffix(name, ".access") || strings.HasSuffix(name, ".part") || strings.Contains(name, ".part.") {
+			continue
+		}
+		entry := &predataCacheEntry{name: name, path: path.Join(pc.Directory, name), size: file.Size(), last_access: file.ModTime()}
+		if file.IsDir() {
+			entry.size = dirSize(entry.path)
+		}
+		if access, xerr := os.Stat(entry.path + ".access"); xerr == nil {
+			entry.last_access = access.ModTime()
+		}
+		entries = append(entries, entry)
+		total += entry.size
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].last_access.Before(entries[j].last_access) })
+	return
+}
+
+// Evict removes least recently used files until reserve additional bytes fit into the cache
+func (pc *PredataCache) Evict(reserve int64) (err error) {
+	max_size := int64(conf.PREDATA_CACHE_MAX) * 1024 * 1024
+	min_free := int64(conf.PREDATA_MIN_FREE) * 1024 * 1024
+	if max_size <= 0 && min_free <= 0 {
+		return
+	}
+
+	err = pc.LockNamed("Evict")
+	if err != nil {
+		return
+	}
+	defer pc.Unlock()
+
+	pc.dropStalePins()
+
+	entries, total, err := pc.entries()
+	if err != nil {
+		err = fmt.Errorf("(PredataCache/Evict) entries returned: %s", err.Error())
+		return
+	}
+
+	free := int64(-1)
+	var stat syscall.Statfs_t
+	if min_free > 0 && syscall.Statfs(pc.Directory, &stat) == nil {
+		free = int64(stat.Bavail) * int64(stat.Bsize)
+	}
+
+	for _, entry := range entries {
+		size_ok := max_size <= 0 || total+reserve <= max_size
+		free_ok := free < 0 || free-reserve >= min_free
+		if size_ok && free_ok {
+			return
+		}
+		if pc.isPinned(entry.name) {
+			continue
+		}
+		logger.Debug(1, "(PredataCache/Evict) evicting %s (%d bytes, last access %s)", entry.name, entry.size, entry.last_access.String())
+		if xerr := os.RemoveAll(entry.path); xerr != nil {
+			logger.Error("(PredataCache/Evict) could not remove %s: %s", entry.path, xerr.Error())
+			continue
+		}
+		os.Remove(entry.path + ".access")
+		pc.evicted += 1
+		total -= entry.size
+		if free >= 0 {
+			free += entry.size
+		}
+	}
+
+	if (max_size > 0 && total+reserve > max_size) || (free >= 0 && free-reserve < min_free) {
+		logger.Warning("(PredataCache/Evict) could not free enough space, remaining files are in use")
+	}
+	return
+}
+
+// State returns the cache contents and statistics for the heartbeat
+func (pc *PredataCache) State() (state *core.PredataCacheState, err error) {
+	rlock, err := pc.RLockNamed("State")
+	if err != nil {
+		return
+	}
+	defer pc.RUnlockNamed(rlock)
+
+	entries, total, err := pc.entries()
+	if err != nil {
+		err = fmt.Errorf("(PredataCache/State) entries returned: %s", err.Error())
+		return
+	}
+	state = &core.PredataCacheState{
+		Size:    total,
+		MaxSize: int64(conf.PREDATA_CACHE_MAX) * 1024 * 1024,
+		Hits:    pc.hits,
+		Misses:  pc.misses,
+		Evicted: pc.evicted,
+		Files:   []core.PredataCacheFile{},
+		Md5:     []string{},
+	}
+	for _, entry := range entries {
+		state.Files = append(state.Files, core.PredataCacheFile{Name: entry.name, Size: entry.size, LastAccess: entry.last_access, InUse: pc.isPinned(entry.name)})
+		// shock predata is stored under its md5 checksum
+		if md5Regex.MatchString(entry.name) {
+			state.Md5 = append(state.Md5, entry.name)
+		}
+	}
+	return
+}
+
+func dirSize(dir string) (size int64) {
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
@@ -41,6 +41,17 @@ type WaitContainerResult struct {
 	Status int
 }
 
+// returned by RunWorkunit if the command was killed because it exceeded workunit.Cmd.Walltime
+var ErrWalltimeExceeded = errors.New("walltime exceeded")
+
+// walltimeTimer returns a channel that fires when the walltime of the workunit is exceeded, nil (blocks forever) if there is no walltime
+func walltimeTimer(workunit *core.Workunit) <-chan time.Time {
+	if workunit.Cmd == nil || workunit.Cmd.Walltime <= 0 {
+		return nil
+	}
+	return time.After(time.Duration(workunit.Cmd.Walltime) * time.Second)
+}
+
 func processor_run(control chan int) (err error) {
 
 	workunit := <-fromMover
@@ -109,16 +120,23 @@ func processor_run(control chan int) (err error) {
 	run_start := time.Now().Unix()
 
 	var pstat *core.WorkPerf
+	log_streamer := startLogStreamer(workunit)
 	pstat, err = RunWorkunit(workunit)
+	log_streamer.Stop()
 	exit_status := workunit.ExitStatus
 	logger.Debug(1, "(processor) ExitStatus of process: %d", exit_status)
 	if err != nil {
 		logger.Error("(processor) RunWorkunit returned error , workid=%s, %s", work_str, err.Error())
-		workunit.Notes = append(workunit.Notes, "[processor#RunWorkunit]"+err.Error())
 
-		if exit_status == 42 {
+		if err == ErrWalltimeExceeded {
+			logger.Event(event.WORK_TIMEOUT, "workid="+work_str)
+			workunit.Notes = append(workunit.Notes, fmt.Sprintf("[processor#RunWorkunit]walltime of %d seconds exceeded, process killed", workunit.Cmd.Walltime))
+			workunit.SetState(core.WORK_STAT_ERROR, "walltime exceeded")
+		} else if exit_status == 42 {
+			workunit.Notes = append(workunit.Notes, "[processor#RunWorkunit]"+err.Error())
 			workunit.SetState(core.WORK_STAT_FAILED_PERMANENT, "exit_status == 42") // process told us that is an error where resubmission does not make sense.
 		} else {
+			workunit.Notes = append(workunit.Notes, "[processor#RunWorkunit]"+err.Error())
 			workunit.SetState(core.WORK_STAT_ERROR, "RunWorkunit failed")
 		}
 		err = nil
@@ -178,13 +196,17 @@ func RunWorkunit(workunit *core.Workunit) (pstats *core.WorkPerf, err error) {
 	if workunit.Cmd.Dockerimage != "" || workunit.Cmd.DockerPull != "" {
 		pstats, err = RunWorkunitDocker(workunit)
 		if err != nil {
-			err = fmt.Errorf("(RunWorkunit) RunWorkunitDocker returned: %s", err.Error())
+			if err != ErrWalltimeExceeded {
+				err = fmt.Errorf("(RunWorkunit) RunWorkunitDocker returned: %s", err.Error())
+			}
 			return
 		}
 	} else {
 		pstats, err = RunWorkunitDirect(workunit)
 		if err != nil {
-			err = fmt.Errorf("(RunWorkunit) RunWorkunitDirect returned: %s", err.Error())
+			if err != ErrWalltimeExceeded {
+				err = fmt.Errorf("(RunWorkunit) RunWorkunitDirect returned: %s", err.Error())
+			}
 			return
 		}
 	}
@@ -471,6 +493,8 @@ func RunWorkunitDocker(workunit *core.Workunit) (pstats *core.WorkPerf, err erro
 			err = fmt.Errorf("error: dockerimage_id != image.ID, %s != %s (%s)", dockerimage_id, image.ID, Dockerimage_normalized)
 			return
 		}
+		// recorded for provenance
+		workunit.WorkPerf.DockerImageId = image.ID
 
 		// tag image to make debugging easier
 		tag_opts := docker.TagImageOptions{Repo: dockerimage_repo, Tag: dockerimage_tag}
@@ -711,6 +735,7 @@ func RunWorkunitDocker(workunit *core.Workunit) (pstats *core.WorkPerf, err erro
 	}
 
 	// wait for container to finish
+	walltime_timer := walltimeTimer(workunit)
 	done := make(chan WaitContainerResult)
 	go func() {
 
@@ -873,6 +898,22 @@ func RunWorkunitDocker(workunit *core.Workunit) (pstats *core.WorkPerf, err erro
 		<-done // allow goroutine to exit
 
 		return nil, errors.New("process killed as requested from chankill")
+	case <-walltime_timer:
+		logger.Debug(1, "walltime of %d seconds exceeded, try to kill container %s... ", workunit.Cmd.Walltime, container_id)
+
+		if client != nil {
+			err = client.KillContainer(docker.KillContainerOptions{ID: container_id})
+		} else {
+			err = KillContainer(container_id)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("(walltime) error killing container id=%s, err=%s", container_id, err.Error())
+		}
+
+		<-done // allow goroutine to exit
+
+		return nil, ErrWalltimeExceeded
 	case cresult = <-done:
 		workunit.ExitStatus = cresult.Status
 		logger.Debug(3, "(1)docker wait returned with status %d", cresult.Status)
@@ -1000,6 +1041,8 @@ func RunWorkunitDirect(workunit *core.Workunit) (pstats *core.WorkPerf, err erro
 		}
 	}()
 
+	walltime_timer := walltimeTimer(workunit)
+
 	do_loop := true
 	for do_loop {
 		logger.Debug(3, "(RunWorkunitDirect) for-loop")
@@ -1014,6 +1057,13 @@ func RunWorkunitDirect(workunit *core.Workunit) (pstats *core.WorkPerf, err erro
 			<-done // allow goroutine to exit
 			logger.Info("(RunWorkunitDirect) worker process was killed")
 			return nil, errors.New("(RunWorkunitDirect) process killed")
+		case <-walltime_timer:
+			if err := cmd.Process.Kill(); err != nil {
+				logger.Error("(RunWorkunitDirect) failed to kill process: %s", err.Error())
+			}
+			<-done // allow goroutine to exit
+			logger.Info("(RunWorkunitDirect) worker process was killed, walltime of %d seconds exceeded", workunit.Cmd.Walltime)
+			return nil, ErrWalltimeExceeded
 		case err = <-done:
 			logger.Debug(3, "(RunWorkunitDirect) received done")
 			if err != nil {
@@ -39,10 +39,19 @@ func workStealerRun(control chan int, retry_previous int) (retry int, err error)
 	if core.Service == "proxy" {
 		<-core.ProxyWorkChan
 	}
+	checkoutLock.Lock()
+	if Draining() {
+		checkoutLock.Unlock()
+		return
+	}
 	workunit, err := CheckoutWorkunitRemote()
+	if err == nil {
+		workmap.Set(workunit.Workunit_Unique_Identifier, ID_WORKSTEALER, "workStealer")
+	}
+	checkoutLock.Unlock()
 	if err != nil {
 		core.Self.Busy = false
-		if err.Error() == e.QueueEmpty || err.Error() == e.QueueSuspend || err.Error() == e.NoEligibleWorkunitFound {
+		if err.Error() == e.QueueEmpty || err.Error() == e.QueueSuspend || err.Error() == e.NoEligibleWorkunitFound || err.Error() == e.ClientDraining {
 			//normal, do nothing
 			logger.Debug(3, "(workStealer) client %s received status %s from server %s", core.Self.Id, err.Error(), conf.SERVER_URL)
 		} else if err.Error() == e.ClientBusy {
@@ -87,6 +96,7 @@ func workStealerRun(control chan int, retry_previous int) (retry int, err error)
 	var work_str string
 	work_str, err = work_id.String()
 	if err != nil {
+		workmap.Delete(work_id)
 		err = fmt.Errorf("(workStealer) work_id.String() returned: %s", err.Error())
 		return
 	}
@@ -96,12 +106,11 @@ func workStealerRun(control chan int, retry_previous int) (retry int, err error)
 
 	err = core.Self.Current_work.Add(work_id)
 	if err != nil {
+		workmap.Delete(work_id)
 		logger.Error("(workStealer) error: %s", err.Error())
 		return
 	}
 
-	workmap.Set(work_id, ID_WORKSTEALER, "workStealer")
-
 	//hand the work to the next step handler: dataMover
 	workstat := core.NewWorkPerf()
 	workstat.Checkout = time.Now().Unix()
@@ -139,6 +148,11 @@ func workStealer(control chan int) {
 	retry := 0
 	var err error
 	for {
+		if Draining() {
+			// stopped on purpose, not restarted
+			logger.Info("(workStealer) draining, stop checking out work")
+			return
+		}
 		retry, err = workStealerRun(control, retry)
 		if err != nil {
 			logger.Error("(workStealer) workStealerRun returns: %s", err.Error())
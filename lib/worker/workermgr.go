@@ -3,9 +3,11 @@ package worker
 import (
 	//"errors"
 	"fmt"
+	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core"
 	//"github.com/MG-RAST/AWE/lib/core/cwl"
 	"github.com/MG-RAST/AWE/lib/logger"
+	"path"
 )
 
 var (
@@ -45,6 +47,7 @@ func InitWorkers() {
 	chanPermit = make(chan bool)
 	//workmap = map[string]int{} //workunit map [work_id]stage_idgit
 	workmap = NewWorkMap()
+	predataCache = NewPredataCache(path.Join(conf.DATA_PATH, "predata"))
 	return
 }
 